@@ -0,0 +1,405 @@
+// Package ap implements a minimal access point for open networks on
+// top of a Handle, which is mainly useful for testing stations.
+//
+// The AP sends beacons, answers probe requests, authenticates and
+// associates stations, and forwards their data frames as Ethernet
+// frames to a callback or a network device such as a TAP device.
+// It does not buffer frames for stations in power save mode.
+package ap
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/unixpickle/gofi"
+	"github.com/unixpickle/gofi/ether"
+	"github.com/unixpickle/gofi/mgmt"
+	"github.com/unixpickle/gofi/mpdu"
+)
+
+var (
+	ErrNoBSSID          = errors.New("missing BSSID")
+	ErrBadRates         = errors.New("basic rates must be supported rates")
+	ErrNotAssociated    = errors.New("station is not associated")
+	ErrNotAuthenticated = errors.New("station is not authenticated")
+)
+
+// DefaultBeaconInterval is the beacon interval which most APs use,
+// and DefaultInactivityTimeout is how long a silent station stays
+// associated, unless the Config says otherwise.
+const (
+	DefaultBeaconInterval    = 100 * mgmt.TimeUnit
+	DefaultInactivityTimeout = time.Minute * 5
+)
+
+// maxAID is the largest association ID.
+const maxAID = 2007
+
+// A Config configures an AP.
+type Config struct {
+	// BSSID is the address of the AP.
+	BSSID net.HardwareAddr
+
+	SSID string
+
+	// If HideSSID is true, beacons carry an empty SSID, and only
+	// stations which probe for the SSID learn it.
+	HideSSID bool
+
+	// Channel is the channel of the BSS.
+	// If it is unset, the Handle stays on its current channel.
+	Channel gofi.Channel
+
+	// Rates are the supported rates of the BSS, and BasicRates are
+	// the rates which every station must support.
+	// If Rates is empty, the Handle's supported rates are used, and
+	// if BasicRates is empty, the lowest rate is the basic rate.
+	Rates      []gofi.DataRate
+	BasicRates []gofi.DataRate
+
+	// Elements are added to beacons and probe responses after the
+	// AP's own elements.
+	Elements []gofi.Element
+
+	// BeaconInterval is the time between beacons, which is rounded
+	// down to a multiple of mgmt.TimeUnit.
+	// If it is 0, DefaultBeaconInterval is used.
+	BeaconInterval time.Duration
+
+	// MaxStations is the number of stations which may be associated
+	// at once.
+	// If it is 0, every association ID may be used.
+	MaxStations int
+
+	// InactivityTimeout is the time after which a station which has
+	// sent nothing is deauthenticated.
+	// If it is 0, DefaultInactivityTimeout is used.
+	InactivityTimeout time.Duration
+
+	// Forward is called with each Ethernet frame which stations
+	// send to the distribution system.
+	Forward func(eth []byte)
+
+	// Rate is the data rate of frames sent to stations.
+	// If it is 0, the lowest basic rate is used.
+	Rate gofi.DataRate
+}
+
+// A Station is a station which is authenticated with an AP.
+type Station struct {
+	Address net.HardwareAddr
+
+	// Associated is true if the station is associated, in which case
+	// AID is its association ID.
+	Associated bool
+	AID        int
+
+	// Capability, ListenInterval, Rates and Elements come from the
+	// latest association request.
+	Capability     uint16
+	ListenInterval int
+	Rates          []gofi.DataRate
+	Elements       []gofi.Element
+
+	// SignalPower is the signal power, in dBm, of the latest frame
+	// from the station.
+	SignalPower int
+
+	LastSeen time.Time
+
+	// lastSequence is the sequence control field of the latest data
+	// frame, which is used to drop retransmitted duplicates.
+	lastSequence int
+}
+
+// An AP serves an open BSS with a Handle.
+type AP struct {
+	handle gofi.Handle
+	config Config
+	mpdu   *mpdu.Sender
+	start  time.Time
+
+	lock     sync.Mutex
+	stations map[string]*Station
+	forward  func(eth []byte)
+	closed   bool
+
+	done chan struct{}
+}
+
+// New creates an AP which owns the Handle, and starts sending beacons
+// and serving stations.
+// Closing the AP closes the Handle.
+func New(h gofi.Handle, c *Config) (*AP, error) {
+	res := &AP{
+		handle:   h,
+		config:   *c,
+		mpdu:     mpdu.NewSender(),
+		start:    time.Now(),
+		stations: map[string]*Station{},
+		forward:  c.Forward,
+		done:     make(chan struct{}),
+	}
+	if len(c.BSSID) != 6 {
+		return nil, ErrNoBSSID
+	}
+	if err := res.config.setDefaults(h); err != nil {
+		return nil, err
+	}
+	if c.Channel.Number != 0 {
+		if err := h.SetChannel(c.Channel); err != nil {
+			return nil, err
+		}
+	}
+	go res.receiveLoop()
+	go res.beaconLoop()
+	return res, nil
+}
+
+func (c *Config) setDefaults(h gofi.Handle) error {
+	if len(c.Rates) == 0 {
+		c.Rates = h.SupportedRates()
+	}
+	c.Rates = append([]gofi.DataRate{}, c.Rates...)
+	sort.Slice(c.Rates, func(i, j int) bool {
+		return c.Rates[i] < c.Rates[j]
+	})
+	if len(c.BasicRates) == 0 && len(c.Rates) > 0 {
+		c.BasicRates = c.Rates[:1]
+	}
+	for _, r := range c.BasicRates {
+		if !containsRate(c.Rates, r) {
+			return ErrBadRates
+		}
+	}
+	if c.Rate == 0 && len(c.BasicRates) > 0 {
+		c.Rate = c.BasicRates[0]
+		for _, r := range c.BasicRates {
+			if r < c.Rate {
+				c.Rate = r
+			}
+		}
+	}
+	if c.BeaconInterval == 0 {
+		c.BeaconInterval = DefaultBeaconInterval
+	}
+	if c.InactivityTimeout == 0 {
+		c.InactivityTimeout = DefaultInactivityTimeout
+	}
+	if c.MaxStations == 0 || c.MaxStations > maxAID {
+		c.MaxStations = maxAID
+	}
+	return nil
+}
+
+// Stations returns the authenticated stations, sorted by address.
+func (a *AP) Stations() []Station {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	res := make([]Station, 0, len(a.stations))
+	for _, s := range a.stations {
+		res = append(res, *s)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return bytes.Compare(res[i].Address, res[j].Address) < 0
+	})
+	return res
+}
+
+// SendEthernet sends an Ethernet frame from the distribution system
+// to an associated station, or to every station if its destination
+// is a group address.
+func (a *AP) SendEthernet(eth []byte) error {
+	frame, err := ether.FromEthernet(eth, a.config.BSSID, ether.DirectionFromAP)
+	if err != nil {
+		return err
+	}
+	dst := frame.Addr1()
+	if !isGroup(dst) {
+		a.lock.Lock()
+		s, ok := a.stations[dst.String()]
+		associated := ok && s.Associated
+		a.lock.Unlock()
+		if !associated {
+			return ErrNotAssociated
+		}
+	}
+	return a.send(frame)
+}
+
+// Deauthenticate removes a station and sends it a deauthentication
+// frame with a reason code.
+func (a *AP) Deauthenticate(addr net.HardwareAddr, reason int) error {
+	a.lock.Lock()
+	_, ok := a.stations[addr.String()]
+	delete(a.stations, addr.String())
+	a.lock.Unlock()
+	if !ok {
+		return ErrNotAuthenticated
+	}
+	return a.sendManagement(gofi.SubtypeDeauth, addr, mgmt.EncodeReason(reason))
+}
+
+// Disassociate disassociates a station, which stays authenticated,
+// and sends it a disassociation frame with a reason code.
+func (a *AP) Disassociate(addr net.HardwareAddr, reason int) error {
+	a.lock.Lock()
+	s, ok := a.stations[addr.String()]
+	associated := ok && s.Associated
+	if associated {
+		s.Associated = false
+		s.AID = 0
+	}
+	a.lock.Unlock()
+	if !associated {
+		return ErrNotAssociated
+	}
+	return a.sendManagement(gofi.SubtypeDisassoc, addr, mgmt.EncodeReason(reason))
+}
+
+// ServeDevice copies frames between the BSS and a network device,
+// such as a tap.TAP, until reading from the device fails.
+//
+// While it runs, frames for the distribution system are written to
+// the device instead of being passed to Config.Forward.
+// Each Read from the device must return one Ethernet frame.
+func (a *AP) ServeDevice(dev io.ReadWriter) error {
+	a.lock.Lock()
+	oldForward := a.forward
+	a.forward = func(eth []byte) {
+		// NOTE: like a network card, the AP drops frames which the
+		// device does not accept.
+		dev.Write(eth)
+	}
+	a.lock.Unlock()
+	defer func() {
+		a.lock.Lock()
+		a.forward = oldForward
+		a.lock.Unlock()
+	}()
+
+	buf := make([]byte, 65536)
+	for {
+		n, err := dev.Read(buf)
+		if err != nil {
+			return err
+		}
+		if err := a.SendEthernet(buf[:n]); err == gofi.ErrClosed {
+			return err
+		}
+	}
+}
+
+// Close deauthenticates every station and closes the Handle.
+func (a *AP) Close() {
+	a.lock.Lock()
+	if a.closed {
+		a.lock.Unlock()
+		return
+	}
+	a.closed = true
+	a.stations = map[string]*Station{}
+	a.lock.Unlock()
+
+	close(a.done)
+	broadcast := net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	a.sendManagement(gofi.SubtypeDeauth, broadcast, mgmt.EncodeReason(mgmt.ReasonDeauthLeaving))
+	a.handle.Close()
+}
+
+func (a *AP) beaconLoop() {
+	ticker := time.NewTicker(a.beaconInterval())
+	defer ticker.Stop()
+	for {
+		a.sendBeacon()
+		a.expire()
+		select {
+		case <-a.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// expire deauthenticates the stations which have been inactive for
+// too long.
+func (a *AP) expire() {
+	deadline := time.Now().Add(-a.config.InactivityTimeout)
+	var expired []net.HardwareAddr
+	a.lock.Lock()
+	for key, s := range a.stations {
+		if s.LastSeen.Before(deadline) {
+			delete(a.stations, key)
+			expired = append(expired, s.Address)
+		}
+	}
+	a.lock.Unlock()
+	for _, addr := range expired {
+		a.sendManagement(gofi.SubtypeDeauth, addr, mgmt.EncodeReason(mgmt.ReasonInactivity))
+	}
+}
+
+func (a *AP) sendBeacon() {
+	ssid := a.config.SSID
+	if a.config.HideSSID {
+		ssid = ""
+	}
+	broadcast := net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	a.sendManagement(gofi.SubtypeBeacon, broadcast, a.beacon(ssid, true).Encode())
+}
+
+// beacon creates the body of a beacon or probe response.
+func (a *AP) beacon(ssid string, tim bool) *mgmt.Beacon {
+	rates := mgmt.RateElements(a.config.Rates, a.config.BasicRates)
+	elements := []gofi.Element{mgmt.SSIDElement(ssid), rates[0], mgmt.DSElement(a.handle.Channel())}
+	if tim {
+		// Every beacon is a DTIM, and no frames are ever buffered.
+		elements = append(elements, gofi.Element{ID: gofi.ElementTIM, Data: []byte{0, 1, 0, 0}})
+	}
+	elements = append(elements, rates[1:]...)
+	elements = append(elements, a.config.Elements...)
+	return &mgmt.Beacon{
+		Timestamp:  uint64(time.Since(a.start) / time.Microsecond),
+		Interval:   int(a.beaconInterval() / mgmt.TimeUnit),
+		Capability: a.capability(),
+		Elements:   elements,
+	}
+}
+
+func (a *AP) beaconInterval() time.Duration {
+	units := a.config.BeaconInterval / mgmt.TimeUnit
+	if units < 1 {
+		units = 1
+	}
+	return units * mgmt.TimeUnit
+}
+
+func (a *AP) capability() uint16 {
+	return mgmt.CapESS | mgmt.CapShortSlotTime
+}
+
+func (a *AP) sendManagement(subtype int, dst net.HardwareAddr, body []byte) error {
+	return a.send(mgmt.NewFrame(subtype, dst, a.config.BSSID, a.config.BSSID, body))
+}
+
+func (a *AP) send(f gofi.Frame) error {
+	return a.mpdu.Send(a.handle, f, a.config.Rate)
+}
+
+func containsRate(rates []gofi.DataRate, r gofi.DataRate) bool {
+	for _, x := range rates {
+		if x == r {
+			return true
+		}
+	}
+	return false
+}
+
+func isGroup(addr net.HardwareAddr) bool {
+	return len(addr) > 0 && addr[0]&1 != 0
+}
@@ -0,0 +1,403 @@
+package ap
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/unixpickle/gofi"
+	"github.com/unixpickle/gofi/ether"
+	"github.com/unixpickle/gofi/internal/testutil"
+	"github.com/unixpickle/gofi/mgmt"
+	"github.com/unixpickle/gofi/sim"
+)
+
+var (
+	testBSSID = net.HardwareAddr{0x02, 0, 0, 0, 0, 0xaa}
+	testAddr1 = net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	testAddr2 = net.HardwareAddr{0x02, 0, 0, 0, 0, 2}
+	testAddr3 = net.HardwareAddr{0x02, 0, 0, 0, 0, 3}
+	testExt   = net.HardwareAddr{0x02, 0, 0, 0, 0, 0xee}
+)
+
+func TestBeaconAndProbe(t *testing.T) {
+	medium := sim.NewMedium()
+	ap := testAP(t, medium, &Config{
+		SSID:     "hidden",
+		HideSSID: true,
+		Channel:  gofi.Channel{Number: 6},
+		Elements: []gofi.Element{{ID: gofi.ElementVendorSpecific, Data: []byte{1, 2, 3, 4}}},
+	})
+	defer ap.Close()
+	sta := newTestStation(medium, testAddr1)
+	defer sta.Close()
+	sta.handle.SetChannel(gofi.Channel{Number: 6})
+
+	beacon := sta.expectBeacon(t, gofi.SubtypeBeacon)
+	if ssid, _ := mgmt.SSID(beacon.Elements); ssid != "" {
+		t.Errorf("hidden SSID was sent: %q", ssid)
+	}
+	if mgmt.DSChannel(beacon.Elements) != 6 || beacon.Capability&mgmt.CapESS == 0 {
+		t.Errorf("unexpected beacon: %+v", beacon)
+	}
+	rates, basic := mgmt.Rates(beacon.Elements)
+	if len(rates) != 12 || len(basic) != 1 || basic[0] != 2 {
+		t.Errorf("unexpected rates %v and basic rates %v", rates, basic)
+	}
+	if gofi.FindElement(beacon.Elements, gofi.ElementVendorSpecific) == nil {
+		t.Error("missing configured element")
+	}
+
+	broadcast := net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	probe := gofi.EncodeElements([]gofi.Element{mgmt.SSIDElement("hidden")})
+	sta.Send(mgmt.NewFrame(gofi.SubtypeProbeRequest, broadcast, testAddr1, broadcast, probe))
+	response := sta.expectBeacon(t, gofi.SubtypeProbeResponse)
+	if ssid, _ := mgmt.SSID(response.Elements); ssid != "hidden" {
+		t.Errorf("unexpected SSID: %q", ssid)
+	}
+}
+
+func TestAssociation(t *testing.T) {
+	medium := sim.NewMedium()
+	ap := testAP(t, medium, &Config{SSID: "test", MaxStations: 2})
+	defer ap.Close()
+
+	var stations []*testStation
+	for i, addr := range []net.HardwareAddr{testAddr1, testAddr2, testAddr3} {
+		sta := newTestStation(medium, addr)
+		defer sta.Close()
+		stations = append(stations, sta)
+		if status := sta.authenticate(t, mgmt.AuthOpen); status != mgmt.StatusSuccess {
+			t.Fatalf("station %d: unexpected status %d", i, status)
+		}
+		resp := sta.associate(t, "test", gofi.LegacyRates(gofi.Band2GHz))
+		if i < 2 && (resp.Status != mgmt.StatusSuccess || resp.AID != i+1) {
+			t.Errorf("station %d: unexpected response %+v", i, resp)
+		} else if i == 2 && resp.Status != mgmt.StatusTooManyStations {
+			t.Errorf("station %d: unexpected response %+v", i, resp)
+		}
+	}
+	if s := ap.Stations(); len(s) != 3 || !s[0].Associated || !s[1].Associated ||
+		s[2].Associated || s[1].AID != 2 || len(s[0].Rates) != 12 {
+		t.Errorf("unexpected stations: %+v", s)
+	}
+
+	// Reassociating keeps the same AID.
+	if resp := stations[1].associate(t, "test", gofi.LegacyRates(gofi.Band2GHz)); resp.AID != 2 {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+
+	// Disassociating frees an AID for the third station.
+	stations[0].Send(mgmt.NewFrame(gofi.SubtypeDisassoc, testBSSID, testAddr1, testBSSID,
+		mgmt.EncodeReason(mgmt.ReasonDisassocLeaving)))
+	testutil.WaitFor(t, func() bool {
+		return !ap.Stations()[0].Associated
+	})
+	if resp := stations[2].associate(t, "test", gofi.LegacyRates(gofi.Band2GHz)); resp.AID != 1 {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+
+	if resp := stations[0].associate(t, "other", gofi.LegacyRates(gofi.Band2GHz)); resp.Status !=
+		mgmt.StatusUnspecified {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+	if resp := stations[0].associate(t, "test", []gofi.DataRate{12, 24}); resp.Status !=
+		mgmt.StatusUnsupportedRates {
+		t.Errorf("unexpected response: %+v", resp)
+	}
+	if status := stations[0].authenticate(t, mgmt.AuthSAE); status != mgmt.StatusUnsupportedAuthAlg {
+		t.Errorf("unexpected status: %d", status)
+	}
+
+	stations[1].Send(mgmt.NewFrame(gofi.SubtypeDeauth, testBSSID, testAddr2, testBSSID,
+		mgmt.EncodeReason(mgmt.ReasonDeauthLeaving)))
+	testutil.WaitFor(t, func() bool {
+		return len(ap.Stations()) == 2
+	})
+
+	// Associating without authenticating is not allowed.
+	stations[1].Send(mgmt.NewFrame(gofi.SubtypeAssocRequest, testBSSID, testAddr2, testBSSID,
+		(&mgmt.AssocRequest{Elements: []gofi.Element{mgmt.SSIDElement("test")}}).Encode()))
+	if reason := stations[1].expectReason(t, gofi.SubtypeDeauth); reason != mgmt.ReasonClass2NonAuth {
+		t.Errorf("unexpected reason: %d", reason)
+	}
+}
+
+func TestData(t *testing.T) {
+	medium := sim.NewMedium()
+	forwarded := make(chan []byte, 10)
+	ap := testAP(t, medium, &Config{
+		SSID: "test",
+		Forward: func(eth []byte) {
+			forwarded <- append([]byte{}, eth...)
+		},
+	})
+	defer ap.Close()
+	sta1 := newTestStation(medium, testAddr1)
+	defer sta1.Close()
+	sta2 := newTestStation(medium, testAddr2)
+	defer sta2.Close()
+	for _, sta := range []*testStation{sta1, sta2} {
+		sta.authenticate(t, mgmt.AuthOpen)
+		sta.associate(t, "test", gofi.LegacyRates(gofi.Band2GHz))
+	}
+
+	// To the distribution system.
+	eth := testutil.Ethernet(testExt, testAddr1, []byte("hello"))
+	sta1.sendEthernet(t, eth)
+	select {
+	case actual := <-forwarded:
+		if !bytes.Equal(actual, eth) {
+			t.Errorf("unexpected frame: %x", actual)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("frame was not forwarded")
+	}
+
+	// From the distribution system.
+	eth = testutil.Ethernet(testAddr2, testExt, []byte("hi"))
+	if err := ap.SendEthernet(eth); err != nil {
+		t.Fatal(err)
+	}
+	if actual := sta2.expectEthernet(t); !bytes.Equal(actual, eth) {
+		t.Errorf("unexpected frame: %x", actual)
+	}
+	if err := ap.SendEthernet(testutil.Ethernet(testAddr3, testExt, []byte("hi"))); err != ErrNotAssociated {
+		t.Error("unexpected error:", err)
+	}
+
+	// Between stations.
+	eth = testutil.Ethernet(testAddr1, testAddr2, []byte("relayed"))
+	sta2.sendEthernet(t, eth)
+	if actual := sta1.expectEthernet(t); !bytes.Equal(actual, eth) {
+		t.Errorf("unexpected frame: %x", actual)
+	}
+	select {
+	case <-forwarded:
+		t.Error("frame between stations was forwarded")
+	default:
+	}
+
+	// From a station which is not associated.
+	sta3 := newTestStation(medium, testAddr3)
+	defer sta3.Close()
+	sta3.sendEthernet(t, testutil.Ethernet(testExt, testAddr3, []byte("hello")))
+	if reason := sta3.expectReason(t, gofi.SubtypeDeauth); reason != mgmt.ReasonClass3NonAssoc {
+		t.Errorf("unexpected reason: %d", reason)
+	}
+}
+
+func TestInactivity(t *testing.T) {
+	medium := sim.NewMedium()
+	ap := testAP(t, medium, &Config{
+		SSID:              "test",
+		BeaconInterval:    mgmt.TimeUnit * 20,
+		InactivityTimeout: time.Millisecond * 200,
+	})
+	defer ap.Close()
+	sta := newTestStation(medium, testAddr1)
+	defer sta.Close()
+	sta.authenticate(t, mgmt.AuthOpen)
+	sta.associate(t, "test", gofi.LegacyRates(gofi.Band2GHz))
+
+	start := time.Now()
+	if reason := sta.expectReason(t, gofi.SubtypeDeauth); reason != mgmt.ReasonInactivity {
+		t.Errorf("unexpected reason: %d", reason)
+	}
+	if time.Since(start) < time.Millisecond*150 {
+		t.Error("station expired too early")
+	}
+	if len(ap.Stations()) != 0 {
+		t.Error("station was not removed")
+	}
+}
+
+func TestServeDevice(t *testing.T) {
+	medium := sim.NewMedium()
+	ap := testAP(t, medium, &Config{SSID: "test"})
+	defer ap.Close()
+	sta := newTestStation(medium, testAddr1)
+	defer sta.Close()
+	sta.authenticate(t, mgmt.AuthOpen)
+	sta.associate(t, "test", gofi.LegacyRates(gofi.Band2GHz))
+
+	dev := &testDevice{reads: make(chan []byte, 1), writes: make(chan []byte, 1)}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- ap.ServeDevice(dev)
+	}()
+
+	eth := testutil.Ethernet(testAddr1, testExt, []byte("down"))
+	dev.reads <- eth
+	if actual := sta.expectEthernet(t); !bytes.Equal(actual, eth) {
+		t.Errorf("unexpected frame: %x", actual)
+	}
+
+	// ServeDevice replaced Config.Forward before it read the first
+	// frame from the device.
+	eth = testutil.Ethernet(testExt, testAddr1, []byte("up"))
+	sta.sendEthernet(t, eth)
+	select {
+	case actual := <-dev.writes:
+		if !bytes.Equal(actual, eth) {
+			t.Errorf("unexpected frame: %x", actual)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("frame was not written to the device")
+	}
+
+	close(dev.reads)
+	if err := <-errs; err != io.EOF {
+		t.Error("unexpected error:", err)
+	}
+}
+
+type testDevice struct {
+	reads  chan []byte
+	writes chan []byte
+}
+
+func (t *testDevice) Read(b []byte) (int, error) {
+	packet, ok := <-t.reads
+	if !ok {
+		return 0, io.EOF
+	}
+	return copy(b, packet), nil
+}
+
+func (t *testDevice) Write(b []byte) (int, error) {
+	t.writes <- append([]byte{}, b...)
+	return len(b), nil
+}
+
+// A testStation is a station which is driven by a test, one frame at
+// a time.
+type testStation struct {
+	handle *sim.Handle
+	addr   net.HardwareAddr
+	frames chan gofi.Frame
+}
+
+func newTestStation(medium *sim.Medium, addr net.HardwareAddr) *testStation {
+	res := &testStation{
+		handle: medium.NewHandle(addr),
+		addr:   addr,
+		frames: make(chan gofi.Frame, 100),
+	}
+	go func() {
+		defer close(res.frames)
+		for {
+			frame, _, err := res.handle.Receive()
+			if err != nil {
+				return
+			}
+			if bytes.Equal(frame.Addr2(), testBSSID) &&
+				(bytes.Equal(frame.Addr1(), addr) || isGroup(frame.Addr1())) {
+				res.frames <- frame
+			}
+		}
+	}()
+	return res
+}
+
+func (s *testStation) Send(f gofi.Frame) {
+	s.handle.Send(f, 0)
+}
+
+func (s *testStation) Close() {
+	s.handle.Close()
+}
+
+// expect waits for a frame of a type and subtype, skipping beacons.
+func (s *testStation) expect(t *testing.T, frameType gofi.FrameType, subtype int) gofi.Frame {
+	timeout := time.After(time.Second * 5)
+	for {
+		select {
+		case frame, ok := <-s.frames:
+			if !ok {
+				t.Fatal("station closed")
+			}
+			if frame.Type() == frameType && frame.Subtype() == subtype {
+				return frame
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for frame with subtype %d", subtype)
+		}
+	}
+}
+
+func (s *testStation) expectBeacon(t *testing.T, subtype int) *mgmt.Beacon {
+	frame := s.expect(t, gofi.FrameTypeManagement, subtype)
+	beacon, err := mgmt.ParseBeacon(frame.Body())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return beacon
+}
+
+func (s *testStation) expectReason(t *testing.T, subtype int) int {
+	frame := s.expect(t, gofi.FrameTypeManagement, subtype)
+	reason, err := mgmt.ParseReason(frame.Body())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reason
+}
+
+func (s *testStation) expectEthernet(t *testing.T) []byte {
+	frame := s.expect(t, gofi.FrameTypeData, gofi.SubtypeData)
+	packets, err := ether.ToEthernet(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return packets[0]
+}
+
+func (s *testStation) authenticate(t *testing.T, algorithm uint16) uint16 {
+	auth := &mgmt.Auth{Algorithm: algorithm, Sequence: 1}
+	s.Send(mgmt.NewFrame(gofi.SubtypeAuth, testBSSID, s.addr, testBSSID, auth.Encode()))
+	frame := s.expect(t, gofi.FrameTypeManagement, gofi.SubtypeAuth)
+	response, err := mgmt.ParseAuth(frame.Body())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Sequence != 2 || response.Algorithm != algorithm {
+		t.Errorf("unexpected response: %+v", response)
+	}
+	return response.Status
+}
+
+func (s *testStation) associate(t *testing.T, ssid string,
+	rates []gofi.DataRate) *mgmt.AssocResponse {
+	req := &mgmt.AssocRequest{
+		Capability:     mgmt.CapESS,
+		ListenInterval: 10,
+		Elements:       append([]gofi.Element{mgmt.SSIDElement(ssid)}, mgmt.RateElements(rates, nil)...),
+	}
+	s.Send(mgmt.NewFrame(gofi.SubtypeAssocRequest, testBSSID, s.addr, testBSSID, req.Encode()))
+	frame := s.expect(t, gofi.FrameTypeManagement, gofi.SubtypeAssocResponse)
+	response, err := mgmt.ParseAssocResponse(frame.Body())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return response
+}
+
+func (s *testStation) sendEthernet(t *testing.T, eth []byte) {
+	frame, err := ether.FromEthernet(eth, testBSSID, ether.DirectionToAP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Send(frame)
+}
+
+func testAP(t *testing.T, medium *sim.Medium, c *Config) *AP {
+	c.BSSID = testBSSID
+	ap, err := New(medium.NewHandle(testBSSID), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ap
+}
@@ -0,0 +1,276 @@
+package ap
+
+import (
+	"bytes"
+	"net"
+	"time"
+
+	"github.com/unixpickle/gofi"
+	"github.com/unixpickle/gofi/ether"
+	"github.com/unixpickle/gofi/mgmt"
+)
+
+func (a *AP) receiveLoop() {
+	for {
+		frame, info, err := a.handle.Receive()
+		if err != nil {
+			return
+		}
+		if info != nil && info.TxStatus != nil {
+			continue
+		}
+		if !frame.ChecksumValid() {
+			continue
+		}
+		a.handleFrame(frame, info)
+	}
+}
+
+func (a *AP) handleFrame(f gofi.Frame, info *gofi.RadioInfo) {
+	if f.Type() == gofi.FrameTypeManagement && f.Subtype() == gofi.SubtypeProbeRequest {
+		a.handleProbeRequest(f)
+		return
+	}
+	if !bytes.Equal(f.Addr1(), a.config.BSSID) {
+		return
+	}
+	addr := f.Addr2()
+	if addr == nil {
+		return
+	}
+	a.touch(addr, info)
+	switch f.Type() {
+	case gofi.FrameTypeManagement:
+		if !bytes.Equal(f.Addr3(), a.config.BSSID) {
+			return
+		}
+		switch f.Subtype() {
+		case gofi.SubtypeAuth:
+			a.handleAuth(f)
+		case gofi.SubtypeAssocRequest, gofi.SubtypeReassocRequest:
+			a.handleAssocRequest(f)
+		case gofi.SubtypeDeauth:
+			a.lock.Lock()
+			delete(a.stations, addr.String())
+			a.lock.Unlock()
+		case gofi.SubtypeDisassoc:
+			a.lock.Lock()
+			if s, ok := a.stations[addr.String()]; ok {
+				s.Associated = false
+				s.AID = 0
+			}
+			a.lock.Unlock()
+		}
+	case gofi.FrameTypeData:
+		a.handleData(f)
+	}
+}
+
+// touch updates the last time a station was heard from.
+func (a *AP) touch(addr net.HardwareAddr, info *gofi.RadioInfo) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if s, ok := a.stations[addr.String()]; ok {
+		s.LastSeen = time.Now()
+		if info != nil {
+			s.SignalPower = info.SignalPower
+		}
+	}
+}
+
+func (a *AP) handleProbeRequest(f gofi.Frame) {
+	if !isGroup(f.Addr1()) && !bytes.Equal(f.Addr1(), a.config.BSSID) {
+		return
+	}
+	if !isGroup(f.Addr3()) && !bytes.Equal(f.Addr3(), a.config.BSSID) {
+		return
+	}
+	body, err := mgmt.Body(f, gofi.SubtypeProbeRequest)
+	if err != nil {
+		return
+	}
+	elements, err := gofi.ParseElements(body)
+	if err != nil {
+		return
+	}
+	if ssid, ok := mgmt.SSID(elements); !ok || (ssid != "" && ssid != a.config.SSID) {
+		return
+	}
+	response := a.beacon(a.config.SSID, false).Encode()
+	a.sendManagement(gofi.SubtypeProbeResponse, f.Addr2(), response)
+}
+
+func (a *AP) handleAuth(f gofi.Frame) {
+	body, err := mgmt.Body(f, gofi.SubtypeAuth)
+	if err != nil {
+		return
+	}
+	auth, err := mgmt.ParseAuth(body)
+	if err != nil || auth.Sequence != 1 {
+		return
+	}
+	response := &mgmt.Auth{Algorithm: auth.Algorithm, Sequence: 2, Status: mgmt.StatusSuccess}
+	if auth.Algorithm != mgmt.AuthOpen {
+		response.Status = mgmt.StatusUnsupportedAuthAlg
+	} else {
+		// A new authentication replaces any previous association.
+		addr := append(net.HardwareAddr{}, f.Addr2()...)
+		a.lock.Lock()
+		a.stations[addr.String()] = &Station{
+			Address:      addr,
+			LastSeen:     time.Now(),
+			lastSequence: -1,
+		}
+		a.lock.Unlock()
+	}
+	a.sendManagement(gofi.SubtypeAuth, f.Addr2(), response.Encode())
+}
+
+func (a *AP) handleAssocRequest(f gofi.Frame) {
+	reassoc := f.Subtype() == gofi.SubtypeReassocRequest
+	body, err := mgmt.Body(f, f.Subtype())
+	if err != nil {
+		return
+	}
+	req, err := mgmt.ParseAssocRequest(body, reassoc)
+	if err != nil {
+		return
+	}
+	addr := f.Addr2()
+
+	a.lock.Lock()
+	s, ok := a.stations[addr.String()]
+	if !ok {
+		a.lock.Unlock()
+		a.sendManagement(gofi.SubtypeDeauth, addr, mgmt.EncodeReason(mgmt.ReasonClass2NonAuth))
+		return
+	}
+	status := a.checkAssocRequest(req)
+	aid := s.AID
+	if status == mgmt.StatusSuccess && aid == 0 {
+		aid = a.allocateAID()
+		if aid == 0 {
+			status = mgmt.StatusTooManyStations
+		}
+	}
+	if status == mgmt.StatusSuccess {
+		s.Associated = true
+		s.AID = aid
+		s.Capability = req.Capability
+		s.ListenInterval = req.ListenInterval
+		s.Rates, _ = mgmt.Rates(req.Elements)
+		s.Elements = req.Elements
+	} else {
+		s.Associated = false
+		s.AID = 0
+		aid = 0
+	}
+	a.lock.Unlock()
+
+	response := &mgmt.AssocResponse{
+		Capability: a.capability(),
+		Status:     uint16(status),
+		AID:        aid,
+		Elements:   mgmt.RateElements(a.config.Rates, a.config.BasicRates),
+	}
+	subtype := gofi.SubtypeAssocResponse
+	if reassoc {
+		subtype = gofi.SubtypeReassocResponse
+	}
+	a.sendManagement(subtype, addr, response.Encode())
+}
+
+// checkAssocRequest returns the status code for an association
+// request.
+func (a *AP) checkAssocRequest(req *mgmt.AssocRequest) int {
+	if ssid, ok := mgmt.SSID(req.Elements); !ok || ssid != a.config.SSID {
+		return mgmt.StatusUnspecified
+	}
+	if req.Capability&mgmt.CapPrivacy != 0 {
+		return mgmt.StatusUnsupportedCapabilities
+	}
+	rates, _ := mgmt.Rates(req.Elements)
+	for _, r := range a.config.BasicRates {
+		if !containsRate(rates, r) {
+			return mgmt.StatusUnsupportedRates
+		}
+	}
+	return mgmt.StatusSuccess
+}
+
+// allocateAID finds the lowest unused association ID, or returns 0 if
+// the AP is full.
+// The caller must hold a.lock.
+func (a *AP) allocateAID() int {
+	used := map[int]bool{}
+	for _, s := range a.stations {
+		if s.Associated {
+			used[s.AID] = true
+		}
+	}
+	if len(used) >= a.config.MaxStations {
+		return 0
+	}
+	for aid := 1; aid <= maxAID; aid++ {
+		if !used[aid] {
+			return aid
+		}
+	}
+	return 0
+}
+
+func (a *AP) handleData(f gofi.Frame) {
+	if f.Protected() || ether.FrameDirection(f) != ether.DirectionToAP {
+		return
+	}
+	addr := f.Addr2()
+
+	a.lock.Lock()
+	s, ok := a.stations[addr.String()]
+	if !ok || !s.Associated {
+		a.lock.Unlock()
+		if !ok {
+			a.sendManagement(gofi.SubtypeDeauth, addr, mgmt.EncodeReason(mgmt.ReasonClass3NonAssoc))
+		} else {
+			a.sendManagement(gofi.SubtypeDisassoc, addr, mgmt.EncodeReason(mgmt.ReasonClass3NonAssoc))
+		}
+		return
+	}
+	seq := int(f.SequenceControl())
+	duplicate := f.Retry() && seq == s.lastSequence
+	s.lastSequence = seq
+	forward := a.forward
+	a.lock.Unlock()
+
+	if duplicate || !f.HasBody() {
+		return
+	}
+	packets, err := ether.ToEthernet(f)
+	if err != nil {
+		return
+	}
+	for _, eth := range packets {
+		a.route(eth, forward)
+	}
+}
+
+// route sends an Ethernet frame from a station to its destination,
+// which may be another station, the distribution system, or both.
+func (a *AP) route(eth []byte, forward func([]byte)) {
+	dst := net.HardwareAddr(eth[:6])
+	if isGroup(dst) {
+		a.SendEthernet(eth)
+	} else {
+		a.lock.Lock()
+		s, ok := a.stations[dst.String()]
+		local := ok && s.Associated
+		a.lock.Unlock()
+		if local {
+			a.SendEthernet(eth)
+			return
+		}
+	}
+	if forward != nil {
+		forward(eth)
+	}
+}
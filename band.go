@@ -0,0 +1,132 @@
+package gofi
+
+// A Band is a range of frequencies used for WiFi.
+type Band int
+
+const (
+	BandUnknown Band = iota
+	Band2GHz
+	Band5GHz
+	Band6GHz
+	Band60GHz
+)
+
+// String returns a human-readable name for the band, such as "2.4 GHz".
+func (b Band) String() string {
+	switch b {
+	case Band2GHz:
+		return "2.4 GHz"
+	case Band5GHz:
+		return "5 GHz"
+	case Band6GHz:
+		return "6 GHz"
+	case Band60GHz:
+		return "60 GHz"
+	default:
+		return "unknown band"
+	}
+}
+
+// Band returns the band that the channel belongs to.
+func (c Channel) Band() Band {
+	switch {
+	case c.Number >= 1 && c.Number <= 14:
+		return Band2GHz
+	case c.Number >= 32 && c.Number <= 177:
+		return Band5GHz
+	default:
+		return BandUnknown
+	}
+}
+
+// LegacyRates returns the standard 802.11a/b/g data rates for a band
+// in ascending order.
+// The 2.4GHz band includes the 802.11b DSSS and CCK rates, while the
+// 5GHz and 6GHz bands only support OFDM rates.
+func LegacyRates(b Band) []DataRate {
+	switch b {
+	case Band2GHz:
+		return []DataRate{2, 4, 11, 12, 18, 22, 24, 36, 48, 72, 96, 108}
+	case Band5GHz, Band6GHz:
+		return []DataRate{12, 18, 24, 36, 48, 72, 96, 108}
+	default:
+		return nil
+	}
+}
+
+// BandCapabilities describes the rates that a device supports
+// within a band.
+type BandCapabilities struct {
+	Band Band
+
+	// Rates lists the supported legacy rates in ascending order.
+	Rates []DataRate
+
+	// HTMCS lists the supported HT MCS indices in ascending order.
+	// It is empty if the device does not support 802.11n in this band.
+	HTMCS []int
+
+	// VHTMaxMCS stores, for each number of spatial streams n, the
+	// highest supported VHT MCS index at VHTMaxMCS[n-1].
+	// It is empty if the device does not support 802.11ac in this band.
+	VHTMaxMCS []int
+
+	// HEMaxMCS stores, for each number of spatial streams n, the
+	// highest supported HE MCS index for channels up to 80MHz wide
+	// at HEMaxMCS[n-1].
+	// It is empty if the device does not support 802.11ax in this band.
+	HEMaxMCS []int
+}
+
+// A CapabilityHandle is a Handle which can describe the rates it
+// supports in every band.
+type CapabilityHandle interface {
+	Handle
+
+	// BandCapabilities returns the capabilities of the device for
+	// every band it supports.
+	BandCapabilities() []BandCapabilities
+}
+
+// bandRates finds the rates for a band in a list of capabilities.
+// If the band is unknown, the first band's rates are used.
+// If no rates are found, the band's legacy rates are returned.
+func bandRates(caps []BandCapabilities, b Band) []DataRate {
+	for _, c := range caps {
+		if (c.Band == b || b == BandUnknown) && len(c.Rates) > 0 {
+			return c.Rates
+		}
+	}
+	if b == BandUnknown {
+		b = Band2GHz
+	}
+	return LegacyRates(b)
+}
+
+// decodeMCSMap decodes a VHT or HE MCS map, which stores two bits per
+// spatial stream.
+// The maxMCS argument maps the values 0 through 2 to MCS indices,
+// while the value 3 indicates an unsupported number of streams.
+func decodeMCSMap(mcsMap uint16, maxMCS [3]int) []int {
+	var res []int
+	for nss := 0; nss < 8; nss++ {
+		value := (mcsMap >> uint(2*nss)) & 3
+		if value == 3 {
+			break
+		}
+		res = append(res, maxMCS[value])
+	}
+	return res
+}
+
+// decodeHTMCSSet decodes the RX MCS bitmask from an HT
+// "Supported MCS Set" field.
+func decodeHTMCSSet(set []byte) []int {
+	var res []int
+	for i := 0; i < 77 && i/8 < len(set); i++ {
+		if (set[i/8] & (1 << uint(i%8))) != 0 {
+			res = append(res, i)
+		}
+	}
+	return res
+}
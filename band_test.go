@@ -0,0 +1,54 @@
+package gofi
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestChannelBand(t *testing.T) {
+	if b := (Channel{Number: 11}).Band(); b != Band2GHz {
+		t.Error("unexpected band for channel 11:", b)
+	}
+	if b := (Channel{Number: 149}).Band(); b != Band5GHz {
+		t.Error("unexpected band for channel 149:", b)
+	}
+	if b := (Channel{}).Band(); b != BandUnknown {
+		t.Error("unexpected band for unknown channel:", b)
+	}
+}
+
+func TestBandRates(t *testing.T) {
+	caps := []BandCapabilities{
+		{Band: Band2GHz, Rates: []DataRate{2, 4, 11, 22}},
+		{Band: Band5GHz, Rates: []DataRate{12, 24, 48}},
+	}
+	if rates := bandRates(caps, Band5GHz); !reflect.DeepEqual(rates, caps[1].Rates) {
+		t.Error("unexpected 5GHz rates:", rates)
+	}
+	if rates := bandRates(caps, BandUnknown); !reflect.DeepEqual(rates, caps[0].Rates) {
+		t.Error("unexpected rates for unknown band:", rates)
+	}
+	if rates := bandRates(caps[:1], Band5GHz); !reflect.DeepEqual(rates, LegacyRates(Band5GHz)) {
+		t.Error("unexpected fallback rates:", rates)
+	}
+}
+
+func TestDecodeMCS(t *testing.T) {
+	htSet := []byte{0xff, 0xff, 0, 0, 0, 0, 0, 0, 0, 0}
+	if mcs := decodeHTMCSSet(htSet); len(mcs) != 16 || mcs[15] != 15 {
+		t.Error("unexpected HT MCS indices:", mcs)
+	}
+
+	// Two streams with MCS 0-9.
+	vhtMap := uint16(0xfff0 | 0x0a)
+	expected := []int{9, 9}
+	if mcs := decodeMCSMap(vhtMap, [3]int{7, 8, 9}); !reflect.DeepEqual(mcs, expected) {
+		t.Error("unexpected VHT MCS map:", mcs)
+	}
+	// Three streams with MCS 0-11, 0-7, and 0-9 respectively.
+	heMap := uint16(0xffc0 | 0x02 | 0x00<<2 | 0x01<<4)
+	expected = []int{11, 7, 9}
+	if mcs := decodeMCSMap(heMap, [3]int{7, 9, 11}); !reflect.DeepEqual(mcs, expected) {
+		t.Error("unexpected HE MCS map:", mcs)
+	}
+}
@@ -94,6 +94,16 @@ func newBpfHandle() (*bpfHandle, error) {
 	}
 }
 
+// DataLinkType returns the data-link type chosen by SetupDataLink.
+func (b *bpfHandle) DataLinkType() int {
+	return b.dataLinkType
+}
+
+// ReadBufferSize returns the size of the read buffer.
+func (b *bpfHandle) ReadBufferSize() int {
+	return len(b.readBuffer)
+}
+
 // Close closes the underlying socket.
 // You should not call this while any send or receive operations are taking place.
 // After closing the handle, you should not call any other methods on it.
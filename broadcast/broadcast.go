@@ -0,0 +1,177 @@
+// Package broadcast streams packets from one sender to any number of
+// receivers in broadcast frames, in the style of wifibroadcast.
+//
+// Nothing is ever acknowledged or retransmitted.
+// Instead, packets are grouped into blocks, and every block of k data
+// packets is followed by n-k parity packets from a Reed-Solomon code,
+// so that receivers can recover a block from any k of its n packets.
+// A receiver may listen with several radios at once, in which case a
+// packet only needs to reach one of them.
+package broadcast
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+
+	"github.com/unixpickle/gofi"
+)
+
+var (
+	ErrBadShards = errors.New("invalid data and total shard counts")
+	ErrTooLarge  = errors.New("packet is too large")
+)
+
+// Default shard counts for a Config which leaves them unset.
+const (
+	DefaultDataShards  = 8
+	DefaultTotalShards = 12
+)
+
+// headerSize is the size of the header which precedes each shard in
+// a frame's body, after the LLC/SNAP header.
+// It contains the block number, the shard index, and the block's data
+// and total shard counts.
+const headerSize = 7
+
+// lengthSize is the size of the length prefix of each data shard.
+// The prefix lets receivers strip the padding from recovered shards.
+const lengthSize = 2
+
+// MaxPacketSize is the largest packet which fits in a frame.
+const MaxPacketSize = 2304 - (8 + headerSize + lengthSize)
+
+// A Config configures a Sender or a Receiver.
+type Config struct {
+	// Address is the transmitter address of sent frames.
+	// Receivers accept frames from any transmitter.
+	Address net.HardwareAddr
+
+	// OUI and Protocol identify the stream in the LLC/SNAP header of
+	// each frame, so that frames from unrelated protocols are
+	// ignored.
+	// As with dgram, an OUI of zero makes Protocol an EtherType.
+	OUI      [3]byte
+	Protocol uint16
+
+	// DataShards (k) is the number of data packets in each block,
+	// and TotalShards (n) is the number of data and parity packets
+	// in each block.
+	// Up to n-k packets may be lost from each block.
+	//
+	// If both are 0, DefaultDataShards and DefaultTotalShards are
+	// used.
+	// Receivers learn the shard counts from the frames themselves.
+	DataShards  int
+	TotalShards int
+
+	// Rate is the fixed data rate of sent frames.
+	// Since broadcasts are never acknowledged, there is no rate
+	// control, and the rate should be chosen so that every receiver
+	// can decode it.
+	Rate gofi.DataRate
+}
+
+func (c *Config) shardCounts() (k, n int, err error) {
+	k, n = c.DataShards, c.TotalShards
+	if k == 0 && n == 0 {
+		return DefaultDataShards, DefaultTotalShards, nil
+	}
+	if k < 1 || n < k || n > maxShards {
+		return 0, 0, ErrBadShards
+	}
+	return k, n, nil
+}
+
+// llcHeader returns the LLC/SNAP header which identifies the stream.
+func (c *Config) llcHeader() []byte {
+	return []byte{0xaa, 0xaa, 0x03, c.OUI[0], c.OUI[1], c.OUI[2],
+		byte(c.Protocol >> 8), byte(c.Protocol)}
+}
+
+// A shard is one packet of a block.
+type shard struct {
+	block uint32
+	index int
+	k     int
+	n     int
+	data  []byte
+}
+
+func (s *shard) encode() []byte {
+	res := make([]byte, headerSize+len(s.data))
+	binary.BigEndian.PutUint32(res, s.block)
+	res[4] = byte(s.index)
+	res[5] = byte(s.k)
+	res[6] = byte(s.n)
+	copy(res[headerSize:], s.data)
+	return res
+}
+
+func decodeShard(body []byte) (*shard, bool) {
+	if len(body) < headerSize {
+		return nil, false
+	}
+	s := &shard{
+		block: binary.BigEndian.Uint32(body),
+		index: int(body[4]),
+		k:     int(body[5]),
+		n:     int(body[6]),
+		data:  append([]byte{}, body[headerSize:]...),
+	}
+	if s.k < 1 || s.n < s.k || s.index >= s.n {
+		return nil, false
+	}
+	if s.index < s.k && len(s.data) < lengthSize {
+		return nil, false
+	}
+	return s, true
+}
+
+// Stats summarizes what a Receiver has received.
+type Stats struct {
+	// BlocksComplete is the number of blocks whose data packets
+	// were all received.
+	BlocksComplete int
+
+	// BlocksRecovered is the number of blocks whose missing data
+	// packets were recovered from parity packets.
+	BlocksRecovered int
+
+	// BlocksLost is the number of blocks which could not be
+	// recovered, including blocks of which nothing was received.
+	BlocksLost int
+
+	// PacketsRecovered is the number of data packets which were
+	// recovered from parity packets.
+	PacketsRecovered int
+
+	// PacketsLost is the number of data packets which were never
+	// delivered from blocks that could not be recovered.
+	// Packets from blocks of which nothing was received are not
+	// counted, since their number is unknown.
+	PacketsLost int
+
+	// Radios contains statistics for each Handle, in the order the
+	// Handles were passed to NewReceiver.
+	Radios []RadioStats
+}
+
+// RadioStats summarizes what one Handle of a Receiver has received.
+type RadioStats struct {
+	// Frames is the number of frames of the stream which the
+	// Handle received with a valid checksum.
+	Frames int
+
+	// Corrupt is the number of frames of the stream which the Handle
+	// received with an invalid checksum.
+	Corrupt int
+
+	// Used is the number of frames which the Handle was the first to
+	// receive, and which were therefore used to rebuild the stream.
+	Used int
+
+	// SignalPower is the average signal power of valid frames in
+	// dBm, or 0 if the Handle did not report it.
+	SignalPower int
+}
@@ -0,0 +1,279 @@
+package broadcast
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/unixpickle/gofi"
+	"github.com/unixpickle/gofi/sim"
+)
+
+var (
+	testAddr1 = net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	testAddr2 = net.HardwareAddr{0x02, 0, 0, 0, 0, 2}
+	testAddr3 = net.HardwareAddr{0x02, 0, 0, 0, 0, 3}
+)
+
+func TestCode(t *testing.T) {
+	for _, counts := range [][2]int{{1, 1}, {1, 3}, {4, 6}, {8, 12}, {20, 30}} {
+		k, n := counts[0], counts[1]
+		c := getCode(k, n)
+		data := make([][]byte, k)
+		for i := range data {
+			data[i] = make([]byte, 37)
+			rand.Read(data[i])
+		}
+		shards := append(append([][]byte{}, data...), c.encode(data)...)
+		for _, i := range rand.Perm(n)[:n-k] {
+			shards[i] = nil
+		}
+		if err := c.reconstruct(shards); err != nil {
+			t.Fatalf("k=%d n=%d: %s", k, n, err)
+		}
+		for i, expected := range data {
+			if !bytes.Equal(shards[i], expected) {
+				t.Errorf("k=%d n=%d: bad shard %d", k, n, i)
+			}
+		}
+	}
+	c := getCode(4, 6)
+	shards := make([][]byte, 6)
+	shards[0] = []byte{1}
+	if c.reconstruct(shards) == nil {
+		t.Error("reconstructed from too few shards")
+	}
+}
+
+func TestCodeCache(t *testing.T) {
+	first := getCode(4, 6)
+	for n := 10; n < 10+codeCacheSize*4; n++ {
+		getCode(2, n)
+		if getCode(4, 6) != first {
+			t.Fatal("recently used code was evicted")
+		}
+	}
+	codeCacheLock.Lock()
+	size := len(codeCache)
+	codeCacheLock.Unlock()
+	if size > codeCacheSize {
+		t.Error("unexpected cache size:", size)
+	}
+}
+
+func TestBadConfig(t *testing.T) {
+	medium := sim.NewMedium()
+	for _, counts := range [][2]int{{0, 4}, {5, 4}, {10, 300}} {
+		h := medium.NewHandle(testAddr1)
+		_, err := NewSender(h, &Config{DataShards: counts[0], TotalShards: counts[1]})
+		if err != ErrBadShards {
+			t.Errorf("k=%d n=%d: unexpected error: %v", counts[0], counts[1], err)
+		}
+		h.Close()
+	}
+	h := medium.NewHandle(testAddr1)
+	sender, err := NewSender(h, &Config{Address: testAddr1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	if err := sender.Send(make([]byte, MaxPacketSize+1)); err != ErrTooLarge {
+		t.Error("unexpected error:", err)
+	}
+}
+
+func TestStream(t *testing.T) {
+	medium := sim.NewMedium()
+	sender, err := NewSender(medium.NewHandle(testAddr1), &Config{
+		Address:     testAddr1,
+		Protocol:    0x88b5,
+		DataShards:  4,
+		TotalShards: 6,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiver := NewReceiver([]gofi.Handle{medium.NewHandle(testAddr2)},
+		&Config{Protocol: 0x88b5})
+	defer receiver.Close()
+
+	packets := testPackets(10)
+	for _, packet := range packets {
+		if err := sender.Send(packet); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sender.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	sender.Close()
+	if err := sender.Send(packets[0]); err != gofi.ErrClosed {
+		t.Error("unexpected error after close:", err)
+	}
+
+	for i, expected := range packets {
+		actual := receiveTimeout(t, receiver)
+		if !bytes.Equal(actual, expected) {
+			t.Fatalf("packet %d: expected %q but got %q", i, expected, actual)
+		}
+	}
+	// Wait for the parity packets of the last block, the first of
+	// which reveals that the block was flushed early.
+	time.Sleep(time.Millisecond * 10)
+
+	stats := receiver.Stats()
+	if stats.BlocksComplete != 3 || stats.BlocksRecovered != 0 || stats.BlocksLost != 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	if radio := stats.Radios[0]; radio.Frames != 16 || radio.Used != 11 ||
+		radio.SignalPower != sim.DefaultSignalPower {
+		t.Errorf("unexpected radio stats: %+v", radio)
+	}
+
+	receiver.Close()
+	if _, err := receiver.Receive(); err != gofi.ErrClosed {
+		t.Error("unexpected error after close:", err)
+	}
+}
+
+func TestStreamLoss(t *testing.T) {
+	medium := sim.NewMedium()
+	medium.SetSeed(1337)
+	medium.SetLossRate(0.2)
+	sender, err := NewSender(medium.NewHandle(testAddr1), &Config{
+		Address:     testAddr1,
+		Protocol:    0x88b5,
+		DataShards:  8,
+		TotalShards: 12,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	receiver := NewReceiver([]gofi.Handle{
+		medium.NewHandle(testAddr2),
+		medium.NewHandle(testAddr3),
+	}, &Config{Protocol: 0x88b5})
+	defer receiver.Close()
+
+	// Send one block at a time, so that neither radio falls far
+	// behind the other, as if packets arrived in real time.
+	// With this seed, some blocks lose too many packets to be
+	// recovered by either radio alone.
+	packets := testPackets(200)
+	for i := 0; i < len(packets); i += 8 {
+		for _, packet := range packets[i : i+8] {
+			if err := sender.Send(packet); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for j, expected := range packets[i : i+8] {
+			actual := receiveTimeout(t, receiver)
+			if !bytes.Equal(actual, expected) {
+				t.Fatalf("packet %d: expected %q but got %q", i+j, expected, actual)
+			}
+		}
+	}
+
+	stats := receiver.Stats()
+	if stats.BlocksRecovered == 0 || stats.PacketsRecovered == 0 {
+		t.Error("nothing was recovered:", stats)
+	}
+	if stats.BlocksComplete+stats.BlocksRecovered != 25 || stats.BlocksLost != 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	for i, radio := range stats.Radios {
+		if radio.Used > radio.Frames {
+			t.Errorf("radio %d: unexpected stats: %+v", i, radio)
+		}
+	}
+}
+
+func TestStreamUnrecoverable(t *testing.T) {
+	medium := sim.NewMedium()
+	medium.SetSeed(1337)
+	medium.SetLossRate(0.5)
+	sender, err := NewSender(medium.NewHandle(testAddr1), &Config{
+		Address:     testAddr1,
+		Protocol:    0x88b5,
+		DataShards:  4,
+		TotalShards: 5,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	receiver := NewReceiver([]gofi.Handle{medium.NewHandle(testAddr2)},
+		&Config{Protocol: 0x88b5})
+	defer receiver.Close()
+
+	packets := testPackets(400)
+	for _, packet := range packets {
+		if err := sender.Send(packet); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sender.Flush()
+
+	// Packets should arrive in order, with gaps.
+	var received int
+	last := -1
+	for {
+		var packet []byte
+		select {
+		case packet = <-receiver.packets:
+		case <-time.After(time.Millisecond * 100):
+		}
+		if packet == nil {
+			break
+		}
+		var index int
+		fmt.Sscanf(string(packet), "packet %d", &index)
+		if index <= last {
+			t.Fatalf("packet %d after packet %d", index, last)
+		}
+		last = index
+		received++
+	}
+
+	stats := receiver.Stats()
+	if stats.BlocksLost == 0 || stats.PacketsLost == 0 {
+		t.Errorf("expected losses: %+v", stats)
+	}
+	if received < stats.BlocksComplete*4 || received+stats.PacketsLost > len(packets) {
+		t.Errorf("received %d packets with stats %+v", received, stats)
+	}
+}
+
+func testPackets(count int) [][]byte {
+	res := make([][]byte, count)
+	for i := range res {
+		res[i] = []byte(fmt.Sprintf("packet %d%s", i, bytes.Repeat([]byte{'!'}, i%50)))
+	}
+	return res
+}
+
+func receiveTimeout(t *testing.T, r *Receiver) []byte {
+	type result struct {
+		packet []byte
+		err    error
+	}
+	results := make(chan result, 1)
+	go func() {
+		packet, err := r.Receive()
+		results <- result{packet, err}
+	}()
+	select {
+	case res := <-results:
+		if res.err != nil {
+			t.Fatal(res.err)
+		}
+		return res.packet
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out")
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package broadcast
+
+import (
+	"errors"
+	"sync"
+)
+
+// maxShards is the largest number of shards in a block.
+// A Vandermonde matrix over GF(2^8) has at most 256 distinct rows,
+// and one value is reserved so that shard indices fit in a byte.
+const maxShards = 255
+
+// gfPoly is the primitive polynomial x^8+x^4+x^3+x^2+1, which
+// defines multiplication in GF(2^8).
+const gfPoly = 0x11d
+
+var (
+	gfExp [510]byte
+	gfLog [256]int
+)
+
+func init() {
+	x := 1
+	for i := 0; i < 255; i++ {
+		gfExp[i] = byte(x)
+		gfExp[i+255] = byte(x)
+		gfLog[x] = i
+		x <<= 1
+		if x&0x100 != 0 {
+			x ^= gfPoly
+		}
+	}
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return gfExp[gfLog[a]+gfLog[b]]
+}
+
+func gfInv(a byte) byte {
+	return gfExp[255-gfLog[a]]
+}
+
+// gfMulAdd adds c*src to dst.
+func gfMulAdd(dst, src []byte, c byte) {
+	if c == 0 {
+		return
+	}
+	logC := gfLog[c]
+	for i, x := range src {
+		if x != 0 {
+			dst[i] ^= gfExp[gfLog[x]+logC]
+		}
+	}
+}
+
+// A code is a systematic Reed-Solomon erasure code which turns k
+// data shards into n shards, any k of which are enough to recover
+// the data.
+type code struct {
+	k int
+	n int
+
+	// matrix has n rows of k coefficients.
+	// The first k rows are the identity matrix, so the first k
+	// shards are the data shards themselves.
+	matrix [][]byte
+}
+
+// codeCacheSize is the number of codes which getCode keeps.
+// It bounds the memory used for codes, since the parameters come
+// from received frames.
+const codeCacheSize = 8
+
+var (
+	codeCacheLock sync.Mutex
+
+	// codeCache holds the most recently used codes first.
+	codeCache []*code
+)
+
+// getCode returns the code for k data shards and n total shards.
+func getCode(k, n int) *code {
+	codeCacheLock.Lock()
+	defer codeCacheLock.Unlock()
+	for i, c := range codeCache {
+		if c.k == k && c.n == n {
+			copy(codeCache[1:i+1], codeCache[:i])
+			codeCache[0] = c
+			return c
+		}
+	}
+	c := newCode(k, n)
+	if len(codeCache) < codeCacheSize {
+		codeCache = append(codeCache, nil)
+	}
+	copy(codeCache[1:], codeCache)
+	codeCache[0] = c
+	return c
+}
+
+// newCode creates a code from a Vandermonde matrix, which is
+// multiplied by the inverse of its top k rows to make the code
+// systematic.
+// Every k rows of the result are still linearly independent.
+func newCode(k, n int) *code {
+	vandermonde := make([][]byte, n)
+	for i := range vandermonde {
+		vandermonde[i] = make([]byte, k)
+		x := byte(1)
+		for j := range vandermonde[i] {
+			vandermonde[i][j] = x
+			x = gfMul(x, byte(i))
+		}
+	}
+	top, err := invertMatrix(vandermonde[:k])
+	if err != nil {
+		panic("Vandermonde matrix is singular")
+	}
+	return &code{k: k, n: n, matrix: multiplyMatrices(vandermonde, top)}
+}
+
+// encode computes the n-k parity shards for k data shards, all of
+// which have the same length.
+func (c *code) encode(data [][]byte) [][]byte {
+	parity := make([][]byte, c.n-c.k)
+	for i := range parity {
+		parity[i] = make([]byte, len(data[0]))
+		for j, shard := range data {
+			gfMulAdd(parity[i], shard, c.matrix[c.k+i][j])
+		}
+	}
+	return parity
+}
+
+// reconstruct fills in the missing data shards, given a list of n
+// shards in which missing shards are nil.
+// At least k shards must be present, and all of them must have the
+// same length.
+func (c *code) reconstruct(shards [][]byte) error {
+	var rows [][]byte
+	var present [][]byte
+	for i, shard := range shards {
+		if shard != nil {
+			rows = append(rows, c.matrix[i])
+			present = append(present, shard)
+			if len(rows) == c.k {
+				break
+			}
+		}
+	}
+	if len(rows) < c.k {
+		return errors.New("not enough shards")
+	}
+	decode, err := invertMatrix(rows)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < c.k; i++ {
+		if shards[i] != nil {
+			continue
+		}
+		shard := make([]byte, len(present[0]))
+		for j, src := range present {
+			gfMulAdd(shard, src, decode[i][j])
+		}
+		shards[i] = shard
+	}
+	return nil
+}
+
+// invertMatrix inverts a square matrix with Gauss-Jordan
+// elimination.
+func invertMatrix(m [][]byte) ([][]byte, error) {
+	size := len(m)
+	work := make([][]byte, size)
+	for i, row := range m {
+		work[i] = make([]byte, size*2)
+		copy(work[i], row)
+		work[i][size+i] = 1
+	}
+	for col := 0; col < size; col++ {
+		pivot := col
+		for pivot < size && work[pivot][col] == 0 {
+			pivot++
+		}
+		if pivot == size {
+			return nil, errors.New("singular matrix")
+		}
+		work[col], work[pivot] = work[pivot], work[col]
+		scale := gfInv(work[col][col])
+		for i := range work[col] {
+			work[col][i] = gfMul(work[col][i], scale)
+		}
+		for row := 0; row < size; row++ {
+			if row != col {
+				gfMulAdd(work[row], work[col], work[row][col])
+			}
+		}
+	}
+	res := make([][]byte, size)
+	for i, row := range work {
+		res[i] = row[size:]
+	}
+	return res, nil
+}
+
+func multiplyMatrices(a, b [][]byte) [][]byte {
+	res := make([][]byte, len(a))
+	for i, row := range a {
+		res[i] = make([]byte, len(b[0]))
+		for j, x := range row {
+			gfMulAdd(res[i], b[j], x)
+		}
+	}
+	return res
+}
@@ -0,0 +1,395 @@
+package broadcast
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync"
+
+	"github.com/unixpickle/gofi"
+)
+
+// maxPendingBlocks is the number of blocks which a Receiver waits on
+// at once.
+// When a packet arrives from a block any further ahead, the oldest
+// blocks are given up on.
+const maxPendingBlocks = 16
+
+// restartDistance is how far behind the oldest pending block a packet
+// must be for the Receiver to assume that the Sender restarted.
+// Closer packets are assumed to be late copies from slower radios.
+const restartDistance = 1024
+
+// receiveQueueSize is the number of delivered packets which are kept
+// while nothing reads them.
+const receiveQueueSize = 256
+
+type block struct {
+	k         int
+	n         int
+	shards    [][]byte
+	count     int
+	delivered int
+	recovered int
+}
+
+// A Receiver rebuilds the stream of packets from a Sender using one or
+// more Handles.
+//
+// Packets are delivered in order.
+// Each data packet is delivered as soon as every packet before it has
+// been, so a block does not hold up its own packets unless some of
+// them are missing.
+// A block with missing packets is waited on until it can be
+// recovered, or until packets arrive from a block too far ahead of it,
+// in which case whatever remains of it is delivered and the rest is
+// counted as lost.
+//
+// Packets which are delivered faster than Receive is called are
+// queued, but once the queue is full, further packets are discarded
+// without being counted in the Stats.
+type Receiver struct {
+	handles []gofi.Handle
+	config  Config
+	packets chan []byte
+
+	lock         sync.Mutex
+	started      bool
+	next         uint32
+	blocks       map[uint32]*block
+	stats        Stats
+	signalSums   []int
+	signalCounts []int
+
+	closeLock sync.Mutex
+	closed    chan struct{}
+
+	// done is closed when every Handle has failed, after which err
+	// is set.
+	done    chan struct{}
+	err     error
+	running int
+}
+
+// NewReceiver creates a Receiver which owns the Handles and starts
+// receiving from all of them.
+// Closing the Receiver closes the Handles.
+//
+// Only the OUI and Protocol of the Config are used.
+// When a packet is received by more than one Handle, the first copy
+// with a valid checksum is used.
+func NewReceiver(handles []gofi.Handle, c *Config) *Receiver {
+	res := &Receiver{
+		handles:      handles,
+		config:       *c,
+		packets:      make(chan []byte, receiveQueueSize),
+		blocks:       map[uint32]*block{},
+		stats:        Stats{Radios: make([]RadioStats, len(handles))},
+		signalSums:   make([]int, len(handles)),
+		signalCounts: make([]int, len(handles)),
+		closed:       make(chan struct{}),
+		done:         make(chan struct{}),
+		running:      len(handles),
+	}
+	for i, h := range handles {
+		go res.receiveLoop(i, h)
+	}
+	return res
+}
+
+// Receive returns the next packet of the stream.
+//
+// If every Handle fails, the packets which were already delivered
+// are returned before the error of the last Handle to fail.
+func (r *Receiver) Receive() ([]byte, error) {
+	select {
+	case <-r.closed:
+		return nil, gofi.ErrClosed
+	default:
+	}
+	select {
+	case packet := <-r.packets:
+		return packet, nil
+	case <-r.closed:
+		return nil, gofi.ErrClosed
+	case <-r.done:
+		select {
+		case packet := <-r.packets:
+			return packet, nil
+		default:
+		}
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		return nil, r.err
+	}
+}
+
+// Stats returns statistics about the blocks and frames which have
+// been received so far.
+func (r *Receiver) Stats() Stats {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	res := r.stats
+	res.Radios = append([]RadioStats{}, r.stats.Radios...)
+	for i, count := range r.signalCounts {
+		if count > 0 {
+			res.Radios[i].SignalPower = r.signalSums[i] / count
+		}
+	}
+	return res
+}
+
+// Close closes the Receiver and its Handles.
+func (r *Receiver) Close() {
+	r.closeLock.Lock()
+	defer r.closeLock.Unlock()
+	select {
+	case <-r.closed:
+		return
+	default:
+	}
+	close(r.closed)
+	for _, h := range r.handles {
+		h.Close()
+	}
+}
+
+func (r *Receiver) receiveLoop(radio int, h gofi.Handle) {
+	for {
+		frame, info, err := h.Receive()
+		if err != nil {
+			r.lock.Lock()
+			defer r.lock.Unlock()
+			r.running--
+			if r.running == 0 {
+				r.err = err
+				close(r.done)
+			}
+			return
+		}
+		r.handleFrame(radio, frame, info)
+	}
+}
+
+func (r *Receiver) handleFrame(radio int, f gofi.Frame, info *gofi.RadioInfo) {
+	if info != nil && info.TxStatus != nil {
+		return
+	}
+	if f.Type() != gofi.FrameTypeData || f.Protected() || !f.HasBody() {
+		return
+	}
+	llc := r.config.llcHeader()
+	body := f.Body()
+	if !bytes.HasPrefix(body, llc) {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	stats := &r.stats.Radios[radio]
+	if !f.ChecksumValid() {
+		stats.Corrupt++
+		return
+	}
+	sh, ok := decodeShard(body[len(llc):])
+	if !ok {
+		stats.Corrupt++
+		return
+	}
+	stats.Frames++
+	if info != nil && info.SignalPower != 0 {
+		r.signalSums[radio] += info.SignalPower
+		r.signalCounts[radio]++
+	}
+	if r.addShard(sh) {
+		stats.Used++
+	}
+}
+
+// addShard adds a shard to its block and delivers whatever packets
+// it makes available, returning false if the shard was not needed.
+// The caller must hold r.lock.
+func (r *Receiver) addShard(sh *shard) bool {
+	if !r.started {
+		r.started = true
+		r.next = sh.block
+	}
+	offset := int32(sh.block - r.next)
+	if offset < 0 {
+		if offset > -restartDistance {
+			return false
+		}
+		r.abandon(r.next + maxPendingBlocks)
+		r.next = sh.block
+	} else if offset >= maxPendingBlocks {
+		r.abandon(sh.block - maxPendingBlocks + 1)
+	}
+
+	b, ok := r.blocks[sh.block]
+	if !ok {
+		b = &block{k: sh.k, n: sh.n, shards: make([][]byte, sh.n)}
+		r.blocks[sh.block] = b
+	} else if b.n-b.k != sh.n-sh.k {
+		return false
+	}
+	if sh.k < b.k {
+		b.shrink(sh.k)
+	}
+	pos := sh.index
+	if sh.index >= sh.k {
+		pos = b.k + sh.index - sh.k
+	} else if sh.index >= b.k {
+		return false
+	}
+	if b.shards[pos] != nil {
+		return false
+	}
+	b.shards[pos] = sh.data
+	b.count++
+	if b.count >= b.k {
+		r.reconstruct(b)
+	}
+	r.advance()
+	return true
+}
+
+// shrink reduces the number of data shards in a block which turned
+// out to be flushed early.
+//
+// The data packets of a flushed block carry the usual shard counts,
+// since the Sender did not know the block would be short when it sent
+// them, but its parity packets carry the actual counts.
+func (b *block) shrink(k int) {
+	for _, data := range b.shards[k:b.k] {
+		if data != nil {
+			// NOTE: this data shard cannot belong to the block,
+			// so it must have come from a corrupt frame.
+			b.count--
+		}
+	}
+	b.shards = append(b.shards[:k], b.shards[b.k:]...)
+	b.n -= b.k - k
+	b.k = k
+}
+
+// ready checks if every data packet of a block is available.
+func (b *block) ready() bool {
+	for _, data := range b.shards[:b.k] {
+		if data == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// reconstruct recovers the missing data packets of a block which has
+// enough shards, if any are missing.
+// The caller must hold r.lock.
+func (r *Receiver) reconstruct(b *block) {
+	if b.ready() {
+		return
+	}
+	size := -1
+	for _, data := range b.shards[b.k:] {
+		if data != nil {
+			size = len(data)
+			break
+		}
+	}
+	shards := make([][]byte, b.n)
+	for i, data := range b.shards {
+		if data == nil {
+			continue
+		}
+		if len(data) > size || (i >= b.k && len(data) != size) {
+			// NOTE: a corrupt or malicious frame could have any
+			// length, so the block simply cannot be recovered.
+			return
+		}
+		shards[i] = make([]byte, size)
+		copy(shards[i], data)
+	}
+	if err := getCode(b.k, b.n).reconstruct(shards); err != nil {
+		return
+	}
+	for i, data := range b.shards[:b.k] {
+		if data == nil {
+			b.shards[i] = shards[i]
+			b.recovered++
+		}
+	}
+	r.stats.PacketsRecovered += b.recovered
+}
+
+// advance delivers packets from the oldest pending blocks until it
+// reaches a missing packet.
+// The caller must hold r.lock.
+func (r *Receiver) advance() {
+	for {
+		b, ok := r.blocks[r.next]
+		if !ok {
+			return
+		}
+		for b.delivered < b.k && b.shards[b.delivered] != nil {
+			r.deliver(b.shards[b.delivered])
+			b.delivered++
+		}
+		if b.delivered < b.k {
+			return
+		}
+		if b.recovered > 0 {
+			r.stats.BlocksRecovered++
+		} else {
+			r.stats.BlocksComplete++
+		}
+		delete(r.blocks, r.next)
+		r.next++
+	}
+}
+
+// abandon gives up on every pending block before end, delivering the
+// packets which they do have.
+// The caller must hold r.lock.
+func (r *Receiver) abandon(end uint32) {
+	for int32(end-r.next) > 0 {
+		b, ok := r.blocks[r.next]
+		if !ok {
+			// Skip straight to the next block that anything was
+			// received from, since there may be a large gap.
+			skip := end
+			for index := range r.blocks {
+				if int32(index-r.next) > 0 && int32(index-skip) < 0 {
+					skip = index
+				}
+			}
+			r.stats.BlocksLost += int(skip - r.next)
+			r.next = skip
+			continue
+		}
+		for ; b.delivered < b.k; b.delivered++ {
+			if data := b.shards[b.delivered]; data != nil {
+				r.deliver(data)
+			} else {
+				r.stats.PacketsLost++
+			}
+		}
+		r.stats.BlocksLost++
+		delete(r.blocks, r.next)
+		r.next++
+		r.advance()
+	}
+}
+
+// deliver strips the length prefix and padding from a data shard and
+// queues the packet.
+// The caller must hold r.lock.
+func (r *Receiver) deliver(data []byte) {
+	size := int(binary.BigEndian.Uint16(data))
+	if size > len(data)-lengthSize {
+		r.stats.PacketsLost++
+		return
+	}
+	select {
+	case r.packets <- data[lengthSize : lengthSize+size]:
+	default:
+	}
+}
@@ -0,0 +1,158 @@
+package broadcast
+
+import (
+	"encoding/binary"
+	"sync"
+
+	"github.com/unixpickle/gofi"
+	"github.com/unixpickle/gofi/mpdu"
+)
+
+// A Sender broadcasts a stream of packets.
+//
+// Each packet is sent as soon as it is given to the Sender, and the
+// parity packets of a block are sent once its last data packet is.
+//
+// A Sender is safe to use from multiple Goroutines.
+type Sender struct {
+	handle gofi.Handle
+	mpdu   *mpdu.Sender
+	config Config
+	k      int
+	n      int
+
+	lock   sync.Mutex
+	block  uint32
+	shards [][]byte
+	closed bool
+}
+
+// NewSender creates a Sender which owns h.
+// Closing the Sender closes h.
+//
+// This fails with ErrBadShards if the shard counts in c are invalid.
+func NewSender(h gofi.Handle, c *Config) (*Sender, error) {
+	k, n, err := c.shardCounts()
+	if err != nil {
+		return nil, err
+	}
+	return &Sender{
+		handle: h,
+		mpdu:   mpdu.NewSender(),
+		config: *c,
+		k:      k,
+		n:      n,
+	}, nil
+}
+
+// Send broadcasts a packet.
+//
+// If the packet completes a block, the block's parity packets are
+// sent as well.
+func (s *Sender) Send(packet []byte) error {
+	if len(packet) > MaxPacketSize {
+		return ErrTooLarge
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return gofi.ErrClosed
+	}
+	data := make([]byte, lengthSize+len(packet))
+	binary.BigEndian.PutUint16(data, uint16(len(packet)))
+	copy(data[lengthSize:], packet)
+	s.shards = append(s.shards, data)
+	sh := &shard{block: s.block, index: len(s.shards) - 1, k: s.k, n: s.n, data: data}
+	err := s.mpdu.Send(s.handle, s.frame(sh), s.config.Rate)
+	if len(s.shards) == s.k {
+		if parityErr := s.finishBlock(); err == nil {
+			err = parityErr
+		}
+	}
+	return err
+}
+
+// Flush sends the parity packets for the current block, if it has
+// any data packets, and starts a new block.
+//
+// Receivers do not deliver the end of an incomplete block until more
+// of the stream arrives, so a Sender which pauses should be flushed
+// first.
+// The flushed block is shorter than usual, but it is followed by the
+// usual number of parity packets.
+func (s *Sender) Flush() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return gofi.ErrClosed
+	}
+	if len(s.shards) == 0 {
+		return nil
+	}
+	return s.finishBlock()
+}
+
+// Close closes the Sender and its Handle, without flushing the
+// current block.
+func (s *Sender) Close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.closed {
+		s.closed = true
+		s.handle.Close()
+	}
+}
+
+// finishBlock sends the parity packets of the current block and
+// starts the next one.
+// The caller must hold s.lock.
+func (s *Sender) finishBlock() error {
+	k := len(s.shards)
+	n := k + s.n - s.k
+
+	var maxLen int
+	for _, data := range s.shards {
+		if len(data) > maxLen {
+			maxLen = len(data)
+		}
+	}
+	padded := make([][]byte, k)
+	for i, data := range s.shards {
+		padded[i] = make([]byte, maxLen)
+		copy(padded[i], data)
+	}
+	parity := getCode(k, n).encode(padded)
+
+	frames := make([]gofi.Frame, len(parity))
+	for i, data := range parity {
+		sh := &shard{block: s.block, index: k + i, k: k, n: n, data: data}
+		frame, err := s.mpdu.Prepare(s.frame(sh))
+		if err != nil {
+			return err
+		}
+		frames[i] = frame[0]
+	}
+
+	s.block++
+	s.shards = nil
+
+	for _, err := range gofi.SendBatch(s.handle, frames, s.config.Rate) {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// frame creates a broadcast data frame which carries a shard.
+// The Sender's address is used as both the transmitter address and
+// the BSSID.
+func (s *Sender) frame(sh *shard) gofi.Frame {
+	header := []byte{byte(gofi.FrameTypeData)<<2 | gofi.SubtypeData<<4, 0, 0, 0}
+	header = append(header, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+	header = append(header, s.config.Address...)
+	header = append(header, s.config.Address...)
+	header = append(header, 0, 0)
+	body := append(s.config.llcHeader(), sh.encode()...)
+	return gofi.NewFrame(header, body)
+}
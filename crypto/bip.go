@@ -0,0 +1,206 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+
+	"github.com/unixpickle/gofi"
+)
+
+// An MME is the information in a Management MIC element, which
+// protects group addressed management frames.
+type MME struct {
+	KeyID int
+	IPN   uint64
+	MIC   []byte
+}
+
+// ParseMME decodes the data of a Management MIC element.
+// The MIC may be 8 or 16 bytes.
+func ParseMME(data []byte) (*MME, error) {
+	if len(data) != 16 && len(data) != 24 {
+		return nil, errors.New("invalid MME length")
+	}
+	var ipn uint64
+	for i := 7; i >= 2; i-- {
+		ipn = (ipn << 8) | uint64(data[i])
+	}
+	return &MME{
+		KeyID: int(binary.LittleEndian.Uint16(data)),
+		IPN:   ipn,
+		MIC:   data[8:],
+	}, nil
+}
+
+// Encode encodes the MME as the data of a Management MIC element.
+func (m *MME) Encode() []byte {
+	res := []byte{byte(m.KeyID), byte(m.KeyID >> 8)}
+	for i := 0; i < 6; i++ {
+		res = append(res, byte(m.IPN>>uint(8*i)))
+	}
+	return append(res, m.MIC...)
+}
+
+// bipMICSize returns the size of the MIC for a BIP cipher.
+func bipMICSize(c Cipher) int {
+	switch c {
+	case CipherBIPCMAC128:
+		return 8
+	case CipherBIPCMAC256, CipherBIPGMAC128, CipherBIPGMAC256:
+		return 16
+	default:
+		return 0
+	}
+}
+
+// FindMME finds the Management MIC element at the end of a group
+// addressed management frame.
+// It returns nil if the frame has no such element.
+func FindMME(f gofi.Frame) *MME {
+	if f.Type() != gofi.FrameTypeManagement {
+		return nil
+	}
+	body := f.Body()
+	for _, micSize := range []int{8, 16} {
+		size := 2 + 8 + micSize
+		if len(body) < size {
+			continue
+		}
+		element := body[len(body)-size:]
+		if element[0] == gofi.ElementMME && int(element[1]) == size-2 {
+			mme, _ := ParseMME(element[2:])
+			return mme
+		}
+	}
+	return nil
+}
+
+// VerifyBIP checks the Management MIC element of a group addressed
+// management frame against an IGTK.
+//
+// On success, the MME is returned so that the caller can check its
+// key ID and IPN.
+// If the frame has no MME, ErrNotProtected is returned.
+// If the MIC is wrong, ErrMICFailure is returned.
+func VerifyBIP(c Cipher, igtk []byte, f gofi.Frame) (*MME, error) {
+	if bipMICSize(c) == 0 || len(igtk) != c.KeySize() {
+		return nil, ErrUnsupportedCipher
+	}
+	mme := FindMME(f)
+	if mme == nil {
+		return nil, ErrNotProtected
+	}
+	if len(mme.MIC) != bipMICSize(c) {
+		return nil, ErrMICFailure
+	}
+	body := append([]byte{}, f.Body()...)
+	for i := len(body) - len(mme.MIC); i < len(body); i++ {
+		body[i] = 0
+	}
+	mic, err := bipMIC(c, igtk, f, mme.IPN, body)
+	if err != nil {
+		return nil, err
+	}
+	if subtle.ConstantTimeCompare(mic, mme.MIC) != 1 {
+		return nil, ErrMICFailure
+	}
+	return mme, nil
+}
+
+// ProtectBIP appends a Management MIC element to a group addressed
+// management frame.
+//
+// Callers must never reuse an IPN with the same IGTK.
+func ProtectBIP(c Cipher, igtk []byte, f gofi.Frame, ipn uint64, keyID int) (gofi.Frame,
+	error) {
+	micSize := bipMICSize(c)
+	if micSize == 0 || len(igtk) != c.KeySize() {
+		return nil, ErrUnsupportedCipher
+	}
+	if f.Body() == nil {
+		return nil, ErrFrameTruncated
+	}
+	mme := &MME{KeyID: keyID, IPN: ipn, MIC: make([]byte, micSize)}
+	body := append(append([]byte{}, f.Body()...), gofi.EncodeElements([]gofi.Element{{
+		ID:   gofi.ElementMME,
+		Data: mme.Encode(),
+	}})...)
+	mic, err := bipMIC(c, igtk, f, ipn, body)
+	if err != nil {
+		return nil, err
+	}
+	copy(body[len(body)-micSize:], mic)
+	return gofi.NewFrame(f[:f.HeaderLen()], body), nil
+}
+
+// bipMIC computes the MIC of a frame whose body ends with an MME
+// with a zero MIC.
+func bipMIC(c Cipher, igtk []byte, f gofi.Frame, ipn uint64, body []byte) ([]byte, error) {
+	fc1 := f[1] &^ (gofi.FlagRetry | gofi.FlagPowerMgmt | gofi.FlagMoreData)
+	aad := append([]byte{f[0], fc1}, f[4:22]...)
+	aad = append(aad, body...)
+
+	block, err := aes.NewCipher(igtk)
+	if err != nil {
+		return nil, err
+	}
+	switch c {
+	case CipherBIPCMAC128:
+		return cmacBlock(block, aad)[:8], nil
+	case CipherBIPCMAC256:
+		return cmacBlock(block, aad), nil
+	default:
+		gcm, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		nonce := append([]byte{}, f.Addr2()...)
+		for i := 5; i >= 0; i-- {
+			nonce = append(nonce, byte(ipn>>uint(8*i)))
+		}
+		return gcm.Seal(nil, nonce, nil, aad), nil
+	}
+}
+
+// IsRobustManagement checks if a management frame is one which
+// management frame protection applies to, namely a deauthentication,
+// disassociation, or robust action frame.
+func IsRobustManagement(f gofi.Frame) bool {
+	if f.Type() != gofi.FrameTypeManagement {
+		return false
+	}
+	switch f.Subtype() {
+	case gofi.SubtypeDeauth, gofi.SubtypeDisassoc:
+		return true
+	case gofi.SubtypeAction, gofi.SubtypeActionNoAck:
+		body := f.Body()
+		if len(body) == 0 {
+			return false
+		}
+		if f.Protected() {
+			return true
+		}
+		switch body[0] {
+		case actionPublic, actionHT, actionUnprotectedWNM, actionTDLS, actionSelfProtected,
+			actionUnprotectedDMG, actionVHT, actionVendorSpecific:
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+// These are the action frame categories which are not robust.
+const (
+	actionPublic         = 4
+	actionHT             = 7
+	actionUnprotectedWNM = 11
+	actionTDLS           = 12
+	actionSelfProtected  = 15
+	actionUnprotectedDMG = 20
+	actionVHT            = 21
+	actionVendorSpecific = 127
+)
@@ -0,0 +1,128 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+)
+
+// ccmNonceSize is the size of a CCMP nonce.
+const ccmNonceSize = 13
+
+// ccmLengthSize is the size of CCM's length field, which is 15
+// minus the nonce size.
+const ccmLengthSize = 15 - ccmNonceSize
+
+var errCCMAuth = errors.New("ccm: message authentication failed")
+
+// ccm implements AES-CCM, as used by CCMP, with a 13 byte nonce.
+// It implements cipher.AEAD.
+type ccm struct {
+	block   cipher.Block
+	tagSize int
+}
+
+func newCCM(key []byte, tagSize int) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &ccm{block: block, tagSize: tagSize}, nil
+}
+
+func (c *ccm) NonceSize() int {
+	return ccmNonceSize
+}
+
+func (c *ccm) Overhead() int {
+	return c.tagSize
+}
+
+func (c *ccm) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	tag := c.mac(nonce, plaintext, additionalData)
+	res := make([]byte, len(plaintext)+c.tagSize)
+	c.ctr(nonce, res, plaintext)
+	c.ctrTag(nonce, res[len(plaintext):], tag)
+	return append(dst, res...)
+}
+
+func (c *ccm) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < c.tagSize {
+		return nil, errCCMAuth
+	}
+	dataSize := len(ciphertext) - c.tagSize
+	plaintext := make([]byte, dataSize)
+	c.ctr(nonce, plaintext, ciphertext[:dataSize])
+	tag := make([]byte, c.tagSize)
+	c.ctrTag(nonce, tag, ciphertext[dataSize:])
+	if subtle.ConstantTimeCompare(tag, c.mac(nonce, plaintext, additionalData)) != 1 {
+		return nil, errCCMAuth
+	}
+	return append(dst, plaintext...), nil
+}
+
+// mac computes the unencrypted CBC-MAC of a message.
+func (c *ccm) mac(nonce, plaintext, additionalData []byte) []byte {
+	var state [aes.BlockSize]byte
+	state[0] = byte(((c.tagSize-2)/2)<<3 | (ccmLengthSize - 1))
+	if len(additionalData) > 0 {
+		state[0] |= 0x40
+	}
+	copy(state[1:], nonce)
+	binary.BigEndian.PutUint16(state[aes.BlockSize-ccmLengthSize:], uint16(len(plaintext)))
+	c.block.Encrypt(state[:], state[:])
+
+	if len(additionalData) > 0 {
+		header := make([]byte, 2+len(additionalData))
+		binary.BigEndian.PutUint16(header, uint16(len(additionalData)))
+		copy(header[2:], additionalData)
+		c.cbc(&state, header)
+	}
+	c.cbc(&state, plaintext)
+	return state[:c.tagSize]
+}
+
+// cbc feeds data through CBC-MAC, padding it with zeroes.
+func (c *ccm) cbc(state *[aes.BlockSize]byte, data []byte) {
+	for len(data) > 0 {
+		n := len(data)
+		if n > aes.BlockSize {
+			n = aes.BlockSize
+		}
+		xorBytes(state[:], data[:n])
+		c.block.Encrypt(state[:], state[:])
+		data = data[n:]
+	}
+}
+
+// ctr encrypts or decrypts data with counters starting at 1.
+func (c *ccm) ctr(nonce, dst, src []byte) {
+	var counter, stream [aes.BlockSize]byte
+	counter[0] = ccmLengthSize - 1
+	copy(counter[1:], nonce)
+	for i := 0; i < len(src); i += aes.BlockSize {
+		binary.BigEndian.PutUint16(counter[aes.BlockSize-ccmLengthSize:],
+			uint16(i/aes.BlockSize+1))
+		c.block.Encrypt(stream[:], counter[:])
+		end := i + aes.BlockSize
+		if end > len(src) {
+			end = len(src)
+		}
+		for j := i; j < end; j++ {
+			dst[j] = src[j] ^ stream[j-i]
+		}
+	}
+}
+
+// ctrTag encrypts or decrypts a tag with counter 0.
+func (c *ccm) ctrTag(nonce, dst, src []byte) {
+	var counter, stream [aes.BlockSize]byte
+	counter[0] = ccmLengthSize - 1
+	copy(counter[1:], nonce)
+	c.block.Encrypt(stream[:], counter[:])
+	for i := range src {
+		dst[i] = src[i] ^ stream[i]
+	}
+}
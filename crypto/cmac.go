@@ -0,0 +1,61 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+)
+
+// cmac computes an AES-CMAC, as described in RFC 4493.
+func cmac(key, message []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cmacBlock(block, message), nil
+}
+
+func cmacBlock(block cipher.Block, message []byte) []byte {
+	var k1, k2 [aes.BlockSize]byte
+	block.Encrypt(k1[:], k1[:])
+	cmacDouble(&k1)
+	k2 = k1
+	cmacDouble(&k2)
+
+	var state [aes.BlockSize]byte
+	for len(message) > aes.BlockSize {
+		xorBytes(state[:], message[:aes.BlockSize])
+		block.Encrypt(state[:], state[:])
+		message = message[aes.BlockSize:]
+	}
+
+	var last [aes.BlockSize]byte
+	copy(last[:], message)
+	if len(message) == aes.BlockSize {
+		xorBytes(last[:], k1[:])
+	} else {
+		last[len(message)] = 0x80
+		xorBytes(last[:], k2[:])
+	}
+	xorBytes(state[:], last[:])
+	block.Encrypt(state[:], state[:])
+	return state[:]
+}
+
+// cmacDouble multiplies a subkey by x in GF(2^128).
+func cmacDouble(b *[aes.BlockSize]byte) {
+	carry := b[0] >> 7
+	for i := 0; i < aes.BlockSize-1; i++ {
+		b[i] = (b[i] << 1) | (b[i+1] >> 7)
+	}
+	b[aes.BlockSize-1] <<= 1
+	if carry != 0 {
+		b[aes.BlockSize-1] ^= 0x87
+	}
+}
+
+// xorBytes xors src into dst.
+func xorBytes(dst, src []byte) {
+	for i, x := range src {
+		dst[i] ^= x
+	}
+}
@@ -0,0 +1,333 @@
+// Package crypto decrypts protected 802.11 frames.
+//
+// A Decrypter watches EAPOL 4-way handshakes and uses known
+// passphrases or PMKs to derive the keys which each station uses,
+// much like the decryption keys feature of Wireshark.
+package crypto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	ErrNoKey             = errors.New("no key for frame")
+	ErrDecryptionFailed  = errors.New("frame could not be decrypted")
+	ErrUnsupportedCipher = errors.New("unsupported cipher suite")
+	ErrNotProtected      = errors.New("frame is not protected")
+	ErrFrameTruncated    = errors.New("frame is truncated")
+	ErrMICFailure        = errors.New("MIC verification failed")
+	ErrReplay            = errors.New("replayed frame")
+)
+
+// A Cipher is a cipher suite type, from the suite selector
+// 00-0F-AC:<type>.
+type Cipher int
+
+const (
+	CipherNone       Cipher = 0
+	CipherWEP40      Cipher = 1
+	CipherTKIP       Cipher = 2
+	CipherCCMP128    Cipher = 4
+	CipherWEP104     Cipher = 5
+	CipherBIPCMAC128 Cipher = 6
+	CipherGCMP128    Cipher = 8
+	CipherGCMP256    Cipher = 9
+	CipherCCMP256    Cipher = 10
+	CipherBIPGMAC128 Cipher = 11
+	CipherBIPGMAC256 Cipher = 12
+	CipherBIPCMAC256 Cipher = 13
+)
+
+// String returns the name of the cipher, such as "CCMP-128".
+func (c Cipher) String() string {
+	switch c {
+	case CipherNone:
+		return "none"
+	case CipherWEP40:
+		return "WEP-40"
+	case CipherTKIP:
+		return "TKIP"
+	case CipherCCMP128:
+		return "CCMP-128"
+	case CipherWEP104:
+		return "WEP-104"
+	case CipherBIPCMAC128:
+		return "BIP-CMAC-128"
+	case CipherGCMP128:
+		return "GCMP-128"
+	case CipherGCMP256:
+		return "GCMP-256"
+	case CipherCCMP256:
+		return "CCMP-256"
+	case CipherBIPGMAC128:
+		return "BIP-GMAC-128"
+	case CipherBIPGMAC256:
+		return "BIP-GMAC-256"
+	case CipherBIPCMAC256:
+		return "BIP-CMAC-256"
+	default:
+		return "unknown"
+	}
+}
+
+// KeySize returns the size of a temporal key for the cipher, in bytes.
+// It returns 0 for unknown ciphers.
+func (c Cipher) KeySize() int {
+	switch c {
+	case CipherWEP40:
+		return 5
+	case CipherWEP104:
+		return 13
+	case CipherTKIP:
+		return 32
+	case CipherCCMP128, CipherGCMP128, CipherBIPCMAC128, CipherBIPGMAC128:
+		return 16
+	case CipherCCMP256, CipherGCMP256, CipherBIPGMAC256, CipherBIPCMAC256:
+		return 32
+	default:
+		return 0
+	}
+}
+
+// An AKM is an authentication and key management suite type, from the
+// suite selector 00-0F-AC:<type>.
+type AKM int
+
+const (
+	AKMUnspecified AKM = 0
+	AKM8021X       AKM = 1
+	AKMPSK         AKM = 2
+	AKMFT8021X     AKM = 3
+	AKMFTPSK       AKM = 4
+	AKM8021XSHA256 AKM = 5
+	AKMPSKSHA256   AKM = 6
+	AKMSAE         AKM = 8
+	AKMFTSAE       AKM = 9
+	AKMSuiteB      AKM = 11
+	AKMSuiteB192   AKM = 12
+	AKMOWE         AKM = 18
+	AKMFTPSKSHA384 AKM = 19
+	AKMPSKSHA384   AKM = 20
+	AKMSAEExtKey   AKM = 24
+	AKMFTSAEExtKey AKM = 25
+)
+
+// These are the key and MIC sizes for AKMs which use SHA-384.
+const (
+	akmSHA384KCKSize = 24
+	akmSHA384KEKSize = 32
+	akmSHA384MICSize = 24
+)
+
+// usesSHA384 checks if the AKM derives keys with SHA-384 and uses
+// longer KCKs, KEKs, and MICs.
+func (a AKM) usesSHA384() bool {
+	return a == AKMSuiteB192 || a == AKMFTPSKSHA384 || a == AKMPSKSHA384
+}
+
+// usesSHA256 checks if the AKM derives keys with SHA-256 rather than
+// the SHA-1 PRF.
+//
+// NOTE: fast transition AKMs derive keys through a key hierarchy
+// which is not supported, so they are treated like their non-FT
+// counterparts.
+func (a AKM) usesSHA256() bool {
+	switch a {
+	case AKM8021XSHA256, AKMPSKSHA256, AKMSAE, AKMFTSAE, AKMSuiteB, AKMOWE,
+		AKMSAEExtKey, AKMFTSAEExtKey:
+		return true
+	}
+	return false
+}
+
+// MICSize returns the size of the MIC in EAPOL-Key frames.
+func (a AKM) MICSize() int {
+	if a.usesSHA384() {
+		return akmSHA384MICSize
+	}
+	return 16
+}
+
+var (
+	rsnOUI = []byte{0x00, 0x0f, 0xac}
+	wpaOUI = []byte{0x00, 0x50, 0xf2}
+)
+
+// wpaElementType is the vendor specific type of the WPA element.
+const wpaElementType = 1
+
+// These are bits of the RSN capabilities field.
+const (
+	RSNCapMFPRequired = 0x0040
+	RSNCapMFPCapable  = 0x0080
+)
+
+// An RSNInfo is the information in an RSN element.
+type RSNInfo struct {
+	GroupCipher        Cipher
+	PairwiseCiphers    []Cipher
+	AKMs               []AKM
+	Capabilities       uint16
+	PMKIDs             [][]byte
+	GroupMgmtCipher    Cipher
+	HasCapabilities    bool
+	HasGroupMgmtCipher bool
+}
+
+// ParseRSN decodes the data of an RSN element.
+// Suites from other vendors are decoded as CipherNone or AKMUnspecified.
+func ParseRSN(data []byte) (*RSNInfo, error) {
+	return parseRSN(data, rsnOUI, CipherCCMP128)
+}
+
+// ParseWPA decodes the data of a pre-standard WPA element, which is a
+// vendor specific element with the OUI 00-50-F2 and type 1.
+// The OUI and type are included in data.
+//
+// WPA suites use the OUI 00-50-F2 and are decoded into the RSN suites
+// with the same types, so WPA with TKIP decodes as CipherTKIP and
+// AKMPSK.
+func ParseWPA(data []byte) (*RSNInfo, error) {
+	if len(data) < 4 || !bytes.Equal(data[:3], wpaOUI) || data[3] != wpaElementType {
+		return nil, errors.New("not a WPA element")
+	}
+	return parseRSN(data[4:], wpaOUI, CipherTKIP)
+}
+
+func parseRSN(data, oui []byte, defaultCipher Cipher) (*RSNInfo, error) {
+	if len(data) < 2 || binary.LittleEndian.Uint16(data) != 1 {
+		return nil, errors.New("unsupported RSN element version")
+	}
+	data = data[2:]
+	res := &RSNInfo{GroupCipher: defaultCipher, GroupMgmtCipher: CipherBIPCMAC128}
+
+	suite := func() (int, bool) {
+		if len(data) < 4 {
+			return 0, false
+		}
+		s := data[:4]
+		data = data[4:]
+		if !bytes.Equal(s[:3], oui) {
+			return 0, true
+		}
+		return int(s[3]), true
+	}
+	count := func() int {
+		if len(data) < 2 {
+			return -1
+		}
+		n := int(binary.LittleEndian.Uint16(data))
+		data = data[2:]
+		return n
+	}
+
+	if len(data) == 0 {
+		return res, nil
+	}
+	if s, ok := suite(); !ok {
+		return nil, ErrFrameTruncated
+	} else {
+		res.GroupCipher = Cipher(s)
+	}
+
+	if len(data) == 0 {
+		return res, nil
+	}
+	n := count()
+	if n < 0 {
+		return nil, ErrFrameTruncated
+	}
+	for i := 0; i < n; i++ {
+		s, ok := suite()
+		if !ok {
+			return nil, ErrFrameTruncated
+		}
+		res.PairwiseCiphers = append(res.PairwiseCiphers, Cipher(s))
+	}
+
+	if len(data) == 0 {
+		return res, nil
+	}
+	n = count()
+	if n < 0 {
+		return nil, ErrFrameTruncated
+	}
+	for i := 0; i < n; i++ {
+		s, ok := suite()
+		if !ok {
+			return nil, ErrFrameTruncated
+		}
+		res.AKMs = append(res.AKMs, AKM(s))
+	}
+
+	if len(data) < 2 {
+		return res, nil
+	}
+	res.Capabilities = binary.LittleEndian.Uint16(data)
+	res.HasCapabilities = true
+	data = data[2:]
+
+	if len(data) < 2 {
+		return res, nil
+	}
+	n = count()
+	for i := 0; i < n; i++ {
+		if len(data) < 16 {
+			return nil, ErrFrameTruncated
+		}
+		res.PMKIDs = append(res.PMKIDs, data[:16])
+		data = data[16:]
+	}
+
+	if s, ok := suite(); ok {
+		res.GroupMgmtCipher = Cipher(s)
+		res.HasGroupMgmtCipher = true
+	}
+	return res, nil
+}
+
+// Encode encodes the information as the data of an RSN element.
+func (r *RSNInfo) Encode() []byte {
+	return r.encode(rsnOUI)
+}
+
+// EncodeWPA encodes the information as the data of a WPA element,
+// including its OUI and type.
+// Capabilities, PMKIDs, and the group management cipher are omitted.
+func (r *RSNInfo) EncodeWPA() []byte {
+	res := append(append([]byte{}, wpaOUI...), wpaElementType)
+	info := &RSNInfo{GroupCipher: r.GroupCipher, PairwiseCiphers: r.PairwiseCiphers, AKMs: r.AKMs}
+	return append(res, info.encode(wpaOUI)...)
+}
+
+func (r *RSNInfo) encode(oui []byte) []byte {
+	res := []byte{1, 0}
+	res = append(res, oui...)
+	res = append(res, byte(r.GroupCipher))
+	res = append(res, byte(len(r.PairwiseCiphers)), 0)
+	for _, c := range r.PairwiseCiphers {
+		res = append(res, oui...)
+		res = append(res, byte(c))
+	}
+	res = append(res, byte(len(r.AKMs)), 0)
+	for _, a := range r.AKMs {
+		res = append(res, oui...)
+		res = append(res, byte(a))
+	}
+	if r.HasCapabilities || len(r.PMKIDs) > 0 || r.HasGroupMgmtCipher {
+		res = append(res, byte(r.Capabilities), byte(r.Capabilities>>8))
+	}
+	if len(r.PMKIDs) > 0 || r.HasGroupMgmtCipher {
+		res = append(res, byte(len(r.PMKIDs)), 0)
+		for _, id := range r.PMKIDs {
+			res = append(res, id...)
+		}
+	}
+	if r.HasGroupMgmtCipher {
+		res = append(res, oui...)
+		res = append(res, byte(r.GroupMgmtCipher))
+	}
+	return res
+}
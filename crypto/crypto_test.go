@@ -0,0 +1,730 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rc4"
+	"encoding/hex"
+	"net"
+	"testing"
+
+	"github.com/unixpickle/gofi"
+	"github.com/unixpickle/gofi/eapol"
+)
+
+var (
+	testAP  = net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	testSTA = net.HardwareAddr{0x02, 0, 0, 0, 0, 2}
+
+	testANonce = bytes.Repeat([]byte{1}, 32)
+	testSNonce = bytes.Repeat([]byte{2}, 32)
+)
+
+func TestPassphrasePMK(t *testing.T) {
+	// Test vector from IEEE 802.11-2016 J.4.2.
+	pmk := PassphrasePMK("password", "IEEE")
+	expected := "f42c6fc52df0ebef9ebb4b90b38a5f902e83fe1b135a70e23aed762e9710a12e"
+	if hex.EncodeToString(pmk) != expected {
+		t.Errorf("unexpected PMK: %x", pmk)
+	}
+}
+
+func TestCMAC(t *testing.T) {
+	// Test vectors from RFC 4493.
+	key := testHex("2b7e151628aed2a6abf7158809cf4f3c")
+	vectors := []struct {
+		message  string
+		expected string
+	}{
+		{"", "bb1d6929e95937287fa37d129b756746"},
+		{"6bc1bee22e409f96e93d7e117393172a", "070a16b46b4d4144f79bdd9dd04a287c"},
+	}
+	for _, v := range vectors {
+		mac, err := cmac(key, testHex(v.message))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(mac) != v.expected {
+			t.Errorf("message %s: expected %s but got %x", v.message, v.expected, mac)
+		}
+	}
+}
+
+func TestKeyWrap(t *testing.T) {
+	// Test vector from RFC 3394 4.1.
+	kek := testHex("000102030405060708090a0b0c0d0e0f")
+	data := testHex("00112233445566778899aabbccddeeff")
+	expected := "1fa68b0a8112b447aef34bd8fb5a7b829d3e862371d2cfe5"
+
+	wrapped, err := KeyWrap(kek, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(wrapped) != expected {
+		t.Errorf("unexpected wrapped key: %x", wrapped)
+	}
+	unwrapped, err := KeyUnwrap(kek, wrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(unwrapped, data) {
+		t.Errorf("unexpected unwrapped key: %x", unwrapped)
+	}
+	wrapped[3] ^= 1
+	if _, err := KeyUnwrap(kek, wrapped); err == nil {
+		t.Error("corrupted key was unwrapped")
+	}
+}
+
+func TestCCMPVector(t *testing.T) {
+	// Test vector from IEEE 802.11-2016 J.6.4.
+	key := testHex("c97c1f67ce371185514a8a19f2bdd52f")
+	header := testHex("0848c32c0fd2e128a57c5030f1844408abaea5b8fcba8033")
+	plaintext := testHex("f8ba1a55d02f85ae967bb62fb6cda8eb7e78a050")
+	expected := "0ce70020769703b5f3d0a2fe9a3dbf2342a643e43246e80c3c04d0197845ce0b16f97623"
+
+	encrypted, err := EncryptFrame(CipherCCMP128, key, gofi.NewFrame(header, plaintext),
+		0xb5039776e70c, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(encrypted.Body()) != expected {
+		t.Errorf("unexpected body: %x", encrypted.Body())
+	}
+
+	decrypted, err := DecryptFrame(CipherCCMP128, key, encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted.Protected() || !bytes.Equal(decrypted.Body(), plaintext) {
+		t.Errorf("unexpected decrypted frame: %x", decrypted)
+	}
+}
+
+func TestAEADVectors(t *testing.T) {
+	// These vectors protect the frame of the J.6.4 vector with the
+	// other ciphers.
+	// They were computed with OpenSSL, from a nonce and AAD which
+	// were built separately from this package.
+	header := testHex("0848c32c0fd2e128a57c5030f1844408abaea5b8fcba8033")
+	plaintext := testHex("f8ba1a55d02f85ae967bb62fb6cda8eb7e78a050")
+	vectors := []struct {
+		cipher   Cipher
+		key      string
+		expected string
+	}{
+		{
+			CipherCCMP256,
+			"202122232425262728292a2b2c2d2e2f303132333435363738393a3b3c3d3e3f",
+			"0ce70020769703b5351e320eb149b603af3ec55f2d4ae9dc366fac4c53f94243c3a3c07c" +
+				"2caf11cbcac6fc7e",
+		},
+		{
+			CipherGCMP128,
+			"101112131415161718191a1b1c1d1e1f",
+			"0ce70020769703b5e398d1b84810f4dbf1fbc60cc295395a91e718321fc2a04d54b2f2eb" +
+				"9e0a539a422f7798",
+		},
+		{
+			CipherGCMP256,
+			"202122232425262728292a2b2c2d2e2f303132333435363738393a3b3c3d3e3f",
+			"0ce70020769703b5d94123e9c5b336210c8e6f6b595f994439206d67e5b7ff05d21a3bf6" +
+				"973c8aeb495a538a",
+		},
+	}
+	for _, v := range vectors {
+		key := testHex(v.key)
+		encrypted, err := EncryptFrame(v.cipher, key, gofi.NewFrame(header, plaintext),
+			0xb5039776e70c, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(encrypted.Body()) != v.expected {
+			t.Errorf("%v: unexpected body: %x", v.cipher, encrypted.Body())
+		}
+		decrypted, err := DecryptFrame(v.cipher, key, gofi.NewFrame(header,
+			testHex(v.expected)))
+		if err != nil {
+			t.Fatalf("%v: %v", v.cipher, err)
+		}
+		if !bytes.Equal(decrypted.Body(), plaintext) {
+			t.Errorf("%v: unexpected decrypted body: %x", v.cipher, decrypted.Body())
+		}
+	}
+}
+
+func TestMichael(t *testing.T) {
+	// Test vectors from IEEE 802.11-2016 M.6.3, where each key is the
+	// result of the previous vector.
+	vectors := []struct {
+		message  string
+		expected string
+	}{
+		{"", "82925c1ca1d130b8"},
+		{"M", "434721ca40639b3f"},
+		{"Mi", "e8f9becae97e5d29"},
+		{"Mic", "90038fc6cf13c1db"},
+		{"Mich", "d55e100510128986"},
+		{"Michael", "0a942b124ecaa546"},
+	}
+	key := make([]byte, 8)
+	for _, v := range vectors {
+		mic := michael(key, []byte(v.message))
+		if hex.EncodeToString(mic) != v.expected {
+			t.Errorf("message %q: expected %s but got %x", v.message, v.expected, mic)
+		}
+		key = testHex(v.expected)
+	}
+}
+
+func TestTKIPMixKey(t *testing.T) {
+	// Test vector from IEEE 802.11-2016 M.6.2.
+	tk := testHex("000102030405060708090a0b0c0d0e0f")
+	ta := testHex("102233445566")
+	key := tkipMixKey(tk, ta, 0)
+	if hex.EncodeToString(key) != "00200033ea8d2f60ca6d1374234a660b" {
+		t.Errorf("unexpected key: %x", key)
+	}
+}
+
+func TestLegacyCiphers(t *testing.T) {
+	frames := []gofi.Frame{
+		testDataFrame(testSTA, testAP, []byte("hello, station")),
+		testDataFrame(testAP, testSTA, []byte("hello, AP")),
+	}
+	ciphers := []Cipher{CipherWEP40, CipherWEP104, CipherTKIP}
+	for _, c := range ciphers {
+		key := make([]byte, c.KeySize())
+		for i := range key {
+			key[i] = byte(i * 7)
+		}
+		for _, f := range frames {
+			encrypted, err := EncryptFrame(c, key, f, 0x123456, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.Contains(encrypted, f.Body()) {
+				t.Errorf("%v: plaintext was not encrypted", c)
+			}
+			decrypted, err := DecryptFrame(c, key, encrypted)
+			if err != nil {
+				t.Fatalf("%v: %v", c, err)
+			}
+			if !bytes.Equal(decrypted, f) {
+				t.Errorf("%v: unexpected decrypted frame: %x", c, decrypted)
+			}
+
+			encrypted[len(encrypted)-8] ^= 1
+			if _, err := DecryptFrame(c, key, encrypted); err != ErrDecryptionFailed {
+				t.Errorf("%v: expected ErrDecryptionFailed but got %v", c, err)
+			}
+		}
+	}
+}
+
+func TestTKIPMICFailure(t *testing.T) {
+	key := bytes.Repeat([]byte{5}, CipherTKIP.KeySize())
+	f := testDataFrame(testSTA, testAP, []byte("hello, station"))
+	encrypted, err := EncryptFrame(CipherTKIP, key, f, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Using the wrong MIC key leaves the ICV intact.
+	wrongKey := append([]byte{}, key...)
+	wrongKey[16] ^= 1
+	if _, err := DecryptFrame(CipherTKIP, wrongKey, encrypted); err != ErrMICFailure {
+		t.Errorf("expected ErrMICFailure but got %v", err)
+	}
+}
+
+func TestDecrypterWEP(t *testing.T) {
+	d := NewDecrypter()
+	d.AddWEPKey([]byte("wrong"))
+	d.AddWEPKey([]byte("0123456789abc"))
+	f := testDataFrame(testSTA, testAP, []byte("hello, station"))
+	encrypted, err := EncryptFrame(CipherWEP104, []byte("0123456789abc"), f, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decrypted, err := d.Process(encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, f) {
+		t.Errorf("unexpected decrypted frame: %x", decrypted)
+	}
+}
+
+func TestDecrypterHandshake(t *testing.T) {
+	suites := []struct {
+		akm     AKM
+		version int
+		cipher  Cipher
+	}{
+		{AKMPSK, keyDescriptorVersionSHA1, CipherCCMP128},
+		{AKMPSKSHA256, keyDescriptorVersionCMAC, CipherCCMP256},
+		{AKMPSK, keyDescriptorVersionSHA1, CipherGCMP128},
+		{AKMSAE, keyDescriptorVersionAKM, CipherGCMP256},
+		{AKMPSK, keyDescriptorVersionMD5, CipherTKIP},
+	}
+	for _, suite := range suites {
+		d := NewDecrypter()
+		d.AddPassphrase("OtherNetwork", "wrong password")
+		pmk := PassphrasePMK("correct horse", "TestNetwork")
+		if suite.akm == AKMSAE {
+			d.AddPMK(pmk)
+		} else {
+			d.AddPassphrase("TestNetwork", "correct horse")
+		}
+
+		ptk := DerivePTK(pmk, suite.akm, suite.cipher, testAP, testSTA, testANonce, testSNonce)
+		gtk := bytes.Repeat([]byte{3}, suite.cipher.KeySize())
+		rsn := &RSNInfo{
+			GroupCipher:     suite.cipher,
+			PairwiseCiphers: []Cipher{suite.cipher},
+			AKMs:            []AKM{suite.akm},
+		}
+		gtkKDE := append([]byte{eapol.KDEGTK, 1, 0}, gtk...)
+		messages := testHandshake(ptk, suite.version, suite.akm, rsn, gtkKDE)
+
+		data := testDataFrame(testSTA, testAP, []byte("hello, station"))
+		encrypted, err := EncryptFrame(suite.cipher, ptk.TK, data, 1, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := d.Process(encrypted); err != ErrNoKey {
+			t.Errorf("%v: expected ErrNoKey but got %v", suite.cipher, err)
+		}
+
+		for _, m := range messages {
+			if _, err := d.Process(m); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if p := d.PTK(testAP, testSTA); p == nil || !bytes.Equal(p.TK, ptk.TK) {
+			t.Fatalf("%v: unexpected PTK: %v", suite.cipher, p)
+		}
+
+		decrypted, err := d.Process(encrypted)
+		if err != nil {
+			t.Fatalf("%v: %v", suite.cipher, err)
+		}
+		if !bytes.Equal(decrypted, data) {
+			t.Errorf("%v: unexpected decrypted frame: %x", suite.cipher, decrypted)
+		}
+
+		broadcast := net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+		groupData := testDataFrame(broadcast, testAP, []byte("hello, everyone"))
+		encrypted, err = EncryptFrame(suite.cipher, gtk, groupData, 1, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decrypted, err := d.Process(encrypted); err != nil {
+			t.Errorf("%v: could not decrypt group frame: %v", suite.cipher, err)
+		} else if !bytes.Equal(decrypted, groupData) {
+			t.Errorf("%v: unexpected decrypted frame: %x", suite.cipher, decrypted)
+		}
+
+		encrypted[len(encrypted)-8] ^= 1
+		expected := ErrDecryptionFailed
+		if suite.cipher == CipherTKIP {
+			// The frame reuses the TSC of the last group frame.
+			expected = ErrReplay
+		}
+		if _, err := d.Process(encrypted); err != expected {
+			t.Errorf("%v: expected %v but got %v", suite.cipher, expected, err)
+		}
+	}
+}
+
+func TestDecrypterWPA(t *testing.T) {
+	d := NewDecrypter()
+	d.AddPassphrase("TestNetwork", "correct horse")
+	pmk := PassphrasePMK("correct horse", "TestNetwork")
+
+	anonce, snonce := testANonce, testSNonce
+	ptk := DerivePTK(pmk, AKMPSK, CipherTKIP, testAP, testSTA, anonce, snonce)
+	gtk := bytes.Repeat([]byte{3}, CipherTKIP.KeySize())
+	wpa := (&RSNInfo{
+		GroupCipher:     CipherTKIP,
+		PairwiseCiphers: []Cipher{CipherTKIP},
+		AKMs:            []AKM{AKMPSK},
+	}).EncodeWPA()
+	if info, err := ParseWPA(wpa); err != nil {
+		t.Fatal(err)
+	} else if info.GroupCipher != CipherTKIP || len(info.AKMs) != 1 ||
+		info.AKMs[0] != AKMPSK {
+		t.Fatalf("unexpected WPA info: %+v", info)
+	}
+	wpaElement := gofi.EncodeElements([]gofi.Element{{
+		ID:   gofi.ElementVendorSpecific,
+		Data: wpa,
+	}})
+
+	info := eapol.KeyInfo(keyDescriptorVersionMD5)
+	messages := []gofi.Frame{
+		testKeyFrame(true, eapol.DescriptorWPA, info|eapol.KeyInfoPairwise|eapol.KeyInfoAck, 1, anonce, nil,
+			nil, AKMPSK),
+		testKeyFrame(false, eapol.DescriptorWPA, info|eapol.KeyInfoPairwise|eapol.KeyInfoMIC, 1, snonce,
+			wpaElement, ptk.KCK, AKMPSK),
+		testKeyFrame(true, eapol.DescriptorWPA, info|eapol.KeyInfoPairwise|eapol.KeyInfoAck|eapol.KeyInfoMIC|
+			eapol.KeyInfoInstall, 2, anonce, wpaElement, ptk.KCK, AKMPSK),
+		testKeyFrame(true, eapol.DescriptorWPA, info|eapol.KeyInfoAck|eapol.KeyInfoMIC|eapol.KeyInfoSecure|(1<<4),
+			3, anonce, testRC4KeyData(ptk.KEK, gtk), ptk.KCK, AKMPSK),
+	}
+	for _, m := range messages {
+		if _, err := d.Process(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data := testDataFrame(testAP, testSTA, []byte("hello, AP"))
+	for _, tsc := range []uint64{1, 2, 0x10002} {
+		encrypted, err := EncryptFrame(CipherTKIP, ptk.TK, data, tsc, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decrypted, err := d.Process(encrypted); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(decrypted, data) {
+			t.Errorf("unexpected decrypted frame: %x", decrypted)
+		}
+	}
+	replayed, _ := EncryptFrame(CipherTKIP, ptk.TK, data, 0x10001, 0)
+	if _, err := d.Process(replayed); err != ErrReplay {
+		t.Errorf("expected ErrReplay but got %v", err)
+	}
+
+	broadcast := net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	groupData := testDataFrame(broadcast, testAP, []byte("hello, everyone"))
+	encrypted, err := EncryptFrame(CipherTKIP, gtk, groupData, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decrypted, err := d.Process(encrypted); err != nil {
+		t.Errorf("could not decrypt group frame: %v", err)
+	} else if !bytes.Equal(decrypted, groupData) {
+		t.Errorf("unexpected decrypted frame: %x", decrypted)
+	}
+}
+
+func TestBIP(t *testing.T) {
+	broadcast := net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	deauth := testManagementFrame(gofi.SubtypeDeauth, broadcast, testAP, []byte{7, 0})
+	ciphers := []Cipher{CipherBIPCMAC128, CipherBIPCMAC256, CipherBIPGMAC128,
+		CipherBIPGMAC256}
+	for _, c := range ciphers {
+		igtk := bytes.Repeat([]byte{4}, c.KeySize())
+		protected, err := ProtectBIP(c, igtk, deauth, 0x0102030405, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		mme, err := VerifyBIP(c, igtk, protected)
+		if err != nil {
+			t.Fatalf("%v: %v", c, err)
+		}
+		if mme.KeyID != 4 || mme.IPN != 0x0102030405 || len(mme.MIC) != bipMICSize(c) {
+			t.Errorf("%v: unexpected MME: %+v", c, mme)
+		}
+		if parsed, err := ParseMME(mme.Encode()); err != nil ||
+			parsed.IPN != mme.IPN || !bytes.Equal(parsed.MIC, mme.MIC) {
+			t.Errorf("%v: MME did not survive encoding: %+v", c, parsed)
+		}
+
+		// The retry flag is not covered by the MIC.
+		protected[1] |= gofi.FlagRetry
+		if _, err := VerifyBIP(c, igtk, protected); err != nil {
+			t.Errorf("%v: retry flag broke MIC: %v", c, err)
+		}
+		protected[gofi.Frame(protected).HeaderLen()] ^= 1
+		if _, err := VerifyBIP(c, igtk, protected); err != ErrMICFailure {
+			t.Errorf("%v: expected ErrMICFailure but got %v", c, err)
+		}
+		if _, err := VerifyBIP(c, igtk, deauth); err != ErrNotProtected {
+			t.Errorf("%v: expected ErrNotProtected but got %v", c, err)
+		}
+	}
+}
+
+func TestBIPVectors(t *testing.T) {
+	// These vectors were computed with OpenSSL, from a MIC input which
+	// was built separately from this package.
+	header := testHex("c0000000ffffffffffff0200000000010200000000011000")
+	body := []byte{7, 0}
+	vectors := []struct {
+		cipher   Cipher
+		key      string
+		expected string
+	}{
+		{
+			CipherBIPCMAC128,
+			"101112131415161718191a1b1c1d1e1f",
+			"07004c100400050403020100b92dc9e115e122ff",
+		},
+		{
+			CipherBIPCMAC256,
+			"202122232425262728292a2b2c2d2e2f303132333435363738393a3b3c3d3e3f",
+			"07004c1804000504030201008de4d2533fdac66c1dce01ec7c8d0f05",
+		},
+		{
+			CipherBIPGMAC128,
+			"101112131415161718191a1b1c1d1e1f",
+			"07004c1804000504030201002b4c8ce150a6d776a8fa87fcebb6a3a9",
+		},
+		{
+			CipherBIPGMAC256,
+			"202122232425262728292a2b2c2d2e2f303132333435363738393a3b3c3d3e3f",
+			"07004c180400050403020100b2a626fb68937549aae7410bc5dcf08d",
+		},
+	}
+	for _, v := range vectors {
+		key := testHex(v.key)
+		protected, err := ProtectBIP(v.cipher, key, gofi.NewFrame(header, body), 0x0102030405, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(protected.Body()) != v.expected {
+			t.Errorf("%v: unexpected body: %x", v.cipher, protected.Body())
+		}
+		if _, err := VerifyBIP(v.cipher, key, gofi.NewFrame(header,
+			testHex(v.expected))); err != nil {
+			t.Errorf("%v: %v", v.cipher, err)
+		}
+	}
+}
+
+func TestDecrypterPMF(t *testing.T) {
+	suites := []struct {
+		cipher     Cipher
+		mgmtCipher Cipher
+	}{
+		{CipherCCMP128, CipherBIPCMAC128},
+		{CipherGCMP256, CipherBIPGMAC256},
+	}
+	for _, suite := range suites {
+		d := NewDecrypter()
+		d.AddPassphrase("TestNetwork", "correct horse")
+		pmk := PassphrasePMK("correct horse", "TestNetwork")
+		ptk := DerivePTK(pmk, AKMPSKSHA256, suite.cipher, testAP, testSTA, testANonce,
+			testSNonce)
+		rsn := &RSNInfo{
+			GroupCipher:        suite.cipher,
+			PairwiseCiphers:    []Cipher{suite.cipher},
+			AKMs:               []AKM{AKMPSKSHA256},
+			Capabilities:       0xc0,
+			GroupMgmtCipher:    suite.mgmtCipher,
+			HasGroupMgmtCipher: true,
+		}
+		igtk := bytes.Repeat([]byte{4}, suite.mgmtCipher.KeySize())
+		igtkKDE := append([]byte{eapol.KDEIGTK, 5, 0, 10, 0, 0, 0, 0, 0}, igtk...)
+		for _, m := range testHandshake(ptk, keyDescriptorVersionAKM, AKMPSKSHA256, rsn, igtkKDE) {
+			if _, err := d.Process(m); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		broadcast := net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+		deauth := testManagementFrame(gofi.SubtypeDeauth, broadcast, testAP, []byte{7, 0})
+		res := d.VerifyManagement(deauth)
+		if !res.Robust || !res.Group || res.Protected || res.Err != ErrNotProtected {
+			t.Errorf("%v: unexpected result for unprotected frame: %+v", suite.cipher, res)
+		}
+
+		beacon := testManagementFrame(gofi.SubtypeBeacon, broadcast, testAP, make([]byte, 12))
+		if res := d.VerifyManagement(beacon); res.Robust || res.Err != nil {
+			t.Errorf("%v: unexpected result for beacon: %+v", suite.cipher, res)
+		}
+
+		for _, ipn := range []uint64{10, 11} {
+			protected, err := ProtectBIP(suite.mgmtCipher, igtk, deauth, ipn, 5)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res := d.VerifyManagement(protected)
+			expected := ErrReplay
+			if ipn == 11 {
+				expected = nil
+			}
+			if res.Err != expected || res.KeyID != 5 || res.PN != ipn || !res.Protected {
+				t.Errorf("%v: unexpected result for IPN %d: %+v", suite.cipher, ipn, res)
+			}
+		}
+		wrongKey, _ := ProtectBIP(suite.mgmtCipher, make([]byte, len(igtk)), deauth, 12, 5)
+		if res := d.VerifyManagement(wrongKey); res.Err != ErrMICFailure {
+			t.Errorf("%v: expected ErrMICFailure but got %v", suite.cipher, res.Err)
+		}
+
+		action := testManagementFrame(gofi.SubtypeAction, testSTA, testAP, []byte{8, 0, 1, 2})
+		encrypted, err := EncryptFrame(suite.cipher, ptk.TK, action, 1, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res = d.VerifyManagement(encrypted)
+		if res.Err != nil || !res.Robust || res.Group || !res.Protected || res.PN != 1 {
+			t.Errorf("%v: unexpected result for unicast frame: %+v", suite.cipher, res)
+		} else if !bytes.Equal(res.Frame, action) {
+			t.Errorf("%v: unexpected decrypted frame: %x", suite.cipher, res.Frame)
+		}
+		if res := d.VerifyManagement(action); res.Err != ErrNotProtected {
+			t.Errorf("%v: expected ErrNotProtected but got %v", suite.cipher, res.Err)
+		}
+	}
+}
+
+// testHandshake creates the messages of a 4-way handshake, the last
+// of which carries an RSN element and some KDEs.
+// Each KDE starts with its data type.
+func testHandshake(ptk *PTK, version int, akm AKM, rsn *RSNInfo, kdes ...[]byte) []gofi.Frame {
+	rsnElement := gofi.EncodeElements([]gofi.Element{{ID: gofi.ElementRSN, Data: rsn.Encode()}})
+	keyData := append([]byte{}, rsnElement...)
+	for _, kde := range kdes {
+		keyData = append(keyData, gofi.EncodeElements([]gofi.Element{{
+			ID:   gofi.ElementVendorSpecific,
+			Data: append([]byte{0x00, 0x0f, 0xac}, kde...),
+		}})...)
+	}
+	keyData = append(keyData, 0xdd)
+	for len(keyData)%8 != 0 {
+		keyData = append(keyData, 0)
+	}
+	var encrypted []byte
+	if version == keyDescriptorVersionMD5 {
+		encrypted = testRC4KeyData(ptk.KEK, keyData)
+	} else {
+		var err error
+		encrypted, err = KeyWrap(ptk.KEK, keyData)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	info := eapol.KeyInfo(version) | eapol.KeyInfoPairwise
+	return []gofi.Frame{
+		testKeyFrame(true, eapol.DescriptorRSN, info|eapol.KeyInfoAck, 1, testANonce, nil, nil, akm),
+		testKeyFrame(false, eapol.DescriptorRSN, info|eapol.KeyInfoMIC, 1, testSNonce, rsnElement,
+			ptk.KCK, akm),
+		testKeyFrame(true, eapol.DescriptorRSN, info|eapol.KeyInfoAck|eapol.KeyInfoMIC|eapol.KeyInfoInstall|
+			eapol.KeyInfoSecure|eapol.KeyInfoEncryptedData, 2, testANonce, encrypted, ptk.KCK, akm),
+	}
+}
+
+func testKeyFrame(fromAP bool, descriptor int, info eapol.KeyInfo, replay uint64, nonce,
+	keyData, kck []byte, akm AKM) gofi.Frame {
+	key := &eapol.Key{
+		Descriptor:    descriptor,
+		Info:          info,
+		Length:        16,
+		ReplayCounter: replay,
+		Nonce:         nonce,
+		Data:          keyData,
+	}
+	packet := &eapol.Packet{Version: 2, Type: eapol.TypeKey, Body: key.Encode()}
+	if kck != nil {
+		key.MIC = KeyMIC(info.Version(), akm, kck, packet.Encode())
+		packet.Body = key.Encode()
+	}
+
+	payload := packet.EncodeLLC()
+	if fromAP {
+		return testDataFrame(testSTA, testAP, payload)
+	}
+	return testDataFrame(testAP, testSTA, payload)
+}
+
+// testRC4KeyData encrypts key data for a key frame with a zero key IV.
+func testRC4KeyData(kek, keyData []byte) []byte {
+	c, err := rc4.NewCipher(append(make([]byte, 16), kek...))
+	if err != nil {
+		panic(err)
+	}
+	res := make([]byte, 256+len(keyData))
+	copy(res[256:], keyData)
+	c.XORKeyStream(res, res)
+	return res[256:]
+}
+
+func TestSAE(t *testing.T) {
+	for _, password := range []string{"password", "wrong"} {
+		sta, err := NewSAE("password", testSTA, testAP)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ap, err := NewSAE(password, testAP, testSTA)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !sta.curve.IsOnCurve(sta.pweX, sta.pweY) {
+			t.Fatal("password element is not on the curve")
+		}
+		if password == "password" && (sta.pweX.Cmp(ap.pweX) != 0 || sta.pweY.Cmp(ap.pweY) != 0) {
+			t.Fatal("password elements differ")
+		}
+
+		// The AP ignores the anti-clogging token.
+		if err := ap.HandleCommit(sta.Commit([]byte("token"))); err != nil {
+			t.Fatal(err)
+		}
+		if err := sta.HandleCommit(ap.Commit(nil)); err != nil {
+			t.Fatal(err)
+		}
+		if err := sta.HandleCommit(ap.Commit(nil)); err != ErrSAEState {
+			t.Error("unexpected error:", err)
+		}
+		errSTA := sta.HandleConfirm(ap.Confirm())
+		errAP := ap.HandleConfirm(sta.Confirm())
+		if password != "password" {
+			if errSTA != ErrSAEConfirm || errAP != ErrSAEConfirm || sta.PMK() != nil {
+				t.Error("wrong password was accepted")
+			}
+			continue
+		}
+		if errSTA != nil || errAP != nil {
+			t.Fatal(errSTA, errAP)
+		}
+		if len(sta.PMK()) != PMKSize || !bytes.Equal(sta.PMK(), ap.PMK()) ||
+			!bytes.Equal(sta.PMKID(), ap.PMKID()) || len(sta.PMKID()) != 16 {
+			t.Error("unexpected PMK or PMKID")
+		}
+	}
+
+	sta, _ := NewSAE("password", testSTA, testAP)
+	if err := sta.HandleCommit(sta.Commit(nil)); err != ErrSAECommit {
+		t.Error("reflected commit was accepted:", err)
+	}
+	commit := sta.Commit(nil)
+	commit[0] = 20
+	if err := sta.HandleCommit(commit); err != ErrSAEGroup {
+		t.Error("unexpected error:", err)
+	}
+}
+
+// testManagementFrame creates a management frame in the test BSS.
+func testManagementFrame(subtype int, to, from net.HardwareAddr, body []byte) gofi.Frame {
+	header := []byte{byte(subtype << 4), 0, 0, 0}
+	header = append(header, to...)
+	header = append(header, from...)
+	header = append(header, testAP...)
+	header = append(header, 0x20, 0)
+	return gofi.NewFrame(header, body)
+}
+
+// testDataFrame creates a QoS data frame between an AP and a station.
+func testDataFrame(to, from net.HardwareAddr, payload []byte) gofi.Frame {
+	header := []byte{0x88, gofi.FlagFromDS, 0, 0}
+	if bytes.Equal(to, testAP) {
+		header[1] = gofi.FlagToDS
+	}
+	header = append(header, to...)
+	header = append(header, from...)
+	header = append(header, testAP...)
+	header = append(header, 0x10, 0, 5, 0)
+	return gofi.NewFrame(header, payload)
+}
+
+func testHex(s string) []byte {
+	res, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
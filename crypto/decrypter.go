@@ -0,0 +1,515 @@
+package crypto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"sync"
+
+	"github.com/unixpickle/gofi"
+	"github.com/unixpickle/gofi/eapol"
+)
+
+// A Decrypter tracks the keys of every station it sees complete a
+// 4-way handshake, and uses them to decrypt protected frames.
+//
+// A Decrypter is safe to use from multiple Goroutines.
+type Decrypter struct {
+	lock      sync.Mutex
+	pmks      [][]byte
+	wepKeys   [][]byte
+	sessions  map[string]*session
+	groupKeys map[string]map[int]*groupKey
+}
+
+// A session is the state of a station's association with an AP.
+type session struct {
+	ap  net.HardwareAddr
+	sta net.HardwareAddr
+
+	akm             AKM
+	cipher          Cipher
+	groupCipher     Cipher
+	groupMgmtCipher Cipher
+
+	anonce []byte
+	snonce []byte
+
+	// message2 is the most recent second message of the handshake,
+	// which is used to check candidate PMKs.
+	message2 *eapolKey
+
+	ptk *PTK
+
+	// tscs is the last TKIP sequence counter seen for each TID.
+	tscs map[int]uint64
+}
+
+type groupKey struct {
+	cipher Cipher
+	key    []byte
+	tscs   map[int]uint64
+
+	// ipn is the last IPN of an IGTK.
+	ipn uint64
+}
+
+// NewDecrypter creates a Decrypter with no keys.
+func NewDecrypter() *Decrypter {
+	return &Decrypter{
+		sessions:  map[string]*session{},
+		groupKeys: map[string]map[int]*groupKey{},
+	}
+}
+
+// AddPassphrase adds a WPA/WPA2 personal network passphrase.
+func (d *Decrypter) AddPassphrase(ssid, passphrase string) {
+	d.AddPMK(PassphrasePMK(passphrase, ssid))
+}
+
+// AddPMK adds a pairwise master key, such as the PMK from an SAE
+// exchange or from an 802.1X server.
+//
+// Every PMK is tried against every handshake, and the one which
+// produces a valid MIC is used.
+func (d *Decrypter) AddPMK(pmk []byte) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.pmks = append(d.pmks, append([]byte{}, pmk...))
+}
+
+// AddWEPKey adds a static WEP key, which may be 5 or 13 bytes.
+//
+// WEP frames do not say which key they use, so every WEP key is
+// tried, and the one which produces a valid ICV is used.
+func (d *Decrypter) AddWEPKey(key []byte) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.wepKeys = append(d.wepKeys, append([]byte{}, key...))
+}
+
+// PTK returns the PTK which was derived for a station, or nil if
+// the station's keys are unknown.
+func (d *Decrypter) PTK(ap, sta net.HardwareAddr) *PTK {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if s, ok := d.sessions[sessionKey(ap, sta)]; ok {
+		return s.ptk
+	}
+	return nil
+}
+
+// Process observes a frame and decrypts it if possible.
+//
+// Unprotected frames are returned as they are.
+// If a frame is protected but cannot be decrypted, it is returned as
+// it is, along with an error.
+func (d *Decrypter) Process(f gofi.Frame) (gofi.Frame, error) {
+	if !f.Protected() {
+		d.Observe(f)
+		return f, nil
+	}
+	plain, err := d.Decrypt(f)
+	if err != nil {
+		return f, err
+	}
+	d.Observe(plain)
+	return plain, nil
+}
+
+// Observe looks for EAPOL-Key frames and uses them to derive keys.
+// Frames which are not EAPOL-Key frames are ignored.
+func (d *Decrypter) Observe(f gofi.Frame) {
+	key, ok := parseEAPOLKey(f)
+	if !ok || (key.Descriptor != eapol.DescriptorRSN && key.Descriptor != eapol.DescriptorWPA) {
+		return
+	}
+
+	var ap, sta net.HardwareAddr
+	if key.Info.Has(eapol.KeyInfoAck) {
+		ap, sta = f.Addr2(), f.Addr1()
+	} else {
+		ap, sta = f.Addr1(), f.Addr2()
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	s := d.session(ap, sta)
+	if !key.Info.Has(eapol.KeyInfoPairwise) {
+		if key.Info.Has(eapol.KeyInfoAck | eapol.KeyInfoMIC) {
+			d.handleGroupMessage(s, key)
+		}
+		return
+	}
+
+	switch {
+	case key.Info.Has(eapol.KeyInfoAck) && !key.Info.Has(eapol.KeyInfoMIC):
+		s.anonce = append([]byte{}, key.Nonce...)
+		s.snonce = nil
+		s.message2 = nil
+	case !key.Info.Has(eapol.KeyInfoAck) && key.Info.Has(eapol.KeyInfoMIC) && !key.Info.Has(eapol.KeyInfoSecure) &&
+		!allZero(key.Nonce):
+		s.snonce = append([]byte{}, key.Nonce...)
+		s.message2 = key
+		s.akm, s.cipher, s.groupCipher = defaultSuites(key.Info.Version())
+		s.groupMgmtCipher = CipherBIPCMAC128
+		if rsn := findRSN(key.Data); rsn != nil {
+			if len(rsn.AKMs) > 0 {
+				s.akm = rsn.AKMs[0]
+			}
+			if len(rsn.PairwiseCiphers) > 0 {
+				s.cipher = rsn.PairwiseCiphers[0]
+			}
+			s.groupCipher = rsn.GroupCipher
+			s.groupMgmtCipher = rsn.GroupMgmtCipher
+		}
+		d.derivePTK(s)
+	case key.Info.Has(eapol.KeyInfoAck | eapol.KeyInfoMIC | eapol.KeyInfoInstall):
+		if !bytes.Equal(s.anonce, key.Nonce) {
+			s.anonce = append([]byte{}, key.Nonce...)
+			d.derivePTK(s)
+		}
+		d.handleGroupMessage(s, key)
+	}
+}
+
+// Decrypt decrypts a protected frame using the keys that have been
+// derived so far.
+//
+// TKIP frames whose TSC is not greater than that of the last frame
+// decrypted with the same key and TID are rejected with ErrReplay.
+// NOTE: this includes retransmissions, which a monitor interface
+// may well capture.
+func (d *Decrypter) Decrypt(f gofi.Frame) (gofi.Frame, error) {
+	if !f.Protected() {
+		return nil, ErrNotProtected
+	}
+	addr1, addr2 := f.Addr1(), f.Addr2()
+	if addr2 == nil {
+		return nil, ErrFrameTruncated
+	}
+	body := f.Body()
+	if len(body) < wepHeaderSize {
+		return nil, ErrFrameTruncated
+	}
+	if (body[3] & extIVFlag) == 0 {
+		return d.decryptWEP(f)
+	}
+	keyID := int(body[3] >> 6)
+
+	d.lock.Lock()
+	var c Cipher
+	var key []byte
+	var tscs map[int]uint64
+	if (addr1[0] & 1) != 0 {
+		if g, ok := d.groupKeys[addr2.String()][keyID]; ok {
+			c, key, tscs = g.cipher, g.key, g.tscs
+		}
+	} else {
+		s, ok := d.sessions[sessionKey(addr2, addr1)]
+		if !ok || s.ptk == nil {
+			s, ok = d.sessions[sessionKey(addr1, addr2)]
+		}
+		if ok && s.ptk != nil {
+			c, key, tscs = s.cipher, s.ptk.TK, s.tscs
+		}
+	}
+	d.lock.Unlock()
+
+	if key == nil {
+		return nil, ErrNoKey
+	}
+	if c != CipherTKIP {
+		return DecryptFrame(c, key, f)
+	}
+
+	tsc, _, err := tkipTSC(f)
+	if err != nil {
+		return nil, err
+	}
+	tid := f.TID()
+	d.lock.Lock()
+	last, ok := tscs[tid]
+	d.lock.Unlock()
+	if ok && tsc <= last {
+		return nil, ErrReplay
+	}
+	res, err := DecryptFrame(c, key, f)
+	if err != nil {
+		return nil, err
+	}
+	d.lock.Lock()
+	if last, ok := tscs[tid]; !ok || tsc > last {
+		tscs[tid] = tsc
+	}
+	d.lock.Unlock()
+	return res, nil
+}
+
+// A ManagementResult is the outcome of checking the protection of a
+// management frame.
+type ManagementResult struct {
+	// Frame is the frame, decrypted if it was a protected individually
+	// addressed frame and it could be decrypted.
+	Frame gofi.Frame
+
+	// Robust is true for frames which should be protected once
+	// management frame protection is in use: deauthentication,
+	// disassociation, and robust action frames.
+	Robust bool
+
+	// Group is true if the frame is group addressed, in which case
+	// it is protected by BIP rather than encrypted.
+	Group bool
+
+	// Protected is true if the frame is encrypted or has an MME.
+	Protected bool
+
+	// KeyID and PN are the key ID and packet number (or IPN) of a
+	// protected frame.
+	KeyID int
+	PN    uint64
+
+	// Err is nil if the frame was protected and verified, or if it is
+	// not a robust frame and was not protected.
+	// Robust frames without protection yield ErrNotProtected.
+	Err error
+}
+
+// VerifyManagement checks the protection of a management frame.
+// Other kinds of frames are reported as unprotected and not robust.
+//
+// Group addressed frames are checked against the IGTKs delivered in
+// handshakes, and are rejected with ErrReplay if their IPN is not
+// greater than that of the last frame verified with the same IGTK.
+// Individually addressed frames are decrypted with the PTK.
+func (d *Decrypter) VerifyManagement(f gofi.Frame) *ManagementResult {
+	res := &ManagementResult{Frame: f, Robust: IsRobustManagement(f)}
+	if f.Type() != gofi.FrameTypeManagement {
+		return res
+	}
+	addr1, addr2 := f.Addr1(), f.Addr2()
+	if addr2 == nil || f.Body() == nil {
+		res.Err = ErrFrameTruncated
+		return res
+	}
+
+	if (addr1[0] & 1) == 0 {
+		if !f.Protected() {
+			if res.Robust {
+				res.Err = ErrNotProtected
+			}
+			return res
+		}
+		res.Protected = true
+		res.PN, res.KeyID, res.Err = FramePN(f)
+		if res.Err != nil {
+			return res
+		}
+		plain, err := d.Decrypt(f)
+		if err != nil {
+			res.Err = err
+			return res
+		}
+		res.Frame = plain
+		return res
+	}
+
+	res.Group = true
+	mme := FindMME(f)
+	if mme == nil {
+		if res.Robust {
+			res.Err = ErrNotProtected
+		}
+		return res
+	}
+	res.Protected = true
+	res.KeyID, res.PN = mme.KeyID, mme.IPN
+
+	d.lock.Lock()
+	g, ok := d.groupKeys[addr2.String()][mme.KeyID]
+	var lastIPN uint64
+	if ok {
+		lastIPN = g.ipn
+	}
+	d.lock.Unlock()
+
+	if !ok || bipMICSize(g.cipher) == 0 {
+		res.Err = ErrNoKey
+		return res
+	}
+	if mme.IPN <= lastIPN {
+		res.Err = ErrReplay
+		return res
+	}
+	if _, err := VerifyBIP(g.cipher, g.key, f); err != nil {
+		res.Err = err
+		return res
+	}
+	d.lock.Lock()
+	if mme.IPN > g.ipn {
+		g.ipn = mme.IPN
+	}
+	d.lock.Unlock()
+	return res
+}
+
+// decryptWEP tries every WEP key on a frame.
+func (d *Decrypter) decryptWEP(f gofi.Frame) (gofi.Frame, error) {
+	d.lock.Lock()
+	keys := d.wepKeys
+	d.lock.Unlock()
+	if len(keys) == 0 {
+		return nil, ErrNoKey
+	}
+	for _, key := range keys {
+		if res, err := decryptWEP(key, f); err == nil {
+			return res, nil
+		} else if err != ErrDecryptionFailed {
+			return nil, err
+		}
+	}
+	return nil, ErrDecryptionFailed
+}
+
+func (d *Decrypter) session(ap, sta net.HardwareAddr) *session {
+	key := sessionKey(ap, sta)
+	s, ok := d.sessions[key]
+	if !ok {
+		s = &session{
+			ap:  append(net.HardwareAddr{}, ap...),
+			sta: append(net.HardwareAddr{}, sta...),
+		}
+		d.sessions[key] = s
+	}
+	return s
+}
+
+// derivePTK tries every PMK against the second message of a handshake.
+func (d *Decrypter) derivePTK(s *session) {
+	if s.anonce == nil || s.snonce == nil || s.message2 == nil {
+		return
+	}
+	for _, pmk := range d.pmks {
+		ptk := DerivePTK(pmk, s.akm, s.cipher, s.ap, s.sta, s.anonce, s.snonce)
+		if s.message2.VerifyMIC(s.akm, ptk.KCK) {
+			s.ptk = ptk
+			s.tscs = map[int]uint64{}
+			return
+		}
+	}
+}
+
+// handleGroupMessage extracts the GTK from the third message of a
+// 4-way handshake or from the first message of a group key handshake.
+func (d *Decrypter) handleGroupMessage(s *session, key *eapolKey) {
+	if s.ptk == nil || !key.VerifyMIC(s.akm, s.ptk.KCK) {
+		return
+	}
+	// NOTE: WPA group key messages encrypt their key data without
+	// setting the encrypted key data bit.
+	wpaGroup := key.Descriptor == eapol.DescriptorWPA && !key.Info.Has(eapol.KeyInfoPairwise)
+
+	keyData := key.Data
+	if key.Info.Has(eapol.KeyInfoEncryptedData) || (wpaGroup && len(keyData) > 0) {
+		var err error
+		keyData, err = key.DecryptData(s.ptk.KEK)
+		if err != nil {
+			return
+		}
+	}
+	if rsn := findRSN(keyData); rsn != nil {
+		s.groupCipher = rsn.GroupCipher
+		s.groupMgmtCipher = rsn.GroupMgmtCipher
+	}
+
+	if wpaGroup {
+		// WPA key data is the bare GTK.
+		if size := s.groupCipher.KeySize(); size > 0 && len(keyData) >= size {
+			d.addGroupKey(s.ap, key.Info.Index(), s.groupCipher, keyData[:size])
+		}
+		return
+	}
+	for _, gtk := range kdes(keyData, eapol.KDEGTK) {
+		if len(gtk) < 2 {
+			continue
+		}
+		d.addGroupKey(s.ap, int(gtk[0]&3), s.groupCipher, gtk[2:])
+	}
+	for _, igtk := range kdes(keyData, eapol.KDEIGTK) {
+		if len(igtk) < 8 {
+			continue
+		}
+		keyID := int(binary.LittleEndian.Uint16(igtk))
+		g := d.addGroupKey(s.ap, keyID, s.groupMgmtCipher, igtk[8:])
+		for i := 7; i >= 2; i-- {
+			g.ipn = (g.ipn << 8) | uint64(igtk[i])
+		}
+	}
+}
+
+// addGroupKey adds a GTK or an IGTK.
+// NOTE: GTKs use key IDs 0 through 3 and IGTKs use 4 and 5, so both
+// kinds of keys share a map.
+func (d *Decrypter) addGroupKey(ap net.HardwareAddr, keyID int, c Cipher,
+	key []byte) *groupKey {
+	keys, ok := d.groupKeys[ap.String()]
+	if !ok {
+		keys = map[int]*groupKey{}
+		d.groupKeys[ap.String()] = keys
+	}
+	g := &groupKey{
+		cipher: c,
+		key:    append([]byte{}, key...),
+		tscs:   map[int]uint64{},
+	}
+	keys[keyID] = g
+	return g
+}
+
+// defaultSuites guesses the AKM and ciphers of a handshake from its
+// key descriptor version, for when there is no RSN element.
+func defaultSuites(version int) (AKM, Cipher, Cipher) {
+	switch version {
+	case keyDescriptorVersionMD5:
+		return AKMPSK, CipherTKIP, CipherTKIP
+	case keyDescriptorVersionCMAC:
+		return AKMPSKSHA256, CipherCCMP128, CipherCCMP128
+	case keyDescriptorVersionAKM:
+		return AKMSAE, CipherCCMP128, CipherCCMP128
+	default:
+		return AKMPSK, CipherCCMP128, CipherCCMP128
+	}
+}
+
+// findRSN finds an RSN element or, failing that, a WPA element.
+func findRSN(keyData []byte) *RSNInfo {
+	elements, _ := gofi.ParseElements(keyData)
+	if e := gofi.FindElement(elements, gofi.ElementRSN); e != nil {
+		if rsn, err := ParseRSN(e.Data); err == nil {
+			return rsn
+		}
+	}
+	for _, e := range elements {
+		if e.ID == gofi.ElementVendorSpecific {
+			if wpa, err := ParseWPA(e.Data); err == nil {
+				return wpa
+			}
+		}
+	}
+	return nil
+}
+
+func sessionKey(ap, sta net.HardwareAddr) string {
+	return ap.String() + "/" + sta.String()
+}
+
+func allZero(b []byte) bool {
+	for _, x := range b {
+		if x != 0 {
+			return false
+		}
+	}
+	return true
+}
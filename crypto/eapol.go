@@ -0,0 +1,80 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/rc4"
+
+	"github.com/unixpickle/gofi"
+	"github.com/unixpickle/gofi/eapol"
+)
+
+// These are the key descriptor versions from the key information
+// field of EAPOL-Key frames.
+const (
+	keyDescriptorVersionAKM  = 0
+	keyDescriptorVersionMD5  = 1
+	keyDescriptorVersionSHA1 = 2
+	keyDescriptorVersionCMAC = 3
+)
+
+// An eapolKey is an EAPOL-Key packet along with the version of the
+// EAPOL packet which carried it, which its MIC covers.
+type eapolKey struct {
+	*eapol.Key
+	version int
+}
+
+// parseEAPOLKey decodes the EAPOL-Key packet in a data frame.
+func parseEAPOLKey(f gofi.Frame) (*eapolKey, bool) {
+	// NOTE: keys keep a reference to the frame, which must not
+	// change under them.
+	packet, err := eapol.ParseFrame(append(gofi.Frame{}, f...))
+	if err != nil {
+		return nil, false
+	}
+	key, err := packet.Key()
+	if err != nil {
+		return nil, false
+	}
+	return &eapolKey{Key: key, version: packet.Version}, true
+}
+
+// VerifyMIC checks the frame's MIC against a KCK.
+func (e *eapolKey) VerifyMIC(akm AKM, kck []byte) bool {
+	zeroed := *e.Key
+	zeroed.MIC = make([]byte, len(e.MIC))
+	packet := &eapol.Packet{Version: e.version, Type: eapol.TypeKey, Body: zeroed.Encode()}
+	mic := KeyMIC(e.Info.Version(), akm, kck, packet.Encode())
+	return len(mic) == len(e.MIC) && bytes.Equal(mic, e.MIC)
+}
+
+// DecryptData decrypts the key data with a KEK.
+//
+// Key descriptor version 1 uses RC4, keyed by the key IV and the KEK,
+// while every other version uses AES key wrap.
+func (e *eapolKey) DecryptData(kek []byte) ([]byte, error) {
+	if e.Info.Version() != keyDescriptorVersionMD5 {
+		return KeyUnwrap(kek, e.Data)
+	}
+	c, err := rc4.NewCipher(append(append([]byte{}, e.IV...), kek...))
+	if err != nil {
+		return nil, err
+	}
+	// NOTE: the first 256 bytes of the key stream are discarded.
+	discard := make([]byte, 256)
+	c.XORKeyStream(discard, discard)
+	res := make([]byte, len(e.Data))
+	c.XORKeyStream(res, e.Data)
+	return res, nil
+}
+
+// kdes returns the data of every standard KDE with the given type.
+func kdes(keyData []byte, kdeType byte) [][]byte {
+	var res [][]byte
+	for _, kde := range (&eapol.Key{Data: keyData}).KDEs() {
+		if kde.IsStandard() && kde.Type == kdeType {
+			res = append(res, kde.Data)
+		}
+	}
+	return res
+}
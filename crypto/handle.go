@@ -0,0 +1,29 @@
+package crypto
+
+import "github.com/unixpickle/gofi"
+
+// A Handle wraps a gofi.Handle and transparently decrypts the
+// frames it receives.
+//
+// Frames which cannot be decrypted are returned as they were
+// received, with the Protected flag still set.
+type Handle struct {
+	gofi.Handle
+
+	Decrypter *Decrypter
+}
+
+// NewHandle creates a Handle which decrypts frames with d.
+func NewHandle(h gofi.Handle, d *Decrypter) *Handle {
+	return &Handle{Handle: h, Decrypter: d}
+}
+
+// Receive receives the next frame and decrypts it if possible.
+func (h *Handle) Receive() (gofi.Frame, *gofi.RadioInfo, error) {
+	frame, info, err := h.Handle.Receive()
+	if err != nil {
+		return nil, nil, err
+	}
+	frame, _ = h.Decrypter.Process(frame)
+	return frame, info, nil
+}
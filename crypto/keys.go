@@ -0,0 +1,137 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"hash"
+	"net"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// PMKSize is the size of a pairwise master key derived from a passphrase.
+const PMKSize = 32
+
+// PassphrasePMK derives the pairwise master key for a WPA/WPA2
+// personal network from its passphrase and SSID.
+func PassphrasePMK(passphrase, ssid string) []byte {
+	return pbkdf2.Key([]byte(passphrase), []byte(ssid), 4096, PMKSize, sha1.New)
+}
+
+// A PTK is a pairwise transient key, split into its parts.
+type PTK struct {
+	// KCK is the key confirmation key, which protects EAPOL-Key frames.
+	KCK []byte
+
+	// KEK is the key encryption key, which encrypts EAPOL-Key data.
+	KEK []byte
+
+	// TK is the temporal key, which protects data frames.
+	TK []byte
+}
+
+// DerivePTK derives a PTK from a PMK, the addresses of the
+// authenticator (aa) and supplicant (spa), and the nonces which
+// they exchanged in the 4-way handshake.
+func DerivePTK(pmk []byte, akm AKM, c Cipher, aa, spa net.HardwareAddr,
+	anonce, snonce []byte) *PTK {
+	kckSize, kekSize := 16, 16
+	if akm.usesSHA384() {
+		kckSize, kekSize = akmSHA384KCKSize, akmSHA384KEKSize
+	}
+	size := kckSize + kekSize + c.KeySize()
+
+	var data []byte
+	if bytes.Compare(aa, spa) < 0 {
+		data = append(append(data, aa...), spa...)
+	} else {
+		data = append(append(data, spa...), aa...)
+	}
+	if bytes.Compare(anonce, snonce) < 0 {
+		data = append(append(data, anonce...), snonce...)
+	} else {
+		data = append(append(data, snonce...), anonce...)
+	}
+
+	const label = "Pairwise key expansion"
+	var key []byte
+	if akm.usesSHA384() {
+		key = kdf(sha512.New384, pmk, label, data, size)
+	} else if akm.usesSHA256() {
+		key = kdf(sha256.New, pmk, label, data, size)
+	} else {
+		key = prf(pmk, label, data, size)
+	}
+	return &PTK{
+		KCK: key[:kckSize],
+		KEK: key[kckSize : kckSize+kekSize],
+		TK:  key[kckSize+kekSize:],
+	}
+}
+
+// prf is the SHA-1 based PRF from IEEE 802.11i.
+func prf(key []byte, label string, data []byte, size int) []byte {
+	var res []byte
+	for i := 0; len(res) < size; i++ {
+		mac := hmac.New(sha1.New, key)
+		mac.Write([]byte(label))
+		mac.Write([]byte{0})
+		mac.Write(data)
+		mac.Write([]byte{byte(i)})
+		res = mac.Sum(res)
+	}
+	return res[:size]
+}
+
+// kdf is the key derivation function from IEEE 802.11-2016 12.7.1.7.2.
+func kdf(h func() hash.Hash, key []byte, label string, context []byte, size int) []byte {
+	var res []byte
+	var num [2]byte
+	var bits [2]byte
+	binary.LittleEndian.PutUint16(bits[:], uint16(size*8))
+	for i := 1; len(res) < size; i++ {
+		binary.LittleEndian.PutUint16(num[:], uint16(i))
+		mac := hmac.New(h, key)
+		mac.Write(num[:])
+		mac.Write([]byte(label))
+		mac.Write(context)
+		mac.Write(bits[:])
+		res = mac.Sum(res)
+	}
+	return res[:size]
+}
+
+// KeyMIC computes the MIC of an EAPOL-Key frame, whose MIC field
+// must be zeroed.
+// The algorithm depends on the key descriptor version and, for
+// version 0, on the AKM.
+func KeyMIC(version int, akm AKM, kck, data []byte) []byte {
+	hmacMIC := func(h func() hash.Hash, size int) []byte {
+		mac := hmac.New(h, kck)
+		mac.Write(data)
+		return mac.Sum(nil)[:size]
+	}
+	switch version {
+	case keyDescriptorVersionMD5:
+		return hmacMIC(md5.New, 16)
+	case keyDescriptorVersionSHA1:
+		return hmacMIC(sha1.New, 16)
+	case keyDescriptorVersionCMAC:
+		res, _ := cmac(kck, data)
+		return res
+	}
+	switch akm {
+	case AKMSAE, AKMFTSAE:
+		res, _ := cmac(kck, data)
+		return res
+	case AKMSuiteB192, AKMFTPSKSHA384, AKMPSKSHA384:
+		return hmacMIC(sha512.New384, akmSHA384MICSize)
+	default:
+		return hmacMIC(sha256.New, 16)
+	}
+}
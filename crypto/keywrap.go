@@ -0,0 +1,71 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+)
+
+var keyWrapIV = []byte{0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6}
+
+var errKeyUnwrap = errors.New("key unwrap integrity check failed")
+
+// KeyWrap wraps data with AES key wrap, as described in RFC 3394.
+// The data must be a multiple of 8 bytes long, and at least 16 bytes.
+func KeyWrap(kek, data []byte) ([]byte, error) {
+	if len(data)%8 != 0 || len(data) < 16 {
+		return nil, errors.New("invalid length for key wrap")
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	n := len(data) / 8
+	res := make([]byte, len(data)+8)
+	copy(res, keyWrapIV)
+	copy(res[8:], data)
+
+	var buf [aes.BlockSize]byte
+	for j := 0; j < 6; j++ {
+		for i := 1; i <= n; i++ {
+			copy(buf[:8], res[:8])
+			copy(buf[8:], res[i*8:i*8+8])
+			block.Encrypt(buf[:], buf[:])
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(res[:8], binary.BigEndian.Uint64(buf[:8])^t)
+			copy(res[i*8:], buf[8:])
+		}
+	}
+	return res, nil
+}
+
+// KeyUnwrap reverses KeyWrap, checking the integrity of the data.
+func KeyUnwrap(kek, data []byte) ([]byte, error) {
+	if len(data)%8 != 0 || len(data) < 24 {
+		return nil, errors.New("invalid length for key unwrap")
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	n := len(data)/8 - 1
+	res := make([]byte, len(data))
+	copy(res, data)
+
+	var buf [aes.BlockSize]byte
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(buf[:8], binary.BigEndian.Uint64(res[:8])^t)
+			copy(buf[8:], res[i*8:i*8+8])
+			block.Decrypt(buf[:], buf[:])
+			copy(res[:8], buf[:8])
+			copy(res[i*8:], buf[8:])
+		}
+	}
+	if subtle.ConstantTimeCompare(res[:8], keyWrapIV) != 1 {
+		return nil, errKeyUnwrap
+	}
+	return res[8:], nil
+}
@@ -0,0 +1,185 @@
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+
+	"github.com/unixpickle/gofi"
+)
+
+// protectedHeaderSize is the size of the CCMP and GCMP headers.
+const protectedHeaderSize = 8
+
+// extIVFlag is the bit of the key ID octet which indicates an
+// extended IV.
+const extIVFlag = 0x20
+
+// newAEAD creates the AEAD for a CCMP or GCMP cipher.
+func newAEAD(c Cipher, key []byte) (cipher.AEAD, error) {
+	if len(key) != c.KeySize() {
+		return nil, ErrUnsupportedCipher
+	}
+	switch c {
+	case CipherCCMP128:
+		return newCCM(key, 8)
+	case CipherCCMP256:
+		return newCCM(key, 16)
+	case CipherGCMP128, CipherGCMP256:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	default:
+		return nil, ErrUnsupportedCipher
+	}
+}
+
+// FramePN extracts the packet number and key ID from a CCMP or GCMP
+// protected frame.
+func FramePN(f gofi.Frame) (pn uint64, keyID int, err error) {
+	body := f.Body()
+	if len(body) < protectedHeaderSize {
+		return 0, 0, ErrFrameTruncated
+	}
+	if (body[3] & extIVFlag) == 0 {
+		return 0, 0, ErrUnsupportedCipher
+	}
+	pn = uint64(body[0]) | uint64(body[1])<<8 | uint64(body[4])<<16 |
+		uint64(body[5])<<24 | uint64(body[6])<<32 | uint64(body[7])<<40
+	return pn, int(body[3] >> 6), nil
+}
+
+// DecryptFrame decrypts a protected frame, returning a new frame
+// without the Protected flag.
+//
+// For TKIP, the key is the 32 byte temporal key, including the
+// Michael MIC keys.
+// TKIP frames which are fragments of a larger MSDU are decrypted,
+// but their MIC is left in place and is not checked.
+func DecryptFrame(c Cipher, key []byte, f gofi.Frame) (gofi.Frame, error) {
+	switch c {
+	case CipherWEP40, CipherWEP104:
+		return decryptWEP(key, f)
+	case CipherTKIP:
+		return decryptTKIP(key, f)
+	default:
+		return decryptAEAD(c, key, f)
+	}
+}
+
+// EncryptFrame protects a frame, returning a new frame with the
+// Protected flag.
+//
+// The pn argument is the packet number for CCMP and GCMP, the TSC
+// for TKIP, or the 24-bit IV for WEP.
+// Callers must never reuse a pn with the same key, except for WEP,
+// which is broken anyway.
+func EncryptFrame(c Cipher, key []byte, f gofi.Frame, pn uint64, keyID int) (gofi.Frame,
+	error) {
+	if len(f) < f.HeaderLen()+4 {
+		return nil, ErrFrameTruncated
+	}
+	switch c {
+	case CipherWEP40, CipherWEP104:
+		return encryptWEP(key, f, uint32(pn), keyID)
+	case CipherTKIP:
+		return encryptTKIP(key, f, pn, keyID)
+	default:
+		return encryptAEAD(c, key, f, pn, keyID)
+	}
+}
+
+// decryptAEAD decrypts a CCMP or GCMP protected frame.
+func decryptAEAD(c Cipher, key []byte, f gofi.Frame) (gofi.Frame, error) {
+	if !f.Protected() {
+		return nil, ErrNotProtected
+	}
+	aead, err := newAEAD(c, key)
+	if err != nil {
+		return nil, err
+	}
+	pn, _, err := FramePN(f)
+	if err != nil {
+		return nil, err
+	}
+	header := f[:f.HeaderLen()]
+	body := f.Body()
+	if len(body) < protectedHeaderSize+aead.Overhead() {
+		return nil, ErrFrameTruncated
+	}
+	plaintext, err := aead.Open(nil, protectedNonce(c, f, pn), body[protectedHeaderSize:],
+		protectedAAD(f))
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+	return unprotectedFrame(header, plaintext), nil
+}
+
+// unprotectedFrame creates a frame with the Protected flag cleared.
+func unprotectedFrame(header, body []byte) gofi.Frame {
+	res := gofi.NewFrame(header, body)
+	res[1] &^= gofi.FlagProtected
+	res.SetChecksum()
+	return res
+}
+
+// encryptAEAD protects a frame with CCMP or GCMP.
+func encryptAEAD(c Cipher, key []byte, f gofi.Frame, pn uint64, keyID int) (gofi.Frame,
+	error) {
+	aead, err := newAEAD(c, key)
+	if err != nil {
+		return nil, err
+	}
+	header := append([]byte{}, f[:f.HeaderLen()]...)
+	header[1] |= gofi.FlagProtected
+	protected := gofi.Frame(append(append([]byte{}, header...), make([]byte, 4)...))
+
+	ivHeader := []byte{byte(pn), byte(pn >> 8), 0, extIVFlag | byte(keyID<<6),
+		byte(pn >> 16), byte(pn >> 24), byte(pn >> 32), byte(pn >> 40)}
+	body := aead.Seal(ivHeader, protectedNonce(c, protected, pn), f.Body(),
+		protectedAAD(protected))
+	return gofi.NewFrame(header, body), nil
+}
+
+// protectedNonce computes the nonce for a frame.
+func protectedNonce(c Cipher, f gofi.Frame, pn uint64) []byte {
+	var pnBytes [6]byte
+	for i := range pnBytes {
+		pnBytes[i] = byte(pn >> uint(8*(5-i)))
+	}
+	if c == CipherGCMP128 || c == CipherGCMP256 {
+		return append(append([]byte{}, f.Addr2()...), pnBytes[:]...)
+	}
+	priority := byte(f.TID())
+	if f.Type() == gofi.FrameTypeManagement {
+		priority |= 0x10
+	}
+	return append(append([]byte{priority}, f.Addr2()...), pnBytes[:]...)
+}
+
+// protectedAAD computes the additional authenticated data for a frame.
+func protectedAAD(f gofi.Frame) []byte {
+	fc0 := f[0]
+	fc1 := f[1] &^ (gofi.FlagRetry | gofi.FlagPowerMgmt | gofi.FlagMoreData)
+	fc1 |= gofi.FlagProtected
+	if f.Type() == gofi.FrameTypeData {
+		fc0 &= 0x8f
+		if f.IsQoSData() {
+			fc1 &^= gofi.FlagOrder
+		}
+	}
+	res := []byte{fc0, fc1}
+	res = append(res, f[4:22]...)
+	var sc [2]byte
+	binary.LittleEndian.PutUint16(sc[:], f.SequenceControl()&0xf)
+	res = append(res, sc[:]...)
+	if addr4 := f.Addr4(); addr4 != nil {
+		res = append(res, addr4...)
+	}
+	if qos, ok := f.QoSControl(); ok {
+		res = append(res, byte(qos&0xf), 0)
+	}
+	return res
+}
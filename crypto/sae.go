@@ -0,0 +1,291 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"math/big"
+	"net"
+)
+
+var (
+	ErrSAEGroup   = errors.New("unsupported SAE group")
+	ErrSAECommit  = errors.New("invalid SAE commit")
+	ErrSAEConfirm = errors.New("SAE confirm does not match")
+	ErrSAEState   = errors.New("SAE message out of order")
+	ErrSAEPWE     = errors.New("no SAE password element found")
+)
+
+// SAEGroup is the only supported SAE group, which is the NIST P-256
+// curve.
+const SAEGroup = 19
+
+// saeHuntingLoops is the minimum number of hunting and pecking
+// iterations, which are all run regardless of when the password
+// element is found (see derivePWE).
+const saeHuntingLoops = 40
+
+// An SAE runs one side of the Simultaneous Authentication of Equals
+// exchange which authenticates stations with WPA3-Personal networks.
+//
+// Each side sends a commit and then a confirm, and the PMK is ready
+// once the peer's confirm has been checked.
+// Only group 19 with the hunting and pecking method of deriving the
+// password element is supported.
+type SAE struct {
+	curve elliptic.Curve
+
+	pweX, pweY *big.Int
+
+	random   *big.Int
+	scalar   *big.Int
+	elementX *big.Int
+	elementY *big.Int
+
+	peerScalar   *big.Int
+	peerElementX *big.Int
+	peerElementY *big.Int
+
+	kck   []byte
+	pmk   []byte
+	pmkid []byte
+
+	sendConfirm uint16
+	confirmed   bool
+}
+
+// NewSAE derives the password element for a password and the
+// addresses of both sides, and creates a commit.
+func NewSAE(password string, own, peer net.HardwareAddr) (*SAE, error) {
+	res := &SAE{curve: elliptic.P256()}
+	if err := res.derivePWE(password, own, peer); err != nil {
+		return nil, err
+	}
+	order := res.curve.Params().N
+	for {
+		random, err := rand.Int(rand.Reader, order)
+		if err != nil {
+			return nil, err
+		}
+		mask, err := rand.Int(rand.Reader, order)
+		if err != nil {
+			return nil, err
+		}
+		scalar := new(big.Int).Add(random, mask)
+		scalar.Mod(scalar, order)
+		if random.Cmp(big.NewInt(1)) <= 0 || mask.Cmp(big.NewInt(1)) <= 0 ||
+			scalar.Cmp(big.NewInt(1)) <= 0 {
+			continue
+		}
+		res.random = random
+		res.scalar = scalar
+		x, y := res.curve.ScalarMult(res.pweX, res.pweY, mask.Bytes())
+		res.elementX, res.elementY = x, res.negate(y)
+		return res, nil
+	}
+}
+
+// Commit encodes the body of a commit message, after the status
+// code, with an anti-clogging token if the peer asked for one.
+func (s *SAE) Commit(token []byte) []byte {
+	res := []byte{SAEGroup & 0xff, SAEGroup >> 8}
+	res = append(res, token...)
+	res = append(res, s.pad(s.scalar)...)
+	return append(res, s.encodePoint(s.elementX, s.elementY)...)
+}
+
+// HandleCommit processes the body of the peer's commit message, after
+// the status code, and derives the PMK.
+// Any anti-clogging token in the commit is ignored.
+func (s *SAE) HandleCommit(data []byte) error {
+	if s.peerScalar != nil {
+		return ErrSAEState
+	}
+	size := s.primeSize()
+	if len(data) < 2 {
+		return ErrSAECommit
+	}
+	if binary.LittleEndian.Uint16(data) != SAEGroup {
+		return ErrSAEGroup
+	}
+	if len(data) < 2+3*size {
+		return ErrSAECommit
+	}
+	data = data[len(data)-3*size:]
+	params := s.curve.Params()
+	scalar := new(big.Int).SetBytes(data[:size])
+	x := new(big.Int).SetBytes(data[size : 2*size])
+	y := new(big.Int).SetBytes(data[2*size:])
+	if scalar.Cmp(big.NewInt(1)) <= 0 || scalar.Cmp(params.N) >= 0 ||
+		x.Cmp(params.P) >= 0 || y.Cmp(params.P) >= 0 || !s.curve.IsOnCurve(x, y) {
+		return ErrSAECommit
+	}
+	if scalar.Cmp(s.scalar) == 0 && x.Cmp(s.elementX) == 0 && y.Cmp(s.elementY) == 0 {
+		// NOTE: a reflected commit would let an attacker
+		// authenticate without knowing the password.
+		return ErrSAECommit
+	}
+
+	// K = rand * (peer-scalar * PWE + PEER-ELEMENT)
+	px, py := s.curve.ScalarMult(s.pweX, s.pweY, scalar.Bytes())
+	px, py = s.curve.Add(px, py, x, y)
+	kx, ky := s.curve.ScalarMult(px, py, s.random.Bytes())
+	if kx.Sign() == 0 && ky.Sign() == 0 {
+		return ErrSAECommit
+	}
+
+	mac := hmac.New(sha256.New, make([]byte, sha256.Size))
+	mac.Write(s.pad(kx))
+	keySeed := mac.Sum(nil)
+	sum := new(big.Int).Add(s.scalar, scalar)
+	sum.Mod(sum, params.N)
+	keys := kdf(sha256.New, keySeed, "SAE KCK and PMK", s.pad(sum), 2*sha256.Size)
+
+	s.peerScalar = scalar
+	s.peerElementX, s.peerElementY = x, y
+	s.kck = keys[:sha256.Size]
+	s.pmk = keys[sha256.Size:]
+	s.pmkid = s.pad(sum)[:16]
+	return nil
+}
+
+// Confirm encodes the body of a confirm message, after the status
+// code.
+// It must be called after HandleCommit.
+func (s *SAE) Confirm() []byte {
+	s.sendConfirm++
+	res := []byte{byte(s.sendConfirm), byte(s.sendConfirm >> 8)}
+	return append(res, s.confirm(s.sendConfirm, s.scalar, s.elementX, s.elementY,
+		s.peerScalar, s.peerElementX, s.peerElementY)...)
+}
+
+// HandleConfirm checks the body of the peer's confirm message, after
+// the status code.
+func (s *SAE) HandleConfirm(data []byte) error {
+	if s.peerScalar == nil {
+		return ErrSAEState
+	}
+	if len(data) != 2+sha256.Size {
+		return ErrSAEConfirm
+	}
+	expected := s.confirm(binary.LittleEndian.Uint16(data), s.peerScalar, s.peerElementX,
+		s.peerElementY, s.scalar, s.elementX, s.elementY)
+	if subtle.ConstantTimeCompare(expected, data[2:]) != 1 {
+		return ErrSAEConfirm
+	}
+	s.confirmed = true
+	return nil
+}
+
+// PMK returns the pairwise master key, or nil if the peer's confirm
+// has not been checked.
+func (s *SAE) PMK() []byte {
+	if !s.confirmed {
+		return nil
+	}
+	return s.pmk
+}
+
+// PMKID returns the identifier of the PMK, or nil if the peer's
+// confirm has not been checked.
+func (s *SAE) PMKID() []byte {
+	if !s.confirmed {
+		return nil
+	}
+	return s.pmkid
+}
+
+func (s *SAE) confirm(counter uint16, scalar1, x1, y1, scalar2, x2, y2 *big.Int) []byte {
+	mac := hmac.New(sha256.New, s.kck)
+	mac.Write([]byte{byte(counter), byte(counter >> 8)})
+	mac.Write(s.pad(scalar1))
+	mac.Write(s.encodePoint(x1, y1))
+	mac.Write(s.pad(scalar2))
+	mac.Write(s.encodePoint(x2, y2))
+	return mac.Sum(nil)
+}
+
+// derivePWE finds the password element by hunting and pecking.
+//
+// Every iteration does the same work and the result is selected
+// with constant time operations, so the number of iterations before
+// the element is found does not show in the timing.
+//
+// NOTE: math/big is not constant time, so the arithmetic within an
+// iteration may still leak some timing information about the
+// candidates.
+func (s *SAE) derivePWE(password string, own, peer net.HardwareAddr) error {
+	var key []byte
+	if bytes.Compare(own, peer) > 0 {
+		key = append(append(key, own...), peer...)
+	} else {
+		key = append(append(key, peer...), own...)
+	}
+	params := s.curve.Params()
+	prime := s.pad(params.P)
+	three := big.NewInt(3)
+	pweX := make([]byte, s.primeSize())
+	pweY := make([]byte, s.primeSize())
+	var found int
+	for counter := 1; counter <= saeHuntingLoops || found == 0; counter++ {
+		if counter > 255 {
+			return ErrSAEPWE
+		}
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(password))
+		mac.Write([]byte{byte(counter)})
+		seed := mac.Sum(nil)
+		xBytes := kdf(sha256.New, seed, "SAE Hunting and Pecking", prime, s.primeSize())
+		x := new(big.Int).SetBytes(xBytes)
+		inRange := subtle.ConstantTimeLessOrEq(x.Cmp(params.P)+1, 0)
+
+		// y^2 = x^3 - 3x + b
+		y2 := new(big.Int).Exp(x, three, params.P)
+		y2.Sub(y2, new(big.Int).Mul(three, x))
+		y2.Add(y2, params.B)
+		y2.Mod(y2, params.P)
+		isSquare := subtle.ConstantTimeEq(int32(big.Jacobi(y2, params.P)), 1)
+
+		// NOTE: -1 is not a square mod p, so if y^2 is not a square,
+		// its negation is, and a square root is taken either way.
+		root := s.pad(s.negate(y2))
+		subtle.ConstantTimeCopy(isSquare, root, s.pad(y2))
+		y := new(big.Int)
+		y.ModSqrt(new(big.Int).SetBytes(root), params.P)
+		yBytes := s.pad(s.negate(y))
+		subtle.ConstantTimeCopy(subtle.ConstantTimeByteEq(byte(y.Bit(0)), seed[len(seed)-1]&1),
+			yBytes, s.pad(y))
+
+		use := isSquare & inRange &^ found
+		subtle.ConstantTimeCopy(use, pweX, xBytes)
+		subtle.ConstantTimeCopy(use, pweY, yBytes)
+		found |= use
+	}
+	s.pweX = new(big.Int).SetBytes(pweX)
+	s.pweY = new(big.Int).SetBytes(pweY)
+	return nil
+}
+
+func (s *SAE) negate(y *big.Int) *big.Int {
+	p := s.curve.Params().P
+	return new(big.Int).Mod(new(big.Int).Sub(p, y), p)
+}
+
+func (s *SAE) primeSize() int {
+	return (s.curve.Params().BitSize + 7) / 8
+}
+
+// pad encodes a number as a big-endian integer of the prime's size.
+func (s *SAE) pad(n *big.Int) []byte {
+	res := make([]byte, s.primeSize())
+	return n.FillBytes(res)
+}
+
+func (s *SAE) encodePoint(x, y *big.Int) []byte {
+	return append(s.pad(x), s.pad(y)...)
+}
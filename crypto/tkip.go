@@ -0,0 +1,256 @@
+package crypto
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+
+	"github.com/unixpickle/gofi"
+)
+
+const (
+	tkipHeaderSize = 8
+	tkipMICSize    = 8
+)
+
+// tkipSbox is the S-box used by TKIP key mixing.
+// Each entry combines the AES S-box output multiplied by 2 and by 3.
+var tkipSbox [256]uint16
+
+func init() {
+	sbox := aesSbox()
+	for i, s := range sbox {
+		double := gfDouble(s)
+		tkipSbox[i] = uint16(double)<<8 | uint16(double^s)
+	}
+}
+
+// tkipTSC extracts the TSC and key ID from a TKIP protected frame.
+func tkipTSC(f gofi.Frame) (tsc uint64, keyID int, err error) {
+	body := f.Body()
+	if len(body) < tkipHeaderSize {
+		return 0, 0, ErrFrameTruncated
+	}
+	if (body[3] & extIVFlag) == 0 {
+		return 0, 0, ErrUnsupportedCipher
+	}
+	tsc = uint64(body[2]) | uint64(body[0])<<8 | uint64(body[4])<<16 |
+		uint64(body[5])<<24 | uint64(body[6])<<32 | uint64(body[7])<<40
+	return tsc, int(body[3] >> 6), nil
+}
+
+// decryptTKIP decrypts a TKIP protected frame, checking its ICV and,
+// unless it is a fragment, its Michael MIC.
+func decryptTKIP(key []byte, f gofi.Frame) (gofi.Frame, error) {
+	if !f.Protected() {
+		return nil, ErrNotProtected
+	}
+	if len(key) != CipherTKIP.KeySize() {
+		return nil, ErrUnsupportedCipher
+	}
+	tsc, _, err := tkipTSC(f)
+	if err != nil {
+		return nil, err
+	}
+	body := f.Body()
+	rc4Key := tkipMixKey(key[:16], f.Addr2(), tsc)
+	plaintext, ok := rc4DecryptICV(rc4Key, body[tkipHeaderSize:])
+	if !ok {
+		return nil, ErrDecryptionFailed
+	}
+
+	if !f.MoreFragments() && f.FragmentNumber() == 0 {
+		if len(plaintext) < tkipMICSize {
+			return nil, ErrFrameTruncated
+		}
+		msdu := plaintext[:len(plaintext)-tkipMICSize]
+		mic := tkipMIC(tkipMICKey(key, f), f, msdu)
+		if subtle.ConstantTimeCompare(mic, plaintext[len(msdu):]) != 1 {
+			return nil, ErrMICFailure
+		}
+		plaintext = msdu
+	}
+
+	return unprotectedFrame(f[:f.HeaderLen()], plaintext), nil
+}
+
+// encryptTKIP protects an unfragmented frame with TKIP.
+func encryptTKIP(key []byte, f gofi.Frame, tsc uint64, keyID int) (gofi.Frame, error) {
+	if len(key) != CipherTKIP.KeySize() {
+		return nil, ErrUnsupportedCipher
+	}
+	msdu := f.Body()
+	plaintext := append(append([]byte{}, msdu...), tkipMIC(tkipMICKey(key, f), f, msdu)...)
+
+	tsc1 := byte(tsc >> 8)
+	body := []byte{tsc1, (tsc1 | 0x20) & 0x7f, byte(tsc), extIVFlag | byte(keyID<<6),
+		byte(tsc >> 16), byte(tsc >> 24), byte(tsc >> 32), byte(tsc >> 40)}
+	body = append(body, rc4EncryptICV(tkipMixKey(key[:16], f.Addr2(), tsc), plaintext)...)
+
+	header := append([]byte{}, f[:f.HeaderLen()]...)
+	header[1] |= gofi.FlagProtected
+	return gofi.NewFrame(header, body), nil
+}
+
+// tkipMICKey selects the Michael key for the direction of a frame.
+// Frames from the AP, including group addressed frames, use the
+// authenticator's key.
+func tkipMICKey(key []byte, f gofi.Frame) []byte {
+	if f.ToDS() && !f.FromDS() {
+		return key[24:32]
+	}
+	return key[16:24]
+}
+
+// tkipMIC computes the Michael MIC of an MSDU.
+func tkipMIC(micKey []byte, f gofi.Frame, msdu []byte) []byte {
+	var da, sa []byte
+	switch {
+	case !f.ToDS() && !f.FromDS():
+		da, sa = f.Addr1(), f.Addr2()
+	case !f.ToDS() && f.FromDS():
+		da, sa = f.Addr1(), f.Addr3()
+	case f.ToDS() && !f.FromDS():
+		da, sa = f.Addr3(), f.Addr2()
+	default:
+		da, sa = f.Addr3(), f.Addr4()
+	}
+	data := append(append([]byte{}, da...), sa...)
+	data = append(data, byte(f.TID()), 0, 0, 0)
+	data = append(data, msdu...)
+	return michael(micKey, data)
+}
+
+// michael computes the Michael MIC of some data.
+func michael(key, data []byte) []byte {
+	l := binary.LittleEndian.Uint32(key)
+	r := binary.LittleEndian.Uint32(key[4:])
+
+	padded := append(append([]byte{}, data...), 0x5a, 0, 0, 0, 0)
+	for len(padded)%4 != 0 {
+		padded = append(padded, 0)
+	}
+	for i := 0; i < len(padded); i += 4 {
+		l ^= binary.LittleEndian.Uint32(padded[i:])
+		r ^= rotl32(l, 17)
+		l += r
+		r ^= ((l & 0xff00ff00) >> 8) | ((l & 0x00ff00ff) << 8)
+		l += r
+		r ^= rotl32(l, 3)
+		l += r
+		r ^= rotl32(l, 30)
+		l += r
+	}
+
+	res := make([]byte, tkipMICSize)
+	binary.LittleEndian.PutUint32(res, l)
+	binary.LittleEndian.PutUint32(res[4:], r)
+	return res
+}
+
+// tkipMixKey computes the per-packet RC4 key, as described in IEEE
+// 802.11-2016 12.5.2.5.
+func tkipMixKey(tk, ta []byte, tsc uint64) []byte {
+	iv16 := uint16(tsc)
+	iv32 := uint32(tsc >> 16)
+	mk16 := func(hi, lo byte) uint16 {
+		return uint16(hi)<<8 | uint16(lo)
+	}
+	s := func(v uint16) uint16 {
+		hi := tkipSbox[v>>8]
+		return tkipSbox[v&0xff] ^ (hi>>8 | hi<<8)
+	}
+
+	// Phase 1
+	var ttak [5]uint16
+	ttak[0] = uint16(iv32)
+	ttak[1] = uint16(iv32 >> 16)
+	ttak[2] = mk16(ta[1], ta[0])
+	ttak[3] = mk16(ta[3], ta[2])
+	ttak[4] = mk16(ta[5], ta[4])
+	for i := 0; i < 8; i++ {
+		j := 2 * (i & 1)
+		ttak[0] += s(ttak[4] ^ mk16(tk[1+j], tk[0+j]))
+		ttak[1] += s(ttak[0] ^ mk16(tk[5+j], tk[4+j]))
+		ttak[2] += s(ttak[1] ^ mk16(tk[9+j], tk[8+j]))
+		ttak[3] += s(ttak[2] ^ mk16(tk[13+j], tk[12+j]))
+		ttak[4] += s(ttak[3]^mk16(tk[1+j], tk[0+j])) + uint16(i)
+	}
+
+	// Phase 2
+	var ppk [6]uint16
+	copy(ppk[:], ttak[:])
+	ppk[5] = ttak[4] + iv16
+	ppk[0] += s(ppk[5] ^ mk16(tk[1], tk[0]))
+	ppk[1] += s(ppk[0] ^ mk16(tk[3], tk[2]))
+	ppk[2] += s(ppk[1] ^ mk16(tk[5], tk[4]))
+	ppk[3] += s(ppk[2] ^ mk16(tk[7], tk[6]))
+	ppk[4] += s(ppk[3] ^ mk16(tk[9], tk[8]))
+	ppk[5] += s(ppk[4] ^ mk16(tk[11], tk[10]))
+	ppk[0] += rotr16(ppk[5] ^ mk16(tk[13], tk[12]))
+	ppk[1] += rotr16(ppk[0] ^ mk16(tk[15], tk[14]))
+	ppk[2] += rotr16(ppk[1])
+	ppk[3] += rotr16(ppk[2])
+	ppk[4] += rotr16(ppk[3])
+	ppk[5] += rotr16(ppk[4])
+
+	key := make([]byte, 16)
+	key[0] = byte(iv16 >> 8)
+	key[1] = (byte(iv16>>8) | 0x20) & 0x7f
+	key[2] = byte(iv16)
+	key[3] = byte((ppk[5] ^ mk16(tk[1], tk[0])) >> 1)
+	for i, p := range ppk {
+		key[4+2*i] = byte(p)
+		key[5+2*i] = byte(p >> 8)
+	}
+	return key
+}
+
+func rotl32(x uint32, n uint) uint32 {
+	return x<<n | x>>(32-n)
+}
+
+func rotr16(x uint16) uint16 {
+	return x>>1 | x<<15
+}
+
+// aesSbox computes the AES S-box.
+func aesSbox() [256]byte {
+	var res [256]byte
+	for i := 0; i < 256; i++ {
+		// Find the multiplicative inverse by brute force, since
+		// this only happens once.
+		var inv byte
+		for j := 1; j < 256 && i != 0; j++ {
+			if gfMul(byte(i), byte(j)) == 1 {
+				inv = byte(j)
+				break
+			}
+		}
+		x := inv
+		res[i] = x ^ rotl8(x, 1) ^ rotl8(x, 2) ^ rotl8(x, 3) ^ rotl8(x, 4) ^ 0x63
+	}
+	return res
+}
+
+func gfDouble(x byte) byte {
+	if (x & 0x80) != 0 {
+		return (x << 1) ^ 0x1b
+	}
+	return x << 1
+}
+
+func gfMul(a, b byte) byte {
+	var res byte
+	for b != 0 {
+		if (b & 1) != 0 {
+			res ^= a
+		}
+		a = gfDouble(a)
+		b >>= 1
+	}
+	return res
+}
+
+func rotl8(x byte, n uint) byte {
+	return x<<n | x>>(8-n)
+}
@@ -0,0 +1,81 @@
+package crypto
+
+import (
+	"crypto/rc4"
+	"encoding/binary"
+	"hash/crc32"
+
+	"github.com/unixpickle/gofi"
+)
+
+const (
+	wepHeaderSize = 4
+	wepICVSize    = 4
+)
+
+// decryptWEP decrypts a WEP protected frame and checks its ICV.
+func decryptWEP(key []byte, f gofi.Frame) (gofi.Frame, error) {
+	if !f.Protected() {
+		return nil, ErrNotProtected
+	}
+	if len(key) != CipherWEP40.KeySize() && len(key) != CipherWEP104.KeySize() {
+		return nil, ErrUnsupportedCipher
+	}
+	body := f.Body()
+	if len(body) < wepHeaderSize+wepICVSize {
+		return nil, ErrFrameTruncated
+	}
+	if (body[3] & extIVFlag) != 0 {
+		return nil, ErrUnsupportedCipher
+	}
+	plaintext, ok := rc4DecryptICV(append(append([]byte{}, body[:3]...), key...),
+		body[wepHeaderSize:])
+	if !ok {
+		return nil, ErrDecryptionFailed
+	}
+	return unprotectedFrame(f[:f.HeaderLen()], plaintext), nil
+}
+
+// encryptWEP protects a frame with WEP.
+func encryptWEP(key []byte, f gofi.Frame, iv uint32, keyID int) (gofi.Frame, error) {
+	if len(key) != CipherWEP40.KeySize() && len(key) != CipherWEP104.KeySize() {
+		return nil, ErrUnsupportedCipher
+	}
+	ivBytes := []byte{byte(iv >> 16), byte(iv >> 8), byte(iv)}
+	body := append(ivBytes, byte(keyID<<6))
+	body = append(body, rc4EncryptICV(append(append([]byte{}, ivBytes...), key...),
+		f.Body())...)
+	header := append([]byte{}, f[:f.HeaderLen()]...)
+	header[1] |= gofi.FlagProtected
+	return gofi.NewFrame(header, body), nil
+}
+
+// rc4DecryptICV decrypts data with RC4 and checks the CRC-32 ICV at
+// the end of it.
+func rc4DecryptICV(key, data []byte) ([]byte, bool) {
+	if len(data) < wepICVSize {
+		return nil, false
+	}
+	c, err := rc4.NewCipher(key)
+	if err != nil {
+		return nil, false
+	}
+	plaintext := make([]byte, len(data))
+	c.XORKeyStream(plaintext, data)
+	payload := plaintext[:len(plaintext)-wepICVSize]
+	icv := binary.LittleEndian.Uint32(plaintext[len(payload):])
+	if crc32.ChecksumIEEE(payload) != icv {
+		return nil, false
+	}
+	return payload, true
+}
+
+// rc4EncryptICV appends a CRC-32 ICV to data and encrypts it with RC4.
+func rc4EncryptICV(key, data []byte) []byte {
+	plaintext := make([]byte, len(data)+wepICVSize)
+	copy(plaintext, data)
+	binary.LittleEndian.PutUint32(plaintext[len(data):], crc32.ChecksumIEEE(data))
+	c, _ := rc4.NewCipher(key)
+	c.XORKeyStream(plaintext, plaintext)
+	return plaintext
+}
@@ -0,0 +1,316 @@
+// Package dgram sends and receives datagrams in raw 802.11 frames,
+// letting nearby devices exchange messages without associating to an
+// AP.
+package dgram
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/unixpickle/gofi"
+	"github.com/unixpickle/gofi/ether"
+	"github.com/unixpickle/gofi/internal/deadline"
+	"github.com/unixpickle/gofi/mpdu"
+)
+
+var (
+	ErrBadAddress = errors.New("address is not a dgram.Addr")
+	ErrTooLarge   = errors.New("datagram is too large")
+)
+
+// An Addr is the MAC address of a PacketConn.
+type Addr net.HardwareAddr
+
+// Network returns "802.11".
+func (a Addr) Network() string {
+	return "802.11"
+}
+
+// String returns the MAC address in the usual notation.
+func (a Addr) String() string {
+	return net.HardwareAddr(a).String()
+}
+
+// A Kind is the kind of frame which carries datagrams.
+type Kind int
+
+const (
+	// KindData sends datagrams in data frames, after an LLC/SNAP
+	// header with the protocol's OUI and identifier.
+	KindData Kind = iota
+
+	// KindAction sends datagrams in vendor specific action frames,
+	// after the protocol's OUI and identifier.
+	KindAction
+)
+
+// vendorCategory is the action category of vendor specific action
+// frames.
+const vendorCategory = 127
+
+// These are the largest datagrams which fit in the largest MSDU and
+// MMPDU bodies, after the protocol header.
+const (
+	maxDataPayload   = 2304 - 8
+	maxActionPayload = 2304 - 6
+)
+
+// receiveQueueSize is the number of received datagrams which are
+// kept while nothing reads them.
+const receiveQueueSize = 64
+
+// A Config configures a PacketConn.
+type Config struct {
+	// Address is the local MAC address.
+	// Frames addressed to other stations are ignored, except for
+	// frames to group addresses.
+	Address net.HardwareAddr
+
+	// Kind is the kind of frame to send.
+	// Frames of either kind are received.
+	Kind Kind
+
+	// OUI and Protocol identify the protocol, so that frames from
+	// unrelated protocols are ignored.
+	//
+	// For KindData, an OUI of zero makes Protocol an EtherType,
+	// in which case a local experimental EtherType such as 0x88b5
+	// is a good choice.
+	OUI      [3]byte
+	Protocol uint16
+
+	// BSSID is the third address of sent frames.
+	// If it is nil, the wildcard BSSID is used.
+	BSSID net.HardwareAddr
+
+	// Rate is the data rate at which datagrams are sent.
+	// If it is 0, the lowest rate for the channel is used.
+	Rate gofi.DataRate
+}
+
+type datagram struct {
+	payload []byte
+	source  Addr
+}
+
+// A PacketConn is a net.PacketConn which sends and receives
+// datagrams over a Handle.
+//
+// Datagrams are sent with sequence numbers, and duplicates from
+// retransmissions are dropped, but otherwise delivery is not
+// guaranteed.
+// Received datagrams wait in a queue until ReadFrom is called, and
+// those which arrive while the queue is full are dropped.
+type PacketConn struct {
+	handle *mpdu.Handle
+	config Config
+
+	packets chan datagram
+
+	readDeadline  *deadline.Deadline
+	writeDeadline *deadline.Deadline
+
+	closeLock sync.Mutex
+	closed    chan struct{}
+}
+
+// NewPacketConn creates a PacketConn which owns h and starts
+// receiving from it.
+// Closing the PacketConn closes h.
+func NewPacketConn(h gofi.Handle, c *Config) *PacketConn {
+	handle := mpdu.NewHandle(h, mpdu.NewReassembler())
+	handle.Sender = mpdu.NewSender()
+	res := &PacketConn{
+		handle:        handle,
+		config:        *c,
+		packets:       make(chan datagram, receiveQueueSize),
+		readDeadline:  deadline.New(),
+		writeDeadline: deadline.New(),
+		closed:        make(chan struct{}),
+	}
+	if res.config.BSSID == nil {
+		res.config.BSSID = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	}
+	go res.receiveLoop()
+	return res
+}
+
+// ReadFrom reads the next datagram.
+// If b is too small, the datagram is truncated.
+func (p *PacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	select {
+	case <-p.closed:
+		return 0, nil, net.ErrClosed
+	case <-p.readDeadline.Wait():
+		return 0, nil, os.ErrDeadlineExceeded
+	default:
+	}
+	select {
+	case packet := <-p.packets:
+		return copy(b, packet.payload), packet.source, nil
+	case <-p.closed:
+		return 0, nil, net.ErrClosed
+	case <-p.readDeadline.Wait():
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+}
+
+// WriteTo sends a datagram to an Addr, which may be a group address.
+func (p *PacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	dst, ok := addr.(Addr)
+	if !ok || len(dst) != 6 {
+		return 0, ErrBadAddress
+	}
+	select {
+	case <-p.closed:
+		return 0, net.ErrClosed
+	case <-p.writeDeadline.Wait():
+		return 0, os.ErrDeadlineExceeded
+	default:
+	}
+	frame, err := p.encode(b, net.HardwareAddr(dst))
+	if err != nil {
+		return 0, err
+	}
+	if err := p.handle.Send(frame, p.config.Rate); err != nil {
+		if err == gofi.ErrClosed {
+			return 0, net.ErrClosed
+		}
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close closes the PacketConn and its Handle.
+func (p *PacketConn) Close() error {
+	p.closeLock.Lock()
+	defer p.closeLock.Unlock()
+	select {
+	case <-p.closed:
+		return net.ErrClosed
+	default:
+	}
+	close(p.closed)
+	p.handle.Close()
+	return nil
+}
+
+// LocalAddr returns the local MAC address.
+func (p *PacketConn) LocalAddr() net.Addr {
+	return Addr(p.config.Address)
+}
+
+// SetDeadline sets the read and write deadlines.
+func (p *PacketConn) SetDeadline(t time.Time) error {
+	p.readDeadline.Set(t)
+	p.writeDeadline.Set(t)
+	return nil
+}
+
+// SetReadDeadline sets the deadline for ReadFrom calls, including
+// calls which are already waiting.
+func (p *PacketConn) SetReadDeadline(t time.Time) error {
+	p.readDeadline.Set(t)
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for WriteTo calls.
+//
+// Since sending a frame does not block for long, the deadline is
+// only checked before sending.
+func (p *PacketConn) SetWriteDeadline(t time.Time) error {
+	p.writeDeadline.Set(t)
+	return nil
+}
+
+func (p *PacketConn) receiveLoop() {
+	for {
+		frame, _, err := p.handle.Receive()
+		if err != nil {
+			return
+		}
+		packet, ok := p.decode(frame)
+		if !ok {
+			continue
+		}
+		select {
+		case p.packets <- packet:
+		default:
+		}
+	}
+}
+
+// protocolHeader returns the OUI and protocol identifier.
+func (p *PacketConn) protocolHeader() []byte {
+	res := append([]byte{}, p.config.OUI[:]...)
+	return append(res, byte(p.config.Protocol>>8), byte(p.config.Protocol))
+}
+
+func (p *PacketConn) encode(payload []byte, dst net.HardwareAddr) (gofi.Frame, error) {
+	var frameControl byte
+	var body []byte
+	if p.config.Kind == KindAction {
+		if len(payload) > maxActionPayload {
+			return nil, ErrTooLarge
+		}
+		frameControl = gofi.SubtypeAction << 4
+		body = append([]byte{vendorCategory}, p.protocolHeader()...)
+	} else {
+		if len(payload) > maxDataPayload {
+			return nil, ErrTooLarge
+		}
+		frameControl = byte(gofi.FrameTypeData)<<2 | gofi.SubtypeData<<4
+		body = append([]byte{0xaa, 0xaa, 0x03}, p.protocolHeader()...)
+	}
+	body = append(body, payload...)
+	header := []byte{frameControl, 0, 0, 0}
+	header = append(header, dst...)
+	header = append(header, p.config.Address...)
+	header = append(header, p.config.BSSID...)
+	header = append(header, 0, 0)
+	return gofi.NewFrame(header, body), nil
+}
+
+func (p *PacketConn) decode(f gofi.Frame) (datagram, bool) {
+	if f.Protected() || !f.HasBody() {
+		return datagram{}, false
+	}
+	body := f.Body()
+	var prefix []byte
+	var src net.HardwareAddr
+	switch f.Type() {
+	case gofi.FrameTypeData:
+		prefix = append([]byte{0xaa, 0xaa, 0x03}, p.protocolHeader()...)
+		var dst net.HardwareAddr
+		dst, src, _ = ether.Addresses(f)
+		if !p.acceptDestination(dst) {
+			return datagram{}, false
+		}
+	case gofi.FrameTypeManagement:
+		if f.Subtype() != gofi.SubtypeAction && f.Subtype() != gofi.SubtypeActionNoAck {
+			return datagram{}, false
+		}
+		prefix = append([]byte{vendorCategory}, p.protocolHeader()...)
+		src = f.Addr2()
+		if !p.acceptDestination(f.Addr1()) {
+			return datagram{}, false
+		}
+	default:
+		return datagram{}, false
+	}
+	if src == nil || !bytes.HasPrefix(body, prefix) {
+		return datagram{}, false
+	}
+	return datagram{
+		payload: append([]byte{}, body[len(prefix):]...),
+		source:  Addr(append(net.HardwareAddr{}, src...)),
+	}, true
+}
+
+func (p *PacketConn) acceptDestination(dst net.HardwareAddr) bool {
+	return len(dst) == 6 && ((dst[0]&1) != 0 || bytes.Equal(dst, p.config.Address))
+}
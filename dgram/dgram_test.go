@@ -0,0 +1,120 @@
+package dgram
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/unixpickle/gofi/internal/testutil"
+	"github.com/unixpickle/gofi/sim"
+)
+
+var (
+	testAddr1 = net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	testAddr2 = net.HardwareAddr{0x02, 0, 0, 0, 0, 2}
+	testAddr3 = net.HardwareAddr{0x02, 0, 0, 0, 0, 3}
+)
+
+var _ net.PacketConn = &PacketConn{}
+
+func TestPacketConn(t *testing.T) {
+	for _, kind := range []Kind{KindData, KindAction} {
+		medium := sim.NewMedium()
+		c1 := NewPacketConn(medium.NewHandle(testAddr1), &Config{
+			Address:  testAddr1,
+			Kind:     kind,
+			Protocol: 0x88b5,
+		})
+		c2 := NewPacketConn(medium.NewHandle(testAddr2), &Config{
+			Address:  testAddr2,
+			Kind:     kind,
+			Protocol: 0x88b5,
+		})
+		other := NewPacketConn(medium.NewHandle(testAddr3), &Config{
+			Address:  testAddr3,
+			Kind:     kind,
+			Protocol: 0x88b6,
+		})
+
+		// The datagram of another protocol should be ignored.
+		if _, err := other.WriteTo([]byte("unrelated"), Addr(testAddr2)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c1.WriteTo([]byte("hello"), Addr(testAddr2)); err != nil {
+			t.Fatal(err)
+		}
+		broadcast := Addr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+		if _, err := c1.WriteTo([]byte("everyone"), broadcast); err != nil {
+			t.Fatal(err)
+		}
+		c2.SetReadDeadline(time.Now().Add(time.Second * 5))
+		for _, expected := range []string{"hello", "everyone"} {
+			buf := make([]byte, 100)
+			n, addr, err := c2.ReadFrom(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(buf[:n]) != expected || addr.String() != testAddr1.String() {
+				t.Errorf("kind %d: unexpected datagram %q from %v", kind, buf[:n], addr)
+			}
+		}
+
+		if _, err := c1.WriteTo([]byte("hi"), &net.UDPAddr{}); err != ErrBadAddress {
+			t.Error("unexpected error:", err)
+		}
+		if _, err := c1.WriteTo(make([]byte, 3000), Addr(testAddr2)); err != ErrTooLarge {
+			t.Error("unexpected error:", err)
+		}
+
+		c1.Close()
+		c2.Close()
+		other.Close()
+	}
+}
+
+func TestPacketConnDeadline(t *testing.T) {
+	medium := sim.NewMedium()
+	c := NewPacketConn(medium.NewHandle(testAddr1), &Config{Address: testAddr1})
+
+	c.SetReadDeadline(time.Now().Add(-time.Second))
+	if _, _, err := c.ReadFrom(make([]byte, 10)); !testutil.IsTimeout(err) {
+		t.Error("expected timeout but got:", err)
+	}
+	if _, err := c.WriteTo([]byte("hi"), Addr(testAddr2)); err != nil {
+		t.Error("write failed with only a read deadline:", err)
+	}
+
+	// Changing the deadline should affect a pending read.
+	c.SetReadDeadline(time.Time{})
+	errs := make(chan error, 1)
+	go func() {
+		_, _, err := c.ReadFrom(make([]byte, 10))
+		errs <- err
+	}()
+	time.Sleep(time.Millisecond * 10)
+	c.SetReadDeadline(time.Now().Add(time.Millisecond * 10))
+	select {
+	case err := <-errs:
+		if !testutil.IsTimeout(err) {
+			t.Error("expected timeout but got:", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("pending read did not time out")
+	}
+
+	c.SetDeadline(time.Now().Add(-time.Second))
+	if _, err := c.WriteTo([]byte("hi"), Addr(testAddr2)); !testutil.IsTimeout(err) {
+		t.Error("expected timeout but got:", err)
+	}
+
+	c.SetDeadline(time.Time{})
+	go func() {
+		_, _, err := c.ReadFrom(make([]byte, 10))
+		errs <- err
+	}()
+	time.Sleep(time.Millisecond * 10)
+	c.Close()
+	if err := <-errs; err != net.ErrClosed {
+		t.Error("unexpected error after close:", err)
+	}
+}
@@ -0,0 +1,296 @@
+// Package discovery finds nearby devices without any infrastructure.
+//
+// Each node periodically broadcasts an announcement with its node ID
+// and metadata, either in vendor specific public action frames or in
+// the vendor specific element of a beacon, while hopping across a set
+// of channels.
+// Every node keeps a table of the peers it hears, and reports when
+// peers join or leave.
+package discovery
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/unixpickle/gofi"
+	"github.com/unixpickle/gofi/oui"
+)
+
+var (
+	ErrNoNodeID           = errors.New("missing node ID")
+	ErrTooLarge           = errors.New("announcement is too large")
+	ErrUnsupportedChannel = errors.New("channel is not supported by the handle")
+)
+
+// DefaultKey identifies announcements for a Config which does not
+// set its Key.
+// Its OUI is locally administered.
+var DefaultKey = oui.Key{OUI: oui.OUI{0x02, 0x67, 0x66}, Type: 1}
+
+// DefaultDwell and DefaultInterval pace channel hopping and
+// announcements, and DefaultTimeout bounds how long a silent peer is
+// remembered, for a Config which does not set its own.
+const (
+	DefaultDwell    = time.Millisecond * 250
+	DefaultInterval = time.Millisecond * 100
+	DefaultTimeout  = time.Second * 3
+)
+
+// announcementVersion is the first byte of every announcement.
+const announcementVersion = 1
+
+// flagLeaving marks the last announcement of a node which is closing.
+const flagLeaving = 1
+
+// capabilityIBSS is the capability bit of beacons from an IBSS.
+const capabilityIBSS = 2
+
+// maxAnnouncementSize is the largest announcement which fits in a
+// vendor specific element after its OUI and type.
+const maxAnnouncementSize = gofi.ElementMaxDataLength - 4
+
+// A Mode determines which frames carry announcements.
+//
+// Nodes receive announcements in either kind of frame, regardless of
+// the Mode with which they send.
+type Mode int
+
+const (
+	// ModeAction sends announcements in vendor specific public
+	// action frames.
+	ModeAction Mode = iota
+
+	// ModeBeacon sends announcements in beacons of a hidden IBSS.
+	ModeBeacon
+)
+
+// A Config configures a Service.
+type Config struct {
+	// Address is the transmitter address of announcements.
+	Address net.HardwareAddr
+
+	// NodeID identifies this node to its peers.
+	NodeID string
+
+	// Metadata describes the services of this node.
+	// The node ID and metadata must fit in a vendor specific
+	// element.
+	Metadata map[string]string
+
+	// Key is the OUI and type of announcements.
+	// If it is zero, DefaultKey is used.
+	Key oui.Key
+
+	Mode Mode
+
+	// Channels are the channels to hop across.
+	// The order of the channels is shuffled on every pass, so that
+	// hopping nodes which start at different times still meet.
+	//
+	// If there are no channels, the Handle stays on its current
+	// channel.
+	// Every channel must be supported by the Handle.
+	Channels []gofi.Channel
+
+	// Dwell is the time spent on each channel.
+	// If it is 0, DefaultDwell is used.
+	Dwell time.Duration
+
+	// Interval is the time between announcements on a channel.
+	// If it is 0, DefaultInterval is used.
+	Interval time.Duration
+
+	// Timeout is the time after which a peer which has not been heard
+	// from is assumed to have left.
+	//
+	// If it is 0, DefaultTimeout is used, or three times the
+	// expected time for two nodes hopping across every channel to
+	// meet, if that is longer.
+	Timeout time.Duration
+
+	// Rate is the data rate of announcements.
+	Rate gofi.DataRate
+}
+
+func (c *Config) setDefaults() {
+	if c.Key == (oui.Key{}) {
+		c.Key = DefaultKey
+	}
+	if c.Dwell == 0 {
+		c.Dwell = DefaultDwell
+	}
+	if c.Interval == 0 {
+		c.Interval = DefaultInterval
+	}
+	if c.Timeout == 0 {
+		c.Timeout = DefaultTimeout
+		n := time.Duration(len(c.Channels))
+		if meet := 3 * n * n * c.Dwell; meet > c.Timeout {
+			c.Timeout = meet
+		}
+	}
+}
+
+// A Peer is a node which has been heard from.
+type Peer struct {
+	NodeID  string
+	Address net.HardwareAddr
+
+	// Metadata is the metadata of the latest announcement.
+	// It must not be modified.
+	Metadata map[string]string
+
+	// Channel and SignalPower describe the latest announcement.
+	// SignalPower is measured in dBm, and is 0 if the Handle did not
+	// report it.
+	Channel     gofi.Channel
+	SignalPower int
+
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+// An EventType is the kind of change which an Event reports.
+type EventType int
+
+const (
+	// EventJoin reports a peer which was heard for the first time,
+	// or for the first time since it left.
+	EventJoin EventType = iota
+
+	// EventUpdate reports a peer whose address or metadata changed.
+	EventUpdate
+
+	// EventLeave reports a peer which announced that it was leaving,
+	// or which timed out.
+	EventLeave
+)
+
+func (e EventType) String() string {
+	switch e {
+	case EventJoin:
+		return "join"
+	case EventUpdate:
+		return "update"
+	case EventLeave:
+		return "leave"
+	default:
+		return "unknown"
+	}
+}
+
+// An Event reports a change to the peer table.
+type Event struct {
+	Type EventType
+
+	// Peer is the peer after the change, or the last known state of
+	// a peer which left.
+	Peer Peer
+}
+
+// announcement is the vendor specific content which a node sends.
+type announcement struct {
+	key      oui.Key
+	nodeID   string
+	metadata map[string]string
+	leaving  bool
+}
+
+func decodeAnnouncement(k oui.Key, data []byte) (oui.Payload, error) {
+	if len(data) < 3 || data[0] != announcementVersion {
+		return nil, oui.ErrTruncated
+	}
+	res := &announcement{key: k, leaving: data[1]&flagLeaving != 0}
+	data = data[2:]
+	readString := func() (string, bool) {
+		if len(data) < 1 || len(data) < 1+int(data[0]) {
+			return "", false
+		}
+		s := string(data[1 : 1+int(data[0])])
+		data = data[1+int(data[0]):]
+		return s, true
+	}
+	var ok bool
+	if res.nodeID, ok = readString(); !ok {
+		return nil, oui.ErrTruncated
+	}
+	res.metadata = map[string]string{}
+	for len(data) > 0 {
+		key, ok1 := readString()
+		value, ok2 := readString()
+		if !ok1 || !ok2 {
+			return nil, oui.ErrTruncated
+		}
+		res.metadata[key] = value
+	}
+	return res, nil
+}
+
+func (a *announcement) Key() oui.Key {
+	return a.key
+}
+
+// Encode encodes the announcement, with the metadata sorted by key.
+// Strings longer than 255 bytes are truncated.
+func (a *announcement) Encode() []byte {
+	var flags byte
+	if a.leaving {
+		flags |= flagLeaving
+	}
+	res := []byte{announcementVersion, flags}
+	writeString := func(s string) {
+		if len(s) > 0xff {
+			s = s[:0xff]
+		}
+		res = append(res, byte(len(s)))
+		res = append(res, s...)
+	}
+	writeString(a.nodeID)
+	keys := make([]string, 0, len(a.metadata))
+	for key := range a.metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		writeString(key)
+		writeString(a.metadata[key])
+	}
+	return res
+}
+
+func (a *announcement) check() error {
+	if a.nodeID == "" {
+		return ErrNoNodeID
+	}
+	if len(a.Encode()) > maxAnnouncementSize || len(a.nodeID) > 0xff {
+		return ErrTooLarge
+	}
+	for key, value := range a.metadata {
+		if len(key) > 0xff || len(value) > 0xff {
+			return ErrTooLarge
+		}
+	}
+	return nil
+}
+
+func metadataEqual(m1, m2 map[string]string) bool {
+	if len(m1) != len(m2) {
+		return false
+	}
+	for key, value := range m1 {
+		if v, ok := m2[key]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+func copyMetadata(m map[string]string) map[string]string {
+	res := make(map[string]string, len(m))
+	for key, value := range m {
+		res[key] = value
+	}
+	return res
+}
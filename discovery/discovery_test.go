@@ -0,0 +1,234 @@
+package discovery
+
+import (
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/unixpickle/gofi"
+	"github.com/unixpickle/gofi/sim"
+)
+
+var (
+	testAddr1 = net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	testAddr2 = net.HardwareAddr{0x02, 0, 0, 0, 0, 2}
+)
+
+func TestAnnouncement(t *testing.T) {
+	a := &announcement{
+		key:      DefaultKey,
+		nodeID:   "node1",
+		metadata: map[string]string{"service": "camera", "port": "8080", "empty": ""},
+		leaving:  true,
+	}
+	decoded, err := decodeAnnouncement(DefaultKey, a.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, a) {
+		t.Errorf("expected %v but got %v", a, decoded)
+	}
+	if _, err := decodeAnnouncement(DefaultKey, a.Encode()[:10]); err == nil {
+		t.Error("decoded truncated announcement")
+	}
+
+	if err := (&announcement{}).check(); err != ErrNoNodeID {
+		t.Error("unexpected error:", err)
+	}
+	a.metadata["big"] = strings.Repeat("x", 250)
+	if err := a.check(); err != ErrTooLarge {
+		t.Error("unexpected error:", err)
+	}
+}
+
+func TestService(t *testing.T) {
+	for _, mode := range []Mode{ModeAction, ModeBeacon} {
+		medium := sim.NewMedium()
+		h1 := medium.NewHandle(testAddr1)
+		h1.SetSignalPower(-60)
+		s1 := testService(t, h1, &Config{
+			NodeID:   "node1",
+			Metadata: map[string]string{"service": "camera"},
+			Mode:     mode,
+		})
+		s2 := testService(t, medium.NewHandle(testAddr2), &Config{NodeID: "node2", Mode: mode})
+
+		event := testEvent(t, s2)
+		if event.Type != EventJoin || event.Peer.NodeID != "node1" ||
+			event.Peer.Address.String() != testAddr1.String() ||
+			event.Peer.Metadata["service"] != "camera" ||
+			event.Peer.SignalPower != -60 || event.Peer.Channel.Number != 1 {
+			t.Errorf("unexpected event: %+v", event)
+		}
+		if event := testEvent(t, s1); event.Type != EventJoin || event.Peer.NodeID != "node2" {
+			t.Errorf("unexpected event: %+v", event)
+		}
+		if peers := s2.Peers(); len(peers) != 1 || peers[0].NodeID != "node1" {
+			t.Errorf("unexpected peers: %v", peers)
+		}
+
+		if err := s1.SetMetadata(map[string]string{"service": "printer"}); err != nil {
+			t.Fatal(err)
+		}
+		event = testEvent(t, s2)
+		if event.Type != EventUpdate || event.Peer.Metadata["service"] != "printer" {
+			t.Errorf("unexpected event: %+v", event)
+		}
+
+		s1.Close()
+		if event := testEvent(t, s2); event.Type != EventLeave || event.Peer.NodeID != "node1" {
+			t.Errorf("unexpected event: %+v", event)
+		}
+		if peers := s2.Peers(); len(peers) != 0 {
+			t.Errorf("unexpected peers: %v", peers)
+		}
+		s2.Close()
+	}
+}
+
+func TestServiceTimeout(t *testing.T) {
+	medium := sim.NewMedium()
+	h1 := medium.NewHandle(testAddr1)
+	s1 := testService(t, h1, &Config{NodeID: "node1"})
+	defer s1.Close()
+	s2 := testService(t, medium.NewHandle(testAddr2), &Config{
+		NodeID:  "node2",
+		Timeout: time.Millisecond * 200,
+	})
+	defer s2.Close()
+
+	if event := testEvent(t, s2); event.Type != EventJoin {
+		t.Fatalf("unexpected event: %+v", event)
+	}
+
+	// Closing the Handle silences the node without announcing that
+	// it is leaving.
+	h1.Close()
+	start := time.Now()
+	if event := testEvent(t, s2); event.Type != EventLeave || event.Peer.NodeID != "node1" {
+		t.Errorf("unexpected event: %+v", event)
+	}
+	if time.Since(start) < time.Millisecond*150 {
+		t.Error("peer left too early")
+	}
+}
+
+func TestServiceHopping(t *testing.T) {
+	medium := sim.NewMedium()
+	var channels []gofi.Channel
+	for _, num := range []int{1, 6, 11} {
+		channels = append(channels, gofi.Channel{Number: num})
+	}
+	config := Config{
+		Channels: channels,
+		Dwell:    time.Millisecond * 20,
+		Interval: time.Millisecond * 5,
+	}
+	config1, config2 := config, config
+	config1.NodeID = "node1"
+	config2.NodeID = "node2"
+	s1 := testService(t, medium.NewHandle(testAddr1), &config1)
+	defer s1.Close()
+	s2 := testService(t, medium.NewHandle(testAddr2), &config2)
+	defer s2.Close()
+
+	if event := testEvent(t, s1); event.Type != EventJoin || event.Peer.NodeID != "node2" {
+		t.Errorf("unexpected event: %+v", event)
+	}
+	if event := testEvent(t, s2); event.Type != EventJoin || event.Peer.NodeID != "node1" {
+		t.Errorf("unexpected event: %+v", event)
+	}
+}
+
+func TestServiceChannelErrors(t *testing.T) {
+	medium := sim.NewMedium()
+	h := medium.NewHandle(testAddr1)
+	defer h.Close()
+	_, err := New(h, &Config{
+		NodeID:   "node1",
+		Address:  testAddr1,
+		Channels: []gofi.Channel{{Number: 1}, {Number: 1000}},
+	})
+	if err != ErrUnsupportedChannel {
+		t.Error("unexpected error:", err)
+	}
+
+	// Once the Handle is closed, the Service should stop hopping.
+	counter := &countingHandle{Handle: h}
+	s, err := New(counter, &Config{
+		NodeID:   "node1",
+		Address:  testAddr1,
+		Channels: []gofi.Channel{{Number: 1}, {Number: 6}},
+		Dwell:    time.Millisecond * 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	h.Close()
+	time.Sleep(time.Millisecond * 50)
+	calls := counter.Calls()
+	time.Sleep(time.Millisecond * 50)
+	if counter.Calls() != calls {
+		t.Error("still hopping after the Handle was closed")
+	}
+}
+
+// A countingHandle counts calls to SetChannel.
+type countingHandle struct {
+	*sim.Handle
+
+	lock  sync.Mutex
+	calls int
+}
+
+func (c *countingHandle) SetChannel(ch gofi.Channel) error {
+	c.lock.Lock()
+	c.calls++
+	c.lock.Unlock()
+	return c.Handle.SetChannel(ch)
+}
+
+func (c *countingHandle) Calls() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.calls
+}
+
+func testService(t *testing.T, h *sim.Handle, c *Config) *Service {
+	if c.Address == nil {
+		c.Address = h.Addr()
+	}
+	if c.Interval == 0 {
+		c.Interval = time.Millisecond * 10
+	}
+	s, err := New(h, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func testEvent(t *testing.T, s *Service) *Event {
+	events := make(chan *Event, 1)
+	go func() {
+		event, err := s.NextEvent()
+		if err != nil {
+			t.Error(err)
+		}
+		events <- event
+	}()
+	select {
+	case event := <-events:
+		if event == nil {
+			t.FailNow()
+		}
+		return event
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for event")
+		return nil
+	}
+}
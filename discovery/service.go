@@ -0,0 +1,369 @@
+package discovery
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/unixpickle/gofi"
+	"github.com/unixpickle/gofi/mpdu"
+	"github.com/unixpickle/gofi/oui"
+)
+
+// A Service announces a node and keeps track of its peers.
+type Service struct {
+	handle     gofi.Handle
+	config     Config
+	mpdu       *mpdu.Sender
+	dispatcher *oui.Dispatcher
+	start      time.Time
+
+	lock     sync.Mutex
+	cond     *sync.Cond
+	metadata map[string]string
+	peers    map[string]*Peer
+	events   []Event
+	closed   bool
+	err      error
+
+	// sendLock serializes announcements, and is held while the
+	// channel changes so that nothing is sent mid-hop.
+	sendLock sync.Mutex
+
+	done chan struct{}
+}
+
+// New creates a Service which owns the Handle, and starts announcing
+// and listening.
+// Closing the Service closes the Handle.
+func New(h gofi.Handle, c *Config) (*Service, error) {
+	res := &Service{
+		handle:   h,
+		config:   *c,
+		mpdu:     mpdu.NewSender(),
+		start:    time.Now(),
+		metadata: copyMetadata(c.Metadata),
+		peers:    map[string]*Peer{},
+		done:     make(chan struct{}),
+	}
+	res.config.setDefaults()
+	res.config.Channels = append([]gofi.Channel{}, c.Channels...)
+	if err := checkChannels(h, res.config.Channels); err != nil {
+		return nil, err
+	}
+	if err := res.announcement(false).check(); err != nil {
+		return nil, err
+	}
+	res.cond = sync.NewCond(&res.lock)
+
+	registry := oui.NewRegistry()
+	registry.Register(oui.KindAction, res.config.Key, decodeAnnouncement)
+	registry.Register(oui.KindElement, res.config.Key, decodeAnnouncement)
+	res.dispatcher = oui.NewDispatcher(registry)
+	res.dispatcher.Handle(oui.KindAction, res.config.Key, res.handleAnnouncement)
+	res.dispatcher.Handle(oui.KindElement, res.config.Key, res.handleAnnouncement)
+
+	go res.receiveLoop()
+	go res.announceLoop()
+	return res, nil
+}
+
+// SetMetadata changes the metadata in future announcements.
+func (s *Service) SetMetadata(m map[string]string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	old := s.metadata
+	s.metadata = copyMetadata(m)
+	if err := s.announcementLocked(false).check(); err != nil {
+		s.metadata = old
+		return err
+	}
+	return nil
+}
+
+// Peers returns the peers which have not left, sorted by node ID.
+func (s *Service) Peers() []Peer {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	res := make([]Peer, 0, len(s.peers))
+	for _, p := range s.peers {
+		res = append(res, *p)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].NodeID < res[j].NodeID
+	})
+	return res
+}
+
+// NextEvent waits for the next change to the peer table.
+//
+// If the Handle fails, the events which were already queued are
+// returned before the Handle's error.
+func (s *Service) NextEvent() (*Event, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for len(s.events) == 0 && !s.closed && s.err == nil {
+		s.cond.Wait()
+	}
+	if s.closed {
+		return nil, gofi.ErrClosed
+	}
+	if len(s.events) == 0 {
+		return nil, s.err
+	}
+	event := s.events[0]
+	s.events[0] = Event{}
+	s.events = s.events[1:]
+	return &event, nil
+}
+
+// Close announces that the node is leaving, on whichever channel the
+// Handle is on, and then closes the Service and its Handle.
+func (s *Service) Close() {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return
+	}
+	s.closed = true
+	s.events = nil
+	s.cond.Broadcast()
+	s.lock.Unlock()
+
+	close(s.done)
+	s.announce(true)
+	s.handle.Close()
+}
+
+func (s *Service) receiveLoop() {
+	for {
+		frame, info, err := s.handle.Receive()
+		if err != nil {
+			s.lock.Lock()
+			defer s.lock.Unlock()
+			s.err = err
+			s.cond.Broadcast()
+			return
+		}
+		if info != nil && info.TxStatus != nil {
+			continue
+		}
+		if !frame.ChecksumValid() || bytes.Equal(frame.Addr2(), s.config.Address) {
+			continue
+		}
+		s.dispatcher.Dispatch(frame, info)
+	}
+}
+
+func (s *Service) handleAnnouncement(p oui.Payload, f gofi.Frame, info *gofi.RadioInfo) {
+	a := p.(*announcement)
+	now := time.Now()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return
+	}
+	peer, ok := s.peers[a.nodeID]
+	if a.leaving {
+		if ok {
+			delete(s.peers, a.nodeID)
+			s.addEvent(EventLeave, peer)
+		}
+		return
+	}
+	changed := ok && (!bytes.Equal(peer.Address, f.Addr2()) ||
+		!metadataEqual(peer.Metadata, a.metadata))
+	if !ok {
+		peer = &Peer{NodeID: a.nodeID, FirstSeen: now}
+		s.peers[a.nodeID] = peer
+	}
+	peer.Address = append(net.HardwareAddr{}, f.Addr2()...)
+	peer.Metadata = a.metadata
+	peer.LastSeen = now
+	if info != nil {
+		peer.Channel = gofi.NewChannelFrequency(info.Frequency)
+		peer.SignalPower = info.SignalPower
+	}
+	if !ok {
+		s.addEvent(EventJoin, peer)
+	} else if changed {
+		s.addEvent(EventUpdate, peer)
+	}
+}
+
+// expire removes the peers which timed out.
+func (s *Service) expire() {
+	deadline := time.Now().Add(-s.config.Timeout)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for id, peer := range s.peers {
+		if peer.LastSeen.Before(deadline) {
+			delete(s.peers, id)
+			s.addEvent(EventLeave, peer)
+		}
+	}
+}
+
+// addEvent queues an event with a copy of a peer.
+// The caller must hold s.lock.
+func (s *Service) addEvent(t EventType, p *Peer) {
+	s.events = append(s.events, Event{Type: t, Peer: *p})
+	s.cond.Broadcast()
+}
+
+func (s *Service) announceLoop() {
+	channels := s.config.Channels
+	var seed int64
+	for _, b := range s.config.Address {
+		seed = seed<<8 | int64(b)
+	}
+	rng := rand.New(rand.NewSource(seed ^ s.start.UnixNano()))
+	for {
+		if len(channels) > 1 {
+			rng.Shuffle(len(channels), func(i, j int) {
+				channels[i], channels[j] = channels[j], channels[i]
+			})
+		}
+		var hopped bool
+		for i := 0; i < len(channels) || i == 0; i++ {
+			select {
+			case <-s.done:
+				return
+			default:
+			}
+			if len(channels) > 0 {
+				if err := s.setChannel(channels[i]); err == gofi.ErrClosed {
+					return
+				} else if err != nil {
+					continue
+				}
+			}
+			hopped = true
+			if !s.dwell() {
+				return
+			}
+		}
+		if !hopped {
+			// NOTE: the channels are supported, so the radio may
+			// just be busy, and the next pass might succeed.
+			select {
+			case <-s.done:
+				return
+			case <-time.After(s.config.Dwell):
+			}
+		}
+	}
+}
+
+// setChannel switches channels while nothing is being sent.
+func (s *Service) setChannel(c gofi.Channel) error {
+	s.sendLock.Lock()
+	defer s.sendLock.Unlock()
+	return s.handle.SetChannel(c)
+}
+
+// checkChannels makes sure that a Handle supports every channel.
+func checkChannels(h gofi.Handle, channels []gofi.Channel) error {
+	supported := h.SupportedChannels()
+	for _, c := range channels {
+		var found bool
+		for _, s := range supported {
+			if s.Number == c.Number {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return ErrUnsupportedChannel
+		}
+	}
+	return nil
+}
+
+// dwell announces the node on the current channel until it is time
+// to hop, returning false if the Service was closed.
+func (s *Service) dwell() bool {
+	end := time.Now().Add(s.config.Dwell)
+	for {
+		s.announce(false)
+		s.expire()
+		wait := s.config.Interval
+		if remaining := time.Until(end); remaining <= 0 {
+			return true
+		} else if remaining < wait {
+			wait = remaining
+		}
+		select {
+		case <-s.done:
+			return false
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (s *Service) announce(leaving bool) {
+	s.lock.Lock()
+	a := s.announcementLocked(leaving)
+	s.lock.Unlock()
+
+	s.sendLock.Lock()
+	defer s.sendLock.Unlock()
+	// NOTE: announcements are best-effort, so errors are ignored.
+	s.mpdu.Send(s.handle, s.frame(a), s.config.Rate)
+}
+
+func (s *Service) announcement(leaving bool) *announcement {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.announcementLocked(leaving)
+}
+
+// announcementLocked is like announcement, but the caller must hold
+// s.lock.
+func (s *Service) announcementLocked(leaving bool) *announcement {
+	return &announcement{
+		key:      s.config.Key,
+		nodeID:   s.config.NodeID,
+		metadata: s.metadata,
+		leaving:  leaving,
+	}
+}
+
+// frame creates a broadcast frame which carries an announcement.
+func (s *Service) frame(a *announcement) gofi.Frame {
+	subtype := gofi.SubtypeAction
+	if s.config.Mode == ModeBeacon {
+		subtype = gofi.SubtypeBeacon
+	}
+	broadcast := []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	header := []byte{byte(gofi.FrameTypeManagement)<<2 | byte(subtype)<<4, 0, 0, 0}
+	header = append(header, broadcast...)
+	header = append(header, s.config.Address...)
+	if s.config.Mode == ModeBeacon {
+		header = append(header, s.config.Address...)
+	} else {
+		// Public action frames outside of a BSS use the wildcard
+		// BSSID.
+		header = append(header, broadcast...)
+	}
+	header = append(header, 0, 0)
+
+	if s.config.Mode != ModeBeacon {
+		return gofi.NewFrame(header, oui.PublicActionBody(a))
+	}
+	body := make([]byte, 12)
+	binary.LittleEndian.PutUint64(body, uint64(time.Since(s.start)/time.Microsecond))
+	binary.LittleEndian.PutUint16(body[8:], uint16(s.config.Interval/(time.Microsecond*1024)))
+	binary.LittleEndian.PutUint16(body[10:], capabilityIBSS)
+	elements := []gofi.Element{
+		{ID: gofi.ElementSSID},
+		{ID: gofi.ElementDSParameterSet, Data: []byte{byte(s.handle.Channel().Number)}},
+		oui.Element(a),
+	}
+	return gofi.NewFrame(header, append(body, gofi.EncodeElements(elements)...))
+}
@@ -0,0 +1,292 @@
+package eapol
+
+import (
+	"encoding/binary"
+	"strconv"
+)
+
+// A Code is the code of an EAP packet.
+type Code int
+
+const (
+	CodeRequest  Code = 1
+	CodeResponse Code = 2
+	CodeSuccess  Code = 3
+	CodeFailure  Code = 4
+)
+
+// String returns a human-readable name for the code.
+func (c Code) String() string {
+	switch c {
+	case CodeRequest:
+		return "Request"
+	case CodeResponse:
+		return "Response"
+	case CodeSuccess:
+		return "Success"
+	case CodeFailure:
+		return "Failure"
+	default:
+		return "Code(" + strconv.Itoa(int(c)) + ")"
+	}
+}
+
+// A Method is the type of an EAP request or response.
+type Method int
+
+const (
+	MethodNone         Method = 0
+	MethodIdentity     Method = 1
+	MethodNotification Method = 2
+	MethodNak          Method = 3
+	MethodMD5          Method = 4
+	MethodGTC          Method = 6
+	MethodTLS          Method = 13
+	MethodSIM          Method = 18
+	MethodTTLS         Method = 21
+	MethodAKA          Method = 23
+	MethodPEAP         Method = 25
+	MethodMSCHAPv2     Method = 26
+	MethodFAST         Method = 43
+	MethodAKAPrime     Method = 50
+	MethodPWD          Method = 52
+	MethodExpanded     Method = 254
+)
+
+// String returns a human-readable name for the method.
+func (m Method) String() string {
+	switch m {
+	case MethodNone:
+		return "None"
+	case MethodIdentity:
+		return "Identity"
+	case MethodNotification:
+		return "Notification"
+	case MethodNak:
+		return "Nak"
+	case MethodMD5:
+		return "MD5"
+	case MethodGTC:
+		return "GTC"
+	case MethodTLS:
+		return "TLS"
+	case MethodSIM:
+		return "SIM"
+	case MethodTTLS:
+		return "TTLS"
+	case MethodAKA:
+		return "AKA"
+	case MethodPEAP:
+		return "PEAP"
+	case MethodMSCHAPv2:
+		return "MSCHAPv2"
+	case MethodFAST:
+		return "FAST"
+	case MethodAKAPrime:
+		return "AKA'"
+	case MethodPWD:
+		return "pwd"
+	case MethodExpanded:
+		return "Expanded"
+	default:
+		return "Method(" + strconv.Itoa(int(m)) + ")"
+	}
+}
+
+// eapHeaderSize is the size of the EAP header, without the type.
+const eapHeaderSize = 4
+
+// An EAP is an EAP packet.
+type EAP struct {
+	Code       Code
+	Identifier int
+
+	// Method is the type of a request or response.
+	// It is MethodNone for success and failure packets.
+	Method Method
+
+	// Data is everything after the type.
+	Data []byte
+}
+
+// ParseEAP decodes an EAP packet.
+func ParseEAP(data []byte) (*EAP, error) {
+	if len(data) < eapHeaderSize {
+		return nil, ErrTruncated
+	}
+	size := int(binary.BigEndian.Uint16(data[2:]))
+	if size < eapHeaderSize || len(data) < size {
+		return nil, ErrTruncated
+	}
+	data = data[:size]
+	res := &EAP{Code: Code(data[0]), Identifier: int(data[1])}
+	if res.Code == CodeRequest || res.Code == CodeResponse {
+		if len(data) < eapHeaderSize+1 {
+			return nil, ErrTruncated
+		}
+		res.Method = Method(data[eapHeaderSize])
+		res.Data = data[eapHeaderSize+1:]
+	}
+	return res, nil
+}
+
+// Encode encodes the EAP packet.
+func (e *EAP) Encode() []byte {
+	size := eapHeaderSize
+	if e.Code == CodeRequest || e.Code == CodeResponse {
+		size += 1 + len(e.Data)
+	}
+	res := []byte{byte(e.Code), byte(e.Identifier), byte(size >> 8), byte(size)}
+	if e.Code == CodeRequest || e.Code == CodeResponse {
+		res = append(res, byte(e.Method))
+		res = append(res, e.Data...)
+	}
+	return res
+}
+
+// Text returns the text of an Identity or Notification packet.
+// For Identity requests, this is an optional prompt.
+func (e *EAP) Text() (string, error) {
+	if e.Method != MethodIdentity && e.Method != MethodNotification {
+		return "", ErrWrongType
+	}
+	return string(e.Data), nil
+}
+
+// NakMethods returns the methods which a Nak response proposes
+// instead of the one which was requested.
+func (e *EAP) NakMethods() ([]Method, error) {
+	if e.Method != MethodNak {
+		return nil, ErrWrongType
+	}
+	var res []Method
+	for _, m := range e.Data {
+		res = append(res, Method(m))
+	}
+	return res, nil
+}
+
+// These are the flags of TLS based methods.
+const (
+	TLSFlagLength        = 0x80
+	TLSFlagMoreFragments = 0x40
+	TLSFlagStart         = 0x20
+
+	// TLSFlagVersionMask covers the version bits used by TTLS,
+	// PEAP, and FAST.
+	TLSFlagVersionMask = 0x07
+)
+
+// A TLSFragment is a fragment of a TLS based method, such as TLS,
+// TTLS, or PEAP.
+type TLSFragment struct {
+	Flags byte
+
+	// Length is the total length of the TLS message, which is only
+	// present if the TLSFlagLength flag is set.
+	Length int
+
+	Data []byte
+}
+
+// TLS decodes the data of a TLS based method.
+func (e *EAP) TLS() (*TLSFragment, error) {
+	switch e.Method {
+	case MethodTLS, MethodTTLS, MethodPEAP, MethodFAST:
+	default:
+		return nil, ErrWrongType
+	}
+	if len(e.Data) < 1 {
+		return nil, ErrTruncated
+	}
+	res := &TLSFragment{Flags: e.Data[0], Data: e.Data[1:]}
+	if res.HasLength() {
+		if len(res.Data) < 4 {
+			return nil, ErrTruncated
+		}
+		res.Length = int(binary.BigEndian.Uint32(res.Data))
+		res.Data = res.Data[4:]
+	}
+	return res, nil
+}
+
+// HasLength checks if the fragment includes the total length.
+func (t *TLSFragment) HasLength() bool {
+	return (t.Flags & TLSFlagLength) != 0
+}
+
+// MoreFragments checks if more fragments follow this one.
+func (t *TLSFragment) MoreFragments() bool {
+	return (t.Flags & TLSFlagMoreFragments) != 0
+}
+
+// Start checks if this is the start of the method.
+func (t *TLSFragment) Start() bool {
+	return (t.Flags & TLSFlagStart) != 0
+}
+
+// Version returns the version of TTLS, PEAP, or FAST.
+func (t *TLSFragment) Version() int {
+	return int(t.Flags & TLSFlagVersionMask)
+}
+
+// These are the subtypes of EAP-SIM and EAP-AKA packets.
+const (
+	SimAkaChallenge        = 1
+	SimAkaAuthReject       = 2
+	SimAkaSyncFailure      = 4
+	SimAkaIdentity         = 5
+	SimAkaStart            = 10
+	SimAkaSIMChallenge     = 11
+	SimAkaNotification     = 12
+	SimAkaReauthentication = 13
+	SimAkaClientError      = 14
+)
+
+// A SimAkaAttribute is an attribute of an EAP-SIM or EAP-AKA packet.
+type SimAkaAttribute struct {
+	Type byte
+
+	// Value is everything after the type and length, including any
+	// reserved bytes or actual length field which the attribute
+	// uses.
+	Value []byte
+}
+
+// A SimAka is the header and attributes of an EAP-SIM, EAP-AKA, or
+// EAP-AKA' packet.
+type SimAka struct {
+	Subtype    int
+	Attributes []SimAkaAttribute
+}
+
+// SimAka decodes the data of an EAP-SIM, EAP-AKA, or EAP-AKA' packet.
+func (e *EAP) SimAka() (*SimAka, error) {
+	switch e.Method {
+	case MethodSIM, MethodAKA, MethodAKAPrime:
+	default:
+		return nil, ErrWrongType
+	}
+	if len(e.Data) < 3 {
+		return nil, ErrTruncated
+	}
+	res := &SimAka{Subtype: int(e.Data[0])}
+	data := e.Data[3:]
+	for len(data) > 0 {
+		if len(data) < 2 {
+			return nil, ErrTruncated
+		}
+		// NOTE: attribute lengths are in units of 4 bytes and
+		// include the type and length.
+		size := int(data[1]) * 4
+		if size < 4 || len(data) < size {
+			return nil, ErrTruncated
+		}
+		res.Attributes = append(res.Attributes, SimAkaAttribute{
+			Type:  data[0],
+			Value: data[2:size],
+		})
+		data = data[size:]
+	}
+	return res, nil
+}
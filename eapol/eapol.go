@@ -0,0 +1,125 @@
+// Package eapol decodes EAPOL and EAP, the protocols which stations
+// use to authenticate with WPA networks.
+//
+// Besides decoding individual packets, a Tracker follows the
+// authentication of each station and reports where it failed.
+package eapol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strconv"
+
+	"github.com/unixpickle/gofi"
+)
+
+var (
+	ErrNotEAPOL   = errors.New("frame does not carry EAPOL")
+	ErrTruncated  = errors.New("packet is truncated")
+	ErrWrongType  = errors.New("packet is of the wrong type")
+	ErrBadKeyData = errors.New("invalid key data")
+)
+
+// A Type is the packet type of an EAPOL packet.
+type Type int
+
+const (
+	TypeEAP    Type = 0
+	TypeStart  Type = 1
+	TypeLogoff Type = 2
+	TypeKey    Type = 3
+	TypeAlert  Type = 4
+)
+
+// String returns a human-readable name for the packet type.
+func (t Type) String() string {
+	switch t {
+	case TypeEAP:
+		return "EAP"
+	case TypeStart:
+		return "Start"
+	case TypeLogoff:
+		return "Logoff"
+	case TypeKey:
+		return "Key"
+	case TypeAlert:
+		return "Alert"
+	default:
+		return "Type(" + strconv.Itoa(int(t)) + ")"
+	}
+}
+
+// headerSize is the size of the EAPOL header.
+const headerSize = 4
+
+// llcSNAP is the LLC/SNAP header of data frames which carry EAPOL.
+var llcSNAP = []byte{0xaa, 0xaa, 0x03, 0, 0, 0, 0x88, 0x8e}
+
+// A Packet is an EAPOL packet.
+type Packet struct {
+	Version int
+	Type    Type
+
+	// Body is the packet body, without any padding which followed
+	// it in the frame.
+	Body []byte
+}
+
+// ParsePacket decodes an EAPOL packet.
+func ParsePacket(data []byte) (*Packet, error) {
+	if len(data) < headerSize {
+		return nil, ErrTruncated
+	}
+	size := int(binary.BigEndian.Uint16(data[2:]))
+	if len(data) < headerSize+size {
+		return nil, ErrTruncated
+	}
+	return &Packet{
+		Version: int(data[0]),
+		Type:    Type(data[1]),
+		Body:    data[headerSize : headerSize+size],
+	}, nil
+}
+
+// ParseFrame decodes the EAPOL packet in an unprotected data frame.
+//
+// If the frame does not carry EAPOL, ErrNotEAPOL is returned.
+func ParseFrame(f gofi.Frame) (*Packet, error) {
+	if f.Type() != gofi.FrameTypeData || f.Protected() || !f.HasBody() {
+		return nil, ErrNotEAPOL
+	}
+	body := f.Body()
+	if !bytes.HasPrefix(body, llcSNAP) {
+		return nil, ErrNotEAPOL
+	}
+	return ParsePacket(body[len(llcSNAP):])
+}
+
+// Encode encodes the packet, including its header.
+func (p *Packet) Encode() []byte {
+	res := []byte{byte(p.Version), byte(p.Type), byte(len(p.Body) >> 8), byte(len(p.Body))}
+	return append(res, p.Body...)
+}
+
+// EncodeLLC encodes the packet with an LLC/SNAP header, making it
+// suitable for the body of a data frame.
+func (p *Packet) EncodeLLC() []byte {
+	return append(append([]byte{}, llcSNAP...), p.Encode()...)
+}
+
+// Key decodes the body of an EAPOL-Key packet.
+func (p *Packet) Key() (*Key, error) {
+	if p.Type != TypeKey {
+		return nil, ErrWrongType
+	}
+	return ParseKey(p.Body)
+}
+
+// EAP decodes the body of an EAP packet.
+func (p *Packet) EAP() (*EAP, error) {
+	if p.Type != TypeEAP {
+		return nil, ErrWrongType
+	}
+	return ParseEAP(p.Body)
+}
@@ -0,0 +1,220 @@
+package eapol
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/unixpickle/gofi"
+)
+
+var (
+	testAP  = net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	testSTA = net.HardwareAddr{0x02, 0, 0, 0, 0, 2}
+)
+
+func TestKey(t *testing.T) {
+	gtk := bytes.Repeat([]byte{3}, 16)
+	keyData := gofi.EncodeElements([]gofi.Element{
+		{ID: gofi.ElementRSN, Data: []byte{1, 0}},
+		{ID: gofi.ElementVendorSpecific, Data: append([]byte{0, 0x0f, 0xac, KDEGTK, 1, 0}, gtk...)},
+	})
+	keyData = append(keyData, 0xdd, 0, 0)
+	key := &Key{
+		Descriptor:    DescriptorRSN,
+		Info:          2 | KeyInfoPairwise | KeyInfoAck | KeyInfoMIC | KeyInfoInstall,
+		Length:        16,
+		ReplayCounter: 3,
+		Nonce:         bytes.Repeat([]byte{1}, 32),
+		MIC:           bytes.Repeat([]byte{4}, 16),
+		Data:          keyData,
+	}
+	packet := &Packet{Version: 2, Type: TypeKey, Body: key.Encode()}
+	frame := testFrame(true, packet)
+
+	parsed, err := ParseFrame(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := parsed.Key()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Info != key.Info || decoded.ReplayCounter != 3 || decoded.Length != 16 ||
+		!bytes.Equal(decoded.Nonce, key.Nonce) || !bytes.Equal(decoded.MIC, key.MIC) ||
+		!bytes.Equal(decoded.Data, keyData) {
+		t.Errorf("unexpected key: %+v", decoded)
+	}
+	if decoded.Message() != KeyMessage3 {
+		t.Errorf("unexpected message: %v", decoded.Message())
+	}
+	if s := decoded.Info.String(); s != "v2|Pairwise|Install|Ack|MIC" {
+		t.Errorf("unexpected key info: %s", s)
+	}
+
+	kdes := decoded.KDEs()
+	if len(kdes) != 1 || !kdes[0].IsStandard() || kdes[0].Type != KDEGTK ||
+		!bytes.Equal(kdes[0].Data[2:], gtk) {
+		t.Errorf("unexpected KDEs: %+v", kdes)
+	}
+
+	if _, err := ParseFrame(gofi.NewFrame(frame[:26], []byte("not EAPOL"))); err != ErrNotEAPOL {
+		t.Errorf("expected ErrNotEAPOL but got %v", err)
+	}
+	if _, err := parsed.EAP(); err != ErrWrongType {
+		t.Errorf("expected ErrWrongType but got %v", err)
+	}
+}
+
+func TestEAP(t *testing.T) {
+	identity := &EAP{Code: CodeResponse, Identifier: 1, Method: MethodIdentity,
+		Data: []byte("alice@example.com")}
+	parsed, err := ParseEAP(identity.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text, err := parsed.Text(); err != nil || text != "alice@example.com" {
+		t.Errorf("unexpected identity: %q (%v)", text, err)
+	}
+
+	tls := &EAP{Code: CodeRequest, Identifier: 2, Method: MethodPEAP,
+		Data: []byte{TLSFlagLength | TLSFlagMoreFragments | 1, 0, 0, 0x10, 0, 0x16, 0x03}}
+	parsed, err = ParseEAP(tls.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	fragment, err := parsed.TLS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fragment.HasLength() || !fragment.MoreFragments() || fragment.Start() ||
+		fragment.Version() != 1 || fragment.Length != 0x1000 ||
+		!bytes.Equal(fragment.Data, []byte{0x16, 0x03}) {
+		t.Errorf("unexpected fragment: %+v", fragment)
+	}
+
+	aka := &EAP{Code: CodeRequest, Identifier: 3, Method: MethodAKA,
+		Data: []byte{SimAkaChallenge, 0, 0, 1, 5, 0, 0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13,
+			14, 15, 16, 11, 1, 0, 0}}
+	parsed, err = ParseEAP(aka.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, err := parsed.SimAka()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Subtype != SimAkaChallenge || len(header.Attributes) != 2 ||
+		header.Attributes[0].Type != 1 || len(header.Attributes[0].Value) != 18 ||
+		header.Attributes[1].Type != 11 {
+		t.Errorf("unexpected header: %+v", header)
+	}
+
+	success, err := ParseEAP((&EAP{Code: CodeSuccess, Identifier: 4}).Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if success.Code != CodeSuccess || success.Method != MethodNone {
+		t.Errorf("unexpected packet: %+v", success)
+	}
+	if _, err := ParseEAP([]byte{1, 2, 0, 10, 1}); err != ErrTruncated {
+		t.Errorf("expected ErrTruncated but got %v", err)
+	}
+}
+
+func TestTrackerPSK(t *testing.T) {
+	tracker := NewTracker()
+	nonce := bytes.Repeat([]byte{1}, 32)
+	info := KeyInfo(2) | KeyInfoPairwise
+	m1 := testKeyFrame(true, &Key{Info: info | KeyInfoAck, Nonce: nonce})
+	m2 := testKeyFrame(false, &Key{Info: info | KeyInfoMIC, Nonce: nonce})
+	m3 := testKeyFrame(true, &Key{Info: info | KeyInfoAck | KeyInfoMIC | KeyInfoInstall |
+		KeyInfoSecure, Nonce: nonce})
+	m4 := testKeyFrame(false, &Key{Info: info | KeyInfoMIC | KeyInfoSecure})
+
+	for i, f := range []gofi.Frame{m1, m2, m3, m4} {
+		s := tracker.Observe(f)
+		if s == nil || s.LastMessage != KeyMessage(i+1) || s.Failed {
+			t.Fatalf("message %d: unexpected session %+v", i+1, s)
+		}
+	}
+	if s := tracker.Session(testAP, testSTA); s.Stage != StageComplete {
+		t.Errorf("unexpected stage: %v", s.Stage)
+	}
+
+	// A wrong passphrase makes the AP repeat message 1.
+	for _, f := range []gofi.Frame{m1, m2, m1} {
+		tracker.Observe(f)
+	}
+	s := tracker.Session(testAP, testSTA)
+	if !s.Failed || s.FailedStage != StageHandshake {
+		t.Errorf("unexpected session: %+v", s)
+	}
+}
+
+func TestTrackerEAP(t *testing.T) {
+	tracker := NewTracker()
+	frames := []gofi.Frame{
+		testFrame(false, &Packet{Version: 2, Type: TypeStart}),
+		testEAPFrame(&EAP{Code: CodeRequest, Identifier: 1, Method: MethodIdentity}),
+		testEAPFrame(&EAP{Code: CodeResponse, Identifier: 1, Method: MethodIdentity,
+			Data: []byte("bob")}),
+		testEAPFrame(&EAP{Code: CodeRequest, Identifier: 2, Method: MethodTLS,
+			Data: []byte{TLSFlagStart}}),
+		testEAPFrame(&EAP{Code: CodeResponse, Identifier: 2, Method: MethodNak,
+			Data: []byte{byte(MethodPEAP)}}),
+		testEAPFrame(&EAP{Code: CodeRequest, Identifier: 3, Method: MethodPEAP,
+			Data: []byte{TLSFlagStart}}),
+		testEAPFrame(&EAP{Code: CodeFailure, Identifier: 3}),
+	}
+	var s *Session
+	for _, f := range frames {
+		s = tracker.Observe(f)
+		if s == nil {
+			t.Fatal("frame was ignored")
+		}
+	}
+	if !s.Failed || s.FailedStage != StageMethod || s.Method != MethodPEAP ||
+		s.Identity != "bob" || s.Reason != "EAP failure" {
+		t.Errorf("unexpected session: %+v", s)
+	}
+
+	tracker.Observe(frames[0])
+	tracker.Observe(testEAPFrame(&EAP{Code: CodeSuccess, Identifier: 4}))
+	header := []byte{gofi.SubtypeDeauth << 4, 0, 0, 0}
+	header = append(header, testSTA...)
+	header = append(header, testAP...)
+	header = append(header, testAP...)
+	header = append(header, 0x20, 0)
+	deauth := gofi.NewFrame(header, []byte{15, 0})
+	s = tracker.Observe(deauth)
+	if s == nil || !s.Failed || s.FailedStage != StageEAPSuccess ||
+		s.Reason != "deauthenticated with reason 15" {
+		t.Errorf("unexpected session: %+v", s)
+	}
+}
+
+func testKeyFrame(fromAP bool, k *Key) gofi.Frame {
+	k.Descriptor = DescriptorRSN
+	return testFrame(fromAP, &Packet{Version: 2, Type: TypeKey, Body: k.Encode()})
+}
+
+func testEAPFrame(e *EAP) gofi.Frame {
+	return testFrame(e.Code != CodeResponse, &Packet{Version: 2, Type: TypeEAP,
+		Body: e.Encode()})
+}
+
+func testFrame(fromAP bool, p *Packet) gofi.Frame {
+	header := []byte{0x88, gofi.FlagFromDS, 0, 0}
+	if fromAP {
+		header = append(header, testSTA...)
+		header = append(header, testAP...)
+	} else {
+		header[1] = gofi.FlagToDS
+		header = append(header, testAP...)
+		header = append(header, testSTA...)
+	}
+	header = append(header, testAP...)
+	header = append(header, 0x10, 0, 6, 0)
+	return gofi.NewFrame(header, p.EncodeLLC())
+}
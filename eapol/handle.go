@@ -0,0 +1,26 @@
+package eapol
+
+import "github.com/unixpickle/gofi"
+
+// A Handle wraps a gofi.Handle and passes every frame it receives to
+// a Tracker.
+type Handle struct {
+	gofi.Handle
+
+	Tracker *Tracker
+}
+
+// NewHandle creates a Handle which observes frames with t.
+func NewHandle(h gofi.Handle, t *Tracker) *Handle {
+	return &Handle{Handle: h, Tracker: t}
+}
+
+// Receive receives the next frame and observes it.
+func (h *Handle) Receive() (gofi.Frame, *gofi.RadioInfo, error) {
+	frame, info, err := h.Handle.Receive()
+	if err != nil {
+		return nil, nil, err
+	}
+	h.Tracker.Observe(frame)
+	return frame, info, nil
+}
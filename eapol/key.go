@@ -0,0 +1,277 @@
+package eapol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+	"strings"
+
+	"github.com/unixpickle/gofi"
+)
+
+// These are the descriptor types of EAPOL-Key packets.
+const (
+	DescriptorRC4 = 1
+	DescriptorRSN = 2
+	DescriptorWPA = 254
+)
+
+// KeyInfo is the key information field of an EAPOL-Key packet.
+type KeyInfo uint16
+
+// These are the bits of the key information field.
+const (
+	KeyInfoVersionMask   KeyInfo = 0x0007
+	KeyInfoPairwise      KeyInfo = 0x0008
+	KeyInfoIndexMask     KeyInfo = 0x0030
+	KeyInfoInstall       KeyInfo = 0x0040
+	KeyInfoAck           KeyInfo = 0x0080
+	KeyInfoMIC           KeyInfo = 0x0100
+	KeyInfoSecure        KeyInfo = 0x0200
+	KeyInfoError         KeyInfo = 0x0400
+	KeyInfoRequest       KeyInfo = 0x0800
+	KeyInfoEncryptedData KeyInfo = 0x1000
+	KeyInfoSMKMessage    KeyInfo = 0x2000
+)
+
+var keyInfoNames = []struct {
+	bit  KeyInfo
+	name string
+}{
+	{KeyInfoPairwise, "Pairwise"},
+	{KeyInfoInstall, "Install"},
+	{KeyInfoAck, "Ack"},
+	{KeyInfoMIC, "MIC"},
+	{KeyInfoSecure, "Secure"},
+	{KeyInfoError, "Error"},
+	{KeyInfoRequest, "Request"},
+	{KeyInfoEncryptedData, "EncryptedData"},
+	{KeyInfoSMKMessage, "SMKMessage"},
+}
+
+// Version returns the key descriptor version.
+func (k KeyInfo) Version() int {
+	return int(k & KeyInfoVersionMask)
+}
+
+// Index returns the key index, which WPA group key messages use to
+// identify the GTK.
+func (k KeyInfo) Index() int {
+	return int(k&KeyInfoIndexMask) >> 4
+}
+
+// Has checks if all of the given bits are set.
+func (k KeyInfo) Has(bits KeyInfo) bool {
+	return (k & bits) == bits
+}
+
+// String returns the descriptor version and the names of the set
+// bits, such as "v2|Pairwise|Ack".
+func (k KeyInfo) String() string {
+	parts := []string{"v" + strconv.Itoa(k.Version())}
+	for _, n := range keyInfoNames {
+		if k.Has(n.bit) {
+			parts = append(parts, n.name)
+		}
+	}
+	return strings.Join(parts, "|")
+}
+
+// keyFixedSize is the size of an EAPOL-Key body up to its MIC.
+const keyFixedSize = 77
+
+// A Key is the body of an EAPOL-Key packet.
+type Key struct {
+	Descriptor    int
+	Info          KeyInfo
+	Length        int
+	ReplayCounter uint64
+	Nonce         []byte
+	IV            []byte
+	RSC           []byte
+	MIC           []byte
+	Data          []byte
+}
+
+// ParseKey decodes the body of an EAPOL-Key packet.
+//
+// The MIC is usually 16 bytes, but is 24 bytes for AKMs which use
+// SHA-384.
+// Since the packet does not say which, the MIC size is inferred
+// from the length of the key data.
+func ParseKey(body []byte) (*Key, error) {
+	if len(body) < keyFixedSize+2 {
+		return nil, ErrTruncated
+	}
+	for _, micSize := range []int{16, 24} {
+		lengthOffset := keyFixedSize + micSize
+		if len(body) < lengthOffset+2 {
+			continue
+		}
+		dataSize := int(binary.BigEndian.Uint16(body[lengthOffset:]))
+		if lengthOffset+2+dataSize != len(body) {
+			continue
+		}
+		return &Key{
+			Descriptor:    int(body[0]),
+			Info:          KeyInfo(binary.BigEndian.Uint16(body[1:])),
+			Length:        int(binary.BigEndian.Uint16(body[3:])),
+			ReplayCounter: binary.BigEndian.Uint64(body[5:]),
+			Nonce:         body[13:45],
+			IV:            body[45:61],
+			RSC:           body[61:69],
+			MIC:           body[keyFixedSize:lengthOffset],
+			Data:          body[lengthOffset+2:],
+		}, nil
+	}
+	return nil, ErrTruncated
+}
+
+// Encode encodes the key as the body of an EAPOL-Key packet.
+// If MIC is nil, a 16 byte zero MIC is used.
+func (k *Key) Encode() []byte {
+	mic := k.MIC
+	if mic == nil {
+		mic = make([]byte, 16)
+	}
+	res := make([]byte, keyFixedSize, keyFixedSize+len(mic)+2+len(k.Data))
+	res[0] = byte(k.Descriptor)
+	binary.BigEndian.PutUint16(res[1:], uint16(k.Info))
+	binary.BigEndian.PutUint16(res[3:], uint16(k.Length))
+	binary.BigEndian.PutUint64(res[5:], k.ReplayCounter)
+	copy(res[13:45], k.Nonce)
+	copy(res[45:61], k.IV)
+	copy(res[61:69], k.RSC)
+	res = append(res, mic...)
+	res = append(res, byte(len(k.Data)>>8), byte(len(k.Data)))
+	return append(res, k.Data...)
+}
+
+// A KeyMessage identifies a message of the 4-way handshake or of the
+// group key handshake.
+type KeyMessage int
+
+const (
+	KeyMessageUnknown KeyMessage = iota
+	KeyMessage1
+	KeyMessage2
+	KeyMessage3
+	KeyMessage4
+	KeyMessageGroup1
+	KeyMessageGroup2
+)
+
+// String returns a human-readable name for the message.
+func (k KeyMessage) String() string {
+	switch k {
+	case KeyMessage1, KeyMessage2, KeyMessage3, KeyMessage4:
+		return "message " + strconv.Itoa(int(k-KeyMessage1)+1) + " of 4"
+	case KeyMessageGroup1, KeyMessageGroup2:
+		return "group message " + strconv.Itoa(int(k-KeyMessageGroup1)+1) + " of 2"
+	default:
+		return "unknown message"
+	}
+}
+
+// Message identifies the handshake message from its key information
+// and nonce.
+func (k *Key) Message() KeyMessage {
+	info := k.Info
+	if info.Has(KeyInfoRequest) {
+		return KeyMessageUnknown
+	}
+	if !info.Has(KeyInfoPairwise) {
+		if info.Has(KeyInfoAck) {
+			return KeyMessageGroup1
+		}
+		return KeyMessageGroup2
+	}
+	switch {
+	case info.Has(KeyInfoAck) && !info.Has(KeyInfoMIC):
+		return KeyMessage1
+	case info.Has(KeyInfoAck):
+		return KeyMessage3
+	case !info.Has(KeyInfoMIC):
+		return KeyMessageUnknown
+	case info.Has(KeyInfoSecure) || allZero(k.Nonce):
+		// NOTE: WPA supplicants do not set the secure bit in the
+		// fourth message, but its nonce is always zero.
+		return KeyMessage4
+	default:
+		return KeyMessage2
+	}
+}
+
+// A KDE is a key data encapsulation from the key data of an
+// EAPOL-Key packet.
+type KDE struct {
+	OUI  []byte
+	Type byte
+	Data []byte
+}
+
+// These are the KDE types with the OUI 00-0F-AC.
+const (
+	KDEGTK      = 1
+	KDEMACAddr  = 3
+	KDEPMKID    = 4
+	KDENonce    = 6
+	KDELifetime = 7
+	KDEError    = 8
+	KDEIGTK     = 9
+	KDEKeyID    = 10
+	KDEBIGTK    = 11
+)
+
+var kdeOUI = []byte{0x00, 0x0f, 0xac}
+
+// Elements decodes the key data as a sequence of elements and KDEs.
+//
+// KDEs have the element ID of vendor specific elements, and are
+// returned as such; KDEs extracts their contents.
+// The key data is only meaningful when it is not encrypted, which is
+// the case unless the EncryptedData bit is set.
+func (k *Key) Elements() ([]gofi.Element, error) {
+	if elements, err := gofi.ParseElements(k.Data); err == nil {
+		return elements, nil
+	}
+	// NOTE: key data may be padded with 0xdd followed by zeros.
+	if i := bytes.LastIndexByte(k.Data, 0xdd); i >= 0 && allZero(k.Data[i+1:]) {
+		if elements, err := gofi.ParseElements(k.Data[:i]); err == nil {
+			return elements, nil
+		}
+	}
+	return nil, ErrBadKeyData
+}
+
+// KDEs returns the KDEs in the key data.
+// If the key data cannot be parsed, this returns nil.
+func (k *Key) KDEs() []KDE {
+	elements, err := k.Elements()
+	if err != nil {
+		return nil
+	}
+	var res []KDE
+	for _, e := range elements {
+		if e.ID != gofi.ElementVendorSpecific || len(e.Data) < 4 {
+			continue
+		}
+		res = append(res, KDE{OUI: e.Data[:3], Type: e.Data[3], Data: e.Data[4:]})
+	}
+	return res
+}
+
+// IsStandard checks if a KDE uses the OUI 00-0F-AC, as opposed to
+// being a vendor specific element.
+func (k KDE) IsStandard() bool {
+	return bytes.Equal(k.OUI, kdeOUI)
+}
+
+func allZero(b []byte) bool {
+	for _, x := range b {
+		if x != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,322 @@
+package eapol
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/unixpickle/gofi"
+)
+
+// A Stage is a step in a station's authentication.
+type Stage int
+
+const (
+	StageNone Stage = iota
+	StageStart
+	StageIdentity
+	StageMethod
+	StageEAPSuccess
+	StageHandshake
+	StageComplete
+)
+
+// String returns a human-readable name for the stage.
+func (s Stage) String() string {
+	switch s {
+	case StageNone:
+		return "none"
+	case StageStart:
+		return "EAPOL start"
+	case StageIdentity:
+		return "EAP identity"
+	case StageMethod:
+		return "EAP method"
+	case StageEAPSuccess:
+		return "EAP success"
+	case StageHandshake:
+		return "4-way handshake"
+	case StageComplete:
+		return "complete"
+	default:
+		return "Stage(" + strconv.Itoa(int(s)) + ")"
+	}
+}
+
+// A Session is the state of one station's authentication with an AP.
+type Session struct {
+	AP      net.HardwareAddr
+	Station net.HardwareAddr
+
+	Stage Stage
+
+	// Identity and Method are the EAP identity and method, if the
+	// network uses 802.1X.
+	Identity string
+	Method   Method
+
+	// LastMessage is the last 4-way handshake message seen.
+	LastMessage KeyMessage
+
+	// If Failed is true, FailedStage is the stage during which the
+	// authentication failed and Reason describes what happened.
+	Failed      bool
+	FailedStage Stage
+	Reason      string
+}
+
+// A Tracker follows the authentication of every station it sees,
+// using EAPOL frames and deauthentication and disassociation frames.
+//
+// A session starts over when a station associates, sends EAPOL-Start,
+// or is sent an EAP identity request, and when a new 4-way handshake
+// follows a completed one.
+//
+// A Tracker is safe to use from multiple Goroutines.
+type Tracker struct {
+	lock     sync.Mutex
+	sessions map[string]*Session
+}
+
+// NewTracker creates a Tracker with no sessions.
+func NewTracker() *Tracker {
+	return &Tracker{sessions: map[string]*Session{}}
+}
+
+// Sessions returns a copy of every session.
+func (t *Tracker) Sessions() []Session {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	var res []Session
+	for _, s := range t.sessions {
+		res = append(res, *s)
+	}
+	return res
+}
+
+// Session returns a copy of the session between an AP and a station,
+// or nil if there is no such session.
+func (t *Tracker) Session(ap, sta net.HardwareAddr) *Session {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if s, ok := t.sessions[sessionKey(ap, sta)]; ok {
+		res := *s
+		return &res
+	}
+	return nil
+}
+
+// Observe updates the session which a frame pertains to.
+//
+// If the frame changed a session, a copy of the session is returned.
+// Otherwise, this returns nil.
+func (t *Tracker) Observe(f gofi.Frame) *Session {
+	if f.Type() == gofi.FrameTypeManagement {
+		return t.observeManagement(f)
+	}
+	packet, err := ParseFrame(f)
+	if err != nil {
+		return nil
+	}
+
+	var fromAP bool
+	switch packet.Type {
+	case TypeStart, TypeLogoff:
+	case TypeKey:
+		key, err := packet.Key()
+		if err != nil {
+			return nil
+		}
+		fromAP = key.Info.Has(KeyInfoAck)
+	case TypeEAP:
+		eap, err := packet.EAP()
+		if err != nil {
+			return nil
+		}
+		fromAP = eap.Code != CodeResponse
+	default:
+		return nil
+	}
+	ap, sta, ok := frameParties(f, fromAP)
+	if !ok {
+		return nil
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	s := t.session(ap, sta)
+
+	switch packet.Type {
+	case TypeStart:
+		s.reset(StageStart)
+	case TypeLogoff:
+		if s.Stage != StageComplete {
+			s.fail("station logged off")
+		}
+	case TypeKey:
+		key, _ := packet.Key()
+		s.observeKey(key)
+	case TypeEAP:
+		eap, _ := packet.EAP()
+		s.observeEAP(eap)
+	}
+	res := *s
+	return &res
+}
+
+func (t *Tracker) observeManagement(f gofi.Frame) *Session {
+	addr1, addr2, addr3 := f.Addr1(), f.Addr2(), f.Addr3()
+	if addr3 == nil {
+		return nil
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	switch f.Subtype() {
+	case gofi.SubtypeAssocRequest, gofi.SubtypeReassocRequest:
+		s := t.session(addr1, addr2)
+		s.reset(StageNone)
+		res := *s
+		return &res
+	case gofi.SubtypeDeauth, gofi.SubtypeDisassoc:
+		ap, sta := addr1, addr2
+		if addr2.String() == addr3.String() {
+			ap, sta = addr2, addr1
+		}
+		s, ok := t.sessions[sessionKey(ap, sta)]
+		if !ok || s.Failed || s.Stage == StageNone || s.Stage == StageComplete {
+			return nil
+		}
+		var reason uint16
+		if body := f.Body(); len(body) >= 2 {
+			reason = binary.LittleEndian.Uint16(body)
+		}
+		kind := "deauthenticated"
+		if f.Subtype() == gofi.SubtypeDisassoc {
+			kind = "disassociated"
+		}
+		s.fail(fmt.Sprintf("%s with reason %d", kind, reason))
+		res := *s
+		return &res
+	}
+	return nil
+}
+
+func (t *Tracker) session(ap, sta net.HardwareAddr) *Session {
+	key := sessionKey(ap, sta)
+	s, ok := t.sessions[key]
+	if !ok {
+		s = &Session{
+			AP:      append(net.HardwareAddr{}, ap...),
+			Station: append(net.HardwareAddr{}, sta...),
+		}
+		t.sessions[key] = s
+	}
+	return s
+}
+
+func (s *Session) reset(stage Stage) {
+	*s = Session{AP: s.AP, Station: s.Station, Stage: stage}
+}
+
+func (s *Session) fail(reason string) {
+	if s.Failed {
+		return
+	}
+	s.Failed = true
+	s.FailedStage = s.Stage
+	s.Reason = reason
+}
+
+func (s *Session) observeEAP(e *EAP) {
+	switch e.Code {
+	case CodeSuccess:
+		s.Stage = StageEAPSuccess
+		return
+	case CodeFailure:
+		s.fail("EAP failure")
+		return
+	}
+
+	switch e.Method {
+	case MethodIdentity:
+		if e.Code == CodeRequest {
+			s.reset(StageIdentity)
+		} else {
+			s.Stage = StageIdentity
+			s.Identity = string(e.Data)
+		}
+	case MethodNotification:
+	case MethodNak:
+		// NOTE: a Nak which proposes other methods is part of
+		// a normal negotiation.
+		if methods, _ := e.NakMethods(); len(methods) == 0 || methods[0] == MethodNone {
+			s.fail(fmt.Sprintf("station rejected %v", s.Method))
+		}
+	default:
+		s.Stage = StageMethod
+		s.Method = e.Method
+		if sa, err := e.SimAka(); err == nil {
+			switch sa.Subtype {
+			case SimAkaAuthReject:
+				s.fail(fmt.Sprintf("station rejected %v authentication", e.Method))
+			case SimAkaClientError:
+				s.fail(fmt.Sprintf("%v client error", e.Method))
+			}
+		}
+	}
+}
+
+func (s *Session) observeKey(k *Key) {
+	if k.Info.Has(KeyInfoRequest | KeyInfoError) {
+		s.fail("station reported a MIC failure")
+		return
+	}
+	msg := k.Message()
+	switch msg {
+	case KeyMessage1:
+		if s.Stage == StageComplete {
+			s.reset(StageHandshake)
+		} else if s.LastMessage == KeyMessage2 {
+			// An AP repeats message 1 when the MIC of message 2 is
+			// wrong, which usually means a wrong passphrase.
+			s.fail("AP rejected message 2 of 4 (wrong passphrase or PMK?)")
+		}
+	case KeyMessage4:
+		s.Stage = StageComplete
+		s.LastMessage = msg
+		return
+	case KeyMessage2, KeyMessage3:
+	default:
+		return
+	}
+	s.Stage = StageHandshake
+	s.LastMessage = msg
+}
+
+// frameParties finds the AP and station of a data frame.
+// If the frame has neither DS bit set, fromAP decides the direction.
+func frameParties(f gofi.Frame, fromAP bool) (ap, sta net.HardwareAddr, ok bool) {
+	addr1, addr2 := f.Addr1(), f.Addr2()
+	if addr2 == nil {
+		return nil, nil, false
+	}
+	switch {
+	case f.ToDS() && f.FromDS():
+		return nil, nil, false
+	case f.ToDS():
+		return addr1, addr2, true
+	case f.FromDS():
+		return addr2, addr1, true
+	case fromAP:
+		return addr2, addr1, true
+	default:
+		return addr1, addr2, true
+	}
+}
+
+func sessionKey(ap, sta net.HardwareAddr) string {
+	return ap.String() + "/" + sta.String()
+}
@@ -0,0 +1,136 @@
+package gofi
+
+import "errors"
+
+// These are the IDs of some common information elements.
+const (
+	ElementSSID            = 0
+	ElementSupportedRates  = 1
+	ElementDSParameterSet  = 3
+	ElementTIM             = 5
+	ElementCountry         = 7
+	ElementChallengeText   = 16
+	ElementHTCapabilities  = 45
+	ElementRSN             = 48
+	ElementExtendedRates   = 50
+	ElementMobilityDomain  = 54
+	ElementHTOperation     = 61
+	ElementMME             = 76
+	ElementVHTCapabilities = 191
+	ElementVHTOperation    = 192
+	ElementVendorSpecific  = 221
+	ElementExtension       = 255
+)
+
+// ElementMaxDataLength is the largest amount of data that fits in
+// one information element.
+const ElementMaxDataLength = 255
+
+const (
+	elementHeaderSize       = 2
+	elementMinVendorDataLen = 3
+)
+
+var (
+	errElementTruncated = errors.New("information element is truncated")
+	errNoElements       = errors.New("frame body has no information elements")
+)
+
+// managementFixedFields maps management frame subtypes to the size
+// of the fixed fields before their information elements.
+var managementFixedFields = map[int]int{
+	SubtypeAssocRequest:    4,
+	SubtypeAssocResponse:   6,
+	SubtypeReassocRequest:  10,
+	SubtypeReassocResponse: 6,
+	SubtypeProbeRequest:    0,
+	SubtypeProbeResponse:   12,
+	SubtypeBeacon:          12,
+	SubtypeDisassoc:        2,
+	SubtypeAuth:            6,
+	SubtypeDeauth:          2,
+}
+
+// An Element is an information element, as found in the bodies of
+// management frames.
+type Element struct {
+	ID   byte
+	Data []byte
+}
+
+// OUI returns the organizationally unique identifier of a vendor
+// specific element, or nil if the element is not vendor specific.
+func (e Element) OUI() []byte {
+	if e.ID != ElementVendorSpecific || len(e.Data) < elementMinVendorDataLen {
+		return nil
+	}
+	return e.Data[:elementMinVendorDataLen]
+}
+
+// ParseElements decodes a sequence of information elements.
+// The returned elements point into the buffer.
+func ParseElements(data []byte) ([]Element, error) {
+	var res []Element
+	for len(data) > 0 {
+		if len(data) < elementHeaderSize {
+			return res, errElementTruncated
+		}
+		size := int(data[1])
+		if len(data) < elementHeaderSize+size {
+			return res, errElementTruncated
+		}
+		res = append(res, Element{
+			ID:   data[0],
+			Data: data[elementHeaderSize : elementHeaderSize+size],
+		})
+		data = data[elementHeaderSize+size:]
+	}
+	return res, nil
+}
+
+// Elements decodes the information elements of a management frame,
+// which follow the fixed fields of its body.
+//
+// This fails for action frames, whose contents depend on their
+// category, and for protected frames.
+func (f Frame) Elements() ([]Element, error) {
+	if f.Type() != FrameTypeManagement || f.Protected() {
+		return nil, errNoElements
+	}
+	fixed, ok := managementFixedFields[f.Subtype()]
+	if !ok {
+		return nil, errNoElements
+	}
+	body := f.Body()
+	if len(body) < fixed {
+		return nil, ErrBufferUnderflow
+	}
+	return ParseElements(body[fixed:])
+}
+
+// FindElement returns the first element with the given ID, or nil
+// if there is no such element.
+func FindElement(elements []Element, id byte) *Element {
+	for i, e := range elements {
+		if e.ID == id {
+			return &elements[i]
+		}
+	}
+	return nil
+}
+
+// EncodeElements encodes a sequence of information elements.
+// Elements with more than ElementMaxDataLength bytes of data are
+// truncated.
+func EncodeElements(elements []Element) []byte {
+	var res []byte
+	for _, e := range elements {
+		data := e.Data
+		if len(data) > ElementMaxDataLength {
+			data = data[:ElementMaxDataLength]
+		}
+		res = append(res, e.ID, byte(len(data)))
+		res = append(res, data...)
+	}
+	return res
+}
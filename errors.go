@@ -5,4 +5,5 @@ import "errors"
 var (
 	ErrBufferUnderflow = errors.New("buffer underflow")
 	ErrClosed          = errors.New("cannot operate on closed handle")
+	ErrNoTxStatus      = errors.New("no transmit status reported")
 )
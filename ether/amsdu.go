@@ -0,0 +1,73 @@
+package ether
+
+import (
+	"encoding/binary"
+	"net"
+
+	"github.com/unixpickle/gofi"
+)
+
+// amsduFlag is the bit of the QoS control field which indicates
+// that a frame's body is an A-MSDU.
+const amsduFlag = 0x80
+
+// amsduHeaderSize is the size of an A-MSDU subframe header.
+const amsduHeaderSize = 14
+
+// An AMSDUSubframe is one MSDU from an A-MSDU.
+type AMSDUSubframe struct {
+	Dst  net.HardwareAddr
+	Src  net.HardwareAddr
+	Data []byte
+}
+
+// IsAMSDU checks if a frame is a QoS data frame whose body is an
+// A-MSDU.
+func IsAMSDU(f gofi.Frame) bool {
+	qos, ok := f.QoSControl()
+	return ok && (qos&amsduFlag) != 0
+}
+
+// ParseAMSDU splits the body of an A-MSDU into its subframes.
+func ParseAMSDU(body []byte) ([]AMSDUSubframe, error) {
+	var res []AMSDUSubframe
+	for len(body) > 0 {
+		if len(body) < amsduHeaderSize {
+			return nil, ErrTruncated
+		}
+		size := int(binary.BigEndian.Uint16(body[12:]))
+		if len(body) < amsduHeaderSize+size {
+			return nil, ErrTruncated
+		}
+		res = append(res, AMSDUSubframe{
+			Dst:  net.HardwareAddr(body[:6]),
+			Src:  net.HardwareAddr(body[6:12]),
+			Data: body[amsduHeaderSize : amsduHeaderSize+size],
+		})
+		body = body[amsduHeaderSize+size:]
+
+		// NOTE: every subframe but the last is padded to a multiple
+		// of four bytes.
+		if padding := (4 - (amsduHeaderSize+size)%4) % 4; len(body) >= padding {
+			body = body[padding:]
+		}
+	}
+	return res, nil
+}
+
+// EncodeAMSDU encodes subframes as the body of an A-MSDU.
+func EncodeAMSDU(subframes []AMSDUSubframe) []byte {
+	var res []byte
+	for i, s := range subframes {
+		res = append(res, s.Dst...)
+		res = append(res, s.Src...)
+		res = append(res, byte(len(s.Data)>>8), byte(len(s.Data)))
+		res = append(res, s.Data...)
+		if i+1 < len(subframes) {
+			for len(res)%4 != 0 {
+				res = append(res, 0)
+			}
+		}
+	}
+	return res
+}
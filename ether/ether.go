@@ -0,0 +1,234 @@
+// Package ether converts between 802.11 data frames and Ethernet
+// frames.
+//
+// Ethernet frames are handled without a preamble or checksum, as
+// they are read from and written to raw sockets and TAP devices.
+package ether
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+
+	"github.com/unixpickle/gofi"
+)
+
+var (
+	ErrNotData       = errors.New("frame is not a data frame with a body")
+	ErrProtected     = errors.New("frame is protected")
+	ErrTruncated     = errors.New("frame is truncated")
+	ErrNeedAddresses = errors.New("WDS frames need receiver and transmitter addresses")
+)
+
+// HeaderSize is the size of an Ethernet header.
+const HeaderSize = 14
+
+// maxLength is the largest value of an 802.3 length field.
+// Larger values are EtherTypes.
+const maxLength = 1500
+
+var (
+	// rfc1042Header is the LLC/SNAP header for most EtherTypes.
+	rfc1042Header = []byte{0xaa, 0xaa, 0x03, 0x00, 0x00, 0x00}
+
+	// bridgeTunnelHeader is the LLC/SNAP header for the EtherTypes
+	// which 802.1H singles out.
+	bridgeTunnelHeader = []byte{0xaa, 0xaa, 0x03, 0x00, 0x00, 0xf8}
+)
+
+// dataFrameControl is the first byte of the frame control field of
+// a non-QoS data frame.
+const dataFrameControl = byte(gofi.FrameTypeData)<<2 | gofi.SubtypeData<<4
+
+// These EtherTypes use bridge-tunnel encapsulation, since RFC 1042
+// encapsulation of them is ambiguous with 802.3 frames carrying
+// SNAP.
+const (
+	etherTypeAARP = 0x80f3
+	etherTypeIPX  = 0x8137
+)
+
+// A Direction is the combination of the To DS and From DS flags of
+// a data frame.
+type Direction int
+
+const (
+	// DirectionNone is used between stations in an IBSS.
+	DirectionNone Direction = iota
+
+	// DirectionToAP is used by stations sending to their AP.
+	DirectionToAP
+
+	// DirectionFromAP is used by APs sending to their stations.
+	DirectionFromAP
+
+	// DirectionWDS is used between APs and by four-address stations.
+	DirectionWDS
+)
+
+// FrameDirection returns the direction of a frame.
+func FrameDirection(f gofi.Frame) Direction {
+	switch {
+	case f.ToDS() && f.FromDS():
+		return DirectionWDS
+	case f.ToDS():
+		return DirectionToAP
+	case f.FromDS():
+		return DirectionFromAP
+	default:
+		return DirectionNone
+	}
+}
+
+// Addresses returns the destination, source, and BSSID of a data
+// frame.
+// For DirectionWDS frames, there is no BSSID, so the returned bssid
+// is the receiver address.
+func Addresses(f gofi.Frame) (dst, src, bssid net.HardwareAddr) {
+	switch FrameDirection(f) {
+	case DirectionToAP:
+		return f.Addr3(), f.Addr2(), f.Addr1()
+	case DirectionFromAP:
+		return f.Addr1(), f.Addr3(), f.Addr2()
+	case DirectionWDS:
+		return f.Addr3(), f.Addr4(), f.Addr1()
+	default:
+		return f.Addr1(), f.Addr2(), f.Addr3()
+	}
+}
+
+// ToEthernet converts an unprotected data frame to Ethernet frames.
+//
+// Usually, there is one Ethernet frame, but an A-MSDU produces one
+// Ethernet frame per subframe.
+// The frame's checksum is not checked.
+func ToEthernet(f gofi.Frame) ([][]byte, error) {
+	if f.Type() != gofi.FrameTypeData || !f.HasBody() {
+		return nil, ErrNotData
+	}
+	if f.Protected() {
+		return nil, ErrProtected
+	}
+	body := f.Body()
+	if body == nil {
+		return nil, ErrTruncated
+	}
+	if IsAMSDU(f) {
+		subframes, err := ParseAMSDU(body)
+		if err != nil {
+			return nil, err
+		}
+		var res [][]byte
+		for _, s := range subframes {
+			res = append(res, Encapsulate(s.Dst, s.Src, s.Data))
+		}
+		return res, nil
+	}
+	dst, src, _ := Addresses(f)
+	if src == nil {
+		return nil, ErrTruncated
+	}
+	return [][]byte{Encapsulate(dst, src, body)}, nil
+}
+
+// Encapsulate creates an Ethernet frame from an 802.11 MSDU, which
+// usually starts with an LLC/SNAP header.
+//
+// RFC 1042 and bridge-tunnel headers are replaced with an EtherType.
+// Other MSDUs become 802.3 frames with a length field.
+func Encapsulate(dst, src net.HardwareAddr, msdu []byte) []byte {
+	res := make([]byte, HeaderSize, HeaderSize+len(msdu))
+	copy(res, dst)
+	copy(res[6:], src)
+	if len(msdu) >= 8 {
+		etherType := binary.BigEndian.Uint16(msdu[6:])
+		rfc1042 := bytes.Equal(msdu[:6], rfc1042Header) && etherType != etherTypeAARP &&
+			etherType != etherTypeIPX
+		tunnel := bytes.Equal(msdu[:6], bridgeTunnelHeader)
+		if (rfc1042 || tunnel) && etherType > maxLength {
+			copy(res[12:], msdu[6:8])
+			return append(res, msdu[8:]...)
+		}
+	}
+	binary.BigEndian.PutUint16(res[12:], uint16(len(msdu)))
+	return append(res, msdu...)
+}
+
+// Decapsulate splits an Ethernet frame into its addresses and an
+// 802.11 MSDU, adding an LLC/SNAP header if the frame has an
+// EtherType.
+func Decapsulate(eth []byte) (dst, src net.HardwareAddr, msdu []byte, err error) {
+	if len(eth) < HeaderSize {
+		return nil, nil, nil, ErrTruncated
+	}
+	dst = net.HardwareAddr(eth[:6])
+	src = net.HardwareAddr(eth[6:12])
+	etherType := binary.BigEndian.Uint16(eth[12:])
+	if etherType <= maxLength {
+		// NOTE: short 802.3 frames are padded, so the length field
+		// is needed to find the end of the payload.
+		if len(eth) < HeaderSize+int(etherType) {
+			return nil, nil, nil, ErrTruncated
+		}
+		return dst, src, eth[HeaderSize : HeaderSize+int(etherType)], nil
+	}
+	header := rfc1042Header
+	if etherType == etherTypeAARP || etherType == etherTypeIPX {
+		header = bridgeTunnelHeader
+	}
+	msdu = make([]byte, 0, 8+len(eth)-HeaderSize)
+	msdu = append(msdu, header...)
+	msdu = append(msdu, eth[12:]...)
+	return dst, src, msdu, nil
+}
+
+// FromEthernet converts an Ethernet frame to a data frame in a BSS.
+// The frame has a zero duration and sequence control field.
+//
+// DirectionWDS frames need separate receiver and transmitter
+// addresses, so they must be created with FromEthernetWDS.
+func FromEthernet(eth []byte, bssid net.HardwareAddr, dir Direction) (gofi.Frame, error) {
+	if dir == DirectionWDS {
+		return nil, ErrNeedAddresses
+	}
+	dst, src, msdu, err := Decapsulate(eth)
+	if err != nil {
+		return nil, err
+	}
+	var addr1, addr2, addr3 net.HardwareAddr
+	var flags byte
+	switch dir {
+	case DirectionToAP:
+		addr1, addr2, addr3 = bssid, src, dst
+		flags = gofi.FlagToDS
+	case DirectionFromAP:
+		addr1, addr2, addr3 = dst, bssid, src
+		flags = gofi.FlagFromDS
+	default:
+		addr1, addr2, addr3 = dst, src, bssid
+	}
+	header := []byte{dataFrameControl, flags, 0, 0}
+	header = append(header, addr1...)
+	header = append(header, addr2...)
+	header = append(header, addr3...)
+	header = append(header, 0, 0)
+	return gofi.NewFrame(header, msdu), nil
+}
+
+// FromEthernetWDS converts an Ethernet frame to a four-address data
+// frame from a transmitter to a receiver.
+func FromEthernetWDS(eth []byte, receiver, transmitter net.HardwareAddr) (gofi.Frame,
+	error) {
+	dst, src, msdu, err := Decapsulate(eth)
+	if err != nil {
+		return nil, err
+	}
+	header := []byte{dataFrameControl, gofi.FlagToDS | gofi.FlagFromDS, 0, 0}
+	header = append(header, receiver...)
+	header = append(header, transmitter...)
+	header = append(header, dst...)
+	header = append(header, 0, 0)
+	header = append(header, src...)
+	return gofi.NewFrame(header, msdu), nil
+}
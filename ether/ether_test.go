@@ -0,0 +1,137 @@
+package ether
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/unixpickle/gofi"
+)
+
+var (
+	testBSSID = net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	testDst   = net.HardwareAddr{0x02, 0, 0, 0, 0, 2}
+	testSrc   = net.HardwareAddr{0x02, 0, 0, 0, 0, 3}
+)
+
+func TestEthernetRoundTrip(t *testing.T) {
+	payloads := []struct {
+		etherType []byte
+		payload   []byte
+		llc       []byte
+	}{
+		{[]byte{0x08, 0x00}, []byte("an IPv4 packet"), rfc1042Header},
+		{[]byte{0x80, 0xf3}, []byte("an AARP packet"), bridgeTunnelHeader},
+	}
+	directions := []Direction{DirectionNone, DirectionToAP, DirectionFromAP}
+	for _, p := range payloads {
+		eth := append(append(append([]byte{}, testDst...), testSrc...), p.etherType...)
+		eth = append(eth, p.payload...)
+		for _, dir := range directions {
+			f, err := FromEthernet(eth, testBSSID, dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !f.ChecksumValid() || f.Type() != gofi.FrameTypeData ||
+				f.Subtype() != gofi.SubtypeData || FrameDirection(f) != dir {
+				t.Fatalf("unexpected frame: %x", f)
+			}
+			expectedBody := append(append(append([]byte{}, p.llc...), p.etherType...),
+				p.payload...)
+			if !bytes.Equal(f.Body(), expectedBody) {
+				t.Errorf("direction %d: unexpected body: %x", dir, f.Body())
+			}
+			dst, src, bssid := Addresses(f)
+			if dst.String() != testDst.String() || src.String() != testSrc.String() ||
+				bssid.String() != testBSSID.String() {
+				t.Errorf("direction %d: unexpected addresses: %v %v %v", dir, dst, src, bssid)
+			}
+			converted, err := ToEthernet(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(converted) != 1 || !bytes.Equal(converted[0], eth) {
+				t.Errorf("direction %d: unexpected Ethernet frames: %x", dir, converted)
+			}
+		}
+	}
+
+	wdsEth := append(append([]byte{}, testDst...), testSrc...)
+	wdsEth = append(wdsEth, 0x86, 0xdd, 1, 2, 3)
+	if _, err := FromEthernet(wdsEth, testBSSID, DirectionWDS); err != ErrNeedAddresses {
+		t.Errorf("expected ErrNeedAddresses but got %v", err)
+	}
+	transmitter := net.HardwareAddr{0x02, 0, 0, 0, 0, 4}
+	f, err := FromEthernetWDS(wdsEth, testBSSID, transmitter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if FrameDirection(f) != DirectionWDS || f.Addr2().String() != transmitter.String() {
+		t.Errorf("unexpected frame: %x", f)
+	}
+	if converted, err := ToEthernet(f); err != nil || !bytes.Equal(converted[0], wdsEth) {
+		t.Errorf("unexpected Ethernet frames: %x (%v)", converted, err)
+	}
+}
+
+func TestEthernet8023(t *testing.T) {
+	// An 802.3 frame with a length field, padded to the minimum size.
+	eth := append(append([]byte{}, testDst...), testSrc...)
+	eth = append(eth, 0, 6, 0x42, 0x42, 0x03, 1, 2, 3)
+	eth = append(eth, make([]byte, 40)...)
+	f, err := FromEthernet(eth, testBSSID, DirectionToAP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(f.Body(), []byte{0x42, 0x42, 0x03, 1, 2, 3}) {
+		t.Errorf("unexpected body: %x", f.Body())
+	}
+	converted, err := ToEthernet(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(converted[0], eth[:HeaderSize+6]) {
+		t.Errorf("unexpected Ethernet frame: %x", converted[0])
+	}
+}
+
+func TestAMSDU(t *testing.T) {
+	subframes := []AMSDUSubframe{
+		{Dst: testDst, Src: testSrc, Data: append(append([]byte{}, rfc1042Header...), 8, 0, 1)},
+		{Dst: testSrc, Src: testDst, Data: append(append([]byte{}, rfc1042Header...), 8, 6,
+			1, 2, 3, 4)},
+		{Dst: testDst, Src: testBSSID, Data: []byte{0x42, 0x42, 0x03}},
+	}
+	body := EncodeAMSDU(subframes)
+
+	header := []byte{0x88, gofi.FlagFromDS, 0, 0}
+	header = append(header, testDst...)
+	header = append(header, testBSSID...)
+	header = append(header, testSrc...)
+	header = append(header, 0, 0, 0x85, 0)
+	f := gofi.NewFrame(header, body)
+	if !IsAMSDU(f) || f.TID() != 5 {
+		t.Fatal("frame should be an A-MSDU")
+	}
+	converted, err := ToEthernet(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]byte{
+		append(append(append([]byte{}, testDst...), testSrc...), 8, 0, 1),
+		append(append(append([]byte{}, testSrc...), testDst...), 8, 6, 1, 2, 3, 4),
+		append(append(append([]byte{}, testDst...), testBSSID...), 0, 3, 0x42, 0x42, 0x03),
+	}
+	if len(converted) != len(expected) {
+		t.Fatalf("expected %d frames but got %d", len(expected), len(converted))
+	}
+	for i, x := range expected {
+		if !bytes.Equal(converted[i], x) {
+			t.Errorf("frame %d: expected %x but got %x", i, x, converted[i])
+		}
+	}
+
+	if _, err := ParseAMSDU(body[:len(body)-1]); err != ErrTruncated {
+		t.Errorf("expected ErrTruncated but got %v", err)
+	}
+}
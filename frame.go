@@ -0,0 +1,278 @@
+package gofi
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"net"
+)
+
+// A FrameType is the type field of an 802.11 frame control field.
+type FrameType int
+
+const (
+	FrameTypeManagement FrameType = 0
+	FrameTypeControl    FrameType = 1
+	FrameTypeData       FrameType = 2
+	FrameTypeExtension  FrameType = 3
+)
+
+// These are the subtypes of management frames.
+const (
+	SubtypeAssocRequest    = 0
+	SubtypeAssocResponse   = 1
+	SubtypeReassocRequest  = 2
+	SubtypeReassocResponse = 3
+	SubtypeProbeRequest    = 4
+	SubtypeProbeResponse   = 5
+	SubtypeBeacon          = 8
+	SubtypeDisassoc        = 10
+	SubtypeAuth            = 11
+	SubtypeDeauth          = 12
+	SubtypeAction          = 13
+	SubtypeActionNoAck     = 14
+)
+
+// These are the subtypes of data frames.
+const (
+	SubtypeData    = 0
+	SubtypeNull    = 4
+	SubtypeQoSData = 8
+	SubtypeQoSNull = 12
+)
+
+// These bits of a data frame's subtype indicate a QoS control field
+// and the absence of a body, respectively.
+const (
+	subtypeQoSFlag  = 8
+	subtypeNullFlag = 4
+)
+
+// These are the bits of the second byte of the frame control field.
+const (
+	FlagToDS          = 0x01
+	FlagFromDS        = 0x02
+	FlagMoreFragments = 0x04
+	FlagRetry         = 0x08
+	FlagPowerMgmt     = 0x10
+	FlagMoreData      = 0x20
+	FlagProtected     = 0x40
+	FlagOrder         = 0x80
+)
+
+// frameMinHeaderSize is the size of the shortest header which has
+// three addresses and a sequence control field.
+const frameMinHeaderSize = 24
+
+// Type returns the frame's type.
+func (f Frame) Type() FrameType {
+	if len(f) < 1 {
+		return 0
+	}
+	return FrameType((f[0] >> 2) & 3)
+}
+
+// Subtype returns the frame's subtype.
+func (f Frame) Subtype() int {
+	if len(f) < 1 {
+		return 0
+	}
+	return int(f[0] >> 4)
+}
+
+// Flags returns the second byte of the frame control field.
+func (f Frame) Flags() byte {
+	if len(f) < 2 {
+		return 0
+	}
+	return f[1]
+}
+
+// ToDS checks if the To DS flag is set.
+func (f Frame) ToDS() bool {
+	return (f.Flags() & FlagToDS) != 0
+}
+
+// FromDS checks if the From DS flag is set.
+func (f Frame) FromDS() bool {
+	return (f.Flags() & FlagFromDS) != 0
+}
+
+// Protected checks if the frame body is encrypted.
+func (f Frame) Protected() bool {
+	return (f.Flags() & FlagProtected) != 0
+}
+
+// Retry checks if the frame is a retransmission.
+func (f Frame) Retry() bool {
+	return (f.Flags() & FlagRetry) != 0
+}
+
+// MoreFragments checks if more fragments of the frame follow it.
+func (f Frame) MoreFragments() bool {
+	return (f.Flags() & FlagMoreFragments) != 0
+}
+
+// IsQoSData checks if the frame is a data frame with a QoS control field.
+func (f Frame) IsQoSData() bool {
+	return f.Type() == FrameTypeData && (f.Subtype()&subtypeQoSFlag) != 0
+}
+
+// HasBody checks if the frame is a data frame which carries data,
+// rather than a null function frame.
+func (f Frame) HasBody() bool {
+	return f.Type() != FrameTypeData || (f.Subtype()&subtypeNullFlag) == 0
+}
+
+// Addr1 returns the first address (usually the receiver), or nil if
+// the frame is too short.
+func (f Frame) Addr1() net.HardwareAddr {
+	return f.addr(4)
+}
+
+// Addr2 returns the second address (usually the transmitter), or nil
+// if the frame does not have one.
+func (f Frame) Addr2() net.HardwareAddr {
+	return f.addr(10)
+}
+
+// Addr3 returns the third address, or nil if the frame does not have one.
+func (f Frame) Addr3() net.HardwareAddr {
+	if f.Type() == FrameTypeControl {
+		return nil
+	}
+	return f.addr(16)
+}
+
+// Addr4 returns the fourth address, which is only present in data
+// frames with both To DS and From DS set.
+func (f Frame) Addr4() net.HardwareAddr {
+	if f.Type() != FrameTypeData || !f.ToDS() || !f.FromDS() {
+		return nil
+	}
+	return f.addr(24)
+}
+
+// SequenceControl returns the sequence control field.
+func (f Frame) SequenceControl() uint16 {
+	if f.Type() == FrameTypeControl || len(f) < frameMinHeaderSize {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(f[22:24])
+}
+
+// SequenceNumber returns the sequence number from the sequence
+// control field.
+func (f Frame) SequenceNumber() int {
+	return int(f.SequenceControl() >> 4)
+}
+
+// FragmentNumber returns the fragment number from the sequence
+// control field.
+func (f Frame) FragmentNumber() int {
+	return int(f.SequenceControl() & 0xf)
+}
+
+// QoSControl returns the QoS control field of a QoS data frame.
+// The second result is false if the frame has no such field.
+func (f Frame) QoSControl() (uint16, bool) {
+	if !f.IsQoSData() {
+		return 0, false
+	}
+	offset := frameMinHeaderSize
+	if f.ToDS() && f.FromDS() {
+		offset += 6
+	}
+	if len(f) < offset+2 {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint16(f[offset:]), true
+}
+
+// TID returns the traffic identifier of a QoS data frame, or 0 for
+// other frames.
+func (f Frame) TID() int {
+	qos, _ := f.QoSControl()
+	return int(qos & 0xf)
+}
+
+// HeaderLen returns the length of the MAC header, including the
+// QoS and HT control fields when they are present.
+//
+// Control frames are treated as having no body, so their header
+// length is the length of the frame without its checksum.
+func (f Frame) HeaderLen() int {
+	if f.Type() == FrameTypeControl {
+		if len(f) < 4 {
+			return len(f)
+		}
+		return len(f) - 4
+	}
+	size := frameMinHeaderSize
+	if f.Type() == FrameTypeData {
+		if f.ToDS() && f.FromDS() {
+			size += 6
+		}
+		if f.IsQoSData() {
+			size += 2
+			if (f.Flags() & FlagOrder) != 0 {
+				size += 4
+			}
+		}
+	} else if f.Type() == FrameTypeManagement && (f.Flags()&FlagOrder) != 0 {
+		size += 4
+	}
+	return size
+}
+
+// Body returns the frame body, which is everything between the
+// header and the checksum.
+// If the frame is too short to contain a header and a checksum,
+// this returns nil.
+func (f Frame) Body() []byte {
+	start := f.HeaderLen()
+	if len(f) < start+4 {
+		return nil
+	}
+	return f[start : len(f)-4]
+}
+
+// Checksum returns the frame check sequence at the end of the frame.
+func (f Frame) Checksum() uint32 {
+	if len(f) < 4 {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(f[len(f)-4:])
+}
+
+// ChecksumValid checks if the frame check sequence matches the
+// contents of the frame.
+func (f Frame) ChecksumValid() bool {
+	if len(f) < 4 {
+		return false
+	}
+	return crc32.ChecksumIEEE(f[:len(f)-4]) == f.Checksum()
+}
+
+// SetChecksum recomputes the frame check sequence in place.
+func (f Frame) SetChecksum() {
+	if len(f) < 4 {
+		return
+	}
+	binary.LittleEndian.PutUint32(f[len(f)-4:], crc32.ChecksumIEEE(f[:len(f)-4]))
+}
+
+// NewFrame creates a Frame from a header and a body, adding a checksum.
+func NewFrame(header, body []byte) Frame {
+	res := make(Frame, len(header)+len(body)+4)
+	copy(res, header)
+	copy(res[len(header):], body)
+	res.SetChecksum()
+	return res
+}
+
+func (f Frame) addr(offset int) net.HardwareAddr {
+	if len(f) < offset+6 {
+		return nil
+	}
+	return net.HardwareAddr(f[offset : offset+6])
+}
@@ -0,0 +1,77 @@
+package gofi
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestFrameFields(t *testing.T) {
+	header := []byte{0x88, FlagToDS | FlagFromDS | FlagProtected, 0, 0,
+		1, 1, 1, 1, 1, 1, 2, 2, 2, 2, 2, 2, 3, 3, 3, 3, 3, 3, 0x52, 0x01,
+		4, 4, 4, 4, 4, 4, 0x05, 0}
+	frame := NewFrame(header, []byte("body"))
+
+	if frame.Type() != FrameTypeData || frame.Subtype() != SubtypeQoSData {
+		t.Error("unexpected type:", frame.Type(), frame.Subtype())
+	}
+	if !frame.ToDS() || !frame.FromDS() || !frame.Protected() || frame.Retry() {
+		t.Error("unexpected flags:", frame.Flags())
+	}
+	addrs := []net.HardwareAddr{frame.Addr1(), frame.Addr2(), frame.Addr3(), frame.Addr4()}
+	for i, addr := range addrs {
+		if !bytes.Equal(addr, bytes.Repeat([]byte{byte(i + 1)}, 6)) {
+			t.Errorf("unexpected address %d: %v", i+1, addr)
+		}
+	}
+	if frame.SequenceNumber() != 0x15 || frame.FragmentNumber() != 2 {
+		t.Error("unexpected sequence control:", frame.SequenceControl())
+	}
+	if frame.TID() != 5 || frame.HeaderLen() != len(header) {
+		t.Error("unexpected QoS header:", frame.TID(), frame.HeaderLen())
+	}
+	if string(frame.Body()) != "body" || !frame.ChecksumValid() {
+		t.Error("unexpected body:", frame.Body())
+	}
+
+	ack := NewFrame([]byte{0xd4, 0, 0, 0, 1, 1, 1, 1, 1, 1}, nil)
+	if ack.Type() != FrameTypeControl || ack.Addr2() != nil || ack.SequenceControl() != 0 {
+		t.Error("unexpected ACK fields")
+	}
+}
+
+func TestElements(t *testing.T) {
+	data := []byte{ElementSSID, 4, 't', 'e', 's', 't', ElementVendorSpecific, 4, 1, 2, 3, 4}
+	elements, err := ParseElements(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(elements) != 2 || string(elements[0].Data) != "test" ||
+		!bytes.Equal(elements[1].OUI(), []byte{1, 2, 3}) {
+		t.Errorf("unexpected elements: %v", elements)
+	}
+	if e := FindElement(elements, ElementVendorSpecific); e == nil || len(e.Data) != 4 {
+		t.Error("could not find element")
+	}
+	if !bytes.Equal(EncodeElements(elements), data) {
+		t.Error("unexpected encoding")
+	}
+	if _, err := ParseElements(data[:len(data)-1]); err == nil {
+		t.Error("parsed truncated elements")
+	}
+
+	header := make([]byte, 24)
+	header[0] = SubtypeBeacon << 4
+	beacon := NewFrame(header, append(make([]byte, 12), data...))
+	elements, err = beacon.Elements()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(elements) != 2 || string(elements[0].Data) != "test" {
+		t.Errorf("unexpected beacon elements: %v", elements)
+	}
+	header[0] = SubtypeAction << 4
+	if _, err := NewFrame(header, data).Elements(); err == nil {
+		t.Error("found elements in an action frame")
+	}
+}
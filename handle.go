@@ -40,6 +40,39 @@ type Channel struct {
 	Width  ChannelWidth
 }
 
+// NewChannelFrequency creates a 20MHz Channel from the center frequency
+// of a 2.4GHz or 5GHz channel, measured in MHz.
+// If the frequency does not correspond to a known channel, the
+// returned channel's Number is 0.
+func NewChannelFrequency(mhz int) Channel {
+	switch {
+	case mhz == 2484:
+		return Channel{Number: 14, Width: ChannelWidth20MHz}
+	case mhz >= 2412 && mhz < 2484 && (mhz-2407)%5 == 0:
+		return Channel{Number: (mhz - 2407) / 5, Width: ChannelWidth20MHz}
+	case mhz >= 5160 && mhz <= 5885 && mhz%5 == 0:
+		return Channel{Number: (mhz - 5000) / 5, Width: ChannelWidth20MHz}
+	default:
+		return Channel{}
+	}
+}
+
+// Frequency returns the center frequency of the channel's primary
+// 20MHz subchannel, measured in MHz.
+// This returns 0 if the channel number is unknown.
+func (c Channel) Frequency() int {
+	switch {
+	case c.Number == 14:
+		return 2484
+	case c.Number >= 1 && c.Number < 14:
+		return 2407 + 5*c.Number
+	case c.Number >= 32 && c.Number <= 177:
+		return 5000 + 5*c.Number
+	default:
+		return 0
+	}
+}
+
 // A DataRate represents a data rate as a multiple of 500Kb/s.
 type DataRate int
 
@@ -49,11 +82,29 @@ func (d DataRate) String() string {
 	return fmt.Sprintf("%.1f Mb/s", mbps)
 }
 
+// A TxReport describes the outcome of transmitting a frame.
+type TxReport struct {
+	// Acked is true if the receiver acknowledged the frame.
+	// Frames which do not solicit an ACK, such as broadcasts,
+	// are never acknowledged.
+	Acked bool
+
+	// Retries is the number of times the frame was retransmitted,
+	// or -1 if the device did not report it.
+	Retries int
+
+	// Rate is the rate used for the final transmission attempt,
+	// or 0 if the device did not report it.
+	Rate DataRate
+}
+
 // A Handle facilitates raw WiFi interactions like packet injection,
 // sniffing, and channel hopping.
 type Handle interface {
 	// SupportedRates returns a list of supported outgoing data rates
 	// in ascending order.
+	// Only the rates which are valid in the band of the current
+	// channel are included.
 	SupportedRates() []DataRate
 
 	// SupportedChannels returns a list of supported WLAN channels.
@@ -73,9 +124,13 @@ type Handle interface {
 	Receive() (Frame, *RadioInfo, error)
 
 	// Send sends a packet over the device.
-	// If the given DataRate is 0, the lowest supported rate is used.
+	// If the given DataRate is 0, the lowest supported rate for the
+	// current channel is used.
 	Send(Frame, DataRate) error
 
+	// Info describes the device and the settings the handle uses.
+	Info() HandleInfo
+
 	// Close closes the handle.
 	// You should always close a Handle once you are done with it.
 	//
@@ -85,3 +140,55 @@ type Handle interface {
 	// no data is read.
 	Close()
 }
+
+// A TxStatusHandle is a Handle which can report the outcome of
+// transmitting a frame.
+type TxStatusHandle interface {
+	Handle
+
+	// SendWithStatus sends a packet over the device and waits
+	// for the device to report the transmission's outcome.
+	// If the given DataRate is 0, the lowest supported rate for the
+	// current channel is used.
+	//
+	// If the packet was sent but the device never reported its
+	// status, this returns ErrNoTxStatus.
+	SendWithStatus(Frame, DataRate) (*TxReport, error)
+}
+
+// A BatchHandle is a Handle which can send many frames at once more
+// efficiently than sending them one at a time.
+type BatchHandle interface {
+	Handle
+
+	// SendBatch sends packets over the device, all at the same rate.
+	// If the given DataRate is 0, the lowest supported rate for the
+	// current channel is used.
+	//
+	// If every frame was sent, this returns nil.
+	// Otherwise, it returns one error per frame, where the error is
+	// nil for frames that were sent successfully.
+	SendBatch([]Frame, DataRate) []error
+}
+
+// SendBatch sends a batch of frames over a Handle.
+//
+// If the Handle is a BatchHandle, its SendBatch method is used.
+// Otherwise, the frames are sent one at a time with Send.
+//
+// The result is the same as for BatchHandle.SendBatch.
+func SendBatch(h Handle, frames []Frame, r DataRate) []error {
+	if bh, ok := h.(BatchHandle); ok {
+		return bh.SendBatch(frames, r)
+	}
+	var errs []error
+	for i, f := range frames {
+		if err := h.Send(f, r); err != nil {
+			if errs == nil {
+				errs = make([]error, len(frames))
+			}
+			errs[i] = err
+		}
+	}
+	return errs
+}
@@ -0,0 +1,124 @@
+package gofi
+
+import (
+	"net"
+	"time"
+)
+
+// These are pcap data-link types which a Handle may capture.
+const (
+	// DLTIEEE802_11 is raw 802.11 frames with no radio information.
+	DLTIEEE802_11 = 105
+
+	// DLTIEEE802_11Radio is 802.11 frames with radiotap headers.
+	DLTIEEE802_11Radio = 127
+)
+
+// An InterfaceMode is a mode of operation for a wireless interface.
+type InterfaceMode int
+
+const (
+	ModeUnknown InterfaceMode = iota
+	ModeStation
+	ModeAdHoc
+	ModeAccessPoint
+	ModeMonitor
+	ModeMesh
+	ModeP2PClient
+	ModeP2PGroupOwner
+)
+
+// String returns a human-readable name for the mode, such as "monitor".
+func (m InterfaceMode) String() string {
+	switch m {
+	case ModeStation:
+		return "station"
+	case ModeAdHoc:
+		return "ad-hoc"
+	case ModeAccessPoint:
+		return "access point"
+	case ModeMonitor:
+		return "monitor"
+	case ModeMesh:
+		return "mesh"
+	case ModeP2PClient:
+		return "P2P client"
+	case ModeP2PGroupOwner:
+		return "P2P group owner"
+	default:
+		return "unknown"
+	}
+}
+
+// InterfaceInfo describes a wireless device and its capabilities.
+type InterfaceInfo struct {
+	// Name is the name of the network interface, such as "wlan0".
+	Name string
+
+	// MAC is the hardware address of the interface.
+	MAC net.HardwareAddr
+
+	// Phy is the name of the physical device, such as "phy0".
+	// It is empty if the OS does not expose physical devices.
+	Phy string
+
+	// Driver is the name of the device's driver, or an empty
+	// string if it is unknown.
+	Driver string
+
+	// Bands describes the rates supported in every band.
+	Bands []BandCapabilities
+
+	// Channels lists the channels the device supports.
+	Channels []Channel
+
+	// Modes lists the interface modes the device supports.
+	Modes []InterfaceMode
+
+	// ActiveMonitor is true if the device can acknowledge frames
+	// addressed to it while in monitor mode.
+	ActiveMonitor bool
+
+	// Injection is true if the device can send raw frames.
+	Injection bool
+
+	// Mode is the current mode of the interface.
+	Mode InterfaceMode
+}
+
+// SupportsMode checks if a mode is in i.Modes.
+func (i *InterfaceInfo) SupportsMode(m InterfaceMode) bool {
+	for _, mode := range i.Modes {
+		if mode == m {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleInfo describes the settings used by a Handle.
+type HandleInfo struct {
+	// Interface is the name of the underlying network interface.
+	Interface string
+
+	// DataLinkType is the pcap data-link type of captured packets,
+	// such as DLTIEEE802_11Radio.
+	DataLinkType int
+
+	// Mode is the mode of the interface while the Handle is open.
+	Mode InterfaceMode
+
+	// Channel is the channel the device is tuned to.
+	Channel Channel
+
+	// ReadBufferSize is the size, in bytes, of the buffer which holds
+	// received packets, or 0 if it is unknown.
+	ReadBufferSize int
+
+	// ReadTimeout is how long a read blocks before checking if the
+	// Handle has been closed, or 0 if reads are never interrupted.
+	ReadTimeout time.Duration
+
+	// TxStatus is true if the Handle can report transmit status.
+	TxStatus bool
+}
@@ -0,0 +1,693 @@
+// +build linux
+
+package gofi
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"golang.org/x/sys/unix"
+)
+
+// An nl80211Interface describes a wireless interface as reported
+// by NL80211_CMD_GET_INTERFACE.
+type nl80211Interface struct {
+	Index     int
+	Name      string
+	Wiphy     uint32
+	Type      uint32
+	MAC       net.HardwareAddr
+	Frequency int
+	Width     uint32
+
+	CenterFreq1 uint32
+	CenterFreq2 uint32
+}
+
+func parseNL80211Interface(attrs netlinkAttrs) nl80211Interface {
+	var res nl80211Interface
+	if index, ok := attrs.Uint32(unix.NL80211_ATTR_IFINDEX); ok {
+		res.Index = int(index)
+	}
+	res.Name, _ = attrs.String(unix.NL80211_ATTR_IFNAME)
+	res.Wiphy, _ = attrs.Uint32(unix.NL80211_ATTR_WIPHY)
+	res.Type, _ = attrs.Uint32(unix.NL80211_ATTR_IFTYPE)
+	if mac, ok := attrs[unix.NL80211_ATTR_MAC]; ok {
+		res.MAC = net.HardwareAddr(append([]byte{}, mac...))
+	}
+	if freq, ok := attrs.Uint32(unix.NL80211_ATTR_WIPHY_FREQ); ok {
+		res.Frequency = int(freq)
+	}
+	res.Width, _ = attrs.Uint32(unix.NL80211_ATTR_CHANNEL_WIDTH)
+	res.CenterFreq1, _ = attrs.Uint32(unix.NL80211_ATTR_CENTER_FREQ1)
+	res.CenterFreq2, _ = attrs.Uint32(unix.NL80211_ATTR_CENTER_FREQ2)
+	return res
+}
+
+// listNL80211Interfaces returns every wireless interface on the system.
+func listNL80211Interfaces(nl *genlFamily) ([]nl80211Interface, error) {
+	resps, err := nl.Request(unix.NL80211_CMD_GET_INTERFACE, unix.NLM_F_DUMP)
+	if err != nil {
+		return nil, err
+	}
+	var res []nl80211Interface
+	for _, resp := range resps {
+		res = append(res, parseNL80211Interface(resp))
+	}
+	return res, nil
+}
+
+// An nl80211Frequency describes one channel supported by a wiphy.
+type nl80211Frequency struct {
+	MHz         int
+	Disabled    bool
+	NoHT40Minus bool
+	NoHT40Plus  bool
+}
+
+// An nl80211Band describes a frequency band supported by a wiphy.
+type nl80211Band struct {
+	Index       int
+	Frequencies []nl80211Frequency
+	HTCapable   bool
+	HTCapa      uint16
+
+	// Rates lists the legacy bitrates, in units of 100Kb/s.
+	Rates []int
+
+	HTMCSSet  []byte
+	VHTMCSSet []byte
+	HEMCSSet  []byte
+}
+
+// Band returns the gofi Band corresponding to the nl80211 band index.
+func (b *nl80211Band) Band() Band {
+	switch b.Index {
+	case unix.NL80211_BAND_2GHZ:
+		return Band2GHz
+	case unix.NL80211_BAND_5GHZ:
+		return Band5GHz
+	case unix.NL80211_BAND_6GHZ:
+		return Band6GHz
+	case unix.NL80211_BAND_60GHZ:
+		return Band60GHz
+	default:
+		return BandUnknown
+	}
+}
+
+// Capabilities converts the band description into BandCapabilities.
+func (b *nl80211Band) Capabilities() BandCapabilities {
+	res := BandCapabilities{Band: b.Band()}
+	seen := map[DataRate]bool{}
+	for _, rate := range b.Rates {
+		// NOTE: nl80211 uses units of 100Kb/s, while we use 500Kb/s.
+		r := DataRate(rate / 5)
+		if rate%5 == 0 && !seen[r] {
+			seen[r] = true
+			res.Rates = append(res.Rates, r)
+		}
+	}
+	sort.Slice(res.Rates, func(i, j int) bool {
+		return res.Rates[i] < res.Rates[j]
+	})
+	if b.HTCapable && len(b.HTMCSSet) >= 10 {
+		res.HTMCS = decodeHTMCSSet(b.HTMCSSet[:10])
+	}
+	if len(b.VHTMCSSet) >= 2 {
+		rxMap := binary.LittleEndian.Uint16(b.VHTMCSSet)
+		res.VHTMaxMCS = decodeMCSMap(rxMap, [3]int{7, 8, 9})
+	}
+	if len(b.HEMCSSet) >= 2 {
+		rxMap := binary.LittleEndian.Uint16(b.HEMCSSet)
+		res.HEMaxMCS = decodeMCSMap(rxMap, [3]int{7, 9, 11})
+	}
+	return res
+}
+
+// An nl80211Wiphy describes a physical wireless device.
+type nl80211Wiphy struct {
+	Index uint32
+	Name  string
+	Bands []*nl80211Band
+
+	// SupportedIftypes and SoftwareIftypes list nl80211 interface types.
+	// Software interface types are implemented by the kernel rather
+	// than the driver, as mac80211 does for monitor interfaces.
+	SupportedIftypes []uint32
+	SoftwareIftypes  []uint32
+
+	FeatureFlags uint32
+}
+
+// SupportsIftype checks if an interface type is supported.
+func (w *nl80211Wiphy) SupportsIftype(iftype uint32) bool {
+	for _, t := range w.SupportedIftypes {
+		if t == iftype {
+			return true
+		}
+	}
+	return false
+}
+
+// Injection checks if the device can inject frames, which is true
+// for every device that uses mac80211.
+// We detect mac80211 by the fact that it implements monitor mode
+// in software.
+func (w *nl80211Wiphy) Injection() bool {
+	for _, t := range w.SoftwareIftypes {
+		if t == unix.NL80211_IFTYPE_MONITOR {
+			return true
+		}
+	}
+	return false
+}
+
+// Channels lists the enabled channels in every band.
+func (w *nl80211Wiphy) Channels() []Channel {
+	res := []Channel{}
+	for _, band := range w.Bands {
+		supports40 := band.HTCapable && (band.HTCapa&htCapSupportedWidth40) != 0
+		for _, freq := range band.Frequencies {
+			ch := NewChannelFrequency(freq.MHz)
+			if freq.Disabled || ch.Number == 0 {
+				continue
+			}
+			res = append(res, ch)
+			if supports40 && !(freq.NoHT40Minus && freq.NoHT40Plus) {
+				res = append(res, Channel{Number: ch.Number, Width: ChannelWidth40MHz})
+			}
+		}
+	}
+	return res
+}
+
+// BandCapabilities converts every band into BandCapabilities.
+func (w *nl80211Wiphy) BandCapabilities() []BandCapabilities {
+	res := []BandCapabilities{}
+	for _, band := range w.Bands {
+		res = append(res, band.Capabilities())
+	}
+	return res
+}
+
+// getNL80211Wiphy fetches the description of a physical device.
+func getNL80211Wiphy(nl *genlFamily, index uint32) (*nl80211Wiphy, error) {
+	// NOTE: modern kernels split wiphy descriptions across several
+	// messages, so we must merge them back together.
+	resps, err := nl.Request(unix.NL80211_CMD_GET_WIPHY, unix.NLM_F_DUMP,
+		netlinkUint32Attr(unix.NL80211_ATTR_WIPHY, index),
+		netlinkFlagAttr(unix.NL80211_ATTR_SPLIT_WIPHY_DUMP))
+	if err != nil {
+		return nil, err
+	}
+	res := &nl80211Wiphy{Index: index}
+	bands := map[int]*nl80211Band{}
+	for _, resp := range resps {
+		if idx, ok := resp.Uint32(unix.NL80211_ATTR_WIPHY); !ok || idx != index {
+			continue
+		}
+		if name, ok := resp.String(unix.NL80211_ATTR_WIPHY_NAME); ok {
+			res.Name = name
+		}
+		if flags, ok := resp.Uint32(unix.NL80211_ATTR_FEATURE_FLAGS); ok {
+			res.FeatureFlags = flags
+		}
+		if iftypes, ok := resp.Nested(unix.NL80211_ATTR_SUPPORTED_IFTYPES); ok {
+			res.SupportedIftypes = sortedAttrTypes(iftypes)
+		}
+		if iftypes, ok := resp.Nested(unix.NL80211_ATTR_SOFTWARE_IFTYPES); ok {
+			res.SoftwareIftypes = sortedAttrTypes(iftypes)
+		}
+		bandAttrs, ok := resp.Nested(unix.NL80211_ATTR_WIPHY_BANDS)
+		if !ok {
+			continue
+		}
+		for bandIndex := range bandAttrs {
+			attrs, ok := bandAttrs.Nested(bandIndex)
+			if !ok {
+				continue
+			}
+			band := bands[int(bandIndex)]
+			if band == nil {
+				band = &nl80211Band{Index: int(bandIndex)}
+				bands[int(bandIndex)] = band
+			}
+			mergeNL80211Band(band, attrs)
+		}
+	}
+	for _, band := range bands {
+		res.Bands = append(res.Bands, band)
+	}
+	sort.Slice(res.Bands, func(i, j int) bool {
+		return res.Bands[i].Index < res.Bands[j].Index
+	})
+	return res, nil
+}
+
+func sortedAttrTypes(attrs netlinkAttrs) []uint32 {
+	var res []uint32
+	for t := range attrs {
+		res = append(res, uint32(t))
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i] < res[j]
+	})
+	return res
+}
+
+func mergeNL80211Band(band *nl80211Band, attrs netlinkAttrs) {
+	if capa, ok := attrs.Uint16(unix.NL80211_BAND_ATTR_HT_CAPA); ok {
+		band.HTCapable = true
+		band.HTCapa = capa
+	}
+	if set, ok := attrs[unix.NL80211_BAND_ATTR_HT_MCS_SET]; ok {
+		band.HTMCSSet = set
+	}
+	if set, ok := attrs[unix.NL80211_BAND_ATTR_VHT_MCS_SET]; ok {
+		band.VHTMCSSet = set
+	}
+	iftypeData, _ := attrs.List(unix.NL80211_BAND_ATTR_IFTYPE_DATA)
+	for _, data := range iftypeData {
+		// NOTE: every interface type shares the same MCS set in practice.
+		if set, ok := data[unix.NL80211_BAND_IFTYPE_ATTR_HE_CAP_MCS_SET]; ok {
+			band.HEMCSSet = set
+		}
+	}
+	rates, _ := attrs.List(unix.NL80211_BAND_ATTR_RATES)
+	for _, rate := range rates {
+		if value, ok := rate.Uint32(unix.NL80211_BITRATE_ATTR_RATE); ok {
+			band.Rates = append(band.Rates, int(value))
+		}
+	}
+	freqs, _ := attrs.List(unix.NL80211_BAND_ATTR_FREQS)
+	for _, freq := range freqs {
+		mhz, ok := freq.Uint32(unix.NL80211_FREQUENCY_ATTR_FREQ)
+		if !ok {
+			continue
+		}
+		band.Frequencies = append(band.Frequencies, nl80211Frequency{
+			MHz:         int(mhz),
+			Disabled:    freq.Flag(unix.NL80211_FREQUENCY_ATTR_DISABLED),
+			NoHT40Minus: freq.Flag(unix.NL80211_FREQUENCY_ATTR_NO_HT40_MINUS),
+			NoHT40Plus:  freq.Flag(unix.NL80211_FREQUENCY_ATTR_NO_HT40_PLUS),
+		})
+	}
+}
+
+// linuxInterfaces describes every wireless interface on the system.
+func linuxInterfaces() ([]InterfaceInfo, error) {
+	nl, err := newGenlFamily("nl80211")
+	if err != nil {
+		return nil, err
+	}
+	defer nl.Close()
+
+	interfaces, err := listNL80211Interfaces(nl)
+	if err != nil {
+		return nil, err
+	}
+	wiphys := map[uint32]*nl80211Wiphy{}
+	res := []InterfaceInfo{}
+	for _, iface := range interfaces {
+		wiphy, ok := wiphys[iface.Wiphy]
+		if !ok {
+			wiphy, err = getNL80211Wiphy(nl, iface.Wiphy)
+			if err != nil {
+				return nil, err
+			}
+			wiphys[iface.Wiphy] = wiphy
+		}
+		info := InterfaceInfo{
+			Name:          iface.Name,
+			MAC:           iface.MAC,
+			Phy:           wiphy.Name,
+			Driver:        linuxDriverName(iface.Name),
+			Bands:         wiphy.BandCapabilities(),
+			Channels:      wiphy.Channels(),
+			ActiveMonitor: (wiphy.FeatureFlags & unix.NL80211_FEATURE_ACTIVE_MONITOR) != 0,
+			Injection:     wiphy.Injection(),
+			Mode:          nl80211InterfaceMode(iface.Type),
+		}
+		seen := map[InterfaceMode]bool{}
+		for _, iftype := range wiphy.SupportedIftypes {
+			mode := nl80211InterfaceMode(iftype)
+			if mode != ModeUnknown && !seen[mode] {
+				seen[mode] = true
+				info.Modes = append(info.Modes, mode)
+			}
+		}
+		res = append(res, info)
+	}
+	return res, nil
+}
+
+// linuxDriverName finds the name of an interface's driver in sysfs.
+func linuxDriverName(name string) string {
+	link, err := os.Readlink("/sys/class/net/" + name + "/device/driver")
+	if err != nil {
+		return ""
+	}
+	return filepath.Base(link)
+}
+
+func nl80211InterfaceMode(iftype uint32) InterfaceMode {
+	switch iftype {
+	case unix.NL80211_IFTYPE_STATION:
+		return ModeStation
+	case unix.NL80211_IFTYPE_ADHOC:
+		return ModeAdHoc
+	case unix.NL80211_IFTYPE_AP, unix.NL80211_IFTYPE_AP_VLAN:
+		return ModeAccessPoint
+	case unix.NL80211_IFTYPE_MONITOR:
+		return ModeMonitor
+	case unix.NL80211_IFTYPE_MESH_POINT:
+		return ModeMesh
+	case unix.NL80211_IFTYPE_P2P_CLIENT:
+		return ModeP2PClient
+	case unix.NL80211_IFTYPE_P2P_GO:
+		return ModeP2PGroupOwner
+	default:
+		return ModeUnknown
+	}
+}
+
+// defaultLinuxInterfaceName returns the name of the default interface,
+// preferring interfaces which are already in monitor mode.
+func defaultLinuxInterfaceName() (string, error) {
+	nl, err := newGenlFamily("nl80211")
+	if err != nil {
+		return "", err
+	}
+	defer nl.Close()
+
+	interfaces, err := listNL80211Interfaces(nl)
+	if err != nil {
+		return "", err
+	}
+	for _, iface := range interfaces {
+		if iface.Type == unix.NL80211_IFTYPE_MONITOR {
+			return iface.Name, nil
+		}
+	}
+	if len(interfaces) > 0 {
+		return interfaces[0].Name, nil
+	}
+
+	return "", errors.New("no WiFi devices found")
+}
+
+// A linuxInterface makes it possible to configure a wireless
+// interface through nl80211.
+type linuxInterface struct {
+	nl    *genlFamily
+	name  string
+	index int
+	wiphy uint32
+
+	// wiphy is fetched lazily, since the capabilities of a device
+	// do not change.
+	wiphyInfo *nl80211Wiphy
+
+	// lastChannel is the channel which was most recently set.
+	// Some drivers do not report the channel of monitor interfaces,
+	// in which case this is the best guess we have.
+	lastChannel Channel
+}
+
+// newLinuxInterface creates an interface given a name.
+// This fails if the interface cannot be found or is not a WiFi device.
+func newLinuxInterface(name string) (*linuxInterface, error) {
+	netIface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, errors.New("no such device: " + name)
+	}
+	nl, err := newGenlFamily("nl80211")
+	if err != nil {
+		return nil, err
+	}
+	iface := &linuxInterface{nl: nl, name: name, index: netIface.Index}
+	info, err := iface.info()
+	if err != nil {
+		nl.Close()
+		if err == unix.ENODEV {
+			return nil, errors.New("not a WiFi device: " + name)
+		}
+		return nil, err
+	}
+	iface.wiphy = info.Wiphy
+	return iface, nil
+}
+
+// Name returns the interface name.
+func (i *linuxInterface) Name() string {
+	return i.name
+}
+
+// Index returns the interface index.
+func (i *linuxInterface) Index() int {
+	return i.index
+}
+
+// Type returns the nl80211 interface type, such as NL80211_IFTYPE_MONITOR.
+func (i *linuxInterface) Type() (uint32, error) {
+	info, err := i.info()
+	if err != nil {
+		return 0, err
+	}
+	return info.Type, nil
+}
+
+// SetType changes the nl80211 interface type.
+// Most drivers require the interface to be down for this to succeed.
+func (i *linuxInterface) SetType(iftype uint32) error {
+	_, err := i.nl.Request(unix.NL80211_CMD_SET_INTERFACE, 0,
+		netlinkUint32Attr(unix.NL80211_ATTR_IFINDEX, uint32(i.index)),
+		netlinkUint32Attr(unix.NL80211_ATTR_IFTYPE, iftype))
+	return err
+}
+
+// EnterMonitorMode switches the interface into monitor mode if it
+// is not already in monitor mode.
+// This brings the interface down and back up again.
+//
+// If flags is non-zero, the monitor flags are set as well.
+func (i *linuxInterface) EnterMonitorMode(flags MonitorFlags) error {
+	if t, err := i.Type(); err != nil {
+		return err
+	} else if t == unix.NL80211_IFTYPE_MONITOR && flags == 0 {
+		return i.SetUp(true)
+	}
+	if err := i.SetUp(false); err != nil {
+		return err
+	}
+	attrs := [][]byte{
+		netlinkUint32Attr(unix.NL80211_ATTR_IFINDEX, uint32(i.index)),
+		netlinkUint32Attr(unix.NL80211_ATTR_IFTYPE, unix.NL80211_IFTYPE_MONITOR),
+	}
+	if flags != 0 {
+		attrs = append(attrs, flags.nl80211Flags())
+	}
+	if _, err := i.nl.Request(unix.NL80211_CMD_SET_INTERFACE, 0, attrs...); err != nil {
+		return err
+	}
+	return i.SetUp(true)
+}
+
+// IsUp checks if the interface is up.
+func (i *linuxInterface) IsUp() (bool, error) {
+	flags, err := i.linkFlags()
+	if err != nil {
+		return false, err
+	}
+	return (flags & unix.IFF_UP) != 0, nil
+}
+
+// SetUp brings the interface up or down.
+func (i *linuxInterface) SetUp(up bool) error {
+	flags, err := i.linkFlags()
+	if err != nil {
+		return err
+	}
+	if up {
+		flags |= unix.IFF_UP
+	} else {
+		flags &^= unix.IFF_UP
+	}
+	return i.setLinkFlags(flags)
+}
+
+// SupportedChannels generates a list of supported channels in
+// an unspecified order.
+func (i *linuxInterface) SupportedChannels() []Channel {
+	wiphy, err := i.Wiphy()
+	if err != nil {
+		return []Channel{}
+	}
+	return wiphy.Channels()
+}
+
+// BandCapabilities returns the capabilities of every supported band.
+func (i *linuxInterface) BandCapabilities() []BandCapabilities {
+	wiphy, err := i.Wiphy()
+	if err != nil {
+		return []BandCapabilities{}
+	}
+	return wiphy.BandCapabilities()
+}
+
+// Mode returns the current interface mode.
+func (i *linuxInterface) Mode() InterfaceMode {
+	t, err := i.Type()
+	if err != nil {
+		return ModeUnknown
+	}
+	return nl80211InterfaceMode(t)
+}
+
+// Wiphy returns a description of the interface's physical device.
+func (i *linuxInterface) Wiphy() (*nl80211Wiphy, error) {
+	if i.wiphyInfo == nil {
+		wiphy, err := getNL80211Wiphy(i.nl, i.wiphy)
+		if err != nil {
+			return nil, err
+		}
+		i.wiphyInfo = wiphy
+	}
+	return i.wiphyInfo, nil
+}
+
+// Channel returns the interface's current channel.
+func (i *linuxInterface) Channel() Channel {
+	info, err := i.info()
+	if err != nil || info.Frequency == 0 {
+		return i.lastChannel
+	}
+	ch := NewChannelFrequency(info.Frequency)
+	switch info.Width {
+	case unix.NL80211_CHAN_WIDTH_40:
+		ch.Width = ChannelWidth40MHz
+	case unix.NL80211_CHAN_WIDTH_20, unix.NL80211_CHAN_WIDTH_20_NOHT:
+		ch.Width = ChannelWidth20MHz
+	default:
+		ch.Width = ChannelWidthUnspecified
+	}
+	return ch
+}
+
+// SetChannel switches to a channel.
+func (i *linuxInterface) SetChannel(c Channel) error {
+	if c.Width == 0 {
+		c.Width = ChannelWidth20MHz
+	}
+	freq := c.Frequency()
+	if freq == 0 {
+		return errors.New("unknown channel")
+	}
+
+	channelType := uint32(unix.NL80211_CHAN_HT20)
+	if c.Width == ChannelWidth40MHz {
+		channelType = unix.NL80211_CHAN_HT40MINUS
+		if secondaryChannelAbove(c.Number) {
+			channelType = unix.NL80211_CHAN_HT40PLUS
+		}
+	} else if c.Width != ChannelWidth20MHz {
+		return errors.New("unsupported channel width")
+	}
+
+	_, err := i.nl.Request(unix.NL80211_CMD_SET_WIPHY, 0,
+		netlinkUint32Attr(unix.NL80211_ATTR_IFINDEX, uint32(i.index)),
+		netlinkUint32Attr(unix.NL80211_ATTR_WIPHY_FREQ, uint32(freq)),
+		netlinkUint32Attr(unix.NL80211_ATTR_WIPHY_CHANNEL_TYPE, channelType))
+	if err != nil {
+		return err
+	}
+	i.lastChannel = c
+	return nil
+}
+
+// setChannelSpec switches to the exact channel described by an
+// interface's info, including its width and center frequencies.
+func (i *linuxInterface) setChannelSpec(info *nl80211Interface) error {
+	attrs := [][]byte{
+		netlinkUint32Attr(unix.NL80211_ATTR_IFINDEX, uint32(i.index)),
+		netlinkUint32Attr(unix.NL80211_ATTR_WIPHY_FREQ, uint32(info.Frequency)),
+		netlinkUint32Attr(unix.NL80211_ATTR_CHANNEL_WIDTH, info.Width),
+	}
+	if info.CenterFreq1 != 0 {
+		attrs = append(attrs, netlinkUint32Attr(unix.NL80211_ATTR_CENTER_FREQ1,
+			info.CenterFreq1))
+	}
+	if info.CenterFreq2 != 0 {
+		attrs = append(attrs, netlinkUint32Attr(unix.NL80211_ATTR_CENTER_FREQ2,
+			info.CenterFreq2))
+	}
+	_, err := i.nl.Request(unix.NL80211_CMD_SET_WIPHY, 0, attrs...)
+	return err
+}
+
+// Close closes the nl80211 socket.
+// After you call this, you should not call anything else
+// on the interface.
+func (i *linuxInterface) Close() {
+	i.nl.Close()
+}
+
+func (i *linuxInterface) info() (*nl80211Interface, error) {
+	resps, err := i.nl.Request(unix.NL80211_CMD_GET_INTERFACE, 0,
+		netlinkUint32Attr(unix.NL80211_ATTR_IFINDEX, uint32(i.index)))
+	if err != nil {
+		return nil, err
+	} else if len(resps) == 0 {
+		return nil, unix.ENODEV
+	}
+	info := parseNL80211Interface(resps[0])
+	return &info, nil
+}
+
+func (i *linuxInterface) linkFlags() (uint16, error) {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer unix.Close(fd)
+	ifreq, err := unix.NewIfreq(i.name)
+	if err != nil {
+		return 0, err
+	}
+	if err := unix.IoctlIfreq(fd, unix.SIOCGIFFLAGS, ifreq); err != nil {
+		return 0, err
+	}
+	return ifreq.Uint16(), nil
+}
+
+func (i *linuxInterface) setLinkFlags(flags uint16) error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+	ifreq, err := unix.NewIfreq(i.name)
+	if err != nil {
+		return err
+	}
+	ifreq.SetUint16(flags)
+	return unix.IoctlIfreq(fd, unix.SIOCSIFFLAGS, ifreq)
+}
+
+// htCapSupportedWidth40 is the HT capabilities bit which indicates
+// support for 40MHz channels.
+const htCapSupportedWidth40 = 0x0002
+
+// secondaryChannelAbove decides if a 40MHz channel should extend
+// above or below the given primary channel.
+func secondaryChannelAbove(number int) bool {
+	if number <= 14 {
+		return number <= 7
+	}
+	return (number/4)%2 == 1
+}
@@ -0,0 +1,271 @@
+// +build linux
+
+package gofi
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"reflect"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestParseNL80211Interface(t *testing.T) {
+	mac := []byte{0x02, 0, 0, 0, 0x01, 0x02}
+	tests := []struct {
+		attrs    [][]byte
+		expected nl80211Interface
+	}{
+		{
+			attrs:    nil,
+			expected: nl80211Interface{},
+		},
+		{
+			attrs: [][]byte{
+				netlinkUint32Attr(unix.NL80211_ATTR_IFINDEX, 7),
+				netlinkStringAttr(unix.NL80211_ATTR_IFNAME, "wlan1"),
+				netlinkUint32Attr(unix.NL80211_ATTR_WIPHY, 2),
+				netlinkUint32Attr(unix.NL80211_ATTR_IFTYPE, unix.NL80211_IFTYPE_STATION),
+				netlinkAttr(unix.NL80211_ATTR_MAC, mac),
+			},
+			expected: nl80211Interface{
+				Index: 7,
+				Name:  "wlan1",
+				Wiphy: 2,
+				Type:  unix.NL80211_IFTYPE_STATION,
+				MAC:   mac,
+			},
+		},
+		{
+			attrs: [][]byte{
+				netlinkUint32Attr(unix.NL80211_ATTR_IFINDEX, 3),
+				netlinkStringAttr(unix.NL80211_ATTR_IFNAME, "mon0"),
+				netlinkUint32Attr(unix.NL80211_ATTR_IFTYPE, unix.NL80211_IFTYPE_MONITOR),
+				netlinkUint32Attr(unix.NL80211_ATTR_WIPHY_FREQ, 5180),
+				netlinkUint32Attr(unix.NL80211_ATTR_CHANNEL_WIDTH, unix.NL80211_CHAN_WIDTH_80),
+				netlinkUint32Attr(unix.NL80211_ATTR_CENTER_FREQ1, 5210),
+			},
+			expected: nl80211Interface{
+				Index:       3,
+				Name:        "mon0",
+				Type:        unix.NL80211_IFTYPE_MONITOR,
+				Frequency:   5180,
+				Width:       unix.NL80211_CHAN_WIDTH_80,
+				CenterFreq1: 5210,
+			},
+		},
+	}
+	for i, test := range tests {
+		attrs, err := parseNetlinkAttrs(testConcat(test.attrs))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := parseNL80211Interface(attrs); !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("test %d: expected %+v but got %+v", i, test.expected, actual)
+		}
+	}
+}
+
+func TestNL80211BandCapabilities(t *testing.T) {
+	tests := []struct {
+		index int
+
+		// messages lists the band attributes of each message of a
+		// split wiphy dump.
+		messages [][][]byte
+
+		channels     []Channel
+		capabilities BandCapabilities
+	}{
+		{
+			index: unix.NL80211_BAND_2GHZ,
+			messages: [][][]byte{
+				{
+					testNL80211Freqs(2412, 2417),
+					testNL80211Rates(10, 20, 55, 110),
+				},
+				{
+					// Rates which are not multiples of 500Kb/s are
+					// dropped, and repeated rates are only listed once.
+					testNL80211Rates(12, 60, 20),
+				},
+			},
+			channels: []Channel{
+				{Number: 1, Width: ChannelWidth20MHz},
+				{Number: 2, Width: ChannelWidth20MHz},
+			},
+			capabilities: BandCapabilities{
+				Band:  Band2GHz,
+				Rates: []DataRate{2, 4, 11, 12, 22},
+			},
+		},
+		{
+			index: unix.NL80211_BAND_5GHZ,
+			messages: [][][]byte{
+				{
+					netlinkAttr(unix.NL80211_BAND_ATTR_HT_CAPA,
+						[]byte{htCapSupportedWidth40, 0}),
+					netlinkAttr(unix.NL80211_BAND_ATTR_HT_MCS_SET,
+						[]byte{0xff, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}),
+					netlinkAttr(unix.NL80211_BAND_ATTR_VHT_MCS_SET,
+						[]byte{0xfe, 0xff, 0, 0, 0xfe, 0xff, 0, 0}),
+					netlinkNestedAttr(unix.NL80211_BAND_ATTR_IFTYPE_DATA,
+						netlinkNestedAttr(0,
+							netlinkAttr(unix.NL80211_BAND_IFTYPE_ATTR_HE_CAP_MCS_SET,
+								[]byte{0xfe, 0xff, 0xfe, 0xff}))),
+				},
+				{
+					netlinkNestedAttr(unix.NL80211_BAND_ATTR_FREQS,
+						netlinkNestedAttr(0,
+							netlinkUint32Attr(unix.NL80211_FREQUENCY_ATTR_FREQ, 5180),
+							netlinkFlagAttr(unix.NL80211_FREQUENCY_ATTR_NO_HT40_MINUS)),
+						netlinkNestedAttr(1,
+							netlinkUint32Attr(unix.NL80211_FREQUENCY_ATTR_FREQ, 5200),
+							netlinkFlagAttr(unix.NL80211_FREQUENCY_ATTR_NO_HT40_MINUS),
+							netlinkFlagAttr(unix.NL80211_FREQUENCY_ATTR_NO_HT40_PLUS)),
+						netlinkNestedAttr(2,
+							netlinkUint32Attr(unix.NL80211_FREQUENCY_ATTR_FREQ, 5220),
+							netlinkFlagAttr(unix.NL80211_FREQUENCY_ATTR_DISABLED))),
+					testNL80211Rates(540, 60, 120),
+				},
+			},
+			channels: []Channel{
+				{Number: 36, Width: ChannelWidth20MHz},
+				{Number: 36, Width: ChannelWidth40MHz},
+				{Number: 40, Width: ChannelWidth20MHz},
+			},
+			capabilities: BandCapabilities{
+				Band:      Band5GHz,
+				Rates:     []DataRate{12, 24, 108},
+				HTMCS:     []int{0, 1, 2, 3, 4, 5, 6, 7},
+				VHTMaxMCS: []int{9},
+				HEMaxMCS:  []int{11},
+			},
+		},
+	}
+	for i, test := range tests {
+		band := &nl80211Band{Index: test.index}
+		for _, message := range test.messages {
+			attrs, err := parseNetlinkAttrs(testConcat(message))
+			if err != nil {
+				t.Fatal(err)
+			}
+			mergeNL80211Band(band, attrs)
+		}
+		wiphy := &nl80211Wiphy{Bands: []*nl80211Band{band}}
+		if channels := wiphy.Channels(); !reflect.DeepEqual(channels, test.channels) {
+			t.Errorf("test %d: expected channels %v but got %v", i, test.channels, channels)
+		}
+		if caps := band.Capabilities(); !reflect.DeepEqual(caps, test.capabilities) {
+			t.Errorf("test %d: expected capabilities %+v but got %+v", i,
+				test.capabilities, caps)
+		}
+	}
+}
+
+func TestInterfaceStateRestore(t *testing.T) {
+	name := testHwsimInterface(t)
+
+	for _, opts := range []*LinuxOptions{
+		{},
+		{CreateMonitor: true, MonitorName: "gofitestmon"},
+	} {
+		handle, err := NewLinuxHandle(name, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if opts.CreateMonitor {
+			if _, err := net.InterfaceByName(opts.MonitorName); err != nil {
+				t.Error("monitor interface was not created:", err)
+			}
+		}
+		handle.Close()
+
+		inter, err := newLinuxInterface(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if iftype, err := inter.Type(); err != nil {
+			t.Error(err)
+		} else if iftype != unix.NL80211_IFTYPE_STATION {
+			t.Error("interface type was not restored:", iftype)
+		}
+		if up, err := inter.IsUp(); err != nil {
+			t.Error(err)
+		} else if up {
+			t.Error("interface was left up")
+		}
+		inter.Close()
+		if opts.CreateMonitor {
+			if _, err := net.InterfaceByName(opts.MonitorName); err == nil {
+				t.Error("monitor interface was not deleted")
+			}
+		}
+	}
+}
+
+// testHwsimInterface loads mac80211_hwsim and returns the name of
+// one of its interfaces, which is down and in station mode.
+// The test is skipped if the module cannot be loaded, e.g. because
+// the test is not running as root.
+func testHwsimInterface(t *testing.T) string {
+	if os.Geteuid() != 0 {
+		t.Skip("mac80211_hwsim requires root")
+	}
+	if _, err := os.Stat("/sys/module/mac80211_hwsim"); os.IsNotExist(err) {
+		if err := exec.Command("modprobe", "mac80211_hwsim", "radios=1").Run(); err != nil {
+			t.Skip("could not load mac80211_hwsim:", err)
+		}
+		t.Cleanup(func() {
+			exec.Command("modprobe", "-r", "mac80211_hwsim").Run()
+		})
+	}
+	infos, err := linuxInterfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, info := range infos {
+		if info.Driver != "mac80211_hwsim" || info.Mode != ModeStation {
+			continue
+		}
+		inter, err := newLinuxInterface(info.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer inter.Close()
+		if err := inter.SetUp(false); err != nil {
+			t.Fatal(err)
+		}
+		return info.Name
+	}
+	t.Skip("no mac80211_hwsim station interface")
+	return ""
+}
+
+func testNL80211Freqs(mhz ...uint32) []byte {
+	var freqs [][]byte
+	for i, freq := range mhz {
+		freqs = append(freqs, netlinkNestedAttr(uint16(i),
+			netlinkUint32Attr(unix.NL80211_FREQUENCY_ATTR_FREQ, freq)))
+	}
+	return netlinkNestedAttr(unix.NL80211_BAND_ATTR_FREQS, freqs...)
+}
+
+func testNL80211Rates(rates ...uint32) []byte {
+	var attrs [][]byte
+	for i, rate := range rates {
+		attrs = append(attrs, netlinkNestedAttr(uint16(i),
+			netlinkUint32Attr(unix.NL80211_BITRATE_ATTR_RATE, rate)))
+	}
+	return netlinkNestedAttr(unix.NL80211_BAND_ATTR_RATES, attrs...)
+}
+
+func testConcat(attrs [][]byte) []byte {
+	var res []byte
+	for _, attr := range attrs {
+		res = append(res, attr...)
+	}
+	return res
+}
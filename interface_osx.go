@@ -22,12 +22,31 @@ const (
 const (
 	a80211CmdChannel           = 4
 	a80211CmdCardCapabilities  = 12
+	a80211CmdPhyMode           = 14
+	a80211CmdOpMode            = 15
 	a80211CmdDisassociate      = 22
 	a80211CmdSupportedChannels = 27
 )
 
 const a80211MaxChannelCount = 64
 
+// These are the PHY mode flags used in Apple's 802.11 ioctl API.
+const (
+	a80211Mode11A  = 0x02
+	a80211Mode11B  = 0x04
+	a80211Mode11G  = 0x08
+	a80211Mode11N  = 0x10
+	a80211Mode11AC = 0x80
+)
+
+// These are the operating mode flags used in Apple's 802.11 ioctl API.
+const (
+	a80211OpModeStation = 0x01
+	a80211OpModeIBSS    = 0x02
+	a80211OpModeHostAP  = 0x08
+	a80211OpModeMonitor = 0x10
+)
+
 // An osxInterface makes it possible to interact with Apple's 802.11
 // ioctl API.
 type osxInterface struct {
@@ -54,6 +73,35 @@ func defaultOSXInterfaceName() (string, error) {
 	return "", errors.New("no WiFi devices found")
 }
 
+// osxInterfaces describes every WiFi device on the machine.
+func osxInterfaces() ([]InterfaceInfo, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	res := []InterfaceInfo{}
+	for _, iface := range interfaces {
+		i, err := newOSXInterface(iface.Name)
+		if err != nil {
+			continue
+		}
+		res = append(res, InterfaceInfo{
+			Name:     iface.Name,
+			MAC:      iface.HardwareAddr,
+			Bands:    i.BandCapabilities(),
+			Channels: i.SupportedChannels(),
+			// NOTE: Apple's API does not tell us which modes are supported,
+			// but every card we know of supports these through BPF.
+			Modes:     []InterfaceMode{ModeStation, ModeMonitor},
+			Injection: true,
+			Mode:      i.Mode(),
+		})
+		i.Close()
+	}
+	return res, nil
+}
+
 // newOSXInterface creates an interface given a name.
 // This fails if the interface cannot be found or is not a WiFi device.
 func newOSXInterface(name string) (*osxInterface, error) {
@@ -92,6 +140,65 @@ func (iface *osxInterface) SupportedChannels() []Channel {
 	return res
 }
 
+// PhyModes returns a bitmask of the PHY modes that the card supports.
+func (i *osxInterface) PhyModes() (uint32, error) {
+	data := make([]byte, 12)
+	if err := i.get(a80211CmdPhyMode, data); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(data[4:]), nil
+}
+
+// Mode returns the current operating mode of the card.
+func (i *osxInterface) Mode() InterfaceMode {
+	data := make([]byte, 8)
+	if err := i.get(a80211CmdOpMode, data); err != nil {
+		return ModeUnknown
+	}
+	opMode := binary.LittleEndian.Uint32(data[4:])
+	switch {
+	case (opMode & a80211OpModeMonitor) != 0:
+		return ModeMonitor
+	case (opMode & a80211OpModeHostAP) != 0:
+		return ModeAccessPoint
+	case (opMode & a80211OpModeIBSS) != 0:
+		return ModeAdHoc
+	case (opMode & a80211OpModeStation) != 0:
+		return ModeStation
+	default:
+		return ModeUnknown
+	}
+}
+
+// BandCapabilities derives the rates supported in each band from the
+// card's PHY modes.
+// Apple's API does not expose MCS capabilities, so only legacy rates
+// are reported.
+func (iface *osxInterface) BandCapabilities() []BandCapabilities {
+	bands := map[Band]bool{}
+	for _, ch := range iface.SupportedChannels() {
+		bands[ch.Band()] = true
+	}
+	modes, err := iface.PhyModes()
+
+	res := []BandCapabilities{}
+	for _, band := range []Band{Band2GHz, Band5GHz} {
+		if !bands[band] {
+			continue
+		}
+		caps := BandCapabilities{Band: band}
+		if err != nil {
+			caps.Rates = LegacyRates(band)
+		} else {
+			caps.Rates = a80211PhyModeRates(modes, band)
+		}
+		if len(caps.Rates) > 0 {
+			res = append(res, caps)
+		}
+	}
+	return res
+}
+
 // Channel returns the interface's current channel number.
 func (i *osxInterface) Channel() Channel {
 	data := make([]byte, 16)
@@ -195,3 +302,18 @@ func decodeA80211ChannelDesc(desc []byte) Channel {
 	}
 	return ch
 }
+
+func a80211PhyModeRates(modes uint32, band Band) []DataRate {
+	var res []DataRate
+	for _, rate := range LegacyRates(band) {
+		isDSSS := rate == 2 || rate == 4 || rate == 11 || rate == 22
+		if band == Band2GHz && isDSSS && (modes&a80211Mode11B) != 0 {
+			res = append(res, rate)
+		} else if band == Band2GHz && !isDSSS && (modes&(a80211Mode11G|a80211Mode11N)) != 0 {
+			res = append(res, rate)
+		} else if band == Band5GHz && (modes&(a80211Mode11A|a80211Mode11N|a80211Mode11AC)) != 0 {
+			res = append(res, rate)
+		}
+	}
+	return res
+}
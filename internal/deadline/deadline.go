@@ -0,0 +1,75 @@
+// Package deadline implements the deadlines of the net.Conn and
+// net.PacketConn types in this module.
+package deadline
+
+import (
+	"sync"
+	"time"
+)
+
+// A Deadline is a channel which is closed once a deadline passes.
+//
+// Changing the deadline affects callers which are already waiting
+// on the channel, as net.Conn deadlines must.
+type Deadline struct {
+	lock    sync.Mutex
+	timer   *time.Timer
+	expired chan struct{}
+}
+
+// New creates a Deadline which never passes.
+func New() *Deadline {
+	return &Deadline{expired: make(chan struct{})}
+}
+
+// Set sets the deadline, where the zero time means no deadline.
+func (d *Deadline) Set(t time.Time) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.timer != nil && !d.timer.Stop() {
+		// NOTE: the timer fired, so wait for it to close the
+		// channel before replacing it.
+		<-d.expired
+	}
+	d.timer = nil
+
+	closed := IsClosed(d.expired)
+	if t.IsZero() {
+		if closed {
+			d.expired = make(chan struct{})
+		}
+		return
+	}
+	remaining := time.Until(t)
+	if remaining <= 0 {
+		if !closed {
+			close(d.expired)
+		}
+		return
+	}
+	if closed {
+		d.expired = make(chan struct{})
+	}
+	expired := d.expired
+	d.timer = time.AfterFunc(remaining, func() {
+		close(expired)
+	})
+}
+
+// Wait returns a channel which is closed when the deadline passes.
+func (d *Deadline) Wait() <-chan struct{} {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.expired
+}
+
+// IsClosed checks if a channel is closed without blocking.
+func IsClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,35 @@
+// Package testutil implements helpers which the tests of several
+// packages share.
+package testutil
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// WaitFor polls a condition until it holds, failing the test if it
+// still does not hold after five seconds.
+func WaitFor(t testing.TB, f func() bool) {
+	t.Helper()
+	for i := 0; i < 500; i++ {
+		if f() {
+			return
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	t.Fatal("timed out")
+}
+
+// Ethernet creates an IPv4 Ethernet frame with a payload.
+func Ethernet(dst, src net.HardwareAddr, payload []byte) []byte {
+	res := append(append([]byte{}, dst...), src...)
+	res = append(res, 0x08, 0x00)
+	return append(res, payload...)
+}
+
+// IsTimeout checks if an error is a net.Error caused by a timeout.
+func IsTimeout(err error) bool {
+	netErr, ok := err.(net.Error)
+	return ok && netErr.Timeout()
+}
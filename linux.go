@@ -0,0 +1,435 @@
+// +build linux
+
+package gofi
+
+import (
+	"sync"
+	"time"
+)
+
+// packetReadTimeout is the amount of time that a read blocks
+// before checking if the handle has been closed.
+const packetReadTimeout = time.Second
+
+// txStatusTimeout is the amount of time to wait for the kernel to
+// report if a frame was acknowledged.
+const txStatusTimeout = time.Second
+
+// txEchoTimeout is the amount of time to wait for the driver to echo a
+// transmitted frame after its status has been reported.
+const txEchoTimeout = time.Millisecond * 50
+
+// DefaultInterfaceName returns the name of the default WiFi device on this machine.
+// If the machine has no default WiFi device, this returns an error.
+func DefaultInterfaceName() (string, error) {
+	return defaultLinuxInterfaceName()
+}
+
+// Interfaces describes every WiFi device on this machine.
+func Interfaces() ([]InterfaceInfo, error) {
+	return linuxInterfaces()
+}
+
+// NewHandle creates a new handle with the given interface name.
+// If the handle cannot be created for any reason (e.g., permissions, no such
+// device, etc.), then this returns an error.
+//
+// If the interface is not already in monitor mode, it is switched into
+// monitor mode until the handle is closed.
+// Use NewLinuxHandle for more control over how the device is set up.
+func NewHandle(interfaceName string) (Handle, error) {
+	return NewLinuxHandle(interfaceName, nil)
+}
+
+func setupPacketSocket(socket *packetSocket, iname string) error {
+	if err := socket.CheckDataLink(iname); err != nil {
+		return err
+	}
+	if err := socket.SetReadTimeout(packetReadTimeout); err != nil {
+		return err
+	}
+	// NOTE: status reports are only enabled by SendWithStatus, since
+	// nothing else collects them from the error queue.
+	return socket.SetTxStatus(false)
+}
+
+type linuxHandle struct {
+	packetSocketLock sync.RWMutex
+	packetSocket     *packetSocket
+
+	linuxInterfaceLock sync.Mutex
+	linuxInterface     *linuxInterface
+
+	receiveLock sync.Mutex
+	sendLock    sync.Mutex
+
+	txEchoes txEchoCache
+
+	// rates caches the supported rates of each band, and band is the
+	// band of the current channel if bandKnown is set.
+	// They are protected by linuxInterfaceLock, and save a netlink
+	// round trip for every frame which is sent at the lowest rate.
+	rates     map[Band][]DataRate
+	band      Band
+	bandKnown bool
+
+	// txRing is created the first time SendBatch is called.
+	// It is protected by sendLock and packetSocketLock.
+	txRing *packetTxRing
+
+	// state is the original configuration of the device, which is
+	// restored when the handle is closed.
+	state *interfaceState
+}
+
+// SupportedRates returns the legacy rates which the device supports
+// in the band of the current channel.
+//
+// NOTE: the channel is only looked up the first time, and then
+// tracked by SetChannel, so changes made by other programs are missed.
+func (h *linuxHandle) SupportedRates() []DataRate {
+	h.linuxInterfaceLock.Lock()
+	defer h.linuxInterfaceLock.Unlock()
+	if h.linuxInterface == nil {
+		return []DataRate{}
+	}
+	if !h.bandKnown {
+		h.band = h.linuxInterface.Channel().Band()
+		h.bandKnown = true
+	}
+	return append([]DataRate{}, h.bandRatesLocked(h.band)...)
+}
+
+// bandRatesLocked returns the supported rates of a band, looking
+// them up the first time.
+// The caller must hold h.linuxInterfaceLock.
+func (h *linuxHandle) bandRatesLocked(b Band) []DataRate {
+	if rates, ok := h.rates[b]; ok {
+		return rates
+	}
+	if h.rates == nil {
+		h.rates = map[Band][]DataRate{}
+	}
+	rates := bandRates(h.linuxInterface.BandCapabilities(), b)
+	h.rates[b] = rates
+	return rates
+}
+
+// BandCapabilities returns the capabilities of the device for every
+// band it supports.
+func (h *linuxHandle) BandCapabilities() []BandCapabilities {
+	h.linuxInterfaceLock.Lock()
+	defer h.linuxInterfaceLock.Unlock()
+	if h.linuxInterface == nil {
+		return []BandCapabilities{}
+	} else {
+		return h.linuxInterface.BandCapabilities()
+	}
+}
+
+func (h *linuxHandle) SupportedChannels() []Channel {
+	h.linuxInterfaceLock.Lock()
+	defer h.linuxInterfaceLock.Unlock()
+	if h.linuxInterface == nil {
+		return []Channel{}
+	} else {
+		return h.linuxInterface.SupportedChannels()
+	}
+}
+
+func (h *linuxHandle) Channel() Channel {
+	h.linuxInterfaceLock.Lock()
+	defer h.linuxInterfaceLock.Unlock()
+	if h.linuxInterface == nil {
+		return Channel{}
+	} else {
+		return h.linuxInterface.Channel()
+	}
+}
+
+func (h *linuxHandle) SetChannel(ch Channel) error {
+	h.linuxInterfaceLock.Lock()
+	defer h.linuxInterfaceLock.Unlock()
+	if h.linuxInterface == nil {
+		return ErrClosed
+	}
+	if err := h.linuxInterface.SetChannel(ch); err != nil {
+		return err
+	}
+	h.band = ch.Band()
+	h.bandKnown = true
+	return nil
+}
+
+func (h *linuxHandle) Receive() (Frame, *RadioInfo, error) {
+	h.receiveLock.Lock()
+	defer h.receiveLock.Unlock()
+
+	for {
+		h.packetSocketLock.RLock()
+		if h.packetSocket == nil {
+			h.packetSocketLock.RUnlock()
+			return nil, nil, ErrClosed
+		}
+		packet, err := h.packetSocket.Receive()
+		h.packetSocketLock.RUnlock()
+
+		if err == errPacketReadTimeout {
+			continue
+		} else if err != nil {
+			return nil, nil, err
+		}
+
+		if packet.RadioInfo.TxStatus != nil {
+			h.txEchoes.Add(packet.Frame, packet.RadioInfo.TxStatus)
+		}
+		return packet.Frame, packet.RadioInfo, nil
+	}
+}
+
+func (h *linuxHandle) Send(f Frame, r DataRate) error {
+	if r == 0 {
+		r = h.lowestRate()
+	}
+
+	h.sendLock.Lock()
+	defer h.sendLock.Unlock()
+
+	h.packetSocketLock.RLock()
+	defer h.packetSocketLock.RUnlock()
+
+	if h.packetSocket != nil {
+		return h.packetSocket.Send(f, r)
+	} else {
+		return ErrClosed
+	}
+}
+
+// SendWithStatus sends a frame and waits for the kernel to report
+// whether or not it was acknowledged.
+//
+// The driver reports retry counts by echoing transmitted frames,
+// so Retries is only known while another goroutine is calling Receive.
+func (h *linuxHandle) SendWithStatus(f Frame, r DataRate) (*TxReport, error) {
+	if r == 0 {
+		r = h.lowestRate()
+	}
+
+	h.sendLock.Lock()
+	defer h.sendLock.Unlock()
+
+	h.packetSocketLock.RLock()
+	defer h.packetSocketLock.RUnlock()
+
+	if h.packetSocket == nil {
+		return nil, ErrClosed
+	}
+
+	h.packetSocket.DiscardTxStatus()
+	if err := h.packetSocket.SetTxStatus(true); err != nil {
+		return nil, err
+	}
+	defer func() {
+		h.packetSocket.SetTxStatus(false)
+		h.packetSocket.DiscardTxStatus()
+	}()
+	if err := h.packetSocket.Send(f, r); err != nil {
+		return nil, err
+	}
+	acked, sent, err := h.packetSocket.ReceiveTxStatus(txStatusTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &TxReport{Acked: acked, Retries: -1, Rate: r}
+	if echo := h.txEchoes.Find(sent, txEchoTimeout); echo != nil {
+		report.Retries = echo.Retries
+		if echo.Rate != 0 {
+			report.Rate = echo.Rate
+		}
+	}
+	return report, nil
+}
+
+// SendBatch queues frames in a transmit ring and sends them with a
+// single system call.
+// If the ring cannot be created, the frames are sent one at a time.
+func (h *linuxHandle) SendBatch(frames []Frame, r DataRate) []error {
+	if r == 0 {
+		r = h.lowestRate()
+	}
+
+	h.linuxInterfaceLock.Lock()
+	ifindex := -1
+	if h.linuxInterface != nil {
+		ifindex = h.linuxInterface.Index()
+	}
+	h.linuxInterfaceLock.Unlock()
+
+	h.sendLock.Lock()
+	defer h.sendLock.Unlock()
+
+	h.packetSocketLock.RLock()
+	defer h.packetSocketLock.RUnlock()
+
+	if h.packetSocket == nil || ifindex < 0 {
+		errs := make([]error, len(frames))
+		for i := range errs {
+			errs[i] = ErrClosed
+		}
+		return errs
+	}
+
+	if h.txRing == nil {
+		if ring, err := newPacketTxRing(ifindex); err == nil {
+			h.txRing = ring
+		}
+	}
+
+	if h.txRing == nil {
+		var errs []error
+		for i, f := range frames {
+			if err := h.packetSocket.Send(f, r); err != nil {
+				if errs == nil {
+					errs = make([]error, len(frames))
+				}
+				errs[i] = err
+			}
+		}
+		return errs
+	}
+
+	packets := make([][]byte, len(frames))
+	for i, f := range frames {
+		packets[i] = encodeRadiotapPacket(f, r)
+	}
+	return h.txRing.SendBatch(packets)
+}
+
+func (h *linuxHandle) Info() HandleInfo {
+	info := HandleInfo{
+		DataLinkType: DLTIEEE802_11Radio,
+		ReadTimeout:  packetReadTimeout,
+		TxStatus:     true,
+	}
+
+	h.linuxInterfaceLock.Lock()
+	if h.linuxInterface != nil {
+		info.Interface = h.linuxInterface.Name()
+		info.Mode = h.linuxInterface.Mode()
+		info.Channel = h.linuxInterface.Channel()
+	}
+	h.linuxInterfaceLock.Unlock()
+
+	h.packetSocketLock.RLock()
+	if h.packetSocket != nil {
+		info.ReadBufferSize, _ = h.packetSocket.ReadBufferSize()
+	}
+	h.packetSocketLock.RUnlock()
+
+	return info
+}
+
+func (h *linuxHandle) Close() {
+	h.packetSocketLock.Lock()
+	h.packetSocket.Close()
+	h.packetSocket = nil
+	if h.txRing != nil {
+		h.txRing.Close()
+		h.txRing = nil
+	}
+	h.packetSocketLock.Unlock()
+
+	h.linuxInterfaceLock.Lock()
+	h.linuxInterface.Close()
+	h.linuxInterface = nil
+	h.linuxInterfaceLock.Unlock()
+
+	h.state.Restore()
+}
+
+// lowestRate returns the lowest rate that is valid for the
+// current channel.
+func (h *linuxHandle) lowestRate() DataRate {
+	if rates := h.SupportedRates(); len(rates) > 0 {
+		return rates[0]
+	}
+	return LegacyRates(Band2GHz)[0]
+}
+
+// txEchoCacheSize is the number of echoed frames a txEchoCache holds.
+const txEchoCacheSize = 16
+
+type txEcho struct {
+	key    string
+	report *TxReport
+}
+
+// A txEchoCache remembers the most recent transmit status reports
+// which the driver echoed, so that they can be matched up with
+// the frames that were sent.
+type txEchoCache struct {
+	lock    sync.Mutex
+	entries []txEcho
+	added   chan struct{}
+}
+
+// Add records a transmit status report.
+func (t *txEchoCache) Add(f []byte, r *TxReport) {
+	key, ok := txEchoKey(f)
+	if !ok {
+		return
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if len(t.entries) == txEchoCacheSize {
+		t.entries = t.entries[1:]
+	}
+	t.entries = append(t.entries, txEcho{key, r})
+	if t.added != nil {
+		close(t.added)
+		t.added = nil
+	}
+}
+
+// Find finds and removes the report for a frame, waiting
+// for it to be added if necessary.
+// If no report arrives before the timeout, this returns nil.
+func (t *txEchoCache) Find(f []byte, timeout time.Duration) *TxReport {
+	key, ok := txEchoKey(f)
+	if !ok {
+		return nil
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		t.lock.Lock()
+		for i, entry := range t.entries {
+			if entry.key == key {
+				t.entries = append(t.entries[:i], t.entries[i+1:]...)
+				t.lock.Unlock()
+				return entry.report
+			}
+		}
+		if t.added == nil {
+			t.added = make(chan struct{})
+		}
+		added := t.added
+		t.lock.Unlock()
+
+		select {
+		case <-added:
+		case <-timer.C:
+			return nil
+		}
+	}
+}
+
+// txEchoKey identifies a transmitted frame by its receiver address
+// and sequence control field.
+func txEchoKey(f []byte) (string, bool) {
+	if len(f) < 24 {
+		return "", false
+	}
+	return string(f[4:10]) + string(f[22:24]), true
+}
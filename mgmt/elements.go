@@ -0,0 +1,97 @@
+package mgmt
+
+import "github.com/unixpickle/gofi"
+
+// maxSupportedRates is the number of rates which fit in a Supported
+// Rates element.
+// The rest go in an Extended Supported Rates element.
+const maxSupportedRates = 8
+
+// basicRateFlag marks the basic rates in rate elements.
+const basicRateFlag = 0x80
+
+// SSID returns the SSID in a list of elements.
+// The second result is false if there is no SSID element.
+func SSID(elements []gofi.Element) (string, bool) {
+	e := gofi.FindElement(elements, gofi.ElementSSID)
+	if e == nil {
+		return "", false
+	}
+	return string(e.Data), true
+}
+
+// SSIDElement creates an SSID element.
+func SSIDElement(ssid string) gofi.Element {
+	return gofi.Element{ID: gofi.ElementSSID, Data: []byte(ssid)}
+}
+
+// RateElements creates a Supported Rates element and, if there are
+// more than eight rates, an Extended Supported Rates element.
+// Rates which are also listed in basic are marked as basic rates.
+func RateElements(rates, basic []gofi.DataRate) []gofi.Element {
+	var data []byte
+	for _, r := range rates {
+		b := byte(r)
+		for _, basicRate := range basic {
+			if basicRate == r {
+				b |= basicRateFlag
+			}
+		}
+		data = append(data, b)
+	}
+	res := []gofi.Element{{ID: gofi.ElementSupportedRates, Data: data}}
+	if len(data) > maxSupportedRates {
+		res[0].Data = data[:maxSupportedRates]
+		res = append(res, gofi.Element{
+			ID:   gofi.ElementExtendedRates,
+			Data: data[maxSupportedRates:],
+		})
+	}
+	return res
+}
+
+// Rates returns the rates in the Supported Rates and Extended
+// Supported Rates elements of a list, and the subset of them which
+// are basic rates.
+//
+// Values which mark BSS membership selectors rather than rates, such
+// as the HT PHY selector, are skipped.
+func Rates(elements []gofi.Element) (rates, basic []gofi.DataRate) {
+	for _, e := range elements {
+		if e.ID != gofi.ElementSupportedRates && e.ID != gofi.ElementExtendedRates {
+			continue
+		}
+		for _, b := range e.Data {
+			r := gofi.DataRate(b &^ basicRateFlag)
+			if isMembershipSelector(r) {
+				continue
+			}
+			rates = append(rates, r)
+			if b&basicRateFlag != 0 {
+				basic = append(basic, r)
+			}
+		}
+	}
+	return
+}
+
+// DSElement creates a DS Parameter Set element for a channel.
+func DSElement(c gofi.Channel) gofi.Element {
+	return gofi.Element{ID: gofi.ElementDSParameterSet, Data: []byte{byte(c.Number)}}
+}
+
+// DSChannel returns the channel number in a DS Parameter Set element,
+// or 0 if there is no such element.
+func DSChannel(elements []gofi.Element) int {
+	e := gofi.FindElement(elements, gofi.ElementDSParameterSet)
+	if e == nil || len(e.Data) < 1 {
+		return 0
+	}
+	return int(e.Data[0])
+}
+
+// isMembershipSelector checks for BSS membership selectors, such as
+// the HT PHY and SAE hash-to-element selectors.
+func isMembershipSelector(r gofi.DataRate) bool {
+	return r >= 121 && r <= 127
+}
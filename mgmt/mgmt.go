@@ -0,0 +1,280 @@
+// Package mgmt encodes and decodes the bodies of the management frames
+// which stations and APs exchange to join a BSS, such as beacons and
+// authentication and association frames.
+package mgmt
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/unixpickle/gofi"
+)
+
+var (
+	ErrTruncated     = errors.New("management frame body is truncated")
+	ErrWrongSubtype  = errors.New("unexpected management frame subtype")
+	ErrNotManagement = errors.New("frame is not an unprotected management frame")
+)
+
+// These are the bits of the capability information field.
+const (
+	CapESS           = 0x0001
+	CapIBSS          = 0x0002
+	CapPrivacy       = 0x0010
+	CapShortPreamble = 0x0020
+	CapShortSlotTime = 0x0400
+)
+
+// These are some status codes of authentication and association
+// responses.
+const (
+	StatusSuccess                 = 0
+	StatusUnspecified             = 1
+	StatusUnsupportedCapabilities = 10
+	StatusUnsupportedAuthAlg      = 13
+	StatusAuthSequence            = 14
+	StatusChallengeFailure        = 15
+	StatusTooManyStations         = 17
+	StatusUnsupportedRates        = 18
+	StatusAntiCloggingToken       = 76
+	StatusUnsupportedGroup        = 77
+	StatusSAEHashToElement        = 126
+)
+
+// These are some reason codes of deauthentication and disassociation
+// frames.
+const (
+	ReasonUnspecified       = 1
+	ReasonPrevAuthInvalid   = 2
+	ReasonDeauthLeaving     = 3
+	ReasonInactivity        = 4
+	ReasonAPFull            = 5
+	ReasonClass2NonAuth     = 6
+	ReasonClass3NonAssoc    = 7
+	ReasonDisassocLeaving   = 8
+	ReasonNotAuthenticated  = 9
+	ReasonInvalidIE         = 13
+	ReasonUnsupportedRateIE = 51
+)
+
+// These are the authentication algorithms.
+const (
+	AuthOpen   = 0
+	AuthShared = 1
+	AuthFT     = 2
+	AuthSAE    = 3
+)
+
+// TimeUnit is the 1024 microsecond unit of beacon intervals and
+// listen intervals.
+const TimeUnit = time.Microsecond * 1024
+
+// aidBits are set in the AID field of association responses.
+const aidBits = 0xc000
+
+// Header creates the header of a management frame.
+// The duration and sequence control fields are zero.
+func Header(subtype int, dst, src, bssid net.HardwareAddr) []byte {
+	header := []byte{byte(gofi.FrameTypeManagement)<<2 | byte(subtype)<<4, 0, 0, 0}
+	header = append(header, dst...)
+	header = append(header, src...)
+	header = append(header, bssid...)
+	return append(header, 0, 0)
+}
+
+// NewFrame creates a management frame with a body.
+func NewFrame(subtype int, dst, src, bssid net.HardwareAddr, body []byte) gofi.Frame {
+	return gofi.NewFrame(Header(subtype, dst, src, bssid), body)
+}
+
+// Body returns the body of an unprotected management frame with one
+// of the given subtypes.
+func Body(f gofi.Frame, subtypes ...int) ([]byte, error) {
+	if f.Type() != gofi.FrameTypeManagement || f.Protected() {
+		return nil, ErrNotManagement
+	}
+	for _, subtype := range subtypes {
+		if f.Subtype() == subtype {
+			body := f.Body()
+			if body == nil {
+				return nil, ErrTruncated
+			}
+			return body, nil
+		}
+	}
+	return nil, ErrWrongSubtype
+}
+
+// A Beacon is the body of a beacon or probe response.
+type Beacon struct {
+	Timestamp  uint64
+	Interval   int
+	Capability uint16
+	Elements   []gofi.Element
+}
+
+// ParseBeacon decodes the body of a beacon or probe response.
+func ParseBeacon(body []byte) (*Beacon, error) {
+	if len(body) < 12 {
+		return nil, ErrTruncated
+	}
+	elements, err := gofi.ParseElements(body[12:])
+	if err != nil {
+		return nil, err
+	}
+	return &Beacon{
+		Timestamp:  binary.LittleEndian.Uint64(body),
+		Interval:   int(binary.LittleEndian.Uint16(body[8:])),
+		Capability: binary.LittleEndian.Uint16(body[10:]),
+		Elements:   elements,
+	}, nil
+}
+
+// Encode encodes the body.
+func (b *Beacon) Encode() []byte {
+	res := make([]byte, 12)
+	binary.LittleEndian.PutUint64(res, b.Timestamp)
+	binary.LittleEndian.PutUint16(res[8:], uint16(b.Interval))
+	binary.LittleEndian.PutUint16(res[10:], b.Capability)
+	return append(res, gofi.EncodeElements(b.Elements)...)
+}
+
+// An Auth is the body of an authentication frame.
+type Auth struct {
+	Algorithm uint16
+	Sequence  uint16
+	Status    uint16
+
+	// Data is the rest of the body, which contains elements for most
+	// algorithms, or the fields of an SAE commit or confirm.
+	Data []byte
+}
+
+// ParseAuth decodes the body of an authentication frame.
+func ParseAuth(body []byte) (*Auth, error) {
+	if len(body) < 6 {
+		return nil, ErrTruncated
+	}
+	return &Auth{
+		Algorithm: binary.LittleEndian.Uint16(body),
+		Sequence:  binary.LittleEndian.Uint16(body[2:]),
+		Status:    binary.LittleEndian.Uint16(body[4:]),
+		Data:      body[6:],
+	}, nil
+}
+
+// Encode encodes the body.
+func (a *Auth) Encode() []byte {
+	res := make([]byte, 6, 6+len(a.Data))
+	binary.LittleEndian.PutUint16(res, a.Algorithm)
+	binary.LittleEndian.PutUint16(res[2:], a.Sequence)
+	binary.LittleEndian.PutUint16(res[4:], a.Status)
+	return append(res, a.Data...)
+}
+
+// An AssocRequest is the body of an association or reassociation
+// request.
+type AssocRequest struct {
+	Capability     uint16
+	ListenInterval int
+
+	// CurrentAP is the BSSID of the AP which a station is
+	// reassociating from, and is nil for association requests.
+	CurrentAP net.HardwareAddr
+
+	Elements []gofi.Element
+}
+
+// ParseAssocRequest decodes the body of an association request, or of
+// a reassociation request if reassoc is true.
+func ParseAssocRequest(body []byte, reassoc bool) (*AssocRequest, error) {
+	size := 4
+	if reassoc {
+		size += 6
+	}
+	if len(body) < size {
+		return nil, ErrTruncated
+	}
+	elements, err := gofi.ParseElements(body[size:])
+	if err != nil {
+		return nil, err
+	}
+	res := &AssocRequest{
+		Capability:     binary.LittleEndian.Uint16(body),
+		ListenInterval: int(binary.LittleEndian.Uint16(body[2:])),
+		Elements:       elements,
+	}
+	if reassoc {
+		res.CurrentAP = net.HardwareAddr(body[4:10])
+	}
+	return res, nil
+}
+
+// Encode encodes the body.
+// If CurrentAP is set, the body is for a reassociation request.
+func (a *AssocRequest) Encode() []byte {
+	res := make([]byte, 4, 10)
+	binary.LittleEndian.PutUint16(res, a.Capability)
+	binary.LittleEndian.PutUint16(res[2:], uint16(a.ListenInterval))
+	res = append(res, a.CurrentAP...)
+	return append(res, gofi.EncodeElements(a.Elements)...)
+}
+
+// An AssocResponse is the body of an association or reassociation
+// response.
+type AssocResponse struct {
+	Capability uint16
+	Status     uint16
+
+	// AID is the association ID, without the two bits which are set
+	// in the encoded field.
+	AID int
+
+	Elements []gofi.Element
+}
+
+// ParseAssocResponse decodes the body of an association or
+// reassociation response.
+func ParseAssocResponse(body []byte) (*AssocResponse, error) {
+	if len(body) < 6 {
+		return nil, ErrTruncated
+	}
+	elements, err := gofi.ParseElements(body[6:])
+	if err != nil {
+		return nil, err
+	}
+	return &AssocResponse{
+		Capability: binary.LittleEndian.Uint16(body),
+		Status:     binary.LittleEndian.Uint16(body[2:]),
+		AID:        int(binary.LittleEndian.Uint16(body[4:]) &^ aidBits),
+		Elements:   elements,
+	}, nil
+}
+
+// Encode encodes the body.
+func (a *AssocResponse) Encode() []byte {
+	res := make([]byte, 6)
+	binary.LittleEndian.PutUint16(res, a.Capability)
+	binary.LittleEndian.PutUint16(res[2:], a.Status)
+	if a.AID != 0 {
+		binary.LittleEndian.PutUint16(res[4:], uint16(a.AID)|aidBits)
+	}
+	return append(res, gofi.EncodeElements(a.Elements)...)
+}
+
+// ParseReason decodes the reason code in the body of a
+// deauthentication or disassociation frame.
+func ParseReason(body []byte) (int, error) {
+	if len(body) < 2 {
+		return 0, ErrTruncated
+	}
+	return int(binary.LittleEndian.Uint16(body)), nil
+}
+
+// EncodeReason encodes the body of a deauthentication or
+// disassociation frame.
+func EncodeReason(reason int) []byte {
+	return []byte{byte(reason), byte(reason >> 8)}
+}
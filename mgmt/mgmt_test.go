@@ -0,0 +1,93 @@
+package mgmt
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/unixpickle/gofi"
+)
+
+var (
+	testAddr1 = net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	testAddr2 = net.HardwareAddr{0x02, 0, 0, 0, 0, 2}
+)
+
+func TestBodies(t *testing.T) {
+	elements := []gofi.Element{SSIDElement("test"), DSElement(gofi.Channel{Number: 11})}
+
+	beacon := &Beacon{Timestamp: 1234567, Interval: 100, Capability: CapESS, Elements: elements}
+	frame := NewFrame(gofi.SubtypeBeacon, testAddr1, testAddr2, testAddr2, beacon.Encode())
+	body, err := Body(frame, gofi.SubtypeProbeResponse, gofi.SubtypeBeacon)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual, err := ParseBeacon(body); err != nil || !reflect.DeepEqual(actual, beacon) {
+		t.Errorf("unexpected beacon %+v (error %v)", actual, err)
+	}
+	if _, err := Body(frame, gofi.SubtypeAuth); err != ErrWrongSubtype {
+		t.Error("unexpected error:", err)
+	}
+	if frame.Addr1().String() != testAddr1.String() || frame.Addr3().String() != testAddr2.String() {
+		t.Error("unexpected addresses")
+	}
+
+	auth := &Auth{Algorithm: AuthSAE, Sequence: 1, Status: StatusSAEHashToElement, Data: []byte{1, 2}}
+	if actual, err := ParseAuth(auth.Encode()); err != nil || !reflect.DeepEqual(actual, auth) {
+		t.Errorf("unexpected auth %+v (error %v)", actual, err)
+	}
+
+	for _, currentAP := range []net.HardwareAddr{nil, testAddr2} {
+		req := &AssocRequest{
+			Capability:     CapESS | CapShortSlotTime,
+			ListenInterval: 10,
+			CurrentAP:      currentAP,
+			Elements:       elements,
+		}
+		actual, err := ParseAssocRequest(req.Encode(), currentAP != nil)
+		if err != nil || !reflect.DeepEqual(actual, req) {
+			t.Errorf("unexpected request %+v (error %v)", actual, err)
+		}
+	}
+
+	resp := &AssocResponse{Capability: CapESS, AID: 2007, Elements: elements}
+	encoded := resp.Encode()
+	if encoded[5] != 0xc7 {
+		t.Errorf("unexpected AID field: %x", encoded[4:6])
+	}
+	if actual, err := ParseAssocResponse(encoded); err != nil || !reflect.DeepEqual(actual, resp) {
+		t.Errorf("unexpected response %+v (error %v)", actual, err)
+	}
+
+	if reason, err := ParseReason(EncodeReason(ReasonInactivity)); err != nil ||
+		reason != ReasonInactivity {
+		t.Errorf("unexpected reason %d (error %v)", reason, err)
+	}
+	if _, err := ParseReason([]byte{1}); err != ErrTruncated {
+		t.Error("unexpected error:", err)
+	}
+}
+
+func TestRates(t *testing.T) {
+	rates := gofi.LegacyRates(gofi.Band2GHz)
+	elements := RateElements(rates, []gofi.DataRate{2, 4, 11, 22})
+	if len(elements) != 2 || len(elements[0].Data) != 8 || elements[0].Data[0] != 0x82 ||
+		elements[0].Data[3] != 0x0c {
+		t.Fatalf("unexpected elements: %v", elements)
+	}
+
+	// The HT PHY membership selector is not a rate.
+	elements[1].Data = append(elements[1].Data, 0x80|127)
+	actual, basic := Rates(elements)
+	if !reflect.DeepEqual(actual, rates) ||
+		!reflect.DeepEqual(basic, []gofi.DataRate{2, 4, 11, 22}) {
+		t.Errorf("unexpected rates %v and basic rates %v", actual, basic)
+	}
+
+	if ssid, ok := SSID(elements); ok || ssid != "" {
+		t.Error("found SSID in rate elements")
+	}
+	if DSChannel(elements) != 0 {
+		t.Error("found channel in rate elements")
+	}
+}
@@ -0,0 +1,365 @@
+// +build linux
+
+package gofi
+
+import (
+	"errors"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// MonitorFlags configure which frames a Linux monitor interface
+// captures and how it behaves.
+type MonitorFlags int
+
+const (
+	// MonitorFCSFail captures frames with bad checksums.
+	MonitorFCSFail MonitorFlags = 1 << iota
+
+	// MonitorPLCPFail captures frames with bad PLCP headers.
+	MonitorPLCPFail
+
+	// MonitorControl captures control frames.
+	MonitorControl
+
+	// MonitorOtherBSS captures frames from other networks.
+	MonitorOtherBSS
+
+	// MonitorCookFrames reports frames which have already been
+	// processed by the kernel, rather than raw frames.
+	MonitorCookFrames
+
+	// MonitorActive makes the interface acknowledge unicast frames
+	// addressed to its MAC address.
+	// This requires support from the driver.
+	MonitorActive
+)
+
+// nl80211Flags converts the flags into NL80211_MNTR_FLAG attributes.
+func (m MonitorFlags) nl80211Flags() []byte {
+	var flags [][]byte
+	for i, attr := range []uint16{
+		unix.NL80211_MNTR_FLAG_FCSFAIL,
+		unix.NL80211_MNTR_FLAG_PLCPFAIL,
+		unix.NL80211_MNTR_FLAG_CONTROL,
+		unix.NL80211_MNTR_FLAG_OTHER_BSS,
+		unix.NL80211_MNTR_FLAG_COOK_FRAMES,
+		unix.NL80211_MNTR_FLAG_ACTIVE,
+	} {
+		if (m & (1 << uint(i))) != 0 {
+			flags = append(flags, netlinkFlagAttr(attr))
+		}
+	}
+	return netlinkNestedAttr(unix.NL80211_ATTR_MNTR_FLAGS, flags...)
+}
+
+// LinuxOptions configure how NewLinuxHandle prepares a device.
+type LinuxOptions struct {
+	// CreateMonitor creates a dedicated monitor interface on the same
+	// physical device, rather than switching the given interface
+	// into monitor mode.
+	// The interface is deleted when the Handle is closed.
+	CreateMonitor bool
+
+	// MonitorName is the name of the created monitor interface.
+	// If it is empty, "mon" is appended to the original name.
+	MonitorName string
+
+	// MonitorFlags are the flags used for the monitor interface.
+	// If this is 0, the kernel's defaults are used.
+	MonitorFlags MonitorFlags
+
+	// RestoreOnSignal restores the device if the process receives
+	// SIGINT, SIGTERM, or SIGHUP, and then lets the signal terminate
+	// the process.
+	// Programs which handle these signals themselves should leave this
+	// false and close the Handle instead.
+	RestoreOnSignal bool
+
+	// RxRing, if non-nil, makes the Handle receive packets through a
+	// memory-mapped ring rather than one system call per packet.
+	RxRing *RxRingOptions
+}
+
+// NewLinuxHandle creates a handle for the given interface, changing
+// the device's configuration as specified by the options.
+// If opts is nil, the interface is switched into monitor mode as by
+// NewHandle.
+//
+// The interface's original type, channel, and up/down state are
+// restored when the Handle is closed.
+func NewLinuxHandle(interfaceName string, opts *LinuxOptions) (Handle, error) {
+	if opts == nil {
+		opts = &LinuxOptions{}
+	}
+
+	state, err := saveInterfaceState(interfaceName)
+	if err != nil {
+		return nil, err
+	}
+
+	inter, err := prepareMonitorInterface(state, opts)
+	if err != nil {
+		state.Restore()
+		return nil, err
+	}
+
+	socket, err := newPacketSocket(inter.Index())
+	if err != nil {
+		inter.Close()
+		state.Restore()
+		return nil, err
+	}
+
+	if err := setupPacketSocket(socket, inter.Name()); err != nil {
+		socket.Close()
+		inter.Close()
+		state.Restore()
+		return nil, err
+	}
+
+	if opts.RxRing != nil {
+		if err := socket.EnableRxRing(opts.RxRing); err != nil {
+			socket.Close()
+			inter.Close()
+			state.Restore()
+			return nil, err
+		}
+	}
+
+	if opts.RestoreOnSignal {
+		registerSignalRestore(state)
+	}
+
+	return &linuxHandle{linuxInterface: inter, packetSocket: socket, state: state}, nil
+}
+
+func prepareMonitorInterface(state *interfaceState, opts *LinuxOptions) (*linuxInterface,
+	error) {
+	if (opts.MonitorFlags & MonitorActive) != 0 {
+		wiphy, err := getNL80211Wiphy(state.nl, state.wiphy)
+		if err != nil {
+			return nil, err
+		}
+		if (wiphy.FeatureFlags & unix.NL80211_FEATURE_ACTIVE_MONITOR) == 0 {
+			return nil, errors.New("active monitor mode is not supported")
+		}
+	}
+
+	if !opts.CreateMonitor {
+		inter, err := newLinuxInterface(state.name)
+		if err != nil {
+			return nil, err
+		}
+		if err := inter.EnterMonitorMode(opts.MonitorFlags); err != nil {
+			inter.Close()
+			return nil, err
+		}
+		return inter, nil
+	}
+
+	name := opts.MonitorName
+	if name == "" {
+		name = state.name + "mon"
+		if len(name) >= unix.IFNAMSIZ {
+			name = name[len(name)-unix.IFNAMSIZ+1:]
+		}
+	}
+	attrs := [][]byte{
+		netlinkUint32Attr(unix.NL80211_ATTR_WIPHY, state.wiphy),
+		netlinkStringAttr(unix.NL80211_ATTR_IFNAME, name),
+		netlinkUint32Attr(unix.NL80211_ATTR_IFTYPE, unix.NL80211_IFTYPE_MONITOR),
+	}
+	if opts.MonitorFlags != 0 {
+		attrs = append(attrs, opts.MonitorFlags.nl80211Flags())
+	}
+	if _, err := state.nl.Request(unix.NL80211_CMD_NEW_INTERFACE, 0, attrs...); err != nil {
+		return nil, err
+	}
+	state.created = append(state.created, name)
+
+	inter, err := newLinuxInterface(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := inter.SetUp(true); err != nil {
+		inter.Close()
+		return nil, err
+	}
+	return inter, nil
+}
+
+// An interfaceState is a snapshot of an interface's configuration
+// which can be restored later.
+type interfaceState struct {
+	lock sync.Mutex
+	nl   *genlFamily
+
+	name  string
+	index int
+	wiphy uint32
+
+	iftype  uint32
+	up      bool
+	channel nl80211Interface
+
+	// created lists interfaces which should be deleted when the
+	// state is restored.
+	created []string
+
+	restored bool
+}
+
+// saveInterfaceState takes a snapshot of an interface.
+func saveInterfaceState(name string) (*interfaceState, error) {
+	inter, err := newLinuxInterface(name)
+	if err != nil {
+		return nil, err
+	}
+	defer inter.Close()
+
+	info, err := inter.info()
+	if err != nil {
+		return nil, err
+	}
+	up, err := inter.IsUp()
+	if err != nil {
+		return nil, err
+	}
+	nl, err := newGenlFamily("nl80211")
+	if err != nil {
+		return nil, err
+	}
+	return &interfaceState{
+		nl:      nl,
+		name:    name,
+		index:   inter.Index(),
+		wiphy:   info.Wiphy,
+		iftype:  info.Type,
+		up:      up,
+		channel: *info,
+	}, nil
+}
+
+// Restore deletes any created interfaces and puts the original
+// interface back into its original state.
+// Only the first call has any effect.
+func (s *interfaceState) Restore() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.restored {
+		return nil
+	}
+	s.restored = true
+	defer s.nl.Close()
+	unregisterSignalRestore(s)
+
+	var firstErr error
+	noteErr := func(err error) {
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	for _, name := range s.created {
+		if iface, err := net.InterfaceByName(name); err != nil {
+			noteErr(err)
+		} else if _, err := s.nl.Request(unix.NL80211_CMD_DEL_INTERFACE, 0,
+			netlinkUint32Attr(unix.NL80211_ATTR_IFINDEX, uint32(iface.Index))); err != nil {
+			noteErr(err)
+		}
+	}
+
+	inter := &linuxInterface{nl: s.nl, name: s.name, index: s.index, wiphy: s.wiphy}
+	if info, err := inter.info(); err != nil {
+		noteErr(err)
+		return firstErr
+	} else if info.Type != s.iftype {
+		if err := inter.SetUp(false); err != nil {
+			noteErr(err)
+		}
+		if err := inter.SetType(s.iftype); err != nil {
+			noteErr(err)
+		}
+	}
+
+	// NOTE: only monitor interfaces can be tuned directly. Other types
+	// of interfaces get their channel by associating with a network.
+	if s.iftype == unix.NL80211_IFTYPE_MONITOR && s.channel.Frequency != 0 {
+		if err := inter.setChannelSpec(&s.channel); err != nil {
+			noteErr(err)
+		}
+	}
+
+	if up, err := inter.IsUp(); err != nil {
+		noteErr(err)
+	} else if up != s.up {
+		if err := inter.SetUp(s.up); err != nil {
+			noteErr(err)
+		}
+	}
+
+	return firstErr
+}
+
+var signalRestore struct {
+	lock    sync.Mutex
+	states  map[*interfaceState]bool
+	signals chan os.Signal
+}
+
+func registerSignalRestore(s *interfaceState) {
+	signalRestore.lock.Lock()
+	defer signalRestore.lock.Unlock()
+	if signalRestore.states == nil {
+		signalRestore.states = map[*interfaceState]bool{}
+	}
+	if len(signalRestore.states) == 0 {
+		signalRestore.signals = make(chan os.Signal, 1)
+		signal.Notify(signalRestore.signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+		go restoreOnSignal(signalRestore.signals)
+	}
+	signalRestore.states[s] = true
+}
+
+func unregisterSignalRestore(s *interfaceState) {
+	signalRestore.lock.Lock()
+	defer signalRestore.lock.Unlock()
+	if !signalRestore.states[s] {
+		return
+	}
+	delete(signalRestore.states, s)
+	if len(signalRestore.states) == 0 {
+		signal.Stop(signalRestore.signals)
+		close(signalRestore.signals)
+	}
+}
+
+func restoreOnSignal(signals <-chan os.Signal) {
+	sig, ok := <-signals
+	if !ok {
+		return
+	}
+
+	signalRestore.lock.Lock()
+	var states []*interfaceState
+	for s := range signalRestore.states {
+		states = append(states, s)
+	}
+	signalRestore.lock.Unlock()
+
+	for _, s := range states {
+		s.Restore()
+	}
+
+	// Let the signal do whatever it would have done without us.
+	signal.Reset(sig)
+	if sysSig, ok := sig.(syscall.Signal); ok {
+		syscall.Kill(os.Getpid(), sysSig)
+	}
+}
@@ -0,0 +1,60 @@
+package mpdu
+
+import "github.com/unixpickle/gofi"
+
+// A Handle wraps a gofi.Handle and passes every frame it receives
+// through a Reassembler, and every frame it sends through a Sender.
+//
+// Either the Reassembler or the Sender may be nil, in which case
+// frames pass through unchanged in that direction.
+type Handle struct {
+	gofi.Handle
+
+	Reassembler *Reassembler
+	Sender      *Sender
+
+	// pending holds frames from an A-MSDU which have not been
+	// returned yet.
+	pending     []gofi.Frame
+	pendingInfo *gofi.RadioInfo
+}
+
+// NewHandle creates a Handle which processes received frames with
+// r.
+// To process sent frames as well, set the Sender field.
+func NewHandle(h gofi.Handle, r *Reassembler) *Handle {
+	return &Handle{Handle: h, Reassembler: r}
+}
+
+// Receive receives the next frame which the Reassembler emits.
+//
+// Every frame from an A-MSDU is returned with the radio information
+// of the A-MSDU.
+//
+// Unlike the underlying Handle, this is not safe to call from
+// multiple Goroutines at once.
+func (h *Handle) Receive() (gofi.Frame, *gofi.RadioInfo, error) {
+	for len(h.pending) == 0 {
+		frame, info, err := h.Handle.Receive()
+		if err != nil {
+			return nil, nil, err
+		}
+		if h.Reassembler == nil {
+			return frame, info, nil
+		}
+		h.pending = h.Reassembler.Process(frame)
+		h.pendingInfo = info
+	}
+	frame := h.pending[0]
+	h.pending = h.pending[1:]
+	return frame, h.pendingInfo, nil
+}
+
+// Send sends a frame, assigning it a sequence number and fragmenting
+// it if the Handle has a Sender.
+func (h *Handle) Send(f gofi.Frame, r gofi.DataRate) error {
+	if h.Sender == nil {
+		return h.Handle.Send(f, r)
+	}
+	return h.Sender.Send(h.Handle, f, r)
+}
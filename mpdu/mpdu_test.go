@@ -0,0 +1,244 @@
+package mpdu
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/unixpickle/gofi"
+	"github.com/unixpickle/gofi/ether"
+	"github.com/unixpickle/gofi/sim"
+)
+
+var (
+	testAP      = net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	testStation = net.HardwareAddr{0x02, 0, 0, 0, 0, 2}
+	testHost1   = net.HardwareAddr{0x02, 0, 0, 0, 0, 3}
+	testHost2   = net.HardwareAddr{0x02, 0, 0, 0, 0, 4}
+)
+
+func TestDuplicates(t *testing.T) {
+	r := NewReassembler()
+	first := testFrame(5, 0, false, false, 0, []byte("first"))
+	retry := testFrame(5, 0, false, true, 0, []byte("first"))
+	otherTID := testFrame(5, 0, false, true, 3, []byte("other TID"))
+	second := testFrame(6, 0, false, true, 0, []byte("second"))
+
+	expected := []int{1, 0, 1, 1}
+	for i, f := range []gofi.Frame{first, retry, otherTID, second} {
+		if res := r.Process(f); len(res) != expected[i] {
+			t.Errorf("frame %d: expected %d results but got %d", i, expected[i], len(res))
+		}
+	}
+}
+
+func TestFragments(t *testing.T) {
+	r := NewReassembler()
+	fragments := []gofi.Frame{
+		testFrame(9, 0, true, false, 0, []byte("hello, ")),
+		testFrame(9, 1, true, false, 0, []byte("fragmented ")),
+		testFrame(9, 1, true, true, 0, []byte("fragmented ")),
+		testFrame(9, 2, false, false, 0, []byte("world")),
+	}
+	var results []gofi.Frame
+	for _, f := range fragments {
+		results = append(results, r.Process(f)...)
+	}
+	if len(results) != 1 {
+		t.Fatal("unexpected number of results:", len(results))
+	}
+	res := results[0]
+	if !bytes.Equal(res.Body(), []byte("hello, fragmented world")) {
+		t.Errorf("unexpected body: %q", res.Body())
+	}
+	if !res.ChecksumValid() || res.MoreFragments() || res.FragmentNumber() != 0 ||
+		res.SequenceNumber() != 9 {
+		t.Errorf("unexpected frame: %x", res)
+	}
+
+	// A missing fragment should discard the MSDU.
+	if len(r.Process(testFrame(10, 0, true, false, 0, []byte("a")))) != 0 ||
+		len(r.Process(testFrame(10, 2, false, false, 0, []byte("c")))) != 0 {
+		t.Error("unexpected results for incomplete MSDU")
+	}
+}
+
+func TestFragmentLifetime(t *testing.T) {
+	r := NewReassembler()
+	now := time.Unix(1000, 0)
+	r.now = func() time.Time {
+		return now
+	}
+	r.SetLifetime(time.Second)
+
+	r.Process(testFrame(1, 0, true, false, 0, []byte("a")))
+	now = now.Add(time.Second / 2)
+	if res := r.Process(testFrame(1, 1, false, false, 0, []byte("b"))); len(res) != 1 {
+		t.Error("MSDU expired too early")
+	}
+
+	r.Process(testFrame(2, 0, true, false, 0, []byte("a")))
+	now = now.Add(time.Second * 2)
+	if res := r.Process(testFrame(2, 1, false, false, 0, []byte("b"))); len(res) != 0 {
+		t.Error("MSDU did not expire")
+	}
+}
+
+func TestAMSDU(t *testing.T) {
+	r := NewReassembler()
+	body := ether.EncodeAMSDU([]ether.AMSDUSubframe{
+		{Dst: testHost1, Src: testStation, Data: []byte("odd length")},
+		{Dst: testHost2, Src: testStation, Data: []byte("second")},
+	})
+	f := testFrame(3, 0, false, false, 0x80|2, body)
+	res := r.Process(f)
+	if len(res) != 2 {
+		t.Fatal("unexpected number of results:", len(res))
+	}
+	for i, dst := range []net.HardwareAddr{testHost1, testHost2} {
+		frame := res[i]
+		if ether.IsAMSDU(frame) || frame.TID() != 2 || !frame.ChecksumValid() {
+			t.Errorf("frame %d: unexpected frame: %x", i, frame)
+		}
+		d, s, bssid := ether.Addresses(frame)
+		if !bytes.Equal(d, dst) || !bytes.Equal(s, testStation) || !bytes.Equal(bssid, testAP) {
+			t.Errorf("frame %d: unexpected addresses: %v %v %v", i, d, s, bssid)
+		}
+	}
+	if !bytes.Equal(res[0].Body(), []byte("odd length")) ||
+		!bytes.Equal(res[1].Body(), []byte("second")) {
+		t.Error("unexpected bodies")
+	}
+}
+
+func TestHandle(t *testing.T) {
+	medium := sim.NewMedium()
+	sender := medium.NewHandle(testStation)
+	receiver := medium.NewHandle(testAP)
+	defer sender.Close()
+	defer receiver.Close()
+	h := NewHandle(receiver, NewReassembler())
+
+	body := ether.EncodeAMSDU([]ether.AMSDUSubframe{
+		{Dst: testHost1, Src: testStation, Data: []byte("one")},
+		{Dst: testHost2, Src: testStation, Data: []byte("two")},
+	})
+	frames := []gofi.Frame{
+		testFrame(1, 0, false, false, 0x80, body),
+		testFrame(1, 0, false, true, 0x80, body),
+		testFrame(2, 0, false, false, 0, []byte("three")),
+	}
+	for _, f := range frames {
+		if err := sender.Send(f, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, expected := range []string{"one", "two", "three"} {
+		f, info, err := h.Receive()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info == nil || string(f.Body()) != expected {
+			t.Errorf("expected %q but got %q", expected, f.Body())
+		}
+	}
+}
+
+func TestSender(t *testing.T) {
+	s := NewSender()
+	for i, tid := range []uint16{0, 0, 3, 0} {
+		frames, err := s.Prepare(testFrame(100, 0, false, false, tid, []byte("hi")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []int{0, 1, 0, 2}[i]
+		if len(frames) != 1 || frames[0].SequenceNumber() != expected ||
+			!frames[0].ChecksumValid() {
+			t.Errorf("frame %d: unexpected result: %x", i, frames)
+		}
+	}
+
+	if err := s.SetFragmentThreshold(100); err != ErrBadThreshold {
+		t.Error("unexpected error:", err)
+	}
+	if err := s.SetFragmentThreshold(301); err != nil {
+		t.Fatal(err)
+	}
+	body := make([]byte, 1000)
+	for i := range body {
+		body[i] = byte(i)
+	}
+	frames, err := s.Prepare(testFrame(0, 0, false, false, 3, body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 4 {
+		t.Fatal("unexpected number of fragments:", len(frames))
+	}
+	r := NewReassembler()
+	for i, f := range frames {
+		if f.FragmentNumber() != i || f.SequenceNumber() != 1 || f.MoreFragments() != (i < 3) ||
+			!f.ChecksumValid() || (i < 3 && len(f) != 300) {
+			t.Errorf("fragment %d: unexpected frame: %x", i, f)
+		}
+		results := r.Process(f)
+		if i == 3 {
+			if len(results) != 1 || !bytes.Equal(results[0].Body(), body) {
+				t.Error("unexpected reassembly:", results)
+			}
+		}
+	}
+
+	if _, err := s.Prepare(testFrame(0, 0, false, false, 0, make([]byte, 5000))); err !=
+		ErrTooManyFragments {
+		t.Error("unexpected error:", err)
+	}
+}
+
+func TestHandleSend(t *testing.T) {
+	medium := sim.NewMedium()
+	sender := NewHandle(medium.NewHandle(testStation), nil)
+	receiver := NewHandle(medium.NewHandle(testAP), NewReassembler())
+	defer sender.Close()
+	defer receiver.Close()
+	sender.Sender = NewSender()
+	if err := sender.Sender.SetFragmentThreshold(256); err != nil {
+		t.Fatal(err)
+	}
+
+	payloads := [][]byte{make([]byte, 1000), []byte("short")}
+	for _, p := range payloads {
+		if err := sender.Send(testFrame(0, 0, false, false, 0, p), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, p := range payloads {
+		f, _, err := receiver.Receive()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(f.Body(), p) || f.SequenceNumber() != i {
+			t.Errorf("payload %d: unexpected frame: %x", i, f)
+		}
+	}
+}
+
+// testFrame creates a QoS data frame from testStation to testAP.
+func testFrame(seq, frag int, more, retry bool, qos uint16, body []byte) gofi.Frame {
+	flags := byte(gofi.FlagToDS)
+	if more {
+		flags |= gofi.FlagMoreFragments
+	}
+	if retry {
+		flags |= gofi.FlagRetry
+	}
+	header := []byte{byte(gofi.FrameTypeData)<<2 | gofi.SubtypeQoSData<<4, flags, 0, 0}
+	header = append(header, testAP...)
+	header = append(header, testStation...)
+	header = append(header, testHost1...)
+	seqControl := seq<<4 | frag
+	header = append(header, byte(seqControl), byte(seqControl>>8))
+	header = append(header, byte(qos), byte(qos>>8))
+	return gofi.NewFrame(header, body)
+}
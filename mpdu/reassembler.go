@@ -0,0 +1,211 @@
+// Package mpdu turns the MPDUs which a Handle receives into MSDUs,
+// and MSDUs into MPDUs to send.
+//
+// A Reassembler drops retransmitted duplicates, reassembles
+// fragments, and splits A-MSDUs into one frame per MSDU.
+// A Sender does the reverse, numbering and fragmenting frames.
+package mpdu
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/unixpickle/gofi"
+	"github.com/unixpickle/gofi/ether"
+)
+
+// DefaultLifetime is the default time limit for receiving every
+// fragment of an MSDU, which matches the default
+// dot11MaxReceiveLifetime of 512 TUs.
+const DefaultLifetime = 512 * 1024 * time.Microsecond
+
+// nonQoSTID is the key which non-QoS data frames and management
+// frames use in place of a TID, since they have their own sequence
+// numbers.
+const nonQoSTID = 16
+
+// amsduFlag is the bit of the QoS control field which indicates
+// that a frame's body is an A-MSDU.
+const amsduFlag = 0x80
+
+// A Reassembler converts received MPDUs into frames which each carry
+// one whole MSDU.
+//
+// Frames are tracked per transmitter and TID.
+// A retransmitted frame is dropped if its sequence control field
+// matches the last frame from the same transmitter and TID.
+// Fragments are buffered until the last one arrives, and are
+// discarded if they do not all arrive within the lifetime.
+// A-MSDUs are split into one data frame per subframe, with the
+// subframe's addresses in place of the A-MSDU's.
+//
+// Control frames are passed through unchanged.
+// Protected frames are not reassembled or split, since each fragment
+// is encrypted separately; a Reassembler should come after any
+// decryption.
+//
+// A Reassembler is safe to use from multiple Goroutines.
+type Reassembler struct {
+	lock      sync.Mutex
+	lifetime  time.Duration
+	sequences map[streamKey]uint16
+	partials  map[streamKey]*partialMSDU
+
+	// now is replaced by tests.
+	now func() time.Time
+}
+
+type streamKey struct {
+	transmitter string
+	tid         int
+}
+
+type partialMSDU struct {
+	start        time.Time
+	sequence     int
+	nextFragment int
+	header       []byte
+	body         []byte
+}
+
+// NewReassembler creates a Reassembler with the DefaultLifetime.
+func NewReassembler() *Reassembler {
+	return &Reassembler{
+		lifetime:  DefaultLifetime,
+		sequences: map[streamKey]uint16{},
+		partials:  map[streamKey]*partialMSDU{},
+		now:       time.Now,
+	}
+}
+
+// SetLifetime sets the time limit for receiving every fragment of an
+// MSDU, measured from the arrival of its first fragment.
+func (r *Reassembler) SetLifetime(d time.Duration) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.lifetime = d
+}
+
+// Process handles a received frame and returns the frames which are
+// ready to be passed on, if any.
+func (r *Reassembler) Process(f gofi.Frame) []gofi.Frame {
+	if f.Type() == gofi.FrameTypeControl || len(f) < f.HeaderLen()+4 {
+		return []gofi.Frame{f}
+	}
+	transmitter := f.Addr2()
+	tid := nonQoSTID
+	if _, ok := f.QoSControl(); ok {
+		tid = f.TID()
+	}
+	key := streamKey{transmitter: transmitter.String(), tid: tid}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.expire()
+
+	// NOTE: QoS Null frames do not use the sequence numbers of their
+	// TID, so they are exempt from duplicate detection.
+	if f.Type() != gofi.FrameTypeData || f.HasBody() || !f.IsQoSData() {
+		seq := f.SequenceControl()
+		if last, ok := r.sequences[key]; ok && last == seq && f.Retry() {
+			return nil
+		}
+		r.sequences[key] = seq
+	}
+
+	if f.Protected() {
+		return []gofi.Frame{f}
+	}
+	if f.MoreFragments() || f.FragmentNumber() != 0 {
+		f = r.addFragment(key, f)
+		if f == nil {
+			return nil
+		}
+	}
+	if ether.IsAMSDU(f) {
+		return splitAMSDU(f)
+	}
+	return []gofi.Frame{f}
+}
+
+// addFragment buffers a fragment, returning the reassembled frame
+// if it was the last fragment.
+func (r *Reassembler) addFragment(key streamKey, f gofi.Frame) gofi.Frame {
+	partial := r.partials[key]
+	if f.FragmentNumber() == 0 {
+		header := append([]byte{}, f[:f.HeaderLen()]...)
+		header[1] &^= gofi.FlagMoreFragments | gofi.FlagRetry
+		partial = &partialMSDU{
+			start:    r.now(),
+			sequence: f.SequenceNumber(),
+			header:   header,
+		}
+		r.partials[key] = partial
+	} else if partial == nil || partial.sequence != f.SequenceNumber() ||
+		partial.nextFragment != f.FragmentNumber() {
+		// NOTE: a missing fragment makes the whole MSDU useless.
+		delete(r.partials, key)
+		return nil
+	}
+	partial.body = append(partial.body, f.Body()...)
+	partial.nextFragment++
+	if f.MoreFragments() {
+		return nil
+	}
+	delete(r.partials, key)
+	return gofi.NewFrame(partial.header, partial.body)
+}
+
+// expire discards partial MSDUs which have outlived the lifetime.
+func (r *Reassembler) expire() {
+	now := r.now()
+	for key, partial := range r.partials {
+		if now.Sub(partial.start) > r.lifetime {
+			delete(r.partials, key)
+		}
+	}
+}
+
+// splitAMSDU creates one data frame per A-MSDU subframe, placing
+// each subframe's destination and source where the frame's direction
+// calls for them.
+func splitAMSDU(f gofi.Frame) []gofi.Frame {
+	subframes, err := ether.ParseAMSDU(f.Body())
+	if err != nil {
+		return nil
+	}
+	header := f[:f.HeaderLen()]
+	qosOffset := 24
+	if f.ToDS() && f.FromDS() {
+		qosOffset += 6
+	}
+	var res []gofi.Frame
+	for _, s := range subframes {
+		frame := gofi.NewFrame(header, s.Data)
+		qos := binary.LittleEndian.Uint16(frame[qosOffset:])
+		binary.LittleEndian.PutUint16(frame[qosOffset:], qos&^amsduFlag)
+		setMSDUAddresses(frame, s.Dst, s.Src)
+		frame.SetChecksum()
+		res = append(res, frame)
+	}
+	return res
+}
+
+func setMSDUAddresses(f gofi.Frame, dst, src net.HardwareAddr) {
+	switch ether.FrameDirection(f) {
+	case ether.DirectionToAP:
+		copy(f.Addr3(), dst)
+		copy(f.Addr2(), src)
+	case ether.DirectionFromAP:
+		copy(f.Addr1(), dst)
+		copy(f.Addr3(), src)
+	case ether.DirectionWDS:
+		copy(f.Addr3(), dst)
+		copy(f.Addr4(), src)
+	default:
+		copy(f.Addr1(), dst)
+		copy(f.Addr2(), src)
+	}
+}
@@ -0,0 +1,126 @@
+package mpdu
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+
+	"github.com/unixpickle/gofi"
+)
+
+var (
+	ErrBadThreshold     = errors.New("fragmentation threshold is too small")
+	ErrTooManyFragments = errors.New("frame needs more than 16 fragments")
+)
+
+// MinFragmentThreshold is the smallest fragmentation threshold.
+const MinFragmentThreshold = 256
+
+// maxFragments is the number of distinct fragment numbers.
+const maxFragments = 16
+
+// A Sender assigns sequence numbers to frames before they are sent,
+// and fragments frames which are longer than a threshold.
+//
+// Sequence numbers are counted separately for each TID of QoS data
+// frames, and once more for all other frames.
+// Control frames are left alone.
+//
+// A Sender is safe to use from multiple Goroutines.
+type Sender struct {
+	lock      sync.Mutex
+	threshold int
+	sequences map[int]int
+}
+
+// NewSender creates a Sender which does not fragment frames.
+func NewSender() *Sender {
+	return &Sender{sequences: map[int]int{}}
+}
+
+// SetFragmentThreshold sets the length, including the header and
+// checksum, beyond which frames are fragmented.
+// Odd thresholds are rounded down, since every fragment but the last
+// must have an even length.
+//
+// A threshold of 0 disables fragmentation.
+func (s *Sender) SetFragmentThreshold(n int) error {
+	if n != 0 && n < MinFragmentThreshold {
+		return ErrBadThreshold
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.threshold = n &^ 1
+	return nil
+}
+
+// Prepare assigns the next sequence number to a frame and fragments
+// it if necessary, returning the frames to send in order.
+//
+// Protected frames, group addressed frames, and frames without a
+// body are never fragmented.
+// The frame itself is not modified.
+func (s *Sender) Prepare(f gofi.Frame) ([]gofi.Frame, error) {
+	if f.Type() == gofi.FrameTypeControl || len(f) < f.HeaderLen()+4 {
+		return []gofi.Frame{f}, nil
+	}
+	tid := nonQoSTID
+	if _, ok := f.QoSControl(); ok {
+		tid = f.TID()
+	}
+	headerLen := f.HeaderLen()
+	body := f.Body()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var numFragments int
+	fragmentSize := len(body)
+	if s.threshold != 0 && len(f) > s.threshold && !f.Protected() &&
+		(f.Addr1()[0]&1) == 0 && len(body) > 0 {
+		fragmentSize = s.threshold - (headerLen + 4)
+		numFragments = (len(body) + fragmentSize - 1) / fragmentSize
+		if numFragments > maxFragments {
+			return nil, ErrTooManyFragments
+		}
+	}
+
+	seq := s.sequences[tid]
+	s.sequences[tid] = (seq + 1) % 4096
+
+	if numFragments == 0 {
+		res := append(gofi.Frame{}, f...)
+		binary.LittleEndian.PutUint16(res[22:], uint16(seq<<4))
+		res.SetChecksum()
+		return []gofi.Frame{res}, nil
+	}
+	res := make([]gofi.Frame, 0, numFragments)
+	header := append([]byte{}, f[:headerLen]...)
+	for i := 0; i < numFragments; i++ {
+		chunk := body[i*fragmentSize:]
+		if len(chunk) > fragmentSize {
+			chunk = chunk[:fragmentSize]
+			header[1] |= gofi.FlagMoreFragments
+		} else {
+			header[1] &^= gofi.FlagMoreFragments
+		}
+		binary.LittleEndian.PutUint16(header[22:], uint16(seq<<4|i))
+		res = append(res, gofi.NewFrame(header, chunk))
+	}
+	return res, nil
+}
+
+// Send prepares a frame and sends the resulting frames with h,
+// stopping at the first error.
+func (s *Sender) Send(h gofi.Handle, f gofi.Frame, r gofi.DataRate) error {
+	frames, err := s.Prepare(f)
+	if err != nil {
+		return err
+	}
+	for _, frame := range frames {
+		if err := h.Send(frame, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+// Package mux shares one Handle between several independent users.
+//
+// A Mux reads every frame from its Handle and gives a copy to each
+// Subscriber whose filter accepts it.
+// Every Subscriber is a gofi.Handle in its own right, so a scanner, a
+// logger and an access point can each run on the same radio.
+//
+// Sends and channel changes from all Subscribers are serialized.
+// Since only one channel can be tuned at a time, a Subscriber which
+// sets the channel holds a lease on it, and the other Subscribers may
+// not move the radio until the lease is released or expires.
+package mux
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/unixpickle/gofi"
+)
+
+var (
+	ErrChannelLeased = errors.New("channel is leased by another subscriber")
+)
+
+// Defaults for a Config or SubscriberConfig which leaves these fields
+// unset.
+const (
+	DefaultLeaseDuration = time.Second * 5
+	DefaultBufferSize    = 256
+)
+
+// A Config configures a Mux.
+type Config struct {
+	// LeaseDuration is the time after its latest SetChannel at which
+	// a Subscriber's lease on the channel expires, so that a stalled
+	// Subscriber cannot hold the radio forever.
+	// If it is 0, DefaultLeaseDuration is used.
+	LeaseDuration time.Duration
+}
+
+// A Mux fans the frames from a Handle out to Subscribers.
+type Mux struct {
+	handle gofi.Handle
+	config Config
+
+	lock        sync.Mutex
+	subscribers map[*Subscriber]bool
+	closed      bool
+	err         error
+
+	// leaseHolder is the Subscriber which last set the channel, and
+	// leaseExpiry is the time at which it loses its lease.
+	leaseHolder *Subscriber
+	leaseExpiry time.Time
+
+	// sendLock serializes sends and channel changes.
+	sendLock sync.Mutex
+}
+
+// New creates a Mux which owns the Handle, and starts receiving
+// frames.
+// Closing the Mux closes the Handle.
+//
+// If c is nil, the defaults are used.
+func New(h gofi.Handle, c *Config) *Mux {
+	res := &Mux{
+		handle:      h,
+		subscribers: map[*Subscriber]bool{},
+	}
+	if c != nil {
+		res.config = *c
+	}
+	if res.config.LeaseDuration == 0 {
+		res.config.LeaseDuration = DefaultLeaseDuration
+	}
+	go res.receiveLoop()
+	return res
+}
+
+// Subscribe creates a Subscriber which receives the frames accepted by
+// its filter from now on.
+//
+// If c is nil, the Subscriber receives every frame.
+func (m *Mux) Subscribe(c *SubscriberConfig) (*Subscriber, error) {
+	res := &Subscriber{mux: m}
+	if c != nil {
+		res.config = *c
+	}
+	if res.config.BufferSize == 0 {
+		res.config.BufferSize = DefaultBufferSize
+	}
+	res.cond = sync.NewCond(&m.lock)
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.closed {
+		return nil, gofi.ErrClosed
+	}
+	m.subscribers[res] = true
+	return res, nil
+}
+
+// Close closes every Subscriber and the Handle.
+func (m *Mux) Close() {
+	m.lock.Lock()
+	if m.closed {
+		m.lock.Unlock()
+		return
+	}
+	m.closed = true
+	for s := range m.subscribers {
+		s.closeLocked()
+	}
+	m.lock.Unlock()
+
+	m.handle.Close()
+}
+
+func (m *Mux) receiveLoop() {
+	for {
+		frame, info, err := m.handle.Receive()
+		m.lock.Lock()
+		if err != nil {
+			m.err = err
+			for s := range m.subscribers {
+				s.cond.Broadcast()
+			}
+			m.lock.Unlock()
+			return
+		}
+		subscribers := make([]*Subscriber, 0, len(m.subscribers))
+		for s := range m.subscribers {
+			subscribers = append(subscribers, s)
+		}
+		m.lock.Unlock()
+
+		// NOTE: filters run without the lock, so that they may be
+		// slow or call back into the Mux.
+		for _, s := range subscribers {
+			if s.config.Filter == nil || s.config.Filter(frame, info) {
+				s.push(frame, info)
+			}
+		}
+	}
+}
+
+// setChannel changes the channel on behalf of a Subscriber, if no
+// other Subscriber holds the lease.
+func (m *Mux) setChannel(s *Subscriber, c gofi.Channel) error {
+	m.sendLock.Lock()
+	defer m.sendLock.Unlock()
+
+	m.lock.Lock()
+	if m.closed {
+		m.lock.Unlock()
+		return gofi.ErrClosed
+	}
+	if m.leaseHolder != nil && m.leaseHolder != s && time.Now().Before(m.leaseExpiry) {
+		m.lock.Unlock()
+		if sameChannel(m.handle.Channel(), c) {
+			return nil
+		}
+		return ErrChannelLeased
+	}
+	m.lock.Unlock()
+
+	if err := m.handle.SetChannel(c); err != nil {
+		return err
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.leaseHolder = s
+	m.leaseExpiry = time.Now().Add(m.config.LeaseDuration)
+	return nil
+}
+
+// releaseChannel gives up a Subscriber's lease, if it holds it.
+// The caller must hold m.lock.
+func (m *Mux) releaseChannel(s *Subscriber) {
+	if m.leaseHolder == s {
+		m.leaseHolder = nil
+	}
+}
+
+func (m *Mux) send(f gofi.Frame, r gofi.DataRate) error {
+	m.sendLock.Lock()
+	defer m.sendLock.Unlock()
+	return m.handle.Send(f, r)
+}
+
+// sameChannel checks if the tuned channel satisfies a request, which
+// may leave the width unspecified.
+func sameChannel(tuned, requested gofi.Channel) bool {
+	return tuned.Number == requested.Number &&
+		(requested.Width == gofi.ChannelWidthUnspecified || requested.Width == tuned.Width)
+}
@@ -0,0 +1,198 @@
+package mux
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/unixpickle/gofi"
+	"github.com/unixpickle/gofi/ether"
+	"github.com/unixpickle/gofi/internal/testutil"
+	"github.com/unixpickle/gofi/mgmt"
+	"github.com/unixpickle/gofi/sim"
+)
+
+var (
+	testAddr1 = net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	testAddr2 = net.HardwareAddr{0x02, 0, 0, 0, 0, 2}
+	testAddr3 = net.HardwareAddr{0x02, 0, 0, 0, 0, 3}
+)
+
+func TestFanOut(t *testing.T) {
+	medium := sim.NewMedium()
+	m := New(medium.NewHandle(testAddr1), nil)
+	defer m.Close()
+	all := testSubscribe(t, m, nil)
+	management := testSubscribe(t, m, &SubscriberConfig{
+		Filter: TypeFilter(gofi.FrameTypeManagement),
+	})
+	fromAddr3 := testSubscribe(t, m, &SubscriberConfig{Filter: AddressFilter(testAddr3)})
+
+	sender := medium.NewHandle(testAddr2)
+	defer sender.Close()
+	data, err := ether.FromEthernet(testutil.Ethernet(testAddr1, testAddr2, []byte{1, 2, 3}), testAddr1,
+		ether.DirectionToAP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender.Send(data, 0)
+	sender.Send(mgmt.NewFrame(gofi.SubtypeDeauth, testAddr1, testAddr2, testAddr1,
+		mgmt.EncodeReason(mgmt.ReasonDeauthLeaving)), 0)
+
+	frame := testReceive(t, all)
+	if frame.Type() != gofi.FrameTypeData {
+		t.Error("unexpected first frame")
+	}
+	deauth := testReceive(t, all)
+	if deauth.Subtype() != gofi.SubtypeDeauth {
+		t.Error("unexpected second frame")
+	}
+	deauth2 := testReceive(t, management)
+	if deauth2.Subtype() != gofi.SubtypeDeauth {
+		t.Error("unexpected management frame")
+	}
+
+	// Subscribers get their own copies.
+	deauth[len(deauth)-1] ^= 1
+	if !deauth2.ChecksumValid() {
+		t.Error("subscribers share frames")
+	}
+	if stats := management.Stats(); stats.Received != 1 || stats.Dropped != 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	if stats := fromAddr3.Stats(); stats.Received != 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	// The sender hears frames which any subscriber sends.
+	if err := fromAddr3.Send(mgmt.NewFrame(gofi.SubtypeDeauth, testAddr2, testAddr1, testAddr1,
+		mgmt.EncodeReason(mgmt.ReasonDeauthLeaving)), 0); err != nil {
+		t.Fatal(err)
+	}
+	received, _, err := sender.Receive()
+	if err != nil || received.Subtype() != gofi.SubtypeDeauth || !received.ChecksumValid() {
+		t.Error("unexpected frame from subscriber:", received, err)
+	}
+}
+
+func TestDrops(t *testing.T) {
+	medium := sim.NewMedium()
+	m := New(medium.NewHandle(testAddr1), nil)
+	defer m.Close()
+	slow := testSubscribe(t, m, &SubscriberConfig{BufferSize: 2})
+
+	sender := medium.NewHandle(testAddr2)
+	defer sender.Close()
+	for i := 0; i < 5; i++ {
+		sender.Send(mgmt.NewFrame(gofi.SubtypeDeauth, testAddr1, testAddr2, testAddr1,
+			mgmt.EncodeReason(i)), 0)
+	}
+	testutil.WaitFor(t, func() bool {
+		return slow.Stats() == SubscriberStats{Received: 2, Dropped: 3}
+	})
+	for i := 0; i < 2; i++ {
+		reason, err := mgmt.ParseReason(testReceive(t, slow).Body())
+		if err != nil || reason != i {
+			t.Errorf("unexpected reason %d (error %v)", reason, err)
+		}
+	}
+}
+
+func TestChannelLease(t *testing.T) {
+	medium := sim.NewMedium()
+	m := New(medium.NewHandle(testAddr1), &Config{LeaseDuration: time.Millisecond * 100})
+	defer m.Close()
+	sub1 := testSubscribe(t, m, nil)
+	sub2 := testSubscribe(t, m, nil)
+
+	if err := sub1.SetChannel(gofi.Channel{Number: 6}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sub2.SetChannel(gofi.Channel{Number: 11}); err != ErrChannelLeased {
+		t.Error("unexpected error:", err)
+	}
+	if err := sub2.SetChannel(gofi.Channel{Number: 6}); err != nil {
+		t.Error("unexpected error:", err)
+	}
+	sub1.ReleaseChannel()
+	if err := sub2.SetChannel(gofi.Channel{Number: 11}); err != nil {
+		t.Fatal(err)
+	}
+	if sub1.Channel().Number != 11 {
+		t.Error("unexpected channel:", sub1.Channel())
+	}
+
+	// The lease expires without renewal.
+	if err := sub1.SetChannel(gofi.Channel{Number: 1}); err != ErrChannelLeased {
+		t.Error("unexpected error:", err)
+	}
+	time.Sleep(time.Millisecond * 150)
+	if err := sub1.SetChannel(gofi.Channel{Number: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Closing a subscriber releases its lease.
+	sub1.Close()
+	if err := sub2.SetChannel(gofi.Channel{Number: 6}); err != nil {
+		t.Error("unexpected error:", err)
+	}
+	if err := sub1.SetChannel(gofi.Channel{Number: 6}); err != gofi.ErrClosed {
+		t.Error("unexpected error:", err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	medium := sim.NewMedium()
+	m := New(medium.NewHandle(testAddr1), nil)
+	sub1 := testSubscribe(t, m, nil)
+	sub2 := testSubscribe(t, m, nil)
+
+	errs := make(chan error, 2)
+	for _, sub := range []*Subscriber{sub1, sub2} {
+		go func(sub *Subscriber) {
+			_, _, err := sub.Receive()
+			errs <- err
+		}(sub)
+	}
+	sub1.Close()
+	if err := <-errs; err != gofi.ErrClosed {
+		t.Error("unexpected error:", err)
+	}
+	m.Close()
+	if err := <-errs; err != gofi.ErrClosed {
+		t.Error("unexpected error:", err)
+	}
+	if _, err := m.Subscribe(nil); err != gofi.ErrClosed {
+		t.Error("unexpected error:", err)
+	}
+	if err := sub2.Send(mgmt.NewFrame(gofi.SubtypeDeauth, testAddr2, testAddr1, testAddr1,
+		mgmt.EncodeReason(mgmt.ReasonDeauthLeaving)), 0); err != gofi.ErrClosed {
+		t.Error("unexpected error:", err)
+	}
+}
+
+func testSubscribe(t *testing.T, m *Mux, c *SubscriberConfig) *Subscriber {
+	res, err := m.Subscribe(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func testReceive(t *testing.T, s *Subscriber) gofi.Frame {
+	frames := make(chan gofi.Frame, 1)
+	go func() {
+		frame, _, _ := s.Receive()
+		frames <- frame
+	}()
+	select {
+	case frame := <-frames:
+		if frame == nil {
+			t.Fatal("subscriber closed")
+		}
+		return frame
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for frame")
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package mux
+
+import (
+	"bytes"
+	"net"
+	"sync"
+
+	"github.com/unixpickle/gofi"
+)
+
+// A Filter decides if a Subscriber receives a frame.
+//
+// Filters are called from the Mux's receive Goroutine, and should
+// return quickly since every Subscriber waits for them.
+// The frame must not be modified or retained.
+type Filter func(f gofi.Frame, info *gofi.RadioInfo) bool
+
+// TypeFilter accepts frames of the given types.
+func TypeFilter(types ...gofi.FrameType) Filter {
+	return func(f gofi.Frame, info *gofi.RadioInfo) bool {
+		for _, t := range types {
+			if f.Type() == t {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// AddressFilter accepts frames with the address in any of their first
+// three address fields.
+func AddressFilter(addr net.HardwareAddr) Filter {
+	addr = append(net.HardwareAddr{}, addr...)
+	return func(f gofi.Frame, info *gofi.RadioInfo) bool {
+		return bytes.Equal(f.Addr1(), addr) || bytes.Equal(f.Addr2(), addr) ||
+			bytes.Equal(f.Addr3(), addr)
+	}
+}
+
+// A SubscriberConfig configures a Subscriber.
+type SubscriberConfig struct {
+	// Filter decides which frames the Subscriber receives.
+	// If it is nil, every frame is received.
+	Filter Filter
+
+	// BufferSize is the number of frames which are buffered for the
+	// Subscriber before new frames are dropped.
+	// If it is 0, DefaultBufferSize is used.
+	BufferSize int
+}
+
+// SubscriberStats counts the frames which a Subscriber's filter
+// accepted.
+type SubscriberStats struct {
+	// Received is the number of frames which were buffered.
+	Received uint64
+
+	// Dropped is the number of frames which were dropped because the
+	// buffer was full.
+	Dropped uint64
+}
+
+// A Subscriber is a Handle which shares the radio of a Mux.
+//
+// Closing a Subscriber unsubscribes it and releases its lease on the
+// channel, but does not close the Mux.
+type Subscriber struct {
+	mux    *Mux
+	config SubscriberConfig
+
+	// These fields are protected by mux.lock.
+	cond   *sync.Cond
+	frames []gofi.Frame
+	infos  []*gofi.RadioInfo
+	stats  SubscriberStats
+	closed bool
+}
+
+func (s *Subscriber) SupportedRates() []gofi.DataRate {
+	return s.mux.handle.SupportedRates()
+}
+
+func (s *Subscriber) SupportedChannels() []gofi.Channel {
+	return s.mux.handle.SupportedChannels()
+}
+
+func (s *Subscriber) Channel() gofi.Channel {
+	return s.mux.handle.Channel()
+}
+
+// SetChannel tunes the radio and takes the lease on the channel, or
+// renews it.
+//
+// If another Subscriber holds the lease, this fails with
+// ErrChannelLeased, unless the radio is already on the channel.
+func (s *Subscriber) SetChannel(c gofi.Channel) error {
+	s.mux.lock.Lock()
+	closed := s.closed
+	s.mux.lock.Unlock()
+	if closed {
+		return gofi.ErrClosed
+	}
+	return s.mux.setChannel(s, c)
+}
+
+// ReleaseChannel gives up the Subscriber's lease on the channel, if it
+// holds it, so that other Subscribers may change the channel.
+func (s *Subscriber) ReleaseChannel() {
+	s.mux.lock.Lock()
+	defer s.mux.lock.Unlock()
+	s.mux.releaseChannel(s)
+}
+
+// Receive waits for the next frame which the Subscriber's filter
+// accepted.
+//
+// If the Mux's Handle fails, the buffered frames are returned before
+// the Handle's error.
+func (s *Subscriber) Receive() (gofi.Frame, *gofi.RadioInfo, error) {
+	s.mux.lock.Lock()
+	defer s.mux.lock.Unlock()
+	for len(s.frames) == 0 && !s.closed && s.mux.err == nil {
+		s.cond.Wait()
+	}
+	if s.closed {
+		return nil, nil, gofi.ErrClosed
+	}
+	if len(s.frames) == 0 {
+		return nil, nil, s.mux.err
+	}
+	frame, info := s.frames[0], s.infos[0]
+	s.frames[0], s.infos[0] = nil, nil
+	s.frames, s.infos = s.frames[1:], s.infos[1:]
+	return frame, info, nil
+}
+
+// Send sends a frame once no other Subscriber is sending or changing
+// the channel.
+func (s *Subscriber) Send(f gofi.Frame, r gofi.DataRate) error {
+	s.mux.lock.Lock()
+	closed := s.closed
+	s.mux.lock.Unlock()
+	if closed {
+		return gofi.ErrClosed
+	}
+	return s.mux.send(f, r)
+}
+
+func (s *Subscriber) Info() gofi.HandleInfo {
+	return s.mux.handle.Info()
+}
+
+// Stats returns the frame counts of the Subscriber.
+func (s *Subscriber) Stats() SubscriberStats {
+	s.mux.lock.Lock()
+	defer s.mux.lock.Unlock()
+	return s.stats
+}
+
+// Close unsubscribes from the Mux.
+func (s *Subscriber) Close() {
+	s.mux.lock.Lock()
+	defer s.mux.lock.Unlock()
+	s.closeLocked()
+}
+
+// closeLocked unsubscribes and wakes up pending Receive calls.
+// The caller must hold s.mux.lock.
+func (s *Subscriber) closeLocked() {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	s.frames = nil
+	s.infos = nil
+	delete(s.mux.subscribers, s)
+	s.mux.releaseChannel(s)
+	s.cond.Broadcast()
+}
+
+// push buffers a copy of a frame, or counts it as dropped if the
+// buffer is full.
+func (s *Subscriber) push(f gofi.Frame, info *gofi.RadioInfo) {
+	// NOTE: every Subscriber gets its own copy, since users like
+	// mpdu.Reassembler modify the frames they receive.
+	f = append(gofi.Frame{}, f...)
+	if info != nil {
+		infoCopy := *info
+		info = &infoCopy
+	}
+
+	s.mux.lock.Lock()
+	defer s.mux.lock.Unlock()
+	if s.closed {
+		return
+	}
+	if len(s.frames) >= s.config.BufferSize {
+		s.stats.Dropped++
+		return
+	}
+	s.stats.Received++
+	s.frames = append(s.frames, f)
+	s.infos = append(s.infos, info)
+	s.cond.Signal()
+}
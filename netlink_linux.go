@@ -0,0 +1,317 @@
+// +build linux
+
+package gofi
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// netlinkHeaderSize is the size of a struct nlmsghdr.
+const netlinkHeaderSize = 16
+
+// genlHeaderSize is the size of a struct genlmsghdr.
+const genlHeaderSize = 4
+
+// netlinkAttrHeaderSize is the size of a struct nlattr.
+const netlinkAttrHeaderSize = 4
+
+// netlinkNested is set in an attribute type when the attribute
+// contains other attributes.
+const netlinkNested = 0x8000
+
+// A netlinkSocket sends requests to the kernel over a netlink socket
+// and collects the responses.
+type netlinkSocket struct {
+	lock sync.Mutex
+	fd   int
+	seq  uint32
+}
+
+func newNetlinkSocket(protocol int) (*netlinkSocket, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, protocol)
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	return &netlinkSocket{fd: fd}, nil
+}
+
+// Close closes the underlying socket.
+func (n *netlinkSocket) Close() error {
+	return unix.Close(n.fd)
+}
+
+// Request sends a message and returns the payloads of every response.
+// If the kernel reports an error, it is returned as a syscall.Errno.
+//
+// The NLM_F_REQUEST and NLM_F_ACK flags are always added to the
+// given flags.
+func (n *netlinkSocket) Request(msgType uint16, flags uint16, payload []byte) ([][]byte, error) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	n.seq++
+	seq := n.seq
+
+	msg := make([]byte, netlinkHeaderSize+len(payload))
+	binary.LittleEndian.PutUint32(msg, uint32(len(msg)))
+	binary.LittleEndian.PutUint16(msg[4:], msgType)
+	binary.LittleEndian.PutUint16(msg[6:], flags|unix.NLM_F_REQUEST|unix.NLM_F_ACK)
+	binary.LittleEndian.PutUint32(msg[8:], seq)
+	copy(msg[netlinkHeaderSize:], payload)
+
+	if err := unix.Sendto(n.fd, msg, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return nil, err
+	}
+
+	var res [][]byte
+	buf := make([]byte, 0x10000)
+	for {
+		amount, _, err := unix.Recvfrom(n.fd, buf, 0)
+		if err == unix.EINTR {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		data := buf[:amount]
+		for len(data) >= netlinkHeaderSize {
+			length := int(binary.LittleEndian.Uint32(data))
+			if length < netlinkHeaderSize || length > len(data) {
+				return nil, ErrBufferUnderflow
+			}
+			respType := binary.LittleEndian.Uint16(data[4:])
+			respSeq := binary.LittleEndian.Uint32(data[8:])
+			body := data[netlinkHeaderSize:length]
+			if netlinkAlign(length) < len(data) {
+				data = data[netlinkAlign(length):]
+			} else {
+				data = nil
+			}
+
+			if respSeq != seq {
+				continue
+			}
+			switch respType {
+			case unix.NLMSG_DONE:
+				return res, nil
+			case unix.NLMSG_ERROR:
+				if len(body) < 4 {
+					return nil, ErrBufferUnderflow
+				}
+				// NOTE: an error code of 0 acknowledges a request
+				// which had no other response.
+				if code := int32(binary.LittleEndian.Uint32(body)); code != 0 {
+					return nil, syscall.Errno(-code)
+				}
+				return res, nil
+			default:
+				// NOTE: dumps end with NLMSG_DONE, while other requests
+				// are followed by an acknowledgement.
+				res = append(res, append([]byte{}, body...))
+			}
+		}
+	}
+}
+
+// A genlFamily uses a netlinkSocket to talk to a generic
+// netlink family.
+type genlFamily struct {
+	socket *netlinkSocket
+	id     uint16
+}
+
+func newGenlFamily(name string) (*genlFamily, error) {
+	socket, err := newNetlinkSocket(unix.NETLINK_GENERIC)
+	if err != nil {
+		return nil, err
+	}
+	ctrl := &genlFamily{socket: socket, id: unix.GENL_ID_CTRL}
+	resps, err := ctrl.Request(unix.CTRL_CMD_GETFAMILY, 0,
+		netlinkStringAttr(unix.CTRL_ATTR_FAMILY_NAME, name))
+	if err != nil {
+		socket.Close()
+		if err == unix.ENOENT {
+			return nil, errors.New("netlink family not found: " + name)
+		}
+		return nil, err
+	}
+	for _, resp := range resps {
+		if id, ok := resp.Uint16(unix.CTRL_ATTR_FAMILY_ID); ok {
+			return &genlFamily{socket: socket, id: id}, nil
+		}
+	}
+	socket.Close()
+	return nil, errors.New("netlink family not found: " + name)
+}
+
+// Close closes the underlying socket.
+func (g *genlFamily) Close() error {
+	return g.socket.Close()
+}
+
+// Request runs a command and returns the attributes of every response.
+func (g *genlFamily) Request(cmd uint8, flags uint16, attrs ...[]byte) ([]netlinkAttrs, error) {
+	payload := []byte{cmd, 1, 0, 0}
+	for _, attr := range attrs {
+		payload = append(payload, attr...)
+	}
+	resps, err := g.socket.Request(g.id, flags, payload)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]netlinkAttrs, 0, len(resps))
+	for _, resp := range resps {
+		if len(resp) < genlHeaderSize {
+			return nil, ErrBufferUnderflow
+		}
+		attrs, err := parseNetlinkAttrs(resp[genlHeaderSize:])
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, attrs)
+	}
+	return res, nil
+}
+
+// netlinkAttrs maps attribute types to their payloads.
+type netlinkAttrs map[uint16][]byte
+
+func parseNetlinkAttrs(data []byte) (netlinkAttrs, error) {
+	res := netlinkAttrs{}
+	for len(data) >= netlinkAttrHeaderSize {
+		length := int(binary.LittleEndian.Uint16(data))
+		attrType := binary.LittleEndian.Uint16(data[2:]) &^ netlinkNested
+		if length < netlinkAttrHeaderSize || length > len(data) {
+			return nil, ErrBufferUnderflow
+		}
+		res[attrType] = data[netlinkAttrHeaderSize:length]
+		if netlinkAlign(length) >= len(data) {
+			break
+		}
+		data = data[netlinkAlign(length):]
+	}
+	return res, nil
+}
+
+// parseNetlinkAttrList parses a nested attribute whose children are
+// indexed by position rather than by type.
+func parseNetlinkAttrList(data []byte) ([]netlinkAttrs, error) {
+	var res []netlinkAttrs
+	for len(data) >= netlinkAttrHeaderSize {
+		length := int(binary.LittleEndian.Uint16(data))
+		if length < netlinkAttrHeaderSize || length > len(data) {
+			return nil, ErrBufferUnderflow
+		}
+		attrs, err := parseNetlinkAttrs(data[netlinkAttrHeaderSize:length])
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, attrs)
+		if netlinkAlign(length) >= len(data) {
+			break
+		}
+		data = data[netlinkAlign(length):]
+	}
+	return res, nil
+}
+
+// Nested parses a nested attribute.
+func (n netlinkAttrs) Nested(attrType uint16) (netlinkAttrs, bool) {
+	data, ok := n[attrType]
+	if !ok {
+		return nil, false
+	}
+	res, err := parseNetlinkAttrs(data)
+	return res, err == nil
+}
+
+// List parses a nested attribute whose children are indexed by position.
+func (n netlinkAttrs) List(attrType uint16) ([]netlinkAttrs, bool) {
+	data, ok := n[attrType]
+	if !ok {
+		return nil, false
+	}
+	res, err := parseNetlinkAttrList(data)
+	return res, err == nil
+}
+
+func (n netlinkAttrs) Uint8(attrType uint16) (uint8, bool) {
+	if data, ok := n[attrType]; ok && len(data) >= 1 {
+		return data[0], true
+	}
+	return 0, false
+}
+
+func (n netlinkAttrs) Uint16(attrType uint16) (uint16, bool) {
+	if data, ok := n[attrType]; ok && len(data) >= 2 {
+		return binary.LittleEndian.Uint16(data), true
+	}
+	return 0, false
+}
+
+func (n netlinkAttrs) Uint32(attrType uint16) (uint32, bool) {
+	if data, ok := n[attrType]; ok && len(data) >= 4 {
+		return binary.LittleEndian.Uint32(data), true
+	}
+	return 0, false
+}
+
+func (n netlinkAttrs) String(attrType uint16) (string, bool) {
+	data, ok := n[attrType]
+	if !ok {
+		return "", false
+	}
+	for i, b := range data {
+		if b == 0 {
+			return string(data[:i]), true
+		}
+	}
+	return string(data), true
+}
+
+func (n netlinkAttrs) Flag(attrType uint16) bool {
+	_, ok := n[attrType]
+	return ok
+}
+
+func netlinkAttr(attrType uint16, data []byte) []byte {
+	res := make([]byte, netlinkAlign(netlinkAttrHeaderSize+len(data)))
+	binary.LittleEndian.PutUint16(res, uint16(netlinkAttrHeaderSize+len(data)))
+	binary.LittleEndian.PutUint16(res[2:], attrType)
+	copy(res[netlinkAttrHeaderSize:], data)
+	return res
+}
+
+func netlinkUint32Attr(attrType uint16, value uint32) []byte {
+	data := make([]byte, 4)
+	binary.LittleEndian.PutUint32(data, value)
+	return netlinkAttr(attrType, data)
+}
+
+func netlinkStringAttr(attrType uint16, value string) []byte {
+	return netlinkAttr(attrType, append([]byte(value), 0))
+}
+
+func netlinkFlagAttr(attrType uint16) []byte {
+	return netlinkAttr(attrType, nil)
+}
+
+func netlinkNestedAttr(attrType uint16, children ...[]byte) []byte {
+	var data []byte
+	for _, child := range children {
+		data = append(data, child...)
+	}
+	return netlinkAttr(attrType|netlinkNested, data)
+}
+
+func netlinkAlign(i int) int {
+	return (i + 3) &^ 3
+}
@@ -13,6 +13,11 @@ func DefaultInterfaceName() (string, error) {
 	return defaultOSXInterfaceName()
 }
 
+// Interfaces describes every WiFi device on this machine.
+func Interfaces() ([]InterfaceInfo, error) {
+	return osxInterfaces()
+}
+
 // NewHandle creates a new handle with the given interface name.
 // If the handle cannot be created for any reason (e.g., permissions, no such
 // device, etc.), then this returns an error.
@@ -58,12 +63,16 @@ func setupBpfHandle(handle *bpfHandle, iname string) error {
 	if err := handle.SetHeaderComplete(true); err != nil {
 		return err
 	}
-	if err := handle.SetReadTimeout(time.Second); err != nil {
+	if err := handle.SetReadTimeout(bpfReadTimeout); err != nil {
 		return err
 	}
 	return nil
 }
 
+// bpfReadTimeout is the amount of time that a read blocks
+// before checking if the handle has been closed.
+const bpfReadTimeout = time.Second
+
 type readBufferNode struct {
 	packet RadioPacket
 	next   *readBufferNode
@@ -76,6 +85,14 @@ type osxHandle struct {
 	osxInterfaceLock sync.Mutex
 	osxInterface     *osxInterface
 
+	// rates caches the supported rates of each band, and band is the
+	// band of the current channel if bandKnown is set.
+	// They are protected by osxInterfaceLock, and save two ioctls for
+	// every frame which is sent at the lowest rate.
+	rates     map[Band][]DataRate
+	band      Band
+	bandKnown bool
+
 	receiveLock     sync.Mutex
 	readBufferFirst *readBufferNode
 	readBufferLast  *readBufferNode
@@ -83,11 +100,52 @@ type osxHandle struct {
 	sendLock sync.Mutex
 }
 
+// SupportedRates returns the legacy rates which the card supports
+// in the band of the current channel.
+//
+// NOTE: some cards seem to ignore the requested rate and always
+// transmit at 1 or 2 Mbps.
+//
+// NOTE: the channel is only looked up the first time, and then
+// tracked by SetChannel, so changes made by other programs are missed.
 func (h *osxHandle) SupportedRates() []DataRate {
-	// For now, I cannot figure out a good way to find the supported data rates
-	// on OS X. Either way, I could not figure out how to transmit at any rates besides
-	// 1 and 2 Mbps.
-	return []DataRate{2, 4}
+	h.osxInterfaceLock.Lock()
+	defer h.osxInterfaceLock.Unlock()
+	if h.osxInterface == nil {
+		return []DataRate{}
+	}
+	if !h.bandKnown {
+		h.band = h.osxInterface.Channel().Band()
+		h.bandKnown = true
+	}
+	return append([]DataRate{}, h.bandRatesLocked(h.band)...)
+}
+
+// bandRatesLocked returns the supported rates of a band, looking
+// them up the first time.
+// The caller must hold h.osxInterfaceLock.
+func (h *osxHandle) bandRatesLocked(b Band) []DataRate {
+	if rates, ok := h.rates[b]; ok {
+		return rates
+	}
+	if h.rates == nil {
+		h.rates = map[Band][]DataRate{}
+	}
+	rates := bandRates(h.osxInterface.BandCapabilities(), b)
+	h.rates[b] = rates
+	return rates
+}
+
+// BandCapabilities returns the capabilities of the card for every
+// band it supports.
+func (h *osxHandle) BandCapabilities() []BandCapabilities {
+	h.osxInterfaceLock.Lock()
+	defer h.osxInterfaceLock.Unlock()
+	if h.osxInterface == nil {
+		return []BandCapabilities{}
+	} else {
+		return h.osxInterface.BandCapabilities()
+	}
 }
 
 func (h *osxHandle) SupportedChannels() []Channel {
@@ -115,9 +173,13 @@ func (h *osxHandle) SetChannel(ch Channel) error {
 	defer h.osxInterfaceLock.Unlock()
 	if h.osxInterface == nil {
 		return ErrClosed
-	} else {
-		return h.osxInterface.SetChannel(ch)
 	}
+	if err := h.osxInterface.SetChannel(ch); err != nil {
+		return err
+	}
+	h.band = ch.Band()
+	h.bandKnown = true
+	return nil
 }
 
 func (h *osxHandle) Receive() (Frame, *RadioInfo, error) {
@@ -140,7 +202,11 @@ func (h *osxHandle) Receive() (Frame, *RadioInfo, error) {
 
 func (h *osxHandle) Send(f Frame, r DataRate) error {
 	if r == 0 {
-		r = 2
+		if rates := h.SupportedRates(); len(rates) > 0 {
+			r = rates[0]
+		} else {
+			r = 2
+		}
 	}
 
 	h.sendLock.Lock()
@@ -156,6 +222,27 @@ func (h *osxHandle) Send(f Frame, r DataRate) error {
 	}
 }
 
+func (h *osxHandle) Info() HandleInfo {
+	info := HandleInfo{ReadTimeout: bpfReadTimeout}
+
+	h.osxInterfaceLock.Lock()
+	if h.osxInterface != nil {
+		info.Interface = h.osxInterface.name
+		info.Mode = h.osxInterface.Mode()
+		info.Channel = h.osxInterface.Channel()
+	}
+	h.osxInterfaceLock.Unlock()
+
+	h.bpfHandleLock.RLock()
+	if h.bpfHandle != nil {
+		info.DataLinkType = h.bpfHandle.DataLinkType()
+		info.ReadBufferSize = h.bpfHandle.ReadBufferSize()
+	}
+	h.bpfHandleLock.RUnlock()
+
+	return info
+}
+
 func (h *osxHandle) Close() {
 	h.bpfHandleLock.Lock()
 	h.bpfHandle.Close()
@@ -0,0 +1,82 @@
+package oui
+
+import "encoding/binary"
+
+// TypeAWDL is the type of Apple Wireless Direct Link actions.
+const TypeAWDL = 8
+
+// These are the subtypes of AWDL actions.
+const (
+	AWDLSubtypePeriodicSync     = 0
+	AWDLSubtypeMasterIndication = 3
+)
+
+func registerApple(r *Registry) {
+	// NOTE: Apple does not document its vendor elements, so none are
+	// registered and they decode as *Raw.
+	r.Register(KindAction, Key{OUI: Apple, Type: TypeAWDL}, decodeAWDLAction)
+}
+
+// An AWDLAction is an action frame of Apple Wireless Direct Link,
+// which Apple devices use to synchronize with their peers.
+type AWDLAction struct {
+	Version byte
+	Subtype byte
+
+	// PHYTxTime and TargetTxTime are the times in microseconds when
+	// the frame was sent and when it was meant to be sent.
+	PHYTxTime    uint32
+	TargetTxTime uint32
+
+	TLVs []AWDLTLV
+}
+
+// An AWDLTLV is a type-length-value field of an AWDL action.
+type AWDLTLV struct {
+	Type byte
+	Data []byte
+}
+
+func decodeAWDLAction(k Key, data []byte) (Payload, error) {
+	if len(data) < 11 {
+		return nil, ErrTruncated
+	}
+	res := &AWDLAction{
+		Version:      data[0],
+		Subtype:      data[1],
+		PHYTxTime:    binary.LittleEndian.Uint32(data[3:]),
+		TargetTxTime: binary.LittleEndian.Uint32(data[7:]),
+	}
+	data = data[11:]
+	for len(data) > 0 {
+		if len(data) < 3 {
+			return nil, ErrTruncated
+		}
+		size := int(binary.LittleEndian.Uint16(data[1:]))
+		if len(data) < 3+size {
+			return nil, ErrTruncated
+		}
+		res.TLVs = append(res.TLVs, AWDLTLV{Type: data[0], Data: data[3 : 3+size]})
+		data = data[3+size:]
+	}
+	return res, nil
+}
+
+// Key returns the key of AWDL actions.
+func (a *AWDLAction) Key() Key {
+	return Key{OUI: Apple, Type: TypeAWDL}
+}
+
+// Encode encodes the action.
+func (a *AWDLAction) Encode() []byte {
+	res := make([]byte, 11)
+	res[0] = a.Version
+	res[1] = a.Subtype
+	binary.LittleEndian.PutUint32(res[3:], a.PHYTxTime)
+	binary.LittleEndian.PutUint32(res[7:], a.TargetTxTime)
+	for _, t := range a.TLVs {
+		res = append(res, t.Type, byte(len(t.Data)), byte(len(t.Data)>>8))
+		res = append(res, t.Data...)
+	}
+	return res
+}
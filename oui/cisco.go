@@ -0,0 +1,31 @@
+package oui
+
+// TypeCCXVersion is the type of Cisco's CCX version element.
+const TypeCCXVersion = 3
+
+func registerCisco(r *Registry) {
+	r.Register(KindElement, Key{OUI: Cisco, Type: TypeCCXVersion}, decodeCCXVersion)
+}
+
+// A CCXVersion element advertises the version of the Cisco Compatible
+// Extensions which a BSS supports.
+type CCXVersion struct {
+	Version int
+}
+
+func decodeCCXVersion(k Key, data []byte) (Payload, error) {
+	if len(data) < 1 {
+		return nil, ErrTruncated
+	}
+	return &CCXVersion{Version: int(data[0])}, nil
+}
+
+// Key returns the key of CCX version elements.
+func (c *CCXVersion) Key() Key {
+	return Key{OUI: Cisco, Type: TypeCCXVersion}
+}
+
+// Encode encodes the element.
+func (c *CCXVersion) Encode() []byte {
+	return []byte{byte(c.Version)}
+}
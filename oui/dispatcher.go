@@ -0,0 +1,135 @@
+package oui
+
+import (
+	"sync"
+
+	"github.com/unixpickle/gofi"
+)
+
+// A Handler is called with decoded vendor specific content and the
+// frame which carried it.
+type Handler func(p Payload, f gofi.Frame, info *gofi.RadioInfo)
+
+type handlerKey struct {
+	kind Kind
+	key  Key
+}
+
+type ouiHandlerKey struct {
+	kind Kind
+	oui  OUI
+}
+
+// A Dispatcher decodes the vendor specific elements and action frames
+// in received frames, and passes them to the handlers for their keys.
+//
+// Content which fails to decode is not passed to any handler.
+//
+// A Dispatcher is safe to use from multiple Goroutines.
+type Dispatcher struct {
+	registry *Registry
+
+	lock        sync.RWMutex
+	handlers    map[handlerKey][]Handler
+	ouiHandlers map[ouiHandlerKey][]Handler
+}
+
+// NewDispatcher creates a Dispatcher which decodes with r.
+// If r is nil, DefaultRegistry is used.
+func NewDispatcher(r *Registry) *Dispatcher {
+	if r == nil {
+		r = DefaultRegistry
+	}
+	return &Dispatcher{
+		registry:    r,
+		handlers:    map[handlerKey][]Handler{},
+		ouiHandlers: map[ouiHandlerKey][]Handler{},
+	}
+}
+
+// Handle adds a handler for one kind of content.
+func (d *Dispatcher) Handle(kind Kind, k Key, h Handler) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	hk := handlerKey{kind: kind, key: k}
+	d.handlers[hk] = append(d.handlers[hk], h)
+}
+
+// HandleOUI adds a handler for every type of an OUI.
+func (d *Dispatcher) HandleOUI(kind Kind, o OUI, h Handler) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	hk := ouiHandlerKey{kind: kind, oui: o}
+	d.ouiHandlers[hk] = append(d.ouiHandlers[hk], h)
+}
+
+// Dispatch passes the vendor specific content of a frame to the
+// handlers for it.
+//
+// For an action frame, this is the body of a vendor specific action.
+// For other management frames, these are the vendor specific
+// elements, which are dispatched in order.
+func (d *Dispatcher) Dispatch(f gofi.Frame, info *gofi.RadioInfo) {
+	if data, ok := actionContent(f); ok {
+		d.dispatch(KindAction, data, f, info)
+		return
+	}
+	// NOTE: a truncated element at the end of a frame should not
+	// hide the elements before it, so errors are ignored.
+	elements, _ := f.Elements()
+	for _, e := range elements {
+		if e.ID == gofi.ElementVendorSpecific {
+			d.dispatch(KindElement, e.Data, f, info)
+		}
+	}
+}
+
+func (d *Dispatcher) dispatch(kind Kind, data []byte, f gofi.Frame, info *gofi.RadioInfo) {
+	k, ok := splitKey(data)
+	if !ok {
+		return
+	}
+	handlers := d.handlersFor(kind, k)
+	if len(handlers) == 0 {
+		return
+	}
+	payload, err := d.registry.Decode(kind, data)
+	if err != nil {
+		return
+	}
+	for _, h := range handlers {
+		h(payload, f, info)
+	}
+}
+
+func (d *Dispatcher) handlersFor(kind Kind, k Key) []Handler {
+	d.lock.RLock()
+	defer d.lock.RUnlock()
+	var res []Handler
+	res = append(res, d.handlers[handlerKey{kind: kind, key: k}]...)
+	res = append(res, d.ouiHandlers[ouiHandlerKey{kind: kind, oui: k.OUI}]...)
+	return res
+}
+
+// A Handle wraps a gofi.Handle and dispatches every frame it receives
+// with a Dispatcher.
+type Handle struct {
+	gofi.Handle
+
+	Dispatcher *Dispatcher
+}
+
+// NewHandle creates a Handle which dispatches frames with d.
+func NewHandle(h gofi.Handle, d *Dispatcher) *Handle {
+	return &Handle{Handle: h, Dispatcher: d}
+}
+
+// Receive receives the next frame and dispatches it.
+func (h *Handle) Receive() (gofi.Frame, *gofi.RadioInfo, error) {
+	frame, info, err := h.Handle.Receive()
+	if err != nil {
+		return nil, nil, err
+	}
+	h.Dispatcher.Dispatch(frame, info)
+	return frame, info, nil
+}
@@ -0,0 +1,231 @@
+package oui
+
+import (
+	"encoding/binary"
+
+	"github.com/unixpickle/gofi/crypto"
+)
+
+// These are the types of Microsoft's vendor specific elements.
+const (
+	TypeWPA = 1
+	TypeWMM = 2
+	TypeWPS = 4
+)
+
+func registerMicrosoft(r *Registry) {
+	r.Register(KindElement, Key{OUI: Microsoft, Type: TypeWPA}, decodeWPA)
+	r.Register(KindElement, Key{OUI: Microsoft, Type: TypeWMM}, decodeWMM)
+	r.Register(KindElement, Key{OUI: Microsoft, Type: TypeWPS}, decodeWPS)
+}
+
+// WPA is the pre-standard WPA element.
+type WPA struct {
+	crypto.RSNInfo
+}
+
+func decodeWPA(k Key, data []byte) (Payload, error) {
+	info, err := crypto.ParseWPA(append(append(Microsoft[:], TypeWPA), data...))
+	if err != nil {
+		return nil, err
+	}
+	return &WPA{RSNInfo: *info}, nil
+}
+
+// Key returns the key of WPA elements.
+func (w *WPA) Key() Key {
+	return Key{OUI: Microsoft, Type: TypeWPA}
+}
+
+// Encode encodes the element.
+// Capabilities, PMKIDs, and the group management cipher are omitted.
+func (w *WPA) Encode() []byte {
+	return w.EncodeWPA()[4:]
+}
+
+// These are the subtypes of WMM elements.
+const (
+	WMMSubtypeInformation = 0
+	WMMSubtypeParameter   = 1
+	WMMSubtypeTSPEC       = 2
+)
+
+// A WMM element advertises the QoS settings of a BSS.
+type WMM struct {
+	Subtype byte
+	Version byte
+
+	// QoSInfo is the QoS info field of information and parameter
+	// elements.
+	QoSInfo byte
+
+	// Parameters contains the parameters of each access category,
+	// for parameter elements.
+	Parameters []WMMParameters
+
+	// Data is the body of elements of other subtypes, after the
+	// version.
+	Data []byte
+}
+
+// WMMParameters are the channel access parameters of one access
+// category.
+type WMMParameters struct {
+	// ACI is the access category index: 0 for best effort, 1 for
+	// background, 2 for video, and 3 for voice.
+	ACI int
+
+	AIFSN int
+
+	// ACM is true if admission control is mandatory.
+	ACM bool
+
+	// ECWMin and ECWMax are the exponents of the minimum and maximum
+	// contention windows, which are 2^ECW-1 slots.
+	ECWMin int
+	ECWMax int
+
+	// TXOPLimit is the TXOP limit in units of 32 microseconds.
+	TXOPLimit int
+}
+
+func decodeWMM(k Key, data []byte) (Payload, error) {
+	if len(data) < 2 {
+		return nil, ErrTruncated
+	}
+	res := &WMM{Subtype: data[0], Version: data[1]}
+	data = data[2:]
+	switch res.Subtype {
+	case WMMSubtypeInformation:
+		if len(data) < 1 {
+			return nil, ErrTruncated
+		}
+		res.QoSInfo = data[0]
+	case WMMSubtypeParameter:
+		if len(data) < 2+4*4 {
+			return nil, ErrTruncated
+		}
+		res.QoSInfo = data[0]
+		for i := 0; i < 4; i++ {
+			record := data[2+4*i:]
+			res.Parameters = append(res.Parameters, WMMParameters{
+				ACI:       int(record[0]>>5) & 3,
+				AIFSN:     int(record[0] & 0xf),
+				ACM:       record[0]&0x10 != 0,
+				ECWMin:    int(record[1] & 0xf),
+				ECWMax:    int(record[1] >> 4),
+				TXOPLimit: int(binary.LittleEndian.Uint16(record[2:])),
+			})
+		}
+	default:
+		res.Data = data
+	}
+	return res, nil
+}
+
+// Key returns the key of WMM elements.
+func (w *WMM) Key() Key {
+	return Key{OUI: Microsoft, Type: TypeWMM}
+}
+
+// Encode encodes the element.
+func (w *WMM) Encode() []byte {
+	res := []byte{w.Subtype, w.Version}
+	switch w.Subtype {
+	case WMMSubtypeInformation:
+		res = append(res, w.QoSInfo)
+	case WMMSubtypeParameter:
+		res = append(res, w.QoSInfo, 0)
+		for _, p := range w.Parameters {
+			aci := byte(p.ACI&3)<<5 | byte(p.AIFSN&0xf)
+			if p.ACM {
+				aci |= 0x10
+			}
+			res = append(res, aci, byte(p.ECWMax&0xf)<<4|byte(p.ECWMin&0xf),
+				byte(p.TXOPLimit), byte(p.TXOPLimit>>8))
+		}
+	default:
+		res = append(res, w.Data...)
+	}
+	return res
+}
+
+// These are the types of some WPS attributes.
+const (
+	WPSAttrConfigMethods     = 0x1008
+	WPSAttrDeviceName        = 0x1011
+	WPSAttrDevicePasswordID  = 0x1012
+	WPSAttrManufacturer      = 0x1021
+	WPSAttrModelName         = 0x1023
+	WPSAttrModelNumber       = 0x1024
+	WPSAttrResponseType      = 0x103b
+	WPSAttrRFBands           = 0x103c
+	WPSAttrSelectedRegistrar = 0x1041
+	WPSAttrSerialNumber      = 0x1042
+	WPSAttrState             = 0x1044
+	WPSAttrUUIDE             = 0x1047
+	WPSAttrVendorExtension   = 0x1049
+	WPSAttrVersion           = 0x104a
+	WPSAttrPrimaryDeviceType = 0x1054
+	WPSAttrAPSetupLocked     = 0x1057
+)
+
+// A WPS element advertises Wi-Fi Protected Setup.
+//
+// Long WPS elements are split across several vendor specific
+// elements, in which case each of them decodes as a WPS element with
+// some of the attributes.
+type WPS struct {
+	Attributes []WPSAttribute
+}
+
+// A WPSAttribute is a type-length-value attribute of a WPS element.
+type WPSAttribute struct {
+	Type uint16
+	Data []byte
+}
+
+func decodeWPS(k Key, data []byte) (Payload, error) {
+	res := &WPS{}
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return nil, ErrTruncated
+		}
+		size := int(binary.BigEndian.Uint16(data[2:]))
+		if len(data) < 4+size {
+			return nil, ErrTruncated
+		}
+		res.Attributes = append(res.Attributes, WPSAttribute{
+			Type: binary.BigEndian.Uint16(data),
+			Data: data[4 : 4+size],
+		})
+		data = data[4+size:]
+	}
+	return res, nil
+}
+
+// Key returns the key of WPS elements.
+func (w *WPS) Key() Key {
+	return Key{OUI: Microsoft, Type: TypeWPS}
+}
+
+// Encode encodes the element.
+func (w *WPS) Encode() []byte {
+	var res []byte
+	for _, a := range w.Attributes {
+		res = append(res, byte(a.Type>>8), byte(a.Type), byte(len(a.Data)>>8), byte(len(a.Data)))
+		res = append(res, a.Data...)
+	}
+	return res
+}
+
+// Attribute returns the data of the first attribute of a type, or
+// nil if there is no such attribute.
+func (w *WPS) Attribute(t uint16) []byte {
+	for _, a := range w.Attributes {
+		if a.Type == t {
+			return a.Data
+		}
+	}
+	return nil
+}
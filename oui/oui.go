@@ -0,0 +1,262 @@
+// Package oui decodes and encodes vendor specific information
+// elements and action frames, which are identified by the OUI of the
+// organization which defined them.
+//
+// Decoders are kept in a Registry, keyed by an OUI and the type byte
+// which follows it, and a Dispatcher routes the vendor specific
+// contents of received frames to handlers.
+// The DefaultRegistry knows about many well-known elements, such as
+// Microsoft's WPA, WMM and WPS elements, and the Wi-Fi Alliance's P2P,
+// Hotspot 2.0 and OWE transition elements.
+package oui
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/unixpickle/gofi"
+)
+
+var (
+	ErrNotVendorSpecific = errors.New("not vendor specific")
+	ErrTruncated         = errors.New("vendor specific data is truncated")
+)
+
+// An OUI is an organizationally unique identifier.
+type OUI [3]byte
+
+// These are the OUIs of some organizations which define vendor
+// specific elements.
+var (
+	Microsoft = OUI{0x00, 0x50, 0xf2}
+	WFA       = OUI{0x50, 0x6f, 0x9a}
+	Apple     = OUI{0x00, 0x17, 0xf2}
+	Cisco     = OUI{0x00, 0x40, 0x96}
+)
+
+// String returns the OUI in the usual notation, such as "00:50:f2".
+func (o OUI) String() string {
+	return fmt.Sprintf("%02x:%02x:%02x", o[0], o[1], o[2])
+}
+
+// A Key identifies a kind of vendor specific content by its OUI and
+// the type byte which follows the OUI.
+type Key struct {
+	OUI  OUI
+	Type byte
+}
+
+// String returns the OUI and type, such as "00:50:f2/1".
+func (k Key) String() string {
+	return fmt.Sprintf("%s/%d", k.OUI, k.Type)
+}
+
+// A Kind is the kind of frame contents which carries vendor specific
+// data.
+type Kind int
+
+const (
+	// KindElement is a vendor specific information element.
+	KindElement Kind = iota
+
+	// KindAction is the body of a vendor specific action frame, or
+	// of a vendor specific public action frame.
+	KindAction
+)
+
+// These are the action categories and public actions which carry
+// vendor specific data.
+const (
+	categoryPublic                  = 4
+	categoryVendorSpecificProtected = 126
+	categoryVendorSpecific          = 127
+
+	publicActionVendorSpecific = 9
+)
+
+// A Payload is decoded vendor specific content.
+type Payload interface {
+	// Key returns the OUI and type of the content.
+	Key() Key
+
+	// Encode encodes the content which follows the OUI and type.
+	Encode() []byte
+}
+
+// A DecodeFunc decodes the content which follows the OUI and type.
+type DecodeFunc func(k Key, data []byte) (Payload, error)
+
+// Raw is vendor specific content which no decoder was registered for.
+type Raw struct {
+	OUI  OUI
+	Type byte
+	Data []byte
+}
+
+// Key returns the OUI and type.
+func (r *Raw) Key() Key {
+	return Key{OUI: r.OUI, Type: r.Type}
+}
+
+// Encode returns the raw data.
+func (r *Raw) Encode() []byte {
+	return r.Data
+}
+
+func decodeRaw(k Key, data []byte) (Payload, error) {
+	return &Raw{OUI: k.OUI, Type: k.Type, Data: data}, nil
+}
+
+// A Registry maps keys to decoders.
+//
+// A Registry is safe to use from multiple Goroutines.
+type Registry struct {
+	lock     sync.RWMutex
+	decoders map[Kind]map[Key]DecodeFunc
+	fallback map[Kind]map[OUI]DecodeFunc
+}
+
+// DefaultRegistry contains the decoders for every built-in Payload.
+var DefaultRegistry = NewRegistry()
+
+func init() {
+	RegisterBuiltins(DefaultRegistry)
+}
+
+// NewRegistry creates an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		decoders: map[Kind]map[Key]DecodeFunc{},
+		fallback: map[Kind]map[OUI]DecodeFunc{},
+	}
+}
+
+// RegisterBuiltins registers the decoders for every built-in Payload.
+func RegisterBuiltins(r *Registry) {
+	registerMicrosoft(r)
+	registerWFA(r)
+	registerApple(r)
+	registerCisco(r)
+}
+
+// Register registers a decoder for one kind of content, replacing
+// any decoder which was registered for the same key before.
+func (r *Registry) Register(kind Kind, k Key, d DecodeFunc) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.decoders[kind] == nil {
+		r.decoders[kind] = map[Key]DecodeFunc{}
+	}
+	r.decoders[kind][k] = d
+}
+
+// RegisterOUI registers a decoder for every type of an OUI which has
+// no decoder of its own.
+func (r *Registry) RegisterOUI(kind Kind, o OUI, d DecodeFunc) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.fallback[kind] == nil {
+		r.fallback[kind] = map[OUI]DecodeFunc{}
+	}
+	r.fallback[kind][o] = d
+}
+
+// Decode decodes vendor specific content, starting with its OUI and
+// type.
+// Content without a decoder is decoded as a *Raw.
+func (r *Registry) Decode(kind Kind, data []byte) (Payload, error) {
+	k, ok := splitKey(data)
+	if !ok {
+		return nil, ErrTruncated
+	}
+	return r.decoder(kind, k)(k, data[4:])
+}
+
+// DecodeElement decodes a vendor specific element.
+func (r *Registry) DecodeElement(e gofi.Element) (Payload, error) {
+	if e.ID != gofi.ElementVendorSpecific {
+		return nil, ErrNotVendorSpecific
+	}
+	return r.Decode(KindElement, e.Data)
+}
+
+// DecodeAction decodes the body of a vendor specific action frame,
+// or of a vendor specific public action frame.
+func (r *Registry) DecodeAction(f gofi.Frame) (Payload, error) {
+	data, ok := actionContent(f)
+	if !ok {
+		return nil, ErrNotVendorSpecific
+	}
+	return r.Decode(KindAction, data)
+}
+
+func (r *Registry) decoder(kind Kind, k Key) DecodeFunc {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if d, ok := r.decoders[kind][k]; ok {
+		return d
+	}
+	if d, ok := r.fallback[kind][k.OUI]; ok {
+		return d
+	}
+	return decodeRaw
+}
+
+// Element encodes a Payload as a vendor specific element.
+// Payloads which do not fit in one element are truncated.
+func Element(p Payload) gofi.Element {
+	return gofi.Element{ID: gofi.ElementVendorSpecific, Data: encodeContent(p)}
+}
+
+// ActionBody encodes a Payload as the body of a vendor specific
+// action frame.
+func ActionBody(p Payload) []byte {
+	return append([]byte{categoryVendorSpecific}, encodeContent(p)...)
+}
+
+// PublicActionBody encodes a Payload as the body of a vendor specific
+// public action frame, which is how Wi-Fi Direct and other protocols
+// send frames to devices they are not associated with.
+func PublicActionBody(p Payload) []byte {
+	body := []byte{categoryPublic, publicActionVendorSpecific}
+	return append(body, encodeContent(p)...)
+}
+
+func encodeContent(p Payload) []byte {
+	k := p.Key()
+	res := append(k.OUI[:], k.Type)
+	return append(res, p.Encode()...)
+}
+
+// actionContent finds the vendor specific content of an action
+// frame, starting with the OUI.
+func actionContent(f gofi.Frame) ([]byte, bool) {
+	if f.Type() != gofi.FrameTypeManagement || f.Protected() ||
+		(f.Subtype() != gofi.SubtypeAction && f.Subtype() != gofi.SubtypeActionNoAck) {
+		return nil, false
+	}
+	body := f.Body()
+	if len(body) < 1 {
+		return nil, false
+	}
+	switch body[0] {
+	case categoryVendorSpecific, categoryVendorSpecificProtected:
+		return body[1:], true
+	case categoryPublic:
+		if len(body) >= 2 && body[1] == publicActionVendorSpecific {
+			return body[2:], true
+		}
+	}
+	return nil, false
+}
+
+func splitKey(data []byte) (Key, bool) {
+	if len(data) < 4 {
+		return Key{}, false
+	}
+	var k Key
+	copy(k.OUI[:], data)
+	k.Type = data[3]
+	return k, true
+}
@@ -0,0 +1,203 @@
+package oui
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/unixpickle/gofi"
+	"github.com/unixpickle/gofi/crypto"
+	"github.com/unixpickle/gofi/sim"
+)
+
+var (
+	testAddr1 = net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	testAddr2 = net.HardwareAddr{0x02, 0, 0, 0, 0, 2}
+)
+
+func TestBuiltins(t *testing.T) {
+	payloads := []Payload{
+		&WPA{RSNInfo: crypto.RSNInfo{
+			GroupCipher:     crypto.CipherTKIP,
+			PairwiseCiphers: []crypto.Cipher{crypto.CipherTKIP, crypto.CipherCCMP128},
+			AKMs:            []crypto.AKM{crypto.AKMPSK},
+			GroupMgmtCipher: crypto.CipherBIPCMAC128,
+		}},
+		&WMM{Subtype: WMMSubtypeInformation, Version: 1, QoSInfo: 0x80},
+		&WMM{
+			Subtype: WMMSubtypeParameter,
+			Version: 1,
+			QoSInfo: 1,
+			Parameters: []WMMParameters{
+				{ACI: 0, AIFSN: 3, ECWMin: 4, ECWMax: 10},
+				{ACI: 1, AIFSN: 7, ECWMin: 4, ECWMax: 10},
+				{ACI: 2, AIFSN: 2, ECWMin: 3, ECWMax: 4, TXOPLimit: 94},
+				{ACI: 3, AIFSN: 2, ACM: true, ECWMin: 2, ECWMax: 3, TXOPLimit: 47},
+			},
+		},
+		&WPS{Attributes: []WPSAttribute{
+			{Type: WPSAttrVersion, Data: []byte{0x10}},
+			{Type: WPSAttrDeviceName, Data: []byte("printer")},
+		}},
+		&P2P{Attributes: []P2PAttribute{
+			{ID: P2PAttrCapability, Data: []byte{0x25, 0}},
+			{ID: P2PAttrDeviceID, Data: testAddr1},
+		}},
+		&P2PAction{
+			Subtype:     P2PProvisionDiscoveryRequest,
+			DialogToken: 7,
+			Elements:    []gofi.Element{{ID: gofi.ElementVendorSpecific, Data: []byte{1, 2, 3, 4}}},
+		},
+		&HS20{Release: 3, DGAFDisabled: true, HasANQPDomainID: true, ANQPDomainID: 0x1234},
+		&OWETransition{BSSID: testAddr2, SSID: []byte("owe"), HasChannel: true,
+			OperatingClass: 81, Channel: 6},
+		&AWDLAction{
+			Version:      0x10,
+			Subtype:      AWDLSubtypePeriodicSync,
+			PHYTxTime:    1000,
+			TargetTxTime: 990,
+			TLVs:         []AWDLTLV{{Type: 4, Data: []byte{1, 2}}},
+		},
+		&CCXVersion{Version: 5},
+	}
+	for _, p := range payloads {
+		kind := KindElement
+		if _, ok := p.(*P2PAction); ok {
+			kind = KindAction
+		} else if _, ok := p.(*AWDLAction); ok {
+			kind = KindAction
+		}
+		decoded, err := DefaultRegistry.Decode(kind, encodeContent(p))
+		if err != nil {
+			t.Errorf("%T: %s", p, err)
+			continue
+		}
+		if !reflect.DeepEqual(decoded, p) {
+			t.Errorf("%T: expected %v but got %v", p, p, decoded)
+		}
+		if !bytes.Equal(decoded.Encode(), p.Encode()) {
+			t.Errorf("%T: encoding changed", p)
+		}
+	}
+
+	wps := payloads[3].(*WPS)
+	if string(wps.Attribute(WPSAttrDeviceName)) != "printer" ||
+		wps.Attribute(WPSAttrSerialNumber) != nil {
+		t.Error("unexpected WPS attributes")
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	data := []byte{0x00, 0x50, 0xf2, TypeCCXVersion, 1}
+	p, err := r.Decode(KindElement, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(p, &Raw{OUI: Microsoft, Type: TypeCCXVersion, Data: []byte{1}}) {
+		t.Errorf("unexpected payload: %v", p)
+	}
+
+	r.RegisterOUI(KindElement, Microsoft, decodeCCXVersion)
+	if p, _ := r.Decode(KindElement, data); !reflect.DeepEqual(p, &CCXVersion{Version: 1}) {
+		t.Errorf("fallback was not used: %v", p)
+	}
+	r.Register(KindElement, Key{OUI: Microsoft, Type: TypeCCXVersion}, decodeRaw)
+	if _, ok := mustDecode(t, r, KindElement, data).(*Raw); !ok {
+		t.Error("decoder did not take precedence over fallback")
+	}
+	if _, ok := mustDecode(t, r, KindAction, data).(*Raw); !ok {
+		t.Error("element decoder was used for action")
+	}
+
+	if _, err := r.Decode(KindElement, data[:3]); err != ErrTruncated {
+		t.Error("unexpected error:", err)
+	}
+	if _, err := r.DecodeElement(gofi.Element{ID: gofi.ElementSSID, Data: data}); err != ErrNotVendorSpecific {
+		t.Error("unexpected error:", err)
+	}
+}
+
+func TestDispatcher(t *testing.T) {
+	d := NewDispatcher(nil)
+	var got []Payload
+	d.Handle(KindElement, Key{OUI: Cisco, Type: TypeCCXVersion}, func(p Payload, f gofi.Frame,
+		info *gofi.RadioInfo) {
+		got = append(got, p)
+	})
+	d.HandleOUI(KindAction, WFA, func(p Payload, f gofi.Frame, info *gofi.RadioInfo) {
+		got = append(got, p)
+	})
+
+	ccx := &CCXVersion{Version: 4}
+	elements := []gofi.Element{
+		{ID: gofi.ElementSSID, Data: []byte("test")},
+		Element(&WMM{Subtype: WMMSubtypeInformation, Version: 1}),
+		Element(ccx),
+	}
+	beacon := testManagementFrame(gofi.SubtypeBeacon,
+		append(make([]byte, 12), gofi.EncodeElements(elements)...))
+	d.Dispatch(beacon, nil)
+	if len(got) != 1 || !reflect.DeepEqual(got[0], ccx) {
+		t.Fatalf("unexpected payloads: %v", got)
+	}
+
+	action := &P2PAction{Subtype: P2PInvitationRequest, DialogToken: 3}
+	got = nil
+	d.Dispatch(testManagementFrame(gofi.SubtypeAction, PublicActionBody(action)), nil)
+	d.Dispatch(testManagementFrame(gofi.SubtypeAction, ActionBody(action)), nil)
+	d.Dispatch(testManagementFrame(gofi.SubtypeAction, ActionBody(ccx)), nil)
+	if len(got) != 2 || !reflect.DeepEqual(got[0], action) || !reflect.DeepEqual(got[1], action) {
+		t.Fatalf("unexpected payloads: %v", got)
+	}
+}
+
+func TestHandle(t *testing.T) {
+	medium := sim.NewMedium()
+	h1 := medium.NewHandle(testAddr1)
+	defer h1.Close()
+	d := NewDispatcher(nil)
+	results := make(chan Payload, 1)
+	d.Handle(KindElement, Key{OUI: Microsoft, Type: TypeWPS}, func(p Payload, f gofi.Frame,
+		info *gofi.RadioInfo) {
+		results <- p
+	})
+	h2 := NewHandle(medium.NewHandle(testAddr2), d)
+	defer h2.Close()
+
+	wps := &WPS{Attributes: []WPSAttribute{{Type: WPSAttrState, Data: []byte{2}}}}
+	body := append(make([]byte, 12), gofi.EncodeElements([]gofi.Element{Element(wps)})...)
+	if err := h1.Send(testManagementFrame(gofi.SubtypeBeacon, body), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := h2.Receive(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case p := <-results:
+		if !reflect.DeepEqual(p, wps) {
+			t.Errorf("unexpected payload: %v", p)
+		}
+	case <-time.After(time.Second):
+		t.Error("frame was not dispatched")
+	}
+}
+
+func mustDecode(t *testing.T, r *Registry, kind Kind, data []byte) Payload {
+	p, err := r.Decode(kind, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func testManagementFrame(subtype int, body []byte) gofi.Frame {
+	header := make([]byte, 24)
+	header[0] = byte(subtype << 4)
+	copy(header[4:], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	copy(header[10:], testAddr1)
+	copy(header[16:], testAddr1)
+	return gofi.NewFrame(header, body)
+}
@@ -0,0 +1,257 @@
+package oui
+
+import (
+	"encoding/binary"
+	"net"
+
+	"github.com/unixpickle/gofi"
+)
+
+// These are the types of the Wi-Fi Alliance's vendor specific
+// elements and actions.
+const (
+	TypeP2P           = 0x09
+	TypeHS20          = 0x10
+	TypeOWETransition = 0x1c
+)
+
+func registerWFA(r *Registry) {
+	r.Register(KindElement, Key{OUI: WFA, Type: TypeP2P}, decodeP2P)
+	r.Register(KindAction, Key{OUI: WFA, Type: TypeP2P}, decodeP2PAction)
+	r.Register(KindElement, Key{OUI: WFA, Type: TypeHS20}, decodeHS20)
+	r.Register(KindElement, Key{OUI: WFA, Type: TypeOWETransition}, decodeOWETransition)
+}
+
+// These are the IDs of some P2P attributes.
+const (
+	P2PAttrStatus                   = 0
+	P2PAttrMinorReasonCode          = 1
+	P2PAttrCapability               = 2
+	P2PAttrDeviceID                 = 3
+	P2PAttrGroupOwnerIntent         = 4
+	P2PAttrConfigurationTimeout     = 5
+	P2PAttrListenChannel            = 6
+	P2PAttrGroupBSSID               = 7
+	P2PAttrExtendedListenTiming     = 8
+	P2PAttrIntendedInterfaceAddress = 9
+	P2PAttrManageability            = 10
+	P2PAttrChannelList              = 11
+	P2PAttrNoticeOfAbsence          = 12
+	P2PAttrDeviceInfo               = 13
+	P2PAttrGroupInfo                = 14
+	P2PAttrGroupID                  = 15
+	P2PAttrInterface                = 16
+	P2PAttrOperatingChannel         = 17
+	P2PAttrInvitationFlags          = 18
+)
+
+// A P2P element advertises Wi-Fi Direct.
+type P2P struct {
+	Attributes []P2PAttribute
+}
+
+// A P2PAttribute is an attribute of a P2P element.
+type P2PAttribute struct {
+	ID   byte
+	Data []byte
+}
+
+func decodeP2P(k Key, data []byte) (Payload, error) {
+	res := &P2P{}
+	for len(data) > 0 {
+		if len(data) < 3 {
+			return nil, ErrTruncated
+		}
+		size := int(binary.LittleEndian.Uint16(data[1:]))
+		if len(data) < 3+size {
+			return nil, ErrTruncated
+		}
+		res.Attributes = append(res.Attributes, P2PAttribute{ID: data[0], Data: data[3 : 3+size]})
+		data = data[3+size:]
+	}
+	return res, nil
+}
+
+// Key returns the key of P2P elements.
+func (p *P2P) Key() Key {
+	return Key{OUI: WFA, Type: TypeP2P}
+}
+
+// Encode encodes the element.
+func (p *P2P) Encode() []byte {
+	var res []byte
+	for _, a := range p.Attributes {
+		res = append(res, a.ID, byte(len(a.Data)), byte(len(a.Data)>>8))
+		res = append(res, a.Data...)
+	}
+	return res
+}
+
+// Attribute returns the data of the first attribute with an ID, or
+// nil if there is no such attribute.
+func (p *P2P) Attribute(id byte) []byte {
+	for _, a := range p.Attributes {
+		if a.ID == id {
+			return a.Data
+		}
+	}
+	return nil
+}
+
+// These are the subtypes of P2P public action frames.
+const (
+	P2PGONegotiationRequest          = 0
+	P2PGONegotiationResponse         = 1
+	P2PGONegotiationConfirmation     = 2
+	P2PInvitationRequest             = 3
+	P2PInvitationResponse            = 4
+	P2PDeviceDiscoverabilityRequest  = 5
+	P2PDeviceDiscoverabilityResponse = 6
+	P2PProvisionDiscoveryRequest     = 7
+	P2PProvisionDiscoveryResponse    = 8
+)
+
+// A P2PAction is the body of a P2P public action frame, or of a P2P
+// action frame.
+// The meaning of the subtype depends on which of these it is.
+type P2PAction struct {
+	Subtype     byte
+	DialogToken byte
+
+	// Elements usually include a P2P element, and sometimes a WPS
+	// element.
+	Elements []gofi.Element
+}
+
+func decodeP2PAction(k Key, data []byte) (Payload, error) {
+	if len(data) < 2 {
+		return nil, ErrTruncated
+	}
+	elements, err := gofi.ParseElements(data[2:])
+	if err != nil {
+		return nil, err
+	}
+	return &P2PAction{Subtype: data[0], DialogToken: data[1], Elements: elements}, nil
+}
+
+// Key returns the key of P2P actions.
+func (p *P2PAction) Key() Key {
+	return Key{OUI: WFA, Type: TypeP2P}
+}
+
+// Encode encodes the action.
+func (p *P2PAction) Encode() []byte {
+	return append([]byte{p.Subtype, p.DialogToken}, gofi.EncodeElements(p.Elements)...)
+}
+
+// An HS20 element indicates that a BSS supports Hotspot 2.0.
+type HS20 struct {
+	// Release is the release number, starting at 1.
+	Release int
+
+	DGAFDisabled bool
+
+	HasPPSMOID bool
+	PPSMOID    uint16
+
+	HasANQPDomainID bool
+	ANQPDomainID    uint16
+}
+
+func decodeHS20(k Key, data []byte) (Payload, error) {
+	if len(data) < 1 {
+		return nil, ErrTruncated
+	}
+	config := data[0]
+	data = data[1:]
+	res := &HS20{
+		Release:      int(config>>4) + 1,
+		DGAFDisabled: config&1 != 0,
+	}
+	if config&2 != 0 {
+		if len(data) < 2 {
+			return nil, ErrTruncated
+		}
+		res.HasPPSMOID = true
+		res.PPSMOID = binary.LittleEndian.Uint16(data)
+		data = data[2:]
+	}
+	if config&4 != 0 {
+		if len(data) < 2 {
+			return nil, ErrTruncated
+		}
+		res.HasANQPDomainID = true
+		res.ANQPDomainID = binary.LittleEndian.Uint16(data)
+	}
+	return res, nil
+}
+
+// Key returns the key of HS20 elements.
+func (h *HS20) Key() Key {
+	return Key{OUI: WFA, Type: TypeHS20}
+}
+
+// Encode encodes the element.
+func (h *HS20) Encode() []byte {
+	config := byte(h.Release-1) << 4
+	if h.DGAFDisabled {
+		config |= 1
+	}
+	res := []byte{config}
+	if h.HasPPSMOID {
+		res[0] |= 2
+		res = append(res, byte(h.PPSMOID), byte(h.PPSMOID>>8))
+	}
+	if h.HasANQPDomainID {
+		res[0] |= 4
+		res = append(res, byte(h.ANQPDomainID), byte(h.ANQPDomainID>>8))
+	}
+	return res
+}
+
+// An OWETransition element links an open BSS to the OWE BSS which
+// replaces it, or the other way around.
+type OWETransition struct {
+	BSSID net.HardwareAddr
+	SSID  []byte
+
+	// HasChannel is true if the other BSS is on a different channel,
+	// which is given by OperatingClass and Channel.
+	HasChannel     bool
+	OperatingClass byte
+	Channel        byte
+}
+
+func decodeOWETransition(k Key, data []byte) (Payload, error) {
+	if len(data) < 7 || len(data) < 7+int(data[6]) {
+		return nil, ErrTruncated
+	}
+	res := &OWETransition{
+		BSSID: net.HardwareAddr(data[:6]),
+		SSID:  data[7 : 7+int(data[6])],
+	}
+	data = data[7+int(data[6]):]
+	if len(data) >= 2 {
+		res.HasChannel = true
+		res.OperatingClass = data[0]
+		res.Channel = data[1]
+	}
+	return res, nil
+}
+
+// Key returns the key of OWE transition elements.
+func (o *OWETransition) Key() Key {
+	return Key{OUI: WFA, Type: TypeOWETransition}
+}
+
+// Encode encodes the element.
+func (o *OWETransition) Encode() []byte {
+	res := make([]byte, 6, 9+len(o.SSID))
+	copy(res, o.BSSID)
+	res = append(res, byte(len(o.SSID)))
+	res = append(res, o.SSID...)
+	if o.HasChannel {
+		res = append(res, o.OperatingClass, o.Channel)
+	}
+	return res
+}
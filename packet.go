@@ -21,8 +21,19 @@ type RadioInfo struct {
 	TransmitPower int
 
 	// Rate is the transmit rate, measured in multiples of 500Kb/s.
-	// If this is 0, then the rate is unknown.
+	// If this is 0, then the rate is unknown or the frame was not
+	// sent at a legacy rate; see RateInfo.
 	Rate DataRate
+
+	// RateInfo describes the modulation of the frame, including the
+	// MCS of HT, VHT, and HE frames.
+	// If the modulation is unknown, RateInfo.PHY is PHYUnknown.
+	RateInfo RateInfo
+
+	// TxStatus is non-nil if the packet was not received over the air,
+	// but was instead echoed by the driver to report the outcome of
+	// sending a frame from this machine.
+	TxStatus *TxReport
 }
 
 type RadioPacket struct {
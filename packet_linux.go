@@ -0,0 +1,209 @@
+// +build linux
+
+package gofi
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// packetMaxSize is the largest packet we expect to read from a
+// packet socket, including the radiotap header.
+const packetMaxSize = 0x10000
+
+var errPacketReadTimeout = errors.New("packet socket read timeout exceeded")
+
+// A packetSocket is an AF_PACKET socket bound to a monitor interface.
+type packetSocket struct {
+	fd          int
+	readBuffer  []byte
+	readTimeout time.Duration
+
+	// ring is non-nil if packets are received through a
+	// memory-mapped ring rather than recvfrom.
+	ring *packetRing
+}
+
+func newPacketSocket(ifindex int) (*packetSocket, error) {
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW|unix.SOCK_CLOEXEC,
+		int(htons(unix.ETH_P_ALL)))
+	if err == unix.EPERM || err == unix.EACCES {
+		return nil, errors.New("permissions denied for packet socket")
+	} else if err != nil {
+		return nil, err
+	}
+	addr := &unix.SockaddrLinklayer{Protocol: htons(unix.ETH_P_ALL), Ifindex: ifindex}
+	if err := unix.Bind(fd, addr); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	return &packetSocket{fd: fd, readBuffer: make([]byte, packetMaxSize)}, nil
+}
+
+// Close closes the underlying socket.
+// You should not call this while any send or receive operations are taking place.
+// After closing the socket, you should not call any other methods on it.
+func (p *packetSocket) Close() error {
+	if p.ring != nil {
+		p.ring.Close()
+	}
+	return unix.Close(p.fd)
+}
+
+// EnableRxRing switches the socket to receive packets through a
+// TPACKET_V3 ring.
+func (p *packetSocket) EnableRxRing(opts *RxRingOptions) error {
+	ring, err := newPacketRing(p.fd, opts)
+	if err != nil {
+		return err
+	}
+	p.ring = ring
+	return nil
+}
+
+// CheckDataLink makes sure that the socket's interface provides
+// radiotap headers.
+func (p *packetSocket) CheckDataLink(name string) error {
+	ifreq, err := unix.NewIfreq(name)
+	if err != nil {
+		return err
+	}
+	if err := unix.IoctlIfreq(p.fd, unix.SIOCGIFHWADDR, ifreq); err != nil {
+		return err
+	}
+	// NOTE: the hardware type is stored in the sa_family field of ifr_hwaddr.
+	if ifreq.Uint16() != unix.ARPHRD_IEEE80211_RADIOTAP {
+		return errors.New("interface does not provide radiotap headers: " + name)
+	}
+	return nil
+}
+
+// SetReadTimeout sets the amount of time before a Receive will fail with
+// errPacketReadTimeout.
+func (p *packetSocket) SetReadTimeout(d time.Duration) error {
+	p.readTimeout = d
+	tv := unix.NsecToTimeval(d.Nanoseconds())
+	return unix.SetsockoptTimeval(p.fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv)
+}
+
+// ReadBufferSize returns the size of the socket's receive buffer,
+// or the size of its ring if it has one.
+func (p *packetSocket) ReadBufferSize() (int, error) {
+	if p.ring != nil {
+		return p.ring.Size(), nil
+	}
+	return unix.GetsockoptInt(p.fd, unix.SOL_SOCKET, unix.SO_RCVBUF)
+}
+
+// SetTxStatus sets whether or not the kernel reports if sent frames
+// were acknowledged, through the socket's error queue.
+//
+// NOTE: queued status reports are charged to the receive buffer, so
+// this should only be enabled while the reports are being collected.
+func (p *packetSocket) SetTxStatus(enabled bool) error {
+	var value int
+	if enabled {
+		value = 1
+	}
+	return unix.SetsockoptInt(p.fd, unix.SOL_SOCKET, unix.SO_WIFI_STATUS, value)
+}
+
+// Receive receives and parses the next incoming packet.
+func (p *packetSocket) Receive() (*RadioPacket, error) {
+	if p.ring != nil {
+		return p.ring.Receive(p.fd, p.readTimeout)
+	}
+	for {
+		amount, from, err := unix.Recvfrom(p.fd, p.readBuffer, 0)
+		if err == unix.EINTR {
+			continue
+		} else if err == unix.ENETDOWN {
+			return nil, errors.New("device is down")
+		} else if err == unix.EAGAIN || err == unix.EWOULDBLOCK {
+			return nil, errPacketReadTimeout
+		} else if err != nil {
+			return nil, err
+		}
+		if ll, ok := from.(*unix.SockaddrLinklayer); ok && ll.Pkttype == unix.PACKET_OUTGOING {
+			continue
+		}
+		// NOTE: parsed frames point into the packet data, so it
+		// must not be shared with the read buffer.
+		data := make([]byte, amount)
+		copy(data, p.readBuffer)
+		return parseRadiotapPacket(data)
+	}
+}
+
+// Send writes a packet to the socket.
+func (p *packetSocket) Send(frame Frame, r DataRate) error {
+	sendData := encodeRadiotapPacket(frame, r)
+	if n, err := unix.Write(p.fd, sendData); err != nil {
+		return err
+	} else if n < len(sendData) {
+		return errors.New("full packet was not sent")
+	} else {
+		return nil
+	}
+}
+
+// ReceiveTxStatus waits for the kernel to report the status of a
+// sent frame.
+// It returns the frame as it was transmitted, since the kernel may
+// modify frames (e.g. by assigning sequence numbers).
+//
+// If no status is reported before the timeout, this returns
+// ErrNoTxStatus.
+func (p *packetSocket) ReceiveTxStatus(timeout time.Duration) (acked bool, sent []byte,
+	err error) {
+	deadline := time.Now().Add(timeout)
+	buf := make([]byte, packetMaxSize)
+	oob := make([]byte, 0x100)
+	for {
+		n, oobn, _, _, err := unix.Recvmsg(p.fd, buf, oob, unix.MSG_ERRQUEUE|unix.MSG_DONTWAIT)
+		if err == unix.EAGAIN || err == unix.EWOULDBLOCK || err == unix.EINTR {
+			remaining := deadline.Sub(time.Now())
+			if remaining <= 0 {
+				return false, nil, ErrNoTxStatus
+			}
+			// NOTE: POLLERR is always reported, so there is no need to request it.
+			fds := []unix.PollFd{{Fd: int32(p.fd)}}
+			if _, err := unix.Poll(fds, int(remaining/time.Millisecond)+1); err != nil &&
+				err != unix.EINTR {
+				return false, nil, err
+			}
+			continue
+		} else if err != nil {
+			return false, nil, err
+		}
+		messages, err := unix.ParseSocketControlMessage(oob[:oobn])
+		if err != nil {
+			return false, nil, err
+		}
+		for _, msg := range messages {
+			if msg.Header.Level == unix.SOL_SOCKET && msg.Header.Type == unix.SCM_WIFI_STATUS &&
+				len(msg.Data) >= 4 {
+				return binary.LittleEndian.Uint32(msg.Data) != 0, buf[:n], nil
+			}
+		}
+	}
+}
+
+// DiscardTxStatus drops every status report in the error queue.
+func (p *packetSocket) DiscardTxStatus() {
+	buf := make([]byte, packetMaxSize)
+	oob := make([]byte, 0x100)
+	for {
+		_, _, _, _, err := unix.Recvmsg(p.fd, buf, oob, unix.MSG_ERRQUEUE|unix.MSG_DONTWAIT)
+		if err != nil && err != unix.EINTR {
+			return
+		}
+	}
+}
+
+func htons(x uint16) uint16 {
+	return (x << 8) | (x >> 8)
+}
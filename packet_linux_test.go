@@ -0,0 +1,42 @@
+// +build linux
+
+package gofi
+
+import (
+	"bytes"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestSendWithoutTxStatus(t *testing.T) {
+	sender, receiver := testVethSockets(t, nil)
+	if err := receiver.SetTxStatus(false); err != nil {
+		t.Fatal(err)
+	}
+
+	// The socket sends far more than its receive buffer could hold
+	// in status reports, and must still receive afterwards.
+	for i := 0; i < 10000; i++ {
+		if err := receiver.Send(testRingFrame(i), 2); err != nil {
+			t.Fatal("could not send packet:", err)
+		}
+	}
+	if enabled, err := unix.GetsockoptInt(receiver.fd, unix.SOL_SOCKET,
+		unix.SO_WIFI_STATUS); err != nil || enabled != 0 {
+		t.Fatal("status reports are enabled:", enabled, err)
+	}
+
+	frame := testRingFrame(1)
+	if err := sender.Send(frame, 2); err != nil {
+		t.Fatal("could not send packet:", err)
+	}
+	packet, err := receiver.Receive()
+	if err != nil {
+		t.Fatal("could not receive packet:", err)
+	}
+	if !bytes.Equal(packet.Frame, frame) {
+		t.Fatal("unexpected frame:", packet.Frame)
+	}
+}
+
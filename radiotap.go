@@ -12,7 +12,8 @@ type radiotapFieldInfo struct {
 }
 
 // radiotapFields stores the alignment and size of every radiotap data field, as specified
-// in http://www.opensource.apple.com/source/tcpdump/tcpdump-16/tcpdump/ieee802_11_radio.h.
+// in http://www.opensource.apple.com/source/tcpdump/tcpdump-16/tcpdump/ieee802_11_radio.h
+// and http://www.radiotap.org/fields/defined.
 // This information makes it possible to walk through the fields.
 var radiotapFields []radiotapFieldInfo = []radiotapFieldInfo{
 	{8, 8},
@@ -29,6 +30,20 @@ var radiotapFields []radiotapFieldInfo = []radiotapFieldInfo{
 	{1, 1},
 	{1, 1},
 	{1, 1},
+	{2, 2},
+	{2, 2},
+	{1, 1},
+	{1, 1},
+	{4, 8},
+	{1, 3},
+	{4, 8},
+	{2, 12},
+	{8, 12},
+	{2, 12},
+	{2, 12},
+	{2, 6},
+	{1, 1},
+	{2, 4},
 }
 
 const (
@@ -38,14 +53,29 @@ const (
 	radiotapSignalPower   = 5
 	radiotapNoisePower    = 6
 	radiotapTransmitPower = 10
+	radiotapTxFlags       = 15
+	radiotapDataRetries   = 17
+	radiotapMCS           = 19
+	radiotapVHT           = 21
+	radiotapHE            = 23
 )
 
+// radiotapPresentExtended is set in a presence bitmap when another
+// presence bitmap follows it.
+const radiotapPresentExtended = 0x80000000
+
 // These are some radiotap flags, taken from http://www.radiotap.org/defined-fields/Flags.
 const (
 	radiotapFlagHasFCS     = 0x10
 	radiotapFlagHasPadding = 0x20
 )
 
+// These are some radiotap TX flags, taken from http://www.radiotap.org/fields/TX%20flags.
+const (
+	radiotapTxFlagFail  = 0x0001
+	radiotapTxFlagNoAck = 0x0008
+)
+
 func parseRadiotapPacket(data []byte) (*RadioPacket, error) {
 	if len(data) < 8 {
 		return nil, ErrBufferUnderflow
@@ -56,18 +86,26 @@ func parseRadiotapPacket(data []byte) (*RadioPacket, error) {
 		return nil, ErrBufferUnderflow
 	}
 
+	// Skip past any extended presence bitmaps. Only the fields in the
+	// first bitmap are decoded, but the fields of every bitmap follow
+	// the last one.
 	presentFlags := binary.LittleEndian.Uint32(data[4:])
-	dataFields := data[8:headerSize]
-	if (presentFlags & 0x80000000) != 0 {
-		if len(data) < 12 || headerSize < 12 {
+	fieldOffset := 8
+	for word := presentFlags; (word & radiotapPresentExtended) != 0; {
+		if fieldOffset+4 > headerSize {
 			return nil, ErrBufferUnderflow
 		}
-		dataFields = data[12:headerSize]
+		word = binary.LittleEndian.Uint32(data[fieldOffset:])
+		fieldOffset += 4
 	}
 
+	// NOTE: field alignment is relative to the start of the header.
+	dataFields := data[:headerSize]
+
 	var radioInfo RadioInfo
-	var fieldOffset int
 	var flags int
+	var txFlags int
+	dataRetries := -1
 	for i, info := range radiotapFields {
 		if (presentFlags & (1 << uint(i))) == 0 {
 			continue
@@ -91,10 +129,33 @@ func parseRadiotapPacket(data []byte) (*RadioPacket, error) {
 			radioInfo.SignalPower = int(int8(dataFields[fieldOffset]))
 		case radiotapTransmitPower:
 			radioInfo.TransmitPower = int(int8(dataFields[fieldOffset]))
+		case radiotapTxFlags:
+			txFlags = int(binary.LittleEndian.Uint16(dataFields[fieldOffset:]))
+		case radiotapDataRetries:
+			dataRetries = int(dataFields[fieldOffset])
+		case radiotapMCS:
+			radioInfo.RateInfo = decodeRadiotapMCS(dataFields[fieldOffset:])
+		case radiotapVHT:
+			radioInfo.RateInfo = decodeRadiotapVHT(dataFields[fieldOffset:])
+		case radiotapHE:
+			radioInfo.RateInfo = decodeRadiotapHE(dataFields[fieldOffset:])
 		}
 		fieldOffset += info.Size
 	}
 
+	if radioInfo.RateInfo.PHY == PHYUnknown && radioInfo.Rate != 0 {
+		radioInfo.RateInfo = RateInfo{PHY: PHYLegacy, Legacy: radioInfo.Rate}
+	}
+
+	// Drivers echo transmitted frames with TX flags to report their status.
+	if (presentFlags & (1 << radiotapTxFlags)) != 0 {
+		radioInfo.TxStatus = &TxReport{
+			Acked:   (txFlags & (radiotapTxFlagFail | radiotapTxFlagNoAck)) == 0,
+			Retries: dataRetries,
+			Rate:    radioInfo.Rate,
+		}
+	}
+
 	frame := Frame(data[headerSize:])
 
 	if (flags & radiotapFlagHasPadding) != 0 {
@@ -113,6 +174,98 @@ func parseRadiotapPacket(data []byte) (*RadioPacket, error) {
 	return &RadioPacket{frame, &radioInfo}, nil
 }
 
+// decodeRadiotapMCS decodes the HT rate information from an MCS field,
+// as described at http://www.radiotap.org/fields/MCS.
+func decodeRadiotapMCS(field []byte) RateInfo {
+	known, flags, mcs := field[0], field[1], field[2]
+	res := RateInfo{PHY: PHYHT, Bandwidth: 20, GuardInterval: 800}
+	if (known & 0x02) != 0 {
+		res.MCS = int(mcs)
+		res.SpatialStreams = int(mcs)/8 + 1
+	}
+	if (known&0x01) != 0 && (flags&0x03) == 1 {
+		res.Bandwidth = 40
+	}
+	if (known&0x04) != 0 && (flags&0x04) != 0 {
+		res.GuardInterval = 400
+	}
+	return res
+}
+
+// decodeRadiotapVHT decodes the rate information for the first user
+// of a VHT field, as described at http://www.radiotap.org/fields/VHT.
+func decodeRadiotapVHT(field []byte) RateInfo {
+	known := binary.LittleEndian.Uint16(field)
+	flags, bandwidth := field[2], field[3]
+	res := RateInfo{
+		PHY:            PHYVHT,
+		MCS:            int(field[4] >> 4),
+		SpatialStreams: int(field[4] & 0xf),
+		Bandwidth:      20,
+		GuardInterval:  800,
+	}
+	if (known&0x0004) != 0 && (flags&0x04) != 0 {
+		res.GuardInterval = 400
+	}
+	if (known & 0x0040) != 0 {
+		switch {
+		// NOTE: several values describe a narrower signal within
+		// a wider channel; we report the bandwidth of the signal.
+		case bandwidth == 1 || bandwidth == 5 || bandwidth == 6 ||
+			(bandwidth >= 14 && bandwidth <= 17):
+			res.Bandwidth = 40
+		case bandwidth == 4 || bandwidth == 12 || bandwidth == 13:
+			res.Bandwidth = 80
+		case bandwidth == 11:
+			res.Bandwidth = 160
+		}
+	}
+	return res
+}
+
+// decodeRadiotapHE decodes the rate information from an HE field,
+// as described at http://www.radiotap.org/fields/HE.
+func decodeRadiotapHE(field []byte) RateInfo {
+	var data [6]uint16
+	for i := range data {
+		data[i] = binary.LittleEndian.Uint16(field[2*i:])
+	}
+	res := RateInfo{PHY: PHYHE, Bandwidth: 20, GuardInterval: 800}
+	if (data[0] & 0x0020) != 0 {
+		res.MCS = int((data[2] >> 8) & 0xf)
+	}
+	if (data[0] & 0x0040) != 0 {
+		res.DCM = (data[2] & 0x1000) != 0
+	}
+	if (data[0] & 0x4000) != 0 {
+		switch data[4] & 0xf {
+		case 1:
+			res.Bandwidth = 40
+		case 2:
+			res.Bandwidth = 80
+		case 3:
+			res.Bandwidth = 160
+		}
+	}
+	if (data[1] & 0x0002) != 0 {
+		switch (data[4] >> 4) & 3 {
+		case 1:
+			res.GuardInterval = 1600
+		case 2:
+			res.GuardInterval = 3200
+		}
+	}
+	// NOTE: NSTS is reported without a "known" bit, and STBC doubles it.
+	res.SpatialStreams = int(data[5] & 0xf)
+	if (data[0]&0x0200) != 0 && (data[2]&0x8000) != 0 {
+		res.SpatialStreams /= 2
+	}
+	if res.SpatialStreams == 0 {
+		res.SpatialStreams = 1
+	}
+	return res
+}
+
 // encodeRadiotapPacket generates a radiotap buffer which contains a Frame.
 func encodeRadiotapPacket(f Frame, r DataRate) []byte {
 	// Generate a radiotap header with the data rate and the checksum flag.
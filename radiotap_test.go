@@ -0,0 +1,146 @@
+package gofi
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseRadiotapExtendedPresence(t *testing.T) {
+	// A header with two presence bitmaps, a TSFT, flags, rate, and an
+	// antenna signal field, followed by a 4-byte frame.
+	// The TSFT must be aligned relative to the start of the header.
+	packet := []byte{
+		0, 0, 28, 0,
+		0x27, 0, 0, 0x80,
+		0x20, 0, 0, 0,
+		0, 0, 0, 0,
+		1, 2, 3, 4, 5, 6, 7, 8,
+		radiotapFlagHasFCS, 4, 0xd6, 0,
+		1, 2, 3, 4,
+	}
+	res, err := parseRadiotapPacket(packet)
+	if err != nil {
+		t.Fatal("failed to parse:", err)
+	}
+	if res.RadioInfo.Rate != 4 {
+		t.Error("unexpected rate:", res.RadioInfo.Rate)
+	}
+	if res.RadioInfo.SignalPower != -42 {
+		t.Error("unexpected signal power:", res.RadioInfo.SignalPower)
+	}
+	if !bytes.Equal(res.Frame, []byte{1, 2, 3, 4}) {
+		t.Error("unexpected frame:", res.Frame)
+	}
+}
+
+func TestParseRadiotapTxStatus(t *testing.T) {
+	packet := []byte{
+		0, 0, 14, 0,
+		(1 << radiotapFlags) | (1 << radiotapRate), 0x80, 2, 0,
+		radiotapFlagHasFCS, 12,
+		radiotapTxFlagFail, 0,
+		3,
+		0,
+		1, 2, 3, 4,
+	}
+	res, err := parseRadiotapPacket(packet)
+	if err != nil {
+		t.Fatal("failed to parse:", err)
+	}
+	report := res.RadioInfo.TxStatus
+	if report == nil {
+		t.Fatal("missing transmit status")
+	}
+	if report.Acked || report.Retries != 3 || report.Rate != 12 {
+		t.Errorf("unexpected report: %+v", *report)
+	}
+
+	packet[10] = 0
+	res, err = parseRadiotapPacket(packet)
+	if err != nil {
+		t.Fatal("failed to parse:", err)
+	}
+	if !res.RadioInfo.TxStatus.Acked {
+		t.Error("expected frame to be acknowledged")
+	}
+
+	res, err = parseRadiotapPacket(encodeRadiotapPacket(Frame{1, 2, 3, 4}, 2))
+	if err != nil {
+		t.Fatal("failed to parse:", err)
+	}
+	if res.RadioInfo.TxStatus != nil {
+		t.Error("unexpected transmit status")
+	}
+}
+
+func TestParseRadiotapMCS(t *testing.T) {
+	// Flags and an MCS field for HT MCS 7, 40MHz, short GI.
+	packet := []byte{
+		0, 0, 12, 0,
+		(1 << radiotapFlags), 0, 0x08, 0,
+		radiotapFlagHasFCS, 0x07, 0x05, 7,
+		1, 2, 3, 4,
+	}
+	res, err := parseRadiotapPacket(packet)
+	if err != nil {
+		t.Fatal("failed to parse:", err)
+	}
+	expected := RateInfo{PHY: PHYHT, MCS: 7, SpatialStreams: 1, Bandwidth: 40, GuardInterval: 400}
+	if res.RadioInfo.RateInfo != expected {
+		t.Errorf("unexpected rate: %+v", res.RadioInfo.RateInfo)
+	}
+	if res.RadioInfo.Rate != 0 {
+		t.Error("unexpected legacy rate:", res.RadioInfo.Rate)
+	}
+}
+
+func TestParseRadiotapVHT(t *testing.T) {
+	// A VHT field for MCS 9, 2 streams, 80MHz, short GI.
+	packet := []byte{
+		0, 0, 20, 0,
+		0, 0, 0x20, 0,
+		0x44, 0, 0x04, 4, 0x92, 0, 0, 0, 0, 0, 0, 0,
+		1, 2, 3, 4,
+	}
+	res, err := parseRadiotapPacket(packet)
+	if err != nil {
+		t.Fatal("failed to parse:", err)
+	}
+	expected := RateInfo{PHY: PHYVHT, MCS: 9, SpatialStreams: 2, Bandwidth: 80, GuardInterval: 400}
+	if res.RadioInfo.RateInfo != expected {
+		t.Errorf("unexpected rate: %+v", res.RadioInfo.RateInfo)
+	}
+}
+
+func TestParseRadiotapHE(t *testing.T) {
+	// An HE field for MCS 11, 2 streams, 80MHz, 1.6us GI, after a
+	// one-byte flags field which forces padding.
+	packet := []byte{
+		0, 0, 22, 0,
+		(1 << radiotapFlags), 0, 0x80, 0,
+		radiotapFlagHasFCS, 0,
+		0x60, 0x40, 0x02, 0, 0, 0x0b, 0, 0, 0x12, 0, 0x02, 0,
+		1, 2, 3, 4,
+	}
+	res, err := parseRadiotapPacket(packet)
+	if err != nil {
+		t.Fatal("failed to parse:", err)
+	}
+	expected := RateInfo{PHY: PHYHE, MCS: 11, SpatialStreams: 2, Bandwidth: 80,
+		GuardInterval: 1600}
+	if res.RadioInfo.RateInfo != expected {
+		t.Errorf("unexpected rate: %+v", res.RadioInfo.RateInfo)
+	}
+
+	// With STBC, 4 space-time streams carry 2 spatial streams.
+	packet[11] |= 0x02
+	packet[15] |= 0x80
+	packet[20] = 0x04
+	res, err = parseRadiotapPacket(packet)
+	if err != nil {
+		t.Fatal("failed to parse:", err)
+	}
+	if res.RadioInfo.RateInfo != expected {
+		t.Errorf("unexpected STBC rate: %+v", res.RadioInfo.RateInfo)
+	}
+}
@@ -0,0 +1,148 @@
+package gofi
+
+import "fmt"
+
+// A PHYType identifies the physical layer used to send a frame.
+type PHYType int
+
+const (
+	PHYUnknown PHYType = iota
+
+	// PHYLegacy covers the DSSS, CCK, and OFDM rates of 802.11a/b/g.
+	PHYLegacy
+
+	// PHYHT is 802.11n.
+	PHYHT
+
+	// PHYVHT is 802.11ac.
+	PHYVHT
+
+	// PHYHE is 802.11ax.
+	PHYHE
+)
+
+// String returns a short name for the PHY type, such as "HT".
+func (p PHYType) String() string {
+	switch p {
+	case PHYLegacy:
+		return "legacy"
+	case PHYHT:
+		return "HT"
+	case PHYVHT:
+		return "VHT"
+	case PHYHE:
+		return "HE"
+	default:
+		return "unknown"
+	}
+}
+
+// RateInfo describes how a frame was modulated.
+type RateInfo struct {
+	PHY PHYType
+
+	// Legacy is the data rate of a PHYLegacy frame.
+	Legacy DataRate
+
+	// MCS is the modulation and coding scheme index of an HT, VHT,
+	// or HE frame.
+	// For HT frames, this ranges from 0 to 31 and implies the
+	// number of spatial streams.
+	MCS int
+
+	// SpatialStreams is the number of spatial streams.
+	SpatialStreams int
+
+	// Bandwidth is the channel bandwidth in MHz.
+	Bandwidth int
+
+	// GuardInterval is the guard interval in nanoseconds.
+	GuardInterval int
+
+	// DCM is true if an HE frame used dual carrier modulation.
+	DCM bool
+}
+
+// Mbps computes the data rate in Mb/s.
+// This returns 0 if the rate cannot be determined.
+func (r RateInfo) Mbps() float64 {
+	switch r.PHY {
+	case PHYLegacy:
+		return float64(r.Legacy) / 2
+	case PHYHT:
+		if r.MCS < 0 || r.MCS > 31 {
+			return 0
+		}
+		streams := r.MCS/8 + 1
+		return r.mimoMbps(htVHTBitsPerSubcarrier[r.MCS%8], streams, 3200)
+	case PHYVHT:
+		if r.MCS < 0 || r.MCS >= len(htVHTBitsPerSubcarrier) {
+			return 0
+		}
+		return r.mimoMbps(htVHTBitsPerSubcarrier[r.MCS], r.SpatialStreams, 3200)
+	case PHYHE:
+		if r.MCS < 0 || r.MCS >= len(heBitsPerSubcarrier) {
+			return 0
+		}
+		bits := heBitsPerSubcarrier[r.MCS]
+		if r.DCM {
+			bits /= 2
+		}
+		return r.mimoMbps(bits, r.SpatialStreams, 12800)
+	default:
+		return 0
+	}
+}
+
+// String returns a human-readable string, measured in Mb/s.
+// For non-legacy frames, the modulation parameters are included.
+func (r RateInfo) String() string {
+	switch r.PHY {
+	case PHYLegacy:
+		return r.Legacy.String()
+	case PHYHT, PHYVHT, PHYHE:
+		streams := r.SpatialStreams
+		if r.PHY == PHYHT {
+			streams = r.MCS/8 + 1
+		}
+		dcm := ""
+		if r.DCM {
+			dcm = ", DCM"
+		}
+		return fmt.Sprintf("%.1f Mb/s (%s MCS %d, %d SS, %d MHz, %d ns GI%s)", r.Mbps(),
+			r.PHY, r.MCS, streams, r.Bandwidth, r.GuardInterval, dcm)
+	default:
+		return "unknown rate"
+	}
+}
+
+// mimoMbps computes a rate given the number of coded bits per
+// subcarrier per stream and the symbol duration (excluding the
+// guard interval) in nanoseconds.
+func (r RateInfo) mimoMbps(bits float64, streams int, symbolNanos int) float64 {
+	subcarriers := dataSubcarriers(r.PHY, r.Bandwidth)
+	if subcarriers == 0 || streams <= 0 {
+		return 0
+	}
+	guard := r.GuardInterval
+	if guard == 0 {
+		guard = 800
+	}
+	bitsPerSymbol := float64(subcarriers) * bits * float64(streams)
+	return bitsPerSymbol * 1000 / float64(symbolNanos+guard)
+}
+
+// htVHTBitsPerSubcarrier stores the number of data bits per
+// subcarrier for each HT (modulo 8) and VHT MCS index.
+var htVHTBitsPerSubcarrier = []float64{0.5, 1, 1.5, 2, 3, 4, 4.5, 5, 6, 20.0 / 3}
+
+// heBitsPerSubcarrier stores the number of data bits per subcarrier
+// for each HE MCS index.
+var heBitsPerSubcarrier = []float64{0.5, 1, 1.5, 2, 3, 4, 4.5, 5, 6, 20.0 / 3, 7.5, 25.0 / 3}
+
+func dataSubcarriers(phy PHYType, bandwidth int) int {
+	if phy == PHYHE {
+		return map[int]int{20: 234, 40: 468, 80: 980, 160: 1960}[bandwidth]
+	}
+	return map[int]int{20: 52, 40: 108, 80: 234, 160: 468}[bandwidth]
+}
@@ -0,0 +1,44 @@
+package gofi
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRateInfoMbps(t *testing.T) {
+	tests := []struct {
+		rate     RateInfo
+		expected float64
+	}{
+		{RateInfo{PHY: PHYLegacy, Legacy: 11}, 5.5},
+		{RateInfo{PHY: PHYHT, MCS: 7, Bandwidth: 20, GuardInterval: 800}, 65},
+		{RateInfo{PHY: PHYHT, MCS: 7, Bandwidth: 20, GuardInterval: 400}, 72.2},
+		{RateInfo{PHY: PHYHT, MCS: 15, Bandwidth: 40, GuardInterval: 400}, 300},
+		{RateInfo{PHY: PHYVHT, MCS: 9, SpatialStreams: 2, Bandwidth: 80, GuardInterval: 400}, 866.7},
+		{RateInfo{PHY: PHYVHT, MCS: 0, SpatialStreams: 1, Bandwidth: 160, GuardInterval: 800}, 58.5},
+		{RateInfo{PHY: PHYHE, MCS: 11, SpatialStreams: 1, Bandwidth: 20, GuardInterval: 800}, 143.4},
+		{RateInfo{PHY: PHYHE, MCS: 11, SpatialStreams: 2, Bandwidth: 80, GuardInterval: 800}, 1201},
+		{RateInfo{PHY: PHYHE, MCS: 0, SpatialStreams: 1, Bandwidth: 20, GuardInterval: 3200,
+			DCM: true}, 3.7},
+		{RateInfo{PHY: PHYHT, MCS: 40, Bandwidth: 20}, 0},
+		{RateInfo{}, 0},
+	}
+	for i, test := range tests {
+		actual := test.rate.Mbps()
+		if math.Abs(actual-test.expected) > 0.1 {
+			t.Errorf("test %d: expected %f but got %f", i, test.expected, actual)
+		}
+	}
+}
+
+func TestRateInfoString(t *testing.T) {
+	legacy := RateInfo{PHY: PHYLegacy, Legacy: 12}
+	if legacy.String() != DataRate(12).String() {
+		t.Error("unexpected legacy string:", legacy.String())
+	}
+	ht := RateInfo{PHY: PHYHT, MCS: 7, Bandwidth: 20, GuardInterval: 400}
+	expected := "72.2 Mb/s (HT MCS 7, 1 SS, 20 MHz, 400 ns GI)"
+	if ht.String() != expected {
+		t.Errorf("expected %q but got %q", expected, ht.String())
+	}
+}
@@ -0,0 +1,514 @@
+package remote
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/unixpickle/gofi"
+)
+
+// Defaults for a ClientConfig which leaves these fields unset.
+const (
+	DefaultReceiveWindow     = 256
+	DefaultReconnectInterval = time.Second
+)
+
+// A ClientConfig configures a Client.
+type ClientConfig struct {
+	// Key is the shared key of the Server.
+	Key []byte
+
+	// TLSConfig enables TLS if it is non-nil.
+	TLSConfig *tls.Config
+
+	// HandshakeTimeout limits the time to connect and authenticate.
+	// If it is 0, DefaultHandshakeTimeout is used.
+	HandshakeTimeout time.Duration
+
+	// ReceiveWindow is the number of received frames which may be
+	// buffered by the Client and in flight from the Server.
+	// If it is 0, DefaultReceiveWindow is used.
+	ReceiveWindow int
+
+	// ReconnectInterval is the time between attempts to reconnect
+	// after the connection is lost.
+	// If it is 0, DefaultReconnectInterval is used.
+	ReconnectInterval time.Duration
+}
+
+func (c *ClientConfig) setDefaults() {
+	if c.HandshakeTimeout == 0 {
+		c.HandshakeTimeout = DefaultHandshakeTimeout
+	}
+	if c.ReceiveWindow == 0 {
+		c.ReceiveWindow = DefaultReceiveWindow
+	}
+	if c.ReconnectInterval == 0 {
+		c.ReconnectInterval = DefaultReconnectInterval
+	}
+}
+
+// A Client is a Handle for the radio of a Server.
+//
+// When the connection is lost, the Client reconnects in the
+// background and restores the channel which it last set.
+// In the meantime, Receive waits, and other calls fail with
+// ErrDisconnected.
+//
+// If the Server's Handle fails or is closed, the Client stops
+// reconnecting, Receive returns the Handle's error once the buffered
+// frames are consumed, and other calls return it right away.
+// A Server which is closed itself looks like a lost connection.
+type Client struct {
+	addr   string
+	config ClientConfig
+
+	lock   sync.Mutex
+	cond   *sync.Cond
+	conn   *clientConn
+	closed bool
+
+	// err is the error of the Server's Handle, once it fails.
+	err error
+
+	// frames and infos are the buffered frames, and consumed is the
+	// number of frames which were returned since the latest credit.
+	frames   []gofi.Frame
+	infos    []*gofi.RadioInfo
+	consumed int
+
+	welcome welcome
+
+	// channel is the latest channel which was set, or nil if the
+	// channel has not been set.
+	channel     *gofi.Channel
+	lastChannel gofi.Channel
+
+	done chan struct{}
+}
+
+// A clientConn is one connection of a Client.
+type clientConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+
+	writeLock sync.Mutex
+
+	// These fields are protected by the Client's lock.
+	nextID  uint32
+	pending map[uint32]chan *response
+}
+
+type response struct {
+	payload []byte
+	err     error
+}
+
+// DialHandle connects to a Server.
+//
+// If c is nil, the defaults are used without a key.
+func DialHandle(addr string, c *ClientConfig) (*Client, error) {
+	res := &Client{addr: addr, done: make(chan struct{})}
+	if c != nil {
+		res.config = *c
+	}
+	res.config.setDefaults()
+	res.cond = sync.NewCond(&res.lock)
+
+	conn, w, err := res.connect()
+	if err != nil {
+		return nil, err
+	}
+	res.welcome = *w
+	res.lastChannel = w.Info.Channel
+	if err := res.start(conn); err != nil {
+		conn.conn.Close()
+		return nil, err
+	}
+	return res, nil
+}
+
+func (c *Client) SupportedRates() []gofi.DataRate {
+	var res []gofi.DataRate
+	c.requestJSON(opSupportedRates, nil, &res)
+	return res
+}
+
+func (c *Client) SupportedChannels() []gofi.Channel {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return append([]gofi.Channel{}, c.welcome.SupportedChannels...)
+}
+
+// Channel returns the remote channel, or the last known channel if
+// the Client is disconnected.
+func (c *Client) Channel() gofi.Channel {
+	var res gofi.Channel
+	if c.requestJSON(opChannel, nil, &res) != nil {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		return c.lastChannel
+	}
+	c.lock.Lock()
+	c.lastChannel = res
+	c.lock.Unlock()
+	return res
+}
+
+func (c *Client) SetChannel(ch gofi.Channel) error {
+	data, _ := json.Marshal(ch)
+	if _, err := c.request(opSetChannel, data); err != nil {
+		return err
+	}
+	c.lock.Lock()
+	c.channel = &ch
+	c.lastChannel = ch
+	c.lock.Unlock()
+	return nil
+}
+
+// Receive waits for the next frame from the Server, including while
+// the Client reconnects.
+func (c *Client) Receive() (gofi.Frame, *gofi.RadioInfo, error) {
+	c.lock.Lock()
+	for len(c.frames) == 0 && !c.closed && c.err == nil {
+		c.cond.Wait()
+	}
+	if c.closed {
+		c.lock.Unlock()
+		return nil, nil, gofi.ErrClosed
+	}
+	if len(c.frames) == 0 {
+		c.lock.Unlock()
+		return nil, nil, c.err
+	}
+	frame, info := c.frames[0], c.infos[0]
+	c.frames[0], c.infos[0] = nil, nil
+	c.frames, c.infos = c.frames[1:], c.infos[1:]
+
+	// Credit is granted in batches to save round trips.
+	c.consumed++
+	conn := c.conn
+	credit := c.consumed
+	if conn == nil || credit < (c.config.ReceiveWindow+1)/2 {
+		credit = 0
+	} else {
+		c.consumed = 0
+	}
+	c.lock.Unlock()
+
+	if credit > 0 {
+		conn.grant(credit)
+	}
+	return frame, info, nil
+}
+
+// Send sends a frame from the remote radio, and waits for the result.
+func (c *Client) Send(f gofi.Frame, r gofi.DataRate) error {
+	data := make([]byte, 2, 2+len(f))
+	binary.BigEndian.PutUint16(data, uint16(r))
+	_, err := c.request(opSend, append(data, f...))
+	return err
+}
+
+// Info returns the remote Handle's information from when the Client
+// connected, with the last known channel.
+func (c *Client) Info() gofi.HandleInfo {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	res := c.welcome.Info
+	res.Channel = c.lastChannel
+	return res
+}
+
+// Stats returns the Server's frame counts for the current connection.
+func (c *Client) Stats() (*Stats, error) {
+	var res Stats
+	if err := c.requestJSON(opStats, nil, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// Close disconnects from the Server, leaving the remote Handle open.
+func (c *Client) Close() {
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		return
+	}
+	c.closed = true
+	conn := c.conn
+	c.conn = nil
+	c.frames = nil
+	c.infos = nil
+	c.cond.Broadcast()
+	c.lock.Unlock()
+
+	close(c.done)
+	if conn != nil {
+		conn.conn.Close()
+	}
+}
+
+// connect dials the Server and authenticates.
+func (c *Client) connect() (*clientConn, *welcome, error) {
+	dialer := &net.Dialer{Timeout: c.config.HandshakeTimeout}
+	var conn net.Conn
+	var err error
+	if c.config.TLSConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", c.addr, c.config.TLSConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", c.addr)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	res := &clientConn{
+		conn:    conn,
+		reader:  bufio.NewReader(conn),
+		pending: map[uint32]chan *response{},
+	}
+	w, err := res.handshake(c.config.Key, c.config.HandshakeTimeout)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return res, w, nil
+}
+
+// start makes a new connection current, grants it credit for the
+// free part of the window, and starts reading from it.
+// It only fails if the Client is closed.
+func (c *Client) start(conn *clientConn) error {
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		return gofi.ErrClosed
+	}
+	c.conn = conn
+	credit := c.config.ReceiveWindow - len(c.frames)
+	c.consumed = 0
+	c.lock.Unlock()
+
+	go c.readLoop(conn)
+	if credit > 0 {
+		// NOTE: if this fails, the read loop fails too and
+		// reconnects.
+		conn.grant(credit)
+	}
+	return nil
+}
+
+func (c *Client) readLoop(conn *clientConn) {
+	for {
+		msgType, data, err := readMessage(conn.reader)
+		if err == nil {
+			err = c.handleMessage(conn, msgType, data)
+		}
+		if err != nil {
+			break
+		}
+	}
+	conn.conn.Close()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.conn == conn {
+		c.conn = nil
+	}
+	for id, ch := range conn.pending {
+		ch <- &response{err: ErrDisconnected}
+		delete(conn.pending, id)
+	}
+	if !c.closed && c.err == nil {
+		go c.reconnectLoop()
+	}
+}
+
+func (c *Client) handleMessage(conn *clientConn, msgType byte, data []byte) error {
+	switch msgType {
+	case msgFrame:
+		if len(data) < radioInfoSize {
+			return ErrBadMessage
+		}
+		info := decodeRadioInfo(data)
+		frame := gofi.Frame(data[radioInfoSize:])
+		c.lock.Lock()
+		c.frames = append(c.frames, frame)
+		c.infos = append(c.infos, info)
+		c.cond.Signal()
+		c.lock.Unlock()
+	case msgResponse:
+		if len(data) < 6 {
+			return ErrBadMessage
+		}
+		id := binary.BigEndian.Uint32(data)
+		errSize := int(binary.BigEndian.Uint16(data[4:]))
+		if len(data) < 6+errSize {
+			return ErrBadMessage
+		}
+		resp := &response{
+			err:     decodeError(string(data[6 : 6+errSize])),
+			payload: data[6+errSize:],
+		}
+		c.lock.Lock()
+		ch, ok := conn.pending[id]
+		delete(conn.pending, id)
+		c.lock.Unlock()
+		if ok {
+			ch <- resp
+		}
+	case msgError:
+		err := decodeError(string(data))
+		if err == nil {
+			return ErrBadMessage
+		}
+		c.lock.Lock()
+		c.err = err
+		c.cond.Broadcast()
+		c.lock.Unlock()
+		return err
+	default:
+		return ErrBadMessage
+	}
+	return nil
+}
+
+func (c *Client) reconnectLoop() {
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-time.After(c.config.ReconnectInterval):
+		}
+		conn, w, err := c.connect()
+		if err != nil {
+			continue
+		}
+		c.lock.Lock()
+		c.welcome = *w
+		channel := c.channel
+		c.lock.Unlock()
+		if err := c.start(conn); err != nil {
+			conn.conn.Close()
+			return
+		}
+		if channel != nil {
+			// NOTE: another client may hold the channel now, in
+			// which case the radio stays where it is.
+			c.SetChannel(*channel)
+		}
+		return
+	}
+}
+
+func (c *Client) requestJSON(op byte, data []byte, result interface{}) error {
+	payload, err := c.request(op, data)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(payload, result); err != nil {
+		return ErrBadMessage
+	}
+	return nil
+}
+
+func (c *Client) request(op byte, data []byte) ([]byte, error) {
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		return nil, gofi.ErrClosed
+	}
+	if c.err != nil {
+		c.lock.Unlock()
+		return nil, c.err
+	}
+	conn := c.conn
+	if conn == nil {
+		c.lock.Unlock()
+		return nil, ErrDisconnected
+	}
+	conn.nextID++
+	id := conn.nextID
+	ch := make(chan *response, 1)
+	conn.pending[id] = ch
+	c.lock.Unlock()
+
+	header := make([]byte, 5)
+	binary.BigEndian.PutUint32(header, id)
+	header[4] = op
+	if err := conn.write(msgRequest, header, data); err != nil {
+		// The read loop fails the request once it sees that the
+		// connection is broken.
+		conn.conn.Close()
+	}
+	select {
+	case resp := <-ch:
+		return resp.payload, resp.err
+	case <-c.done:
+		return nil, gofi.ErrClosed
+	}
+}
+
+// handshake authenticates the client and the Server to each other,
+// and reads the welcome message.
+func (c *clientConn) handshake(key []byte, timeout time.Duration) (*welcome, error) {
+	c.conn.SetDeadline(time.Now().Add(timeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	clientNonce := make([]byte, nonceSize)
+	if _, err := rand.Read(clientNonce); err != nil {
+		return nil, err
+	}
+	if err := writeMessage(c.conn, msgHello, []byte{protocolVersion}, clientNonce); err != nil {
+		return nil, err
+	}
+	msgType, data, err := readMessage(c.reader)
+	if err != nil {
+		return nil, err
+	}
+	if msgType != msgChallenge || len(data) != 2*nonceSize {
+		return nil, ErrBadMessage
+	}
+	serverNonce := data[:nonceSize]
+	if !hmac.Equal(data[nonceSize:], proof(key, "server", clientNonce, serverNonce)) {
+		return nil, ErrAuthFailed
+	}
+	clientProof := proof(key, "client", clientNonce, serverNonce)
+	if err := writeMessage(c.conn, msgAuth, clientProof); err != nil {
+		return nil, err
+	}
+
+	msgType, data, err = readMessage(c.reader)
+	if err != nil {
+		return nil, err
+	}
+	if msgType != msgWelcome {
+		return nil, ErrBadMessage
+	}
+	var res welcome
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, ErrBadMessage
+	}
+	return &res, nil
+}
+
+func (c *clientConn) grant(credit int) error {
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, uint32(credit))
+	return c.write(msgCredit, data)
+}
+
+func (c *clientConn) write(msgType byte, payload ...[]byte) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+	return writeMessage(c.conn, msgType, payload...)
+}
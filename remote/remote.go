@@ -0,0 +1,238 @@
+// Package remote exports a Handle over a stream connection, such as
+// TCP or TLS, so that a radio on one machine can be used from another.
+//
+// A Server serves a local Handle to any number of clients, which
+// share it like the Subscribers of a mux.Mux.
+// DialHandle connects to a Server and returns a Client, which
+// implements gofi.Handle.
+//
+// Both sides prove knowledge of a shared key before anything else is
+// exchanged.
+// The protocol does not encrypt anything itself, so connections over
+// untrusted networks should use TLS.
+//
+// Received frames are only sent to a client while it has credit,
+// which it grants as it consumes frames, so a slow client makes the
+// Server drop frames instead of buffering them without bound.
+package remote
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/unixpickle/gofi"
+	"github.com/unixpickle/gofi/mux"
+)
+
+var (
+	ErrAuthFailed     = errors.New("remote authentication failed")
+	ErrDisconnected   = errors.New("not connected to remote handle")
+	ErrBadMessage     = errors.New("invalid remote handle message")
+	ErrBadVersion     = errors.New("unsupported remote handle protocol version")
+	ErrMessageTooLong = errors.New("remote handle message is too long")
+)
+
+// DefaultHandshakeTimeout is the time limit for authentication, for
+// a config which does not specify one.
+const DefaultHandshakeTimeout = time.Second * 10
+
+// protocolVersion is sent in the hello message.
+const protocolVersion = 1
+
+// nonceSize is the size of the nonces and proofs of the handshake.
+const nonceSize = 32
+
+// maxMessageSize is the largest message which either side accepts.
+const maxMessageSize = 1 << 20
+
+// These are the types of messages, which are given by the byte after
+// their length.
+//
+// The handshake is hello, challenge, auth, welcome.
+// After that, the client sends requests and credits, and the Server
+// sends responses and frames.
+// If the Server's Handle fails, the Server sends its error in a final
+// error message.
+const (
+	msgHello = 1 + iota
+	msgChallenge
+	msgAuth
+	msgWelcome
+	msgRequest
+	msgResponse
+	msgFrame
+	msgCredit
+	msgError
+)
+
+// These are the operations of request messages, which are given by
+// the byte after the request ID.
+const (
+	opSend = 1 + iota
+	opSetChannel
+	opChannel
+	opSupportedRates
+	opSupportedChannels
+	opInfo
+	opStats
+)
+
+// Stats describes the frames of one client of a Server.
+type Stats struct {
+	// Received is the number of frames which the Server queued for
+	// the client.
+	Received uint64
+
+	// Dropped is the number of frames which the Server dropped
+	// because the client did not keep up.
+	Dropped uint64
+
+	// Sent is the number of frames which the client sent.
+	Sent uint64
+}
+
+// welcome is the body of a welcome message.
+type welcome struct {
+	SupportedChannels []gofi.Channel
+	Info              gofi.HandleInfo
+}
+
+// knownErrors are the errors which keep their identity when a
+// Server returns them to a client.
+var knownErrors = []error{gofi.ErrClosed, mux.ErrChannelLeased}
+
+func decodeError(msg string) error {
+	if msg == "" {
+		return nil
+	}
+	for _, err := range knownErrors {
+		if err.Error() == msg {
+			return err
+		}
+	}
+	return errors.New(msg)
+}
+
+func writeMessage(w io.Writer, msgType byte, payload ...[]byte) error {
+	size := 1
+	for _, p := range payload {
+		size += len(p)
+	}
+	if size > maxMessageSize {
+		return ErrMessageTooLong
+	}
+	msg := make([]byte, 5, 4+size)
+	binary.BigEndian.PutUint32(msg, uint32(size))
+	msg[4] = msgType
+	for _, p := range payload {
+		msg = append(msg, p...)
+	}
+	_, err := w.Write(msg)
+	return err
+}
+
+func readMessage(r *bufio.Reader) (byte, []byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(header[:])
+	if size == 0 {
+		return 0, nil, ErrBadMessage
+	} else if size > maxMessageSize {
+		return 0, nil, ErrMessageTooLong
+	}
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return 0, nil, err
+	}
+	return msg[0], msg[1:], nil
+}
+
+// proof computes the handshake proof of one side.
+func proof(key []byte, side string, clientNonce, serverNonce []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(side))
+	mac.Write(clientNonce)
+	mac.Write(serverNonce)
+	return mac.Sum(nil)
+}
+
+// radioInfoSize is the size of an encoded RadioInfo.
+const radioInfoSize = 26
+
+// These are bits of the flags of an encoded RadioInfo.
+const (
+	infoPresent = 1 << iota
+	infoDCM
+	infoTxStatus
+	infoAcked
+)
+
+func encodeRadioInfo(info *gofi.RadioInfo) []byte {
+	res := make([]byte, radioInfoSize)
+	if info == nil {
+		return res
+	}
+	flags := byte(infoPresent)
+	if info.RateInfo.DCM {
+		flags |= infoDCM
+	}
+	if info.TxStatus != nil {
+		flags |= infoTxStatus
+		if info.TxStatus.Acked {
+			flags |= infoAcked
+		}
+		binary.BigEndian.PutUint16(res[22:], uint16(info.TxStatus.Retries))
+		binary.BigEndian.PutUint16(res[24:], uint16(info.TxStatus.Rate))
+	}
+	res[0] = flags
+	binary.BigEndian.PutUint32(res[1:], uint32(info.Frequency))
+	binary.BigEndian.PutUint16(res[5:], uint16(info.NoisePower))
+	binary.BigEndian.PutUint16(res[7:], uint16(info.SignalPower))
+	binary.BigEndian.PutUint16(res[9:], uint16(info.TransmitPower))
+	binary.BigEndian.PutUint16(res[11:], uint16(info.Rate))
+	res[13] = byte(info.RateInfo.PHY)
+	binary.BigEndian.PutUint16(res[14:], uint16(info.RateInfo.Legacy))
+	res[16] = byte(info.RateInfo.MCS)
+	res[17] = byte(info.RateInfo.SpatialStreams)
+	binary.BigEndian.PutUint16(res[18:], uint16(info.RateInfo.Bandwidth))
+	binary.BigEndian.PutUint16(res[20:], uint16(info.RateInfo.GuardInterval))
+	return res
+}
+
+func decodeRadioInfo(data []byte) *gofi.RadioInfo {
+	flags := data[0]
+	if flags&infoPresent == 0 {
+		return nil
+	}
+	res := &gofi.RadioInfo{
+		Frequency:     int(int32(binary.BigEndian.Uint32(data[1:]))),
+		NoisePower:    int(int16(binary.BigEndian.Uint16(data[5:]))),
+		SignalPower:   int(int16(binary.BigEndian.Uint16(data[7:]))),
+		TransmitPower: int(int16(binary.BigEndian.Uint16(data[9:]))),
+		Rate:          gofi.DataRate(binary.BigEndian.Uint16(data[11:])),
+		RateInfo: gofi.RateInfo{
+			PHY:            gofi.PHYType(data[13]),
+			Legacy:         gofi.DataRate(binary.BigEndian.Uint16(data[14:])),
+			MCS:            int(data[16]),
+			SpatialStreams: int(data[17]),
+			Bandwidth:      int(binary.BigEndian.Uint16(data[18:])),
+			GuardInterval:  int(binary.BigEndian.Uint16(data[20:])),
+			DCM:            flags&infoDCM != 0,
+		},
+	}
+	if flags&infoTxStatus != 0 {
+		res.TxStatus = &gofi.TxReport{
+			Acked:   flags&infoAcked != 0,
+			Retries: int(int16(binary.BigEndian.Uint16(data[22:]))),
+			Rate:    gofi.DataRate(binary.BigEndian.Uint16(data[24:])),
+		}
+	}
+	return res
+}
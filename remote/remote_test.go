@@ -0,0 +1,303 @@
+package remote
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/unixpickle/gofi"
+	"github.com/unixpickle/gofi/internal/testutil"
+	"github.com/unixpickle/gofi/mgmt"
+	"github.com/unixpickle/gofi/mux"
+	"github.com/unixpickle/gofi/sim"
+)
+
+var (
+	testAddr1 = net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	testAddr2 = net.HardwareAddr{0x02, 0, 0, 0, 0, 2}
+	testKey   = []byte("secret")
+)
+
+func TestHandle(t *testing.T) {
+	for _, useTLS := range []bool{false, true} {
+		medium := sim.NewMedium()
+		server := NewServer(medium.NewHandle(testAddr1), &ServerConfig{Key: testKey})
+		defer server.Close()
+		listener := testListen(t, server, nil)
+		clientConfig := &ClientConfig{Key: testKey}
+		if useTLS {
+			serverTLS, clientTLS := testTLSConfigs(t)
+			listener = testListen(t, server, serverTLS)
+			clientConfig.TLSConfig = clientTLS
+		}
+		client, err := DialHandle(listener.Addr().String(), clientConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer client.Close()
+
+		peer := medium.NewHandle(testAddr2)
+		defer peer.Close()
+		peer.Send(testFrame(testAddr1, testAddr2, 1), 0)
+		frame, info, err := client.Receive()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reason, _ := mgmt.ParseReason(frame.Body()); reason != 1 || !frame.ChecksumValid() {
+			t.Error("unexpected frame:", frame)
+		}
+		if info == nil || info.SignalPower != sim.DefaultSignalPower || info.Frequency != 2412 {
+			t.Errorf("unexpected info: %+v", info)
+		}
+
+		if err := client.Send(testFrame(testAddr2, testAddr1, 2), 0); err != nil {
+			t.Fatal(err)
+		}
+		frame, _, err = peer.Receive()
+		if reason, _ := mgmt.ParseReason(frame.Body()); err != nil || reason != 2 {
+			t.Error("unexpected frame:", frame, err)
+		}
+
+		if err := client.SetChannel(gofi.Channel{Number: 36}); err != nil {
+			t.Fatal(err)
+		}
+		if client.Channel().Number != 36 || client.Info().Channel.Number != 36 {
+			t.Error("unexpected channel:", client.Channel())
+		}
+		if rates := client.SupportedRates(); len(rates) != 8 {
+			t.Errorf("unexpected rates: %v", rates)
+		}
+		if len(client.SupportedChannels()) != len(peer.SupportedChannels()) {
+			t.Error("unexpected channels:", client.SupportedChannels())
+		}
+		if err := client.SetChannel(gofi.Channel{Number: 1000}); err == nil {
+			t.Error("expected error")
+		}
+
+		// A second client shares the radio.
+		other, err := DialHandle(listener.Addr().String(), clientConfig)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := other.SetChannel(gofi.Channel{Number: 6}); err != mux.ErrChannelLeased {
+			t.Error("unexpected error:", err)
+		}
+		other.Close()
+
+		if stats, err := client.Stats(); err != nil || stats.Received != 1 || stats.Sent != 1 {
+			t.Errorf("unexpected stats %+v (error %v)", stats, err)
+		}
+		client.Close()
+		if _, _, err := client.Receive(); err != gofi.ErrClosed {
+			t.Error("unexpected error:", err)
+		}
+		if err := client.Send(testFrame(testAddr2, testAddr1, 2), 0); err != gofi.ErrClosed {
+			t.Error("unexpected error:", err)
+		}
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	medium := sim.NewMedium()
+	server := NewServer(medium.NewHandle(testAddr1), &ServerConfig{Key: testKey})
+	defer server.Close()
+	listener := testListen(t, server, nil)
+	for _, key := range [][]byte{nil, []byte("wrong")} {
+		_, err := DialHandle(listener.Addr().String(), &ClientConfig{Key: key})
+		if err != ErrAuthFailed {
+			t.Error("unexpected error:", err)
+		}
+	}
+}
+
+func TestBackpressure(t *testing.T) {
+	medium := sim.NewMedium()
+	server := NewServer(medium.NewHandle(testAddr1), &ServerConfig{Key: testKey, BufferSize: 4})
+	defer server.Close()
+	listener := testListen(t, server, nil)
+	client, err := DialHandle(listener.Addr().String(), &ClientConfig{Key: testKey, ReceiveWindow: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	peer := medium.NewHandle(testAddr2)
+	defer peer.Close()
+	for i := 0; i < 20; i++ {
+		peer.Send(testFrame(testAddr1, testAddr2, i), 0)
+	}
+	testutil.WaitFor(t, func() bool {
+		stats, err := client.Stats()
+		return err == nil && stats.Received+stats.Dropped == 20
+	})
+	stats, _ := client.Stats()
+	if stats.Dropped == 0 || stats.Received > 8 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+
+	// The frames in flight and buffered on both sides arrive in order.
+	last := -1
+	for i := 0; i < int(stats.Received); i++ {
+		frame, _, err := client.Receive()
+		if err != nil {
+			t.Fatal(err)
+		}
+		reason, _ := mgmt.ParseReason(frame.Body())
+		if reason <= last {
+			t.Errorf("frame %d: unexpected reason %d", i, reason)
+		}
+		last = reason
+	}
+	peer.Send(testFrame(testAddr1, testAddr2, 100), 0)
+	frame, _, err := client.Receive()
+	if reason, _ := mgmt.ParseReason(frame.Body()); err != nil || reason != 100 {
+		t.Error("unexpected frame:", frame, err)
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	medium := sim.NewMedium()
+	server := NewServer(medium.NewHandle(testAddr1), &ServerConfig{Key: testKey})
+	listener := testListen(t, server, nil)
+	addr := listener.Addr().String()
+	client, err := DialHandle(addr, &ClientConfig{
+		Key:               testKey,
+		ReconnectInterval: time.Millisecond * 20,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	if err := client.SetChannel(gofi.Channel{Number: 6}); err != nil {
+		t.Fatal(err)
+	}
+
+	server.Close()
+	testutil.WaitFor(t, func() bool {
+		return client.Send(testFrame(testAddr2, testAddr1, 0), 0) == ErrDisconnected
+	})
+	if client.Channel().Number != 6 {
+		t.Error("lost the last known channel")
+	}
+
+	handle := medium.NewHandle(testAddr1)
+	server = NewServer(handle, &ServerConfig{Key: testKey})
+	defer server.Close()
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(l)
+
+	// The client restores its channel on the new server.
+	testutil.WaitFor(t, func() bool {
+		return handle.Channel().Number == 6
+	})
+	peer := medium.NewHandle(testAddr2)
+	defer peer.Close()
+	peer.SetChannel(gofi.Channel{Number: 6})
+	peer.Send(testFrame(testAddr1, testAddr2, 3), 0)
+	frame, _, err := client.Receive()
+	if reason, _ := mgmt.ParseReason(frame.Body()); err != nil || reason != 3 {
+		t.Error("unexpected frame:", frame, err)
+	}
+}
+
+func TestHandleFailure(t *testing.T) {
+	medium := sim.NewMedium()
+	handle := medium.NewHandle(testAddr1)
+	server := NewServer(handle, &ServerConfig{Key: testKey})
+	defer server.Close()
+	listener := testListen(t, server, nil)
+	client, err := DialHandle(listener.Addr().String(), &ClientConfig{
+		Key:               testKey,
+		ReconnectInterval: time.Millisecond * 20,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	peer := medium.NewHandle(testAddr2)
+	defer peer.Close()
+	peer.Send(testFrame(testAddr1, testAddr2, 1), 0)
+	testutil.WaitFor(t, func() bool {
+		stats, err := client.Stats()
+		return err == nil && stats.Received == 1
+	})
+	handle.Close()
+
+	// The buffered frame comes first, and then the Handle's error.
+	if _, _, err := client.Receive(); err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 1)
+	go func() {
+		_, _, err := client.Receive()
+		errs <- err
+	}()
+	select {
+	case err := <-errs:
+		if err != gofi.ErrClosed {
+			t.Error("unexpected error:", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("Receive did not return")
+	}
+	if err := client.Send(testFrame(testAddr2, testAddr1, 2), 0); err != gofi.ErrClosed {
+		t.Error("unexpected error:", err)
+	}
+}
+
+func testListen(t *testing.T, s *Server, c *tls.Config) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c != nil {
+		l = tls.NewListener(l, c)
+	}
+	go s.Serve(l)
+	return l
+}
+
+// testTLSConfigs creates a server configuration with a self-signed
+// certificate, and a client configuration which trusts it.
+func testTLSConfigs(t *testing.T) (*tls.Config, *tls.Config) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	server := &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	}
+	return server, &tls.Config{RootCAs: pool}
+}
+
+func testFrame(dst, src net.HardwareAddr, reason int) gofi.Frame {
+	return mgmt.NewFrame(gofi.SubtypeDeauth, dst, src, src, mgmt.EncodeReason(reason))
+}
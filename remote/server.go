@@ -0,0 +1,334 @@
+package remote
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/unixpickle/gofi"
+	"github.com/unixpickle/gofi/mux"
+)
+
+// A ServerConfig configures a Server.
+type ServerConfig struct {
+	// Key is the shared key which clients must know.
+	// If it is empty, only clients without a key are accepted, which
+	// is only safe if TLS client certificates authenticate them.
+	Key []byte
+
+	// HandshakeTimeout limits the time for a client to authenticate.
+	// If it is 0, DefaultHandshakeTimeout is used.
+	HandshakeTimeout time.Duration
+
+	// BufferSize is the number of received frames which are buffered
+	// for each client while it has no credit.
+	// If it is 0, mux.DefaultBufferSize is used.
+	BufferSize int
+
+	// LeaseDuration is the time for which a client which sets the
+	// channel keeps other clients from changing it.
+	// If it is 0, mux.DefaultLeaseDuration is used.
+	LeaseDuration time.Duration
+}
+
+// A Server exports a Handle to remote clients.
+type Server struct {
+	mux    *mux.Mux
+	config ServerConfig
+
+	lock      sync.Mutex
+	listeners map[net.Listener]bool
+	conns     map[net.Conn]bool
+	closed    bool
+}
+
+// NewServer creates a Server which owns the Handle.
+// Closing the Server closes the Handle.
+func NewServer(h gofi.Handle, c *ServerConfig) *Server {
+	res := &Server{
+		config:    *c,
+		listeners: map[net.Listener]bool{},
+		conns:     map[net.Conn]bool{},
+	}
+	if res.config.HandshakeTimeout == 0 {
+		res.config.HandshakeTimeout = DefaultHandshakeTimeout
+	}
+	res.mux = mux.New(h, &mux.Config{LeaseDuration: c.LeaseDuration})
+	return res
+}
+
+// ListenAndServe listens on a TCP address and serves clients.
+// If t is non-nil, connections use TLS.
+func (s *Server) ListenAndServe(addr string, t *tls.Config) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if t != nil {
+		l = tls.NewListener(l, t)
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts clients from a listener until it fails or the Server
+// is closed, in which case gofi.ErrClosed is returned.
+// The listener is closed when Serve returns.
+func (s *Server) Serve(l net.Listener) error {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		l.Close()
+		return gofi.ErrClosed
+	}
+	s.listeners[l] = true
+	s.lock.Unlock()
+	defer func() {
+		s.lock.Lock()
+		delete(s.listeners, l)
+		s.lock.Unlock()
+		l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.lock.Lock()
+			closed := s.closed
+			s.lock.Unlock()
+			if closed {
+				return gofi.ErrClosed
+			}
+			return err
+		}
+		s.lock.Lock()
+		if s.closed {
+			s.lock.Unlock()
+			conn.Close()
+			return gofi.ErrClosed
+		}
+		s.conns[conn] = true
+		s.lock.Unlock()
+		go func() {
+			s.serveConn(conn)
+			s.lock.Lock()
+			delete(s.conns, conn)
+			s.lock.Unlock()
+		}()
+	}
+}
+
+// Close disconnects every client, stops every Serve call, and closes
+// the Handle.
+func (s *Server) Close() {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return
+	}
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	s.lock.Unlock()
+	s.mux.Close()
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	if err := s.handshake(conn, reader); err != nil {
+		return
+	}
+	sub, err := s.mux.Subscribe(&mux.SubscriberConfig{BufferSize: s.config.BufferSize})
+	if err != nil {
+		return
+	}
+	defer sub.Close()
+	info, _ := json.Marshal(&welcome{
+		SupportedChannels: sub.SupportedChannels(),
+		Info:              sub.Info(),
+	})
+	sess := &session{conn: conn, sub: sub}
+	sess.cond = sync.NewCond(&sess.lock)
+	if err := sess.write(msgWelcome, info); err != nil {
+		return
+	}
+
+	go sess.forwardLoop()
+	defer func() {
+		sess.lock.Lock()
+		sess.closed = true
+		sess.cond.Broadcast()
+		sess.lock.Unlock()
+	}()
+	for {
+		msgType, data, err := readMessage(reader)
+		if err != nil {
+			return
+		}
+		switch msgType {
+		case msgCredit:
+			if len(data) != 4 {
+				return
+			}
+			sess.lock.Lock()
+			sess.credit += int(binary.BigEndian.Uint32(data))
+			sess.cond.Broadcast()
+			sess.lock.Unlock()
+		case msgRequest:
+			if len(data) < 5 {
+				return
+			}
+			result, err := sess.handleRequest(data[4], data[5:])
+			var errMsg string
+			if err != nil {
+				errMsg = err.Error()
+			}
+			header := make([]byte, 6)
+			copy(header, data[:4])
+			binary.BigEndian.PutUint16(header[4:], uint16(len(errMsg)))
+			if err := sess.write(msgResponse, header, []byte(errMsg), result); err != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// handshake authenticates the client and the Server to each other.
+func (s *Server) handshake(conn net.Conn, reader *bufio.Reader) error {
+	conn.SetDeadline(time.Now().Add(s.config.HandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	msgType, data, err := readMessage(reader)
+	if err != nil {
+		return err
+	}
+	if msgType != msgHello || len(data) != 1+nonceSize {
+		return ErrBadMessage
+	}
+	if data[0] != protocolVersion {
+		return ErrBadVersion
+	}
+	clientNonce := data[1:]
+	serverNonce := make([]byte, nonceSize)
+	if _, err := rand.Read(serverNonce); err != nil {
+		return err
+	}
+	serverProof := proof(s.config.Key, "server", clientNonce, serverNonce)
+	if err := writeMessage(conn, msgChallenge, serverNonce, serverProof); err != nil {
+		return err
+	}
+	msgType, data, err = readMessage(reader)
+	if err != nil {
+		return err
+	}
+	if msgType != msgAuth ||
+		!hmac.Equal(data, proof(s.config.Key, "client", clientNonce, serverNonce)) {
+		return ErrAuthFailed
+	}
+	return nil
+}
+
+// A session serves one client.
+type session struct {
+	conn net.Conn
+	sub  *mux.Subscriber
+
+	writeLock sync.Mutex
+
+	lock   sync.Mutex
+	cond   *sync.Cond
+	credit int
+	sent   uint64
+	closed bool
+}
+
+// forwardLoop sends received frames to the client while it has
+// credit, and the Handle's error once it fails.
+func (s *session) forwardLoop() {
+	defer s.conn.Close()
+	for {
+		s.lock.Lock()
+		for s.credit == 0 && !s.closed {
+			s.cond.Wait()
+		}
+		if s.closed {
+			s.lock.Unlock()
+			return
+		}
+		s.credit--
+		s.lock.Unlock()
+
+		frame, info, err := s.sub.Receive()
+		if err != nil {
+			s.lock.Lock()
+			closed := s.closed
+			s.lock.Unlock()
+			// NOTE: the Subscriber is also closed when the client
+			// goes away, which must not look like a failed Handle.
+			if !closed {
+				s.write(msgError, []byte(err.Error()))
+			}
+			return
+		}
+		if err := s.write(msgFrame, encodeRadioInfo(info), frame); err != nil {
+			return
+		}
+	}
+}
+
+func (s *session) handleRequest(op byte, data []byte) ([]byte, error) {
+	switch op {
+	case opSend:
+		if len(data) < 2 {
+			return nil, ErrBadMessage
+		}
+		rate := gofi.DataRate(binary.BigEndian.Uint16(data))
+		if err := s.sub.Send(gofi.Frame(data[2:]), rate); err != nil {
+			return nil, err
+		}
+		s.lock.Lock()
+		s.sent++
+		s.lock.Unlock()
+		return nil, nil
+	case opSetChannel:
+		var c gofi.Channel
+		if err := json.Unmarshal(data, &c); err != nil {
+			return nil, ErrBadMessage
+		}
+		return nil, s.sub.SetChannel(c)
+	case opChannel:
+		return json.Marshal(s.sub.Channel())
+	case opSupportedRates:
+		return json.Marshal(s.sub.SupportedRates())
+	case opSupportedChannels:
+		return json.Marshal(s.sub.SupportedChannels())
+	case opInfo:
+		return json.Marshal(s.sub.Info())
+	case opStats:
+		stats := s.sub.Stats()
+		s.lock.Lock()
+		sent := s.sent
+		s.lock.Unlock()
+		return json.Marshal(&Stats{Received: stats.Received, Dropped: stats.Dropped, Sent: sent})
+	default:
+		return nil, ErrBadMessage
+	}
+}
+
+func (s *session) write(msgType byte, payload ...[]byte) error {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+	return writeMessage(s.conn, msgType, payload...)
+}
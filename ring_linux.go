@@ -0,0 +1,201 @@
+// +build linux
+
+package gofi
+
+import (
+	"errors"
+	"os"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// These are the defaults for RxRingOptions.
+const (
+	DefaultRxRingBlockSize    = 1 << 20
+	DefaultRxRingBlockCount   = 64
+	DefaultRxRingBlockTimeout = time.Millisecond * 10
+)
+
+// rxRingFrameSize is the frame size passed to the kernel.
+// TPACKET_V3 packs packets of any size into blocks, but the kernel
+// still validates the frame layout.
+const rxRingFrameSize = 1 << 11
+
+// RxRingOptions configure a memory-mapped receive ring.
+//
+// The kernel fills blocks of packets and hands each block to the
+// Handle as a whole, so one system call can deliver many packets.
+type RxRingOptions struct {
+	// BlockSize is the size of each block in bytes.
+	// It must be a power of two multiple of the page size.
+	// If it is 0, DefaultRxRingBlockSize is used.
+	BlockSize int
+
+	// BlockCount is the number of blocks in the ring.
+	// If it is 0, DefaultRxRingBlockCount is used.
+	BlockCount int
+
+	// BlockTimeout is how long the kernel waits for a block to fill
+	// before handing over a partial block.
+	// If it is 0, DefaultRxRingBlockTimeout is used.
+	BlockTimeout time.Duration
+}
+
+// A packetRing is a TPACKET_V3 receive ring mapped into memory.
+type packetRing struct {
+	data       []byte
+	blockSize  int
+	blockCount int
+
+	// block is the index of the current block.
+	block int
+
+	// inBlock is true if the current block belongs to us and has
+	// not been returned to the kernel.
+	inBlock bool
+
+	// offset is the offset of the next packet in the current block.
+	offset int
+
+	// remaining is the number of unread packets in the current block.
+	remaining int
+}
+
+func newPacketRing(fd int, opts *RxRingOptions) (*packetRing, error) {
+	blockSize := opts.BlockSize
+	if blockSize == 0 {
+		blockSize = DefaultRxRingBlockSize
+	}
+	blockCount := opts.BlockCount
+	if blockCount == 0 {
+		blockCount = DefaultRxRingBlockCount
+	}
+	timeout := opts.BlockTimeout
+	if timeout == 0 {
+		timeout = DefaultRxRingBlockTimeout
+	}
+
+	pageSize := os.Getpagesize()
+	if blockSize < pageSize || blockSize%pageSize != 0 ||
+		(blockSize/pageSize)&(blockSize/pageSize-1) != 0 {
+		return nil, errors.New("ring block size must be a power of two multiple of the page size")
+	} else if blockCount <= 0 {
+		return nil, errors.New("ring block count must be positive")
+	}
+	timeoutMillis := uint32(timeout / time.Millisecond)
+	if timeoutMillis == 0 {
+		timeoutMillis = 1
+	}
+
+	if err := unix.SetsockoptInt(fd, unix.SOL_PACKET, unix.PACKET_VERSION,
+		unix.TPACKET_V3); err != nil {
+		return nil, err
+	}
+	req := unix.TpacketReq3{
+		Block_size:     uint32(blockSize),
+		Block_nr:       uint32(blockCount),
+		Frame_size:     rxRingFrameSize,
+		Frame_nr:       uint32(blockSize / rxRingFrameSize * blockCount),
+		Retire_blk_tov: timeoutMillis,
+	}
+	if err := unix.SetsockoptTpacketReq3(fd, unix.SOL_PACKET, unix.PACKET_RX_RING,
+		&req); err != nil {
+		return nil, err
+	}
+	data, err := unix.Mmap(fd, 0, blockSize*blockCount, unix.PROT_READ|unix.PROT_WRITE,
+		unix.MAP_SHARED|unix.MAP_LOCKED|unix.MAP_POPULATE)
+	if err != nil {
+		// NOTE: MAP_LOCKED is subject to RLIMIT_MEMLOCK, so we fall
+		// back on a regular mapping.
+		data, err = unix.Mmap(fd, 0, blockSize*blockCount, unix.PROT_READ|unix.PROT_WRITE,
+			unix.MAP_SHARED)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &packetRing{data: data, blockSize: blockSize, blockCount: blockCount}, nil
+}
+
+// Size returns the total size of the ring in bytes.
+func (r *packetRing) Size() int {
+	return len(r.data)
+}
+
+// Receive returns the next incoming packet, waiting up to timeout
+// for the kernel to fill a block.
+// If the timeout elapses, this returns errPacketReadTimeout.
+func (r *packetRing) Receive(fd int, timeout time.Duration) (*RadioPacket, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		header := r.blockHeader()
+		if !r.inBlock {
+			if (atomic.LoadUint32(&header.Block_status) & unix.TP_STATUS_USER) == 0 {
+				if err := r.wait(fd, deadline); err != nil {
+					return nil, err
+				}
+				continue
+			}
+			r.inBlock = true
+			r.offset = int(header.Offset_to_first_pkt)
+			r.remaining = int(header.Num_pkts)
+		}
+
+		if r.remaining == 0 {
+			atomic.StoreUint32(&header.Block_status, unix.TP_STATUS_KERNEL)
+			r.inBlock = false
+			r.block = (r.block + 1) % r.blockCount
+			continue
+		}
+
+		block := r.data[r.block*r.blockSize : (r.block+1)*r.blockSize]
+		packetHeader := (*unix.Tpacket3Hdr)(unsafe.Pointer(&block[r.offset]))
+		addrOffset := r.offset + tpacketAlign(unix.SizeofTpacket3Hdr)
+		addr := (*unix.RawSockaddrLinklayer)(unsafe.Pointer(&block[addrOffset]))
+		start := r.offset + int(packetHeader.Mac)
+		end := start + int(packetHeader.Snaplen)
+
+		r.offset += int(packetHeader.Next_offset)
+		r.remaining--
+
+		if addr.Pkttype == unix.PACKET_OUTGOING {
+			continue
+		}
+
+		// NOTE: the block is handed back to the kernel once it has been
+		// read, so parsed frames must not point into it.
+		data := make([]byte, end-start)
+		copy(data, block[start:end])
+		return parseRadiotapPacket(data)
+	}
+}
+
+// Close unmaps the ring.
+func (r *packetRing) Close() error {
+	return unix.Munmap(r.data)
+}
+
+func (r *packetRing) blockHeader() *unix.TpacketHdrV1 {
+	desc := (*unix.TpacketBlockDesc)(unsafe.Pointer(&r.data[r.block*r.blockSize]))
+	return (*unix.TpacketHdrV1)(unsafe.Pointer(&desc.Hdr[0]))
+}
+
+// wait waits for the socket to become readable.
+func (r *packetRing) wait(fd int, deadline time.Time) error {
+	remaining := deadline.Sub(time.Now())
+	if remaining <= 0 {
+		return errPacketReadTimeout
+	}
+	fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+	if _, err := unix.Poll(fds, int(remaining/time.Millisecond)+1); err != nil &&
+		err != unix.EINTR {
+		return err
+	}
+	return nil
+}
+
+func tpacketAlign(x int) int {
+	return (x + unix.TPACKET_ALIGNMENT - 1) &^ (unix.TPACKET_ALIGNMENT - 1)
+}
@@ -0,0 +1,154 @@
+// +build linux
+
+package gofi
+
+import (
+	"bytes"
+	"net"
+	"os/exec"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRxRing(t *testing.T) {
+	sender, receiver := testVethSockets(t, &RxRingOptions{BlockSize: 1 << 16, BlockCount: 4})
+
+	var frames []Frame
+	for i := 0; i < 100; i++ {
+		frames = append(frames, testRingFrame(i))
+	}
+	for _, frame := range frames {
+		if err := sender.Send(frame, 2); err != nil {
+			t.Fatal("could not send packet:", err)
+		}
+	}
+	for i, frame := range frames {
+		packet, err := receiver.Receive()
+		if err != nil {
+			t.Fatal("could not receive packet", i, "-", err)
+		}
+		if !bytes.Equal(packet.Frame, frame) {
+			t.Fatal("unexpected frame", i, "-", packet.Frame)
+		}
+		if packet.RadioInfo.Rate != 2 {
+			t.Fatal("unexpected rate:", packet.RadioInfo.Rate)
+		}
+	}
+
+	if _, err := receiver.Receive(); err != errPacketReadTimeout {
+		t.Error("expected read timeout but got:", err)
+	}
+}
+
+func BenchmarkReceiveRecvfrom(b *testing.B) {
+	sender, receiver := testVethSockets(b, nil)
+	benchmarkReceive(b, sender, receiver)
+}
+
+func BenchmarkReceiveRxRing(b *testing.B) {
+	sender, receiver := testVethSockets(b, &RxRingOptions{})
+	benchmarkReceive(b, sender, receiver)
+}
+
+func benchmarkReceive(b *testing.B, sender, receiver *packetSocket) {
+	frame := testRingFrame(0)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			sender.Send(frame, 2)
+		}
+	}()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := receiver.Receive(); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+
+	close(stop)
+	wg.Wait()
+}
+
+// testVethSockets creates a veth pair and returns a packet socket on
+// each end of it.
+// The test is skipped if the pair cannot be created, e.g. because
+// the test is not running as root.
+func testVethSockets(t testing.TB, ring *RxRingOptions) (sender, receiver *packetSocket) {
+	name := testVethSender
+	peer := testVethReceiver
+	exec.Command("ip", "link", "del", name).Run()
+	if err := exec.Command("ip", "link", "add", name, "type", "veth", "peer", "name",
+		peer).Run(); err != nil {
+		t.Skip("could not create veth pair:", err)
+	}
+	t.Cleanup(func() {
+		exec.Command("ip", "link", "del", name).Run()
+	})
+	for _, iface := range []string{name, peer} {
+		if err := exec.Command("ip", "link", "set", iface, "up").Run(); err != nil {
+			t.Fatal("could not bring up interface:", err)
+		}
+	}
+
+	var sockets []*packetSocket
+	for _, iface := range []string{name, peer} {
+		netIface, err := net.InterfaceByName(iface)
+		if err != nil {
+			t.Fatal(err)
+		}
+		socket, err := newPacketSocket(netIface.Index)
+		if err != nil {
+			t.Fatal("could not create packet socket:", err)
+		}
+		t.Cleanup(func() {
+			socket.Close()
+		})
+		if err := socket.SetReadTimeout(time.Millisecond * 100); err != nil {
+			t.Fatal(err)
+		}
+		sockets = append(sockets, socket)
+	}
+
+	if ring != nil {
+		if err := sockets[1].EnableRxRing(ring); err != nil {
+			t.Fatal("could not enable ring:", err)
+		}
+	}
+
+	// Let IPv6 and other protocols finish announcing the new
+	// interfaces before we start listening for our own frames.
+	exec.Command("sysctl", "-q", "-w", "net.ipv6.conf."+name+".disable_ipv6=1").Run()
+	exec.Command("sysctl", "-q", "-w", "net.ipv6.conf."+peer+".disable_ipv6=1").Run()
+	for {
+		if _, err := sockets[1].Receive(); err == errPacketReadTimeout {
+			break
+		}
+	}
+
+	return sockets[0], sockets[1]
+}
+
+// These are the names of the interfaces created by testVethSockets.
+const (
+	testVethSender   = "gofitest0"
+	testVethReceiver = "gofitest1"
+)
+
+func testRingFrame(index int) Frame {
+	frame := Frame{0x08, 0, 0, 0, 0x02, 0, 0, 0, 0, 1, 0x02, 0, 0, 0, 0, 2,
+		0x02, 0, 0, 0, 0, 2, byte(index << 4), byte(index >> 4)}
+	frame = append(frame, []byte("hello, world")...)
+	return append(frame, 0, 0, 0, 0)
+}
@@ -0,0 +1,682 @@
+// Package secure encrypts and authenticates the datagrams of a
+// net.PacketConn, such as a dgram.PacketConn which sends raw 802.11
+// frames.
+//
+// Before two peers exchange datagrams, they perform a Noise handshake
+// with their static Curve25519 keys, using either the XX or the IK
+// pattern.
+// Each datagram is then encrypted with ChaCha20-Poly1305 or AES-GCM
+// and carries an explicit counter, so that datagrams may be lost or
+// reordered, but replayed datagrams are rejected.
+package secure
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/unixpickle/gofi/internal/deadline"
+)
+
+var (
+	ErrHandshakeTimeout = errors.New("handshake timed out")
+	ErrUnknownPeer      = errors.New("static key of peer is unknown")
+	ErrRejected         = errors.New("peer's static key was rejected")
+)
+
+// DefaultHandshakeTimeout is the handshake timeout for a Config which
+// does not specify one.
+const DefaultHandshakeTimeout = time.Second * 5
+
+// handshakeRetryInterval is how long to wait for the next handshake
+// message before sending the last one again.
+const handshakeRetryInterval = time.Millisecond * 250
+
+// receiveQueueSize is the number of received datagrams which are
+// kept while nothing reads them.
+const receiveQueueSize = 64
+
+// These are the types of messages, which are given by their first
+// byte.
+//
+// Every message but the first handshake message starts with the
+// session identifier which the receiver chose, so that the receiver
+// can find the session.
+const (
+	msgHandshake1 = 1 + iota
+	msgHandshake2
+	msgHandshake3
+	msgData
+)
+
+// dataHeaderSize is the size of the type, the receiver's session
+// identifier, and the counter, which precede each encrypted datagram.
+const dataHeaderSize = 13
+
+// A Config configures a PacketConn.
+type Config struct {
+	// StaticKey identifies this side of every session.
+	// It must not be nil.
+	StaticKey *KeyPair
+
+	// Pattern is the handshake pattern, which both sides must agree
+	// on.
+	Pattern Pattern
+
+	// Cipher encrypts handshake payloads and datagrams, and both
+	// sides must agree on it.
+	Cipher Cipher
+
+	// Prologue is data which both sides must agree on for a
+	// handshake to succeed, such as a protocol version.
+	Prologue []byte
+
+	// PeerKey returns the static key of the peer at an address.
+	// It must be set to send datagrams with PatternIK.
+	PeerKey func(addr net.Addr) (PublicKey, bool)
+
+	// Authorize decides whether to accept a peer which has proven
+	// that it owns a static key.
+	// If it is nil, every peer is accepted, in which case datagrams
+	// are still confidential, but peers should be identified with
+	// RemoteKey.
+	Authorize func(addr net.Addr, key PublicKey) bool
+
+	// HandshakeTimeout limits how long a handshake may take.
+	// If it is 0, DefaultHandshakeTimeout is used.
+	HandshakeTimeout time.Duration
+}
+
+type datagram struct {
+	payload []byte
+	source  net.Addr
+}
+
+// A session is an established set of keys for one peer.
+type session struct {
+	localID   uint32
+	remoteID  uint32
+	remoteKey PublicKey
+	send      cipher.AEAD
+	recv      cipher.AEAD
+	counter   uint64
+	replay    replayWindow
+
+	// reply is the last handshake message which established the
+	// session, which is sent again if the peer did not receive it.
+	reply []byte
+}
+
+// A handshake is a handshake in progress.
+type handshake struct {
+	peer      *peer
+	state     *handshakeState
+	localID   uint32
+	remoteID  uint32
+	initiator bool
+	deadline  time.Time
+	timer     *time.Timer
+
+	// message is the last message which was sent, which is sent
+	// again if no reply arrives.
+	message []byte
+
+	// done is closed when the handshake succeeds or fails, after
+	// which err is set.
+	done chan struct{}
+	err  error
+}
+
+type peer struct {
+	addr      net.Addr
+	current   *session
+	previous  *session
+	initiator *handshake
+	responder *handshake
+}
+
+// A PacketConn is a net.PacketConn which encrypts and authenticates
+// datagrams sent over another net.PacketConn.
+//
+// A handshake is performed the first time a datagram is sent to a
+// peer, or when a peer starts one.
+// Datagrams from peers without a session are dropped, as are those
+// which are decrypted while the queue of unread datagrams is full.
+type PacketConn struct {
+	pc     net.PacketConn
+	config Config
+
+	lock       sync.Mutex
+	peers      map[string]*peer
+	sessions   map[uint32]*session
+	handshakes map[uint32]*handshake
+
+	packets chan datagram
+
+	readDeadline  *deadline.Deadline
+	writeDeadline *deadline.Deadline
+
+	closeLock sync.Mutex
+	closed    chan struct{}
+}
+
+// NewPacketConn creates a PacketConn which owns pc and starts
+// receiving from it.
+// Closing the PacketConn closes pc.
+func NewPacketConn(pc net.PacketConn, c *Config) *PacketConn {
+	res := &PacketConn{
+		pc:            pc,
+		config:        *c,
+		peers:         map[string]*peer{},
+		sessions:      map[uint32]*session{},
+		handshakes:    map[uint32]*handshake{},
+		packets:       make(chan datagram, receiveQueueSize),
+		readDeadline:  deadline.New(),
+		writeDeadline: deadline.New(),
+		closed:        make(chan struct{}),
+	}
+	if res.config.HandshakeTimeout == 0 {
+		res.config.HandshakeTimeout = DefaultHandshakeTimeout
+	}
+	go res.readLoop()
+	return res
+}
+
+// ReadFrom reads the next datagram.
+// If b is too small, the datagram is truncated.
+func (p *PacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	select {
+	case <-p.closed:
+		return 0, nil, net.ErrClosed
+	case <-p.readDeadline.Wait():
+		return 0, nil, os.ErrDeadlineExceeded
+	default:
+	}
+	select {
+	case packet := <-p.packets:
+		return copy(b, packet.payload), packet.source, nil
+	case <-p.closed:
+		return 0, nil, net.ErrClosed
+	case <-p.readDeadline.Wait():
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+}
+
+// WriteTo encrypts a datagram and sends it to a peer.
+//
+// If there is no session with the peer, this performs a handshake
+// first, which may fail with ErrHandshakeTimeout, ErrUnknownPeer, or
+// ErrRejected.
+func (p *PacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	select {
+	case <-p.closed:
+		return 0, net.ErrClosed
+	case <-p.writeDeadline.Wait():
+		return 0, os.ErrDeadlineExceeded
+	default:
+	}
+
+	p.lock.Lock()
+	peer := p.peer(addr)
+	if peer.current == nil {
+		hs := peer.initiator
+		if hs == nil {
+			var err error
+			hs, err = p.initiate(peer)
+			if err != nil {
+				p.lock.Unlock()
+				return 0, err
+			}
+		}
+		p.lock.Unlock()
+		select {
+		case <-hs.done:
+		case <-p.closed:
+			return 0, net.ErrClosed
+		case <-p.writeDeadline.Wait():
+			return 0, os.ErrDeadlineExceeded
+		}
+		p.lock.Lock()
+		if peer.current == nil {
+			p.lock.Unlock()
+			return 0, hs.err
+		}
+	}
+	packet := encryptDatagram(peer.current, p.config.Cipher, b)
+	p.lock.Unlock()
+
+	if _, err := p.pc.WriteTo(packet, addr); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// RemoteKey returns the static key of the peer at addr, if there is
+// a session with it.
+func (p *PacketConn) RemoteKey(addr net.Addr) (PublicKey, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if peer, ok := p.peers[addr.String()]; ok && peer.current != nil {
+		return peer.current.remoteKey, true
+	}
+	return PublicKey{}, false
+}
+
+// Close closes the PacketConn and the underlying PacketConn.
+func (p *PacketConn) Close() error {
+	p.closeLock.Lock()
+	defer p.closeLock.Unlock()
+	select {
+	case <-p.closed:
+		return net.ErrClosed
+	default:
+	}
+	close(p.closed)
+
+	p.lock.Lock()
+	for _, hs := range p.handshakes {
+		hs.timer.Stop()
+	}
+	p.lock.Unlock()
+
+	return p.pc.Close()
+}
+
+// LocalAddr returns the address of the underlying PacketConn.
+func (p *PacketConn) LocalAddr() net.Addr {
+	return p.pc.LocalAddr()
+}
+
+// SetDeadline sets the read and write deadlines.
+func (p *PacketConn) SetDeadline(t time.Time) error {
+	p.readDeadline.Set(t)
+	p.writeDeadline.Set(t)
+	return nil
+}
+
+// SetReadDeadline sets the deadline for ReadFrom calls, including
+// calls which are already waiting.
+func (p *PacketConn) SetReadDeadline(t time.Time) error {
+	p.readDeadline.Set(t)
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for WriteTo calls, including
+// calls which are waiting for a handshake.
+func (p *PacketConn) SetWriteDeadline(t time.Time) error {
+	p.writeDeadline.Set(t)
+	return nil
+}
+
+func (p *PacketConn) readLoop() {
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := p.pc.ReadFrom(buf)
+		if err != nil {
+			p.Close()
+			return
+		}
+		msg := append([]byte{}, buf[:n]...)
+		if len(msg) < 5 {
+			continue
+		}
+		p.lock.Lock()
+		switch msg[0] {
+		case msgHandshake1:
+			p.handleHandshake1(msg, addr)
+		case msgHandshake2:
+			p.handleHandshake2(msg, addr)
+		case msgHandshake3:
+			p.handleHandshake3(msg, addr)
+		case msgData:
+			p.handleData(msg, addr)
+		}
+		p.lock.Unlock()
+	}
+}
+
+// peer finds or creates the peer at an address.
+// The caller must hold p.lock.
+func (p *PacketConn) peer(addr net.Addr) *peer {
+	key := addr.String()
+	res, ok := p.peers[key]
+	if !ok {
+		res = &peer{addr: addr}
+		p.peers[key] = res
+	}
+	return res
+}
+
+// prunePeer forgets a peer which has no session or handshake, so
+// that peers which never complete a handshake do not accumulate.
+// The caller must hold p.lock.
+func (p *PacketConn) prunePeer(peer *peer) {
+	if peer.current == nil && peer.previous == nil && peer.initiator == nil &&
+		peer.responder == nil && p.peers[peer.addr.String()] == peer {
+		delete(p.peers, peer.addr.String())
+	}
+}
+
+// initiate starts a handshake with a peer.
+// The caller must hold p.lock.
+func (p *PacketConn) initiate(peer *peer) (*handshake, error) {
+	var rs *PublicKey
+	if p.config.Pattern == PatternIK {
+		if p.config.PeerKey == nil {
+			p.prunePeer(peer)
+			return nil, ErrUnknownPeer
+		}
+		key, ok := p.config.PeerKey(peer.addr)
+		if !ok {
+			p.prunePeer(peer)
+			return nil, ErrUnknownPeer
+		}
+		rs = &key
+	}
+	hs := p.newHandshake(peer, true, rs)
+	msg, err := hs.state.writeMessage(nil)
+	if err != nil {
+		p.removeHandshake(hs)
+		p.prunePeer(peer)
+		return nil, err
+	}
+	peer.initiator = hs
+	p.sendHandshake(hs, append(appendID([]byte{msgHandshake1}, hs.localID), msg...))
+	return hs, nil
+}
+
+// handleHandshake1 responds to a peer which started a handshake.
+// The caller must hold p.lock.
+func (p *PacketConn) handleHandshake1(msg []byte, addr net.Addr) {
+	remoteID := binary.BigEndian.Uint32(msg[1:])
+
+	// The initiator may not have received our reply.
+	if peer, ok := p.peers[addr.String()]; ok {
+		if hs := peer.responder; hs != nil && hs.remoteID == remoteID {
+			p.pc.WriteTo(hs.message, addr)
+			return
+		}
+		for _, s := range []*session{peer.current, peer.previous} {
+			if s != nil && s.remoteID == remoteID && s.reply != nil {
+				p.pc.WriteTo(s.reply, addr)
+				return
+			}
+		}
+	}
+
+	state := newHandshakeState(p.config.Pattern, p.config.Cipher, false,
+		p.config.Prologue, p.config.StaticKey, nil)
+	if _, err := state.readMessage(msg[5:]); err != nil {
+		return
+	}
+	if p.config.Pattern == PatternIK && !p.authorize(addr, *state.rs) {
+		return
+	}
+
+	// NOTE: the peer is only created for valid messages, since
+	// anybody can send garbage from any address.
+	peer := p.peer(addr)
+	if peer.responder != nil {
+		peer.responder.finish(ErrHandshakeTimeout)
+		p.removeHandshake(peer.responder)
+		peer.responder = nil
+	}
+	hs := p.newHandshake(peer, false, nil)
+	hs.state = state
+	hs.remoteID = remoteID
+	reply, err := state.writeMessage(nil)
+	if err != nil {
+		p.removeHandshake(hs)
+		p.prunePeer(peer)
+		return
+	}
+	reply = append(appendID(appendID([]byte{msgHandshake2}, remoteID), hs.localID), reply...)
+	if state.done() {
+		p.removeHandshake(hs)
+		s := p.establish(hs)
+		s.reply = reply
+		p.pc.WriteTo(reply, addr)
+		return
+	}
+	peer.responder = hs
+	p.sendHandshake(hs, reply)
+}
+
+// handleHandshake2 processes the responder's reply to a handshake
+// which this side started.
+// The caller must hold p.lock.
+func (p *PacketConn) handleHandshake2(msg []byte, addr net.Addr) {
+	if len(msg) < 9 {
+		return
+	}
+	localID := binary.BigEndian.Uint32(msg[1:])
+	remoteID := binary.BigEndian.Uint32(msg[5:])
+
+	// The responder may not have received our last message.
+	if s, ok := p.sessions[localID]; ok {
+		if s.remoteID == remoteID && s.reply != nil && p.sameAddr(s, addr) {
+			p.pc.WriteTo(s.reply, addr)
+		}
+		return
+	}
+
+	hs, ok := p.handshakes[localID]
+	if !ok || !hs.initiator || hs.peer.addr.String() != addr.String() {
+		return
+	}
+	if _, err := hs.state.readMessage(msg[9:]); err != nil {
+		return
+	}
+	hs.remoteID = remoteID
+	p.removeHandshake(hs)
+	hs.peer.initiator = nil
+	if p.config.Pattern == PatternXX && !p.authorize(addr, *hs.state.rs) {
+		p.prunePeer(hs.peer)
+		hs.finish(ErrRejected)
+		return
+	}
+
+	var reply []byte
+	if !hs.state.done() {
+		confirm, err := hs.state.writeMessage(nil)
+		if err != nil {
+			hs.finish(err)
+			return
+		}
+		reply = append(appendID([]byte{msgHandshake3}, remoteID), confirm...)
+		p.pc.WriteTo(reply, addr)
+	}
+	s := p.establish(hs)
+	s.reply = reply
+	hs.finish(nil)
+}
+
+// handleHandshake3 finishes an XX handshake which the peer started.
+// The caller must hold p.lock.
+func (p *PacketConn) handleHandshake3(msg []byte, addr net.Addr) {
+	localID := binary.BigEndian.Uint32(msg[1:])
+	hs, ok := p.handshakes[localID]
+	if !ok || hs.initiator || hs.peer.addr.String() != addr.String() {
+		return
+	}
+	if _, err := hs.state.readMessage(msg[5:]); err != nil {
+		return
+	}
+	p.removeHandshake(hs)
+	hs.peer.responder = nil
+	if !p.authorize(addr, *hs.state.rs) {
+		p.prunePeer(hs.peer)
+		hs.finish(ErrRejected)
+		return
+	}
+	p.establish(hs)
+	hs.finish(nil)
+}
+
+// handleData decrypts a datagram and queues it.
+// The caller must hold p.lock.
+func (p *PacketConn) handleData(msg []byte, addr net.Addr) {
+	if len(msg) < dataHeaderSize {
+		return
+	}
+	s, ok := p.sessions[binary.BigEndian.Uint32(msg[1:])]
+	if !ok || !p.sameAddr(s, addr) {
+		return
+	}
+	counter := binary.BigEndian.Uint64(msg[5:])
+	if !s.replay.Check(counter) {
+		return
+	}
+	nonce := nonceBytes(p.config.Cipher, counter)
+	payload, err := s.recv.Open(nil, nonce, msg[dataHeaderSize:], msg[:dataHeaderSize])
+	if err != nil {
+		return
+	}
+	s.replay.Add(counter)
+	select {
+	case p.packets <- datagram{payload: payload, source: addr}:
+	default:
+	}
+}
+
+// newHandshake creates and registers a handshake with a new random
+// session identifier.
+// The caller must hold p.lock.
+func (p *PacketConn) newHandshake(peer *peer, initiator bool, rs *PublicKey) *handshake {
+	hs := &handshake{
+		peer:      peer,
+		localID:   p.newID(),
+		initiator: initiator,
+		deadline:  time.Now().Add(p.config.HandshakeTimeout),
+		done:      make(chan struct{}),
+	}
+	if initiator {
+		hs.state = newHandshakeState(p.config.Pattern, p.config.Cipher, true,
+			p.config.Prologue, p.config.StaticKey, rs)
+	}
+	p.handshakes[hs.localID] = hs
+	return hs
+}
+
+// removeHandshake unregisters a handshake and stops its timer.
+// The caller must hold p.lock.
+func (p *PacketConn) removeHandshake(hs *handshake) {
+	delete(p.handshakes, hs.localID)
+	if hs.timer != nil {
+		hs.timer.Stop()
+	}
+}
+
+// sendHandshake sends a handshake message, and sends it again
+// periodically until the handshake moves on or times out.
+// The caller must hold p.lock.
+func (p *PacketConn) sendHandshake(hs *handshake, msg []byte) {
+	hs.message = msg
+	p.pc.WriteTo(msg, hs.peer.addr)
+	hs.timer = time.AfterFunc(handshakeRetryInterval, func() {
+		p.retryHandshake(hs)
+	})
+}
+
+func (p *PacketConn) retryHandshake(hs *handshake) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.handshakes[hs.localID] != hs {
+		return
+	}
+	if time.Now().After(hs.deadline) {
+		p.removeHandshake(hs)
+		if hs.peer.initiator == hs {
+			hs.peer.initiator = nil
+		}
+		if hs.peer.responder == hs {
+			hs.peer.responder = nil
+		}
+		p.prunePeer(hs.peer)
+		hs.finish(ErrHandshakeTimeout)
+		return
+	}
+	p.pc.WriteTo(hs.message, hs.peer.addr)
+	hs.timer.Reset(handshakeRetryInterval)
+}
+
+// establish creates a session from a finished handshake and makes
+// it the peer's current session.
+// The caller must hold p.lock.
+func (p *PacketConn) establish(hs *handshake) *session {
+	k1, k2 := hs.state.split()
+	if !hs.initiator {
+		k1, k2 = k2, k1
+	}
+	s := &session{
+		localID:   hs.localID,
+		remoteID:  hs.remoteID,
+		remoteKey: *hs.state.rs,
+		send:      newAEAD(p.config.Cipher, k1),
+		recv:      newAEAD(p.config.Cipher, k2),
+	}
+	peer := hs.peer
+	if peer.previous != nil {
+		delete(p.sessions, peer.previous.localID)
+	}
+	peer.previous = peer.current
+	peer.current = s
+	p.sessions[s.localID] = s
+	return s
+}
+
+// authorize checks if a peer's static key is acceptable.
+func (p *PacketConn) authorize(addr net.Addr, key PublicKey) bool {
+	return p.config.Authorize == nil || p.config.Authorize(addr, key)
+}
+
+// sameAddr checks if a session belongs to the peer at an address.
+// The caller must hold p.lock.
+func (p *PacketConn) sameAddr(s *session, addr net.Addr) bool {
+	peer, ok := p.peers[addr.String()]
+	return ok && (peer.current == s || peer.previous == s)
+}
+
+// newID generates a session identifier which is not in use.
+// The caller must hold p.lock.
+func (p *PacketConn) newID() uint32 {
+	for {
+		var buf [4]byte
+		rand.Read(buf[:])
+		id := binary.BigEndian.Uint32(buf[:])
+		_, usedBySession := p.sessions[id]
+		_, usedByHandshake := p.handshakes[id]
+		if !usedBySession && !usedByHandshake {
+			return id
+		}
+	}
+}
+
+// finish ends a handshake, which succeeded if err is nil.
+func (h *handshake) finish(err error) {
+	h.err = err
+	close(h.done)
+}
+
+func encryptDatagram(s *session, c Cipher, payload []byte) []byte {
+	header := make([]byte, dataHeaderSize)
+	header[0] = msgData
+	binary.BigEndian.PutUint32(header[1:], s.remoteID)
+	binary.BigEndian.PutUint64(header[5:], s.counter)
+	nonce := nonceBytes(c, s.counter)
+	s.counter++
+	res := make([]byte, dataHeaderSize, dataHeaderSize+len(payload)+s.send.Overhead())
+	copy(res, header)
+	return s.send.Seal(res, nonce, payload, header)
+}
+
+func appendID(b []byte, id uint32) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], id)
+	return append(b, buf[:]...)
+}
@@ -0,0 +1,49 @@
+package secure
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+// KeySize is the size of a private or public key.
+const KeySize = 32
+
+// A PublicKey is a Curve25519 public key.
+type PublicKey [KeySize]byte
+
+// String returns the key in hexadecimal.
+func (p PublicKey) String() string {
+	return hex.EncodeToString(p[:])
+}
+
+// A KeyPair is a Curve25519 key pair.
+//
+// Each PacketConn has a static KeyPair which identifies it to its
+// peers.
+type KeyPair struct {
+	Private [KeySize]byte
+	Public  PublicKey
+}
+
+// GenerateKey creates a random KeyPair.
+func GenerateKey() (*KeyPair, error) {
+	var private [KeySize]byte
+	if _, err := rand.Read(private[:]); err != nil {
+		return nil, err
+	}
+	return NewKeyPair(private)
+}
+
+// NewKeyPair creates a KeyPair from a private key, such as one
+// which was saved from an earlier KeyPair.
+func NewKeyPair(private [KeySize]byte) (*KeyPair, error) {
+	public, err := curve25519.X25519(private[:], curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	res := &KeyPair{Private: private}
+	copy(res.Public[:], public)
+	return res, nil
+}
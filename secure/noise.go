@@ -0,0 +1,360 @@
+package secure
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+)
+
+var errBadHandshake = errors.New("invalid handshake message")
+
+// A Pattern is a Noise handshake pattern.
+type Pattern int
+
+const (
+	// PatternXX exchanges static keys during the handshake, so
+	// neither side needs to know the other's key in advance.
+	PatternXX Pattern = iota
+
+	// PatternIK sends the initiator's static key in the first
+	// message, encrypted to the responder's static key, which the
+	// initiator must know in advance.
+	// It takes one message fewer than PatternXX.
+	PatternIK
+)
+
+// A Cipher is the AEAD which encrypts handshake payloads and
+// datagrams.
+type Cipher int
+
+const (
+	CipherChaChaPoly Cipher = iota
+	CipherAESGCM
+)
+
+// A token is a step of a handshake message.
+type token int
+
+const (
+	tokenE token = iota
+	tokenS
+	tokenEE
+	tokenES
+	tokenSE
+	tokenSS
+)
+
+// messagePatterns returns the tokens of each handshake message.
+// Even messages are sent by the initiator, odd ones by the responder.
+func (p Pattern) messagePatterns() [][]token {
+	if p == PatternIK {
+		return [][]token{
+			{tokenE, tokenES, tokenS, tokenSS},
+			{tokenE, tokenEE, tokenSE},
+		}
+	}
+	return [][]token{
+		{tokenE},
+		{tokenE, tokenEE, tokenS, tokenES},
+		{tokenS, tokenSE},
+	}
+}
+
+// protocolName returns the full Noise protocol name.
+func protocolName(p Pattern, c Cipher) string {
+	name := "Noise_XX_25519_"
+	if p == PatternIK {
+		name = "Noise_IK_25519_"
+	}
+	if c == CipherAESGCM {
+		return name + "AESGCM_SHA256"
+	}
+	return name + "ChaChaPoly_SHA256"
+}
+
+// newAEAD creates an AEAD for a 32-byte key.
+func newAEAD(c Cipher, key []byte) cipher.AEAD {
+	if c == CipherAESGCM {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			panic(err)
+		}
+		res, err := cipher.NewGCM(block)
+		if err != nil {
+			panic(err)
+		}
+		return res
+	}
+	res, err := chacha20poly1305.New(key)
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
+
+// nonceBytes encodes a 64-bit nonce the way the Noise spec does for
+// each cipher.
+func nonceBytes(c Cipher, n uint64) []byte {
+	res := make([]byte, 12)
+	if c == CipherAESGCM {
+		binary.BigEndian.PutUint64(res[4:], n)
+	} else {
+		binary.LittleEndian.PutUint64(res[4:], n)
+	}
+	return res
+}
+
+// A cipherState is a Noise CipherState.
+type cipherState struct {
+	cipher Cipher
+	aead   cipher.AEAD
+	nonce  uint64
+}
+
+func (c *cipherState) encrypt(ad, plaintext []byte) []byte {
+	if c.aead == nil {
+		return append([]byte{}, plaintext...)
+	}
+	res := c.aead.Seal(nil, nonceBytes(c.cipher, c.nonce), plaintext, ad)
+	c.nonce++
+	return res
+}
+
+func (c *cipherState) decrypt(ad, ciphertext []byte) ([]byte, error) {
+	if c.aead == nil {
+		return append([]byte{}, ciphertext...), nil
+	}
+	res, err := c.aead.Open(nil, nonceBytes(c.cipher, c.nonce), ciphertext, ad)
+	if err != nil {
+		return nil, err
+	}
+	c.nonce++
+	return res, nil
+}
+
+// A symmetricState is a Noise SymmetricState.
+type symmetricState struct {
+	cipherState
+	ck []byte
+	h  []byte
+}
+
+func newSymmetricState(name string, c Cipher) *symmetricState {
+	h := make([]byte, sha256.Size)
+	if len(name) <= sha256.Size {
+		copy(h, name)
+	} else {
+		sum := sha256.Sum256([]byte(name))
+		h = sum[:]
+	}
+	return &symmetricState{
+		cipherState: cipherState{cipher: c},
+		ck:          append([]byte{}, h...),
+		h:           h,
+	}
+}
+
+func (s *symmetricState) mixKey(ikm []byte) {
+	outputs := hkdf(s.ck, ikm, 2)
+	s.ck = outputs[0]
+	s.aead = newAEAD(s.cipher, outputs[1])
+	s.nonce = 0
+}
+
+func (s *symmetricState) mixHash(data []byte) {
+	h := sha256.New()
+	h.Write(s.h)
+	h.Write(data)
+	s.h = h.Sum(nil)
+}
+
+func (s *symmetricState) encryptAndHash(plaintext []byte) []byte {
+	res := s.encrypt(s.h, plaintext)
+	s.mixHash(res)
+	return res
+}
+
+func (s *symmetricState) decryptAndHash(ciphertext []byte) ([]byte, error) {
+	res, err := s.decrypt(s.h, ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	s.mixHash(ciphertext)
+	return res, nil
+}
+
+// split returns the keys for messages sent by the initiator and by
+// the responder, in that order.
+func (s *symmetricState) split() ([]byte, []byte) {
+	outputs := hkdf(s.ck, nil, 2)
+	return outputs[0], outputs[1]
+}
+
+// A handshakeState is a Noise HandshakeState.
+type handshakeState struct {
+	symmetricState
+	initiator bool
+	messages  [][]token
+
+	s  *KeyPair
+	e  *KeyPair
+	rs *PublicKey
+	re *PublicKey
+}
+
+// newHandshakeState starts a handshake.
+// For PatternIK, the initiator must pass the responder's static key
+// as rs.
+func newHandshakeState(p Pattern, c Cipher, initiator bool, prologue []byte,
+	s *KeyPair, rs *PublicKey) *handshakeState {
+	res := &handshakeState{
+		symmetricState: *newSymmetricState(protocolName(p, c), c),
+		initiator:      initiator,
+		messages:       p.messagePatterns(),
+		s:              s,
+		rs:             rs,
+	}
+	res.mixHash(prologue)
+	if p == PatternIK {
+		if initiator {
+			res.mixHash(rs[:])
+		} else {
+			res.mixHash(s.Public[:])
+		}
+	}
+	return res
+}
+
+// done checks if every handshake message has been processed.
+func (h *handshakeState) done() bool {
+	return len(h.messages) == 0
+}
+
+// writeMessage creates the next handshake message.
+func (h *handshakeState) writeMessage(payload []byte) ([]byte, error) {
+	var res []byte
+	for _, t := range h.messages[0] {
+		switch t {
+		case tokenE:
+			e, err := GenerateKey()
+			if err != nil {
+				return nil, err
+			}
+			h.e = e
+			res = append(res, e.Public[:]...)
+			h.mixHash(e.Public[:])
+		case tokenS:
+			res = append(res, h.encryptAndHash(h.s.Public[:])...)
+		default:
+			if err := h.mixDH(t); err != nil {
+				return nil, err
+			}
+		}
+	}
+	h.messages = h.messages[1:]
+	return append(res, h.encryptAndHash(payload)...), nil
+}
+
+// readMessage processes the next handshake message and returns its
+// payload.
+//
+// If the message is invalid, the state is left unchanged, so that a
+// forged message cannot disrupt the handshake.
+func (h *handshakeState) readMessage(msg []byte) (payload []byte, err error) {
+	saved := *h
+	defer func() {
+		if err != nil {
+			*h = saved
+		}
+	}()
+	for _, t := range h.messages[0] {
+		switch t {
+		case tokenE:
+			if len(msg) < KeySize {
+				return nil, errBadHandshake
+			}
+			h.re = new(PublicKey)
+			copy(h.re[:], msg)
+			h.mixHash(msg[:KeySize])
+			msg = msg[KeySize:]
+		case tokenS:
+			size := KeySize
+			if h.aead != nil {
+				size += h.aead.Overhead()
+			}
+			if len(msg) < size {
+				return nil, errBadHandshake
+			}
+			plaintext, err := h.decryptAndHash(msg[:size])
+			if err != nil {
+				return nil, err
+			}
+			h.rs = new(PublicKey)
+			copy(h.rs[:], plaintext)
+			msg = msg[size:]
+		default:
+			if err := h.mixDH(t); err != nil {
+				return nil, err
+			}
+		}
+	}
+	h.messages = h.messages[1:]
+	return h.decryptAndHash(msg)
+}
+
+// mixDH performs the Diffie-Hellman operation of a token.
+// Tokens are written from the initiator's point of view, so the
+// responder swaps the roles of the keys for es and se.
+func (h *handshakeState) mixDH(t token) error {
+	var local *KeyPair
+	var remote *PublicKey
+	switch t {
+	case tokenEE:
+		local, remote = h.e, h.re
+	case tokenSS:
+		local, remote = h.s, h.rs
+	case tokenES:
+		if h.initiator {
+			local, remote = h.e, h.rs
+		} else {
+			local, remote = h.s, h.re
+		}
+	case tokenSE:
+		if h.initiator {
+			local, remote = h.s, h.re
+		} else {
+			local, remote = h.e, h.rs
+		}
+	}
+	shared, err := curve25519.X25519(local.Private[:], remote[:])
+	if err != nil {
+		return err
+	}
+	h.mixKey(shared)
+	return nil
+}
+
+// hkdf is the HKDF function from the Noise spec, which returns n
+// 32-byte outputs.
+func hkdf(chainingKey, ikm []byte, n int) [][]byte {
+	tempKey := hmacSHA256(chainingKey, ikm)
+	var res [][]byte
+	var last []byte
+	for i := 1; i <= n; i++ {
+		last = hmacSHA256(tempKey, append(append([]byte{}, last...), byte(i)))
+		res = append(res, last)
+	}
+	return res
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
@@ -0,0 +1,62 @@
+package secure
+
+// replayWindowSize is the number of counters, up to and including
+// the highest one seen, which a replayWindow remembers.
+// Datagrams with older counters are rejected, even if they were never
+// received, since they cannot be told apart from replays.
+const replayWindowSize = 1024
+
+// A replayWindow tracks which datagram counters have been received,
+// so that replayed datagrams can be rejected while reordered ones are
+// accepted.
+type replayWindow struct {
+	started bool
+	highest uint64
+	bits    [replayWindowSize / 64]uint64
+}
+
+// Check checks if a counter is new, without recording it.
+func (r *replayWindow) Check(counter uint64) bool {
+	if !r.started || counter > r.highest {
+		return true
+	}
+	if r.highest-counter >= replayWindowSize {
+		return false
+	}
+	return !r.get(counter)
+}
+
+// Add records a counter which passed Check.
+//
+// Counters should only be added once the datagram is known to be
+// authentic, so that forged counters cannot advance the window.
+func (r *replayWindow) Add(counter uint64) {
+	if !r.started {
+		r.started = true
+		r.highest = counter
+	} else if counter > r.highest {
+		if counter-r.highest >= replayWindowSize {
+			r.bits = [replayWindowSize / 64]uint64{}
+		} else {
+			for i := r.highest + 1; i < counter; i++ {
+				r.set(i, false)
+			}
+		}
+		r.highest = counter
+	}
+	r.set(counter, true)
+}
+
+func (r *replayWindow) get(counter uint64) bool {
+	index := counter % replayWindowSize
+	return r.bits[index/64]&(1<<(index%64)) != 0
+}
+
+func (r *replayWindow) set(counter uint64, value bool) {
+	index := counter % replayWindowSize
+	if value {
+		r.bits[index/64] |= 1 << (index % 64)
+	} else {
+		r.bits[index/64] &^= 1 << (index % 64)
+	}
+}
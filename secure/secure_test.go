@@ -0,0 +1,347 @@
+package secure
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/unixpickle/gofi/dgram"
+	"github.com/unixpickle/gofi/internal/testutil"
+	"github.com/unixpickle/gofi/sim"
+)
+
+var (
+	testAddr1 = net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	testAddr2 = net.HardwareAddr{0x02, 0, 0, 0, 0, 2}
+)
+
+var _ net.PacketConn = &PacketConn{}
+
+func TestHandshakeStates(t *testing.T) {
+	for _, pattern := range []Pattern{PatternXX, PatternIK} {
+		for _, c := range []Cipher{CipherChaChaPoly, CipherAESGCM} {
+			initiatorKey, responderKey := testKey(t), testKey(t)
+			initiator := newHandshakeState(pattern, c, true, []byte("prologue"),
+				initiatorKey, &responderKey.Public)
+			responder := newHandshakeState(pattern, c, false, []byte("prologue"),
+				responderKey, nil)
+			sender, receiver := initiator, responder
+			for i := 0; !initiator.done(); i++ {
+				payload := []byte{byte(i)}
+				msg, err := sender.writeMessage(payload)
+				if err != nil {
+					t.Fatal(err)
+				}
+				actual, err := receiver.readMessage(msg)
+				if err != nil {
+					t.Fatalf("pattern %d cipher %d message %d: %s", pattern, c, i, err)
+				}
+				if !bytes.Equal(actual, payload) {
+					t.Errorf("message %d: unexpected payload %v", i, actual)
+				}
+				sender, receiver = receiver, sender
+			}
+			if !responder.done() {
+				t.Fatal("responder is not done")
+			}
+			if *initiator.rs != responderKey.Public || *responder.rs != initiatorKey.Public {
+				t.Error("static keys were not exchanged")
+			}
+			k1, k2 := initiator.split()
+			r1, r2 := responder.split()
+			if !bytes.Equal(k1, r1) || !bytes.Equal(k2, r2) || bytes.Equal(k1, k2) {
+				t.Error("unexpected transport keys")
+			}
+		}
+	}
+
+	// A different prologue should make the handshake fail.
+	initiatorKey, responderKey := testKey(t), testKey(t)
+	initiator := newHandshakeState(PatternXX, CipherChaChaPoly, true, []byte("a"),
+		initiatorKey, nil)
+	responder := newHandshakeState(PatternXX, CipherChaChaPoly, false, []byte("b"),
+		responderKey, nil)
+	msg, _ := initiator.writeMessage(nil)
+	if _, err := responder.readMessage(msg); err != nil {
+		t.Fatal(err)
+	}
+	msg, _ = responder.writeMessage(nil)
+	if _, err := initiator.readMessage(msg); err == nil {
+		t.Error("handshake succeeded with different prologues")
+	}
+	if len(initiator.messages) != 2 {
+		t.Error("failed message changed the handshake state")
+	}
+}
+
+func TestReplayWindow(t *testing.T) {
+	var w replayWindow
+	for _, counter := range []uint64{5, 3, 4, 100, 6, 2000} {
+		if !w.Check(counter) {
+			t.Fatalf("counter %d rejected", counter)
+		}
+		w.Add(counter)
+	}
+	for _, counter := range []uint64{5, 3, 100, 2000, 2000 - replayWindowSize} {
+		if w.Check(counter) {
+			t.Errorf("counter %d accepted", counter)
+		}
+	}
+	for _, counter := range []uint64{1999, 2000 - replayWindowSize + 1, 2001} {
+		if !w.Check(counter) {
+			t.Errorf("counter %d rejected", counter)
+		}
+	}
+}
+
+func TestPacketConn(t *testing.T) {
+	for _, pattern := range []Pattern{PatternXX, PatternIK} {
+		for _, c := range []Cipher{CipherChaChaPoly, CipherAESGCM} {
+			medium := sim.NewMedium()
+			key1, key2 := testKey(t), testKey(t)
+			keys := map[string]PublicKey{
+				dgram.Addr(testAddr1).String(): key1.Public,
+				dgram.Addr(testAddr2).String(): key2.Public,
+			}
+			config := Config{
+				Pattern: pattern,
+				Cipher:  c,
+				PeerKey: func(addr net.Addr) (PublicKey, bool) {
+					key, ok := keys[addr.String()]
+					return key, ok
+				},
+				Authorize: func(addr net.Addr, key PublicKey) bool {
+					return keys[addr.String()] == key
+				},
+			}
+			config1, config2 := config, config
+			config1.StaticKey = key1
+			config2.StaticKey = key2
+			c1 := NewPacketConn(testPacketConn(medium, testAddr1), &config1)
+			c2 := NewPacketConn(testPacketConn(medium, testAddr2), &config2)
+
+			if _, err := c1.WriteTo([]byte("hello"), dgram.Addr(testAddr2)); err != nil {
+				t.Fatal(err)
+			}
+			testRead(t, c2, "hello", testAddr1)
+			if _, err := c2.WriteTo([]byte("hi"), dgram.Addr(testAddr1)); err != nil {
+				t.Fatal(err)
+			}
+			testRead(t, c1, "hi", testAddr2)
+
+			if key, ok := c1.RemoteKey(dgram.Addr(testAddr2)); !ok || key != key2.Public {
+				t.Error("unexpected remote key:", key)
+			}
+			if key, ok := c2.RemoteKey(dgram.Addr(testAddr1)); !ok || key != key1.Public {
+				t.Error("unexpected remote key:", key)
+			}
+
+			c1.Close()
+			c2.Close()
+		}
+	}
+}
+
+func TestPacketConnLoss(t *testing.T) {
+	medium := sim.NewMedium()
+	medium.SetSeed(1337)
+	medium.SetLossRate(0.4)
+	c1 := NewPacketConn(testPacketConn(medium, testAddr1), &Config{StaticKey: testKey(t)})
+	c2 := NewPacketConn(testPacketConn(medium, testAddr2), &Config{StaticKey: testKey(t)})
+	defer c1.Close()
+	defer c2.Close()
+
+	// Data sent right after the handshake may be lost, so keep
+	// sending until something arrives.
+	go func() {
+		for i := 0; i < 100; i++ {
+			if _, err := c1.WriteTo([]byte("hello"), dgram.Addr(testAddr2)); err != nil {
+				return
+			}
+			time.Sleep(time.Millisecond * 10)
+		}
+	}()
+	testRead(t, c2, "hello", testAddr1)
+}
+
+func TestPacketConnReplay(t *testing.T) {
+	medium := sim.NewMedium()
+	recorder := &recordingConn{PacketConn: testPacketConn(medium, testAddr1)}
+	c1 := NewPacketConn(recorder, &Config{StaticKey: testKey(t)})
+	c2 := NewPacketConn(testPacketConn(medium, testAddr2), &Config{StaticKey: testKey(t)})
+	defer c1.Close()
+	defer c2.Close()
+
+	if _, err := c1.WriteTo([]byte("hello"), dgram.Addr(testAddr2)); err != nil {
+		t.Fatal(err)
+	}
+	testRead(t, c2, "hello", testAddr1)
+
+	packet := recorder.Last()
+	recorder.PacketConn.WriteTo(packet, dgram.Addr(testAddr2))
+	tampered := append([]byte{}, packet...)
+	tampered[len(tampered)-1] ^= 1
+	tampered[5+7]++
+	recorder.PacketConn.WriteTo(tampered, dgram.Addr(testAddr2))
+
+	c2.SetReadDeadline(time.Now().Add(time.Millisecond * 100))
+	if _, _, err := c2.ReadFrom(make([]byte, 100)); !testutil.IsTimeout(err) {
+		t.Error("replayed or tampered datagram was accepted")
+	}
+}
+
+func TestPacketConnRejected(t *testing.T) {
+	reject := func(addr net.Addr, key PublicKey) bool {
+		return false
+	}
+	for _, initiatorRejects := range []bool{true, false} {
+		medium := sim.NewMedium()
+		config1 := &Config{StaticKey: testKey(t)}
+		config2 := &Config{StaticKey: testKey(t)}
+		if initiatorRejects {
+			config1.Authorize = reject
+		} else {
+			config2.Authorize = reject
+		}
+		c1 := NewPacketConn(testPacketConn(medium, testAddr1), config1)
+		c2 := NewPacketConn(testPacketConn(medium, testAddr2), config2)
+
+		_, err := c1.WriteTo([]byte("hello"), dgram.Addr(testAddr2))
+		if initiatorRejects {
+			// The initiator learns the responder's key first.
+			if err != ErrRejected {
+				t.Error("unexpected error:", err)
+			}
+		} else {
+			// The responder silently drops the handshake.
+			if err != nil {
+				t.Fatal(err)
+			}
+			c2.SetReadDeadline(time.Now().Add(time.Millisecond * 100))
+			if _, _, err := c2.ReadFrom(make([]byte, 100)); !testutil.IsTimeout(err) {
+				t.Error("expected timeout but got:", err)
+			}
+		}
+		c1.Close()
+		c2.Close()
+	}
+
+	medium := sim.NewMedium()
+	c := NewPacketConn(testPacketConn(medium, testAddr1), &Config{
+		StaticKey: testKey(t),
+		Pattern:   PatternIK,
+	})
+	defer c.Close()
+	if _, err := c.WriteTo([]byte("hello"), dgram.Addr(testAddr2)); err != ErrUnknownPeer {
+		t.Error("unexpected error:", err)
+	}
+}
+
+func TestPacketConnTimeout(t *testing.T) {
+	medium := sim.NewMedium()
+	c := NewPacketConn(testPacketConn(medium, testAddr1), &Config{
+		StaticKey:        testKey(t),
+		HandshakeTimeout: time.Millisecond * 300,
+	})
+	defer c.Close()
+	if _, err := c.WriteTo([]byte("hello"), dgram.Addr(testAddr2)); err != ErrHandshakeTimeout {
+		t.Error("unexpected error:", err)
+	}
+	c.SetWriteDeadline(time.Now().Add(time.Millisecond * 50))
+	if _, err := c.WriteTo([]byte("hello"), dgram.Addr(testAddr2)); !testutil.IsTimeout(err) {
+		t.Error("expected timeout but got:", err)
+	}
+}
+
+func TestPacketConnPeers(t *testing.T) {
+	medium := sim.NewMedium()
+	c := NewPacketConn(testPacketConn(medium, testAddr1), &Config{
+		StaticKey:        testKey(t),
+		HandshakeTimeout: time.Millisecond * 300,
+	})
+	defer c.Close()
+	raw := testPacketConn(medium, testAddr2)
+	defer raw.Close()
+	numPeers := func() int {
+		c.lock.Lock()
+		defer c.lock.Unlock()
+		return len(c.peers)
+	}
+
+	// Invalid handshakes should not create peers.
+	spoofer := testPacketConn(medium, net.HardwareAddr{0x02, 0, 0, 0, 0, 3})
+	defer spoofer.Close()
+	garbage := append(appendID([]byte{msgHandshake1}, 1), make([]byte, 10)...)
+	spoofer.WriteTo(garbage, dgram.Addr(testAddr1))
+
+	// A handshake which is never finished should be forgotten once
+	// it times out.
+	state := newHandshakeState(PatternXX, CipherChaChaPoly, true, nil, testKey(t), nil)
+	msg, err := state.writeMessage(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw.WriteTo(append(appendID([]byte{msgHandshake1}, 2), msg...), dgram.Addr(testAddr1))
+	raw.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if _, _, err := raw.ReadFrom(make([]byte, 1000)); err != nil {
+		t.Fatal(err)
+	}
+	if n := numPeers(); n != 1 {
+		t.Fatal("unexpected number of peers:", n)
+	}
+	for i := 0; numPeers() != 0; i++ {
+		if i == 100 {
+			t.Fatal("peer was not forgotten")
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+type recordingConn struct {
+	net.PacketConn
+
+	lock sync.Mutex
+	last []byte
+}
+
+func (r *recordingConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	r.lock.Lock()
+	r.last = append([]byte{}, b...)
+	r.lock.Unlock()
+	return r.PacketConn.WriteTo(b, addr)
+}
+
+func (r *recordingConn) Last() []byte {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.last
+}
+
+func testKey(t *testing.T) *KeyPair {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func testPacketConn(medium *sim.Medium, addr net.HardwareAddr) net.PacketConn {
+	return dgram.NewPacketConn(medium.NewHandle(addr), &dgram.Config{
+		Address:  addr,
+		Protocol: 0x88b5,
+	})
+}
+
+func testRead(t *testing.T, c net.PacketConn, expected string, source net.HardwareAddr) {
+	c.SetReadDeadline(time.Now().Add(time.Second * 5))
+	buf := make([]byte, 100)
+	n, addr, err := c.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != expected || addr.String() != source.String() {
+		t.Errorf("unexpected datagram %q from %v", buf[:n], addr)
+	}
+}
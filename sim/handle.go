@@ -0,0 +1,234 @@
+package sim
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/unixpickle/gofi"
+)
+
+// DefaultSignalPower is the signal power, in dBm, at which a Handle's
+// transmissions are received unless SetSignalPower is called.
+const DefaultSignalPower = -40
+
+// NoisePower is the noise power, in dBm, reported for every received frame.
+const NoisePower = -95
+
+// MaxReorderDelay is the longest time for which a frame is held back
+// to simulate reordering.
+const MaxReorderDelay = time.Millisecond
+
+// QueueSize is the maximum number of received packets that a Handle
+// buffers. Packets which arrive while the buffer is full are dropped.
+const QueueSize = 1024
+
+// A Handle is a simulated gofi.Handle.
+// It implements gofi.TxStatusHandle and gofi.CapabilityHandle.
+type Handle struct {
+	medium *Medium
+	addr   net.HardwareAddr
+
+	lock        sync.Mutex
+	cond        *sync.Cond
+	channel     gofi.Channel
+	signalPower int
+	queue       []gofi.RadioPacket
+	closed      bool
+
+	// delayed is a received frame which is being held back until
+	// the next frame arrives.
+	delayed *gofi.RadioPacket
+}
+
+// Addr returns the Handle's MAC address.
+func (h *Handle) Addr() net.HardwareAddr {
+	return append(net.HardwareAddr{}, h.addr...)
+}
+
+// SetSignalPower sets the signal power, in dBm, at which other
+// Handles receive this Handle's transmissions.
+func (h *Handle) SetSignalPower(dBm int) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.signalPower = dBm
+}
+
+// SupportedRates returns the 802.11b/g rates on 2.4GHz channels,
+// or the 802.11a rates on 5GHz channels.
+func (h *Handle) SupportedRates() []gofi.DataRate {
+	return gofi.LegacyRates(h.Channel().Band())
+}
+
+// BandCapabilities returns the capabilities of a simulated
+// single-stream 802.11n device.
+func (h *Handle) BandCapabilities() []gofi.BandCapabilities {
+	htMCS := []int{0, 1, 2, 3, 4, 5, 6, 7}
+	return []gofi.BandCapabilities{
+		{Band: gofi.Band2GHz, Rates: gofi.LegacyRates(gofi.Band2GHz), HTMCS: htMCS},
+		{Band: gofi.Band5GHz, Rates: gofi.LegacyRates(gofi.Band5GHz), HTMCS: htMCS},
+	}
+}
+
+// SupportedChannels returns the 2.4GHz channels and the
+// non-DFS 5GHz channels.
+func (h *Handle) SupportedChannels() []gofi.Channel {
+	var res []gofi.Channel
+	for _, num := range supportedChannelNumbers() {
+		res = append(res, gofi.Channel{Number: num, Width: gofi.ChannelWidth20MHz})
+	}
+	return res
+}
+
+func (h *Handle) Channel() gofi.Channel {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.channel
+}
+
+func (h *Handle) SetChannel(c gofi.Channel) error {
+	if c.Width == gofi.ChannelWidthUnspecified {
+		c.Width = gofi.ChannelWidth20MHz
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.closed {
+		return gofi.ErrClosed
+	}
+	for _, num := range supportedChannelNumbers() {
+		if num == c.Number && c.Width == gofi.ChannelWidth20MHz {
+			h.channel = c
+			return nil
+		}
+	}
+	return errors.New("unknown channel")
+}
+
+func (h *Handle) Receive() (gofi.Frame, *gofi.RadioInfo, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for len(h.queue) == 0 && !h.closed {
+		h.cond.Wait()
+	}
+	if h.closed {
+		return nil, nil, gofi.ErrClosed
+	}
+	packet := h.queue[0]
+	h.queue[0] = gofi.RadioPacket{}
+	h.queue = h.queue[1:]
+	return packet.Frame, packet.RadioInfo, nil
+}
+
+func (h *Handle) Send(f gofi.Frame, r gofi.DataRate) error {
+	_, err := h.SendWithStatus(f, r)
+	return err
+}
+
+// SendWithStatus sends a frame and reports if it was acknowledged.
+//
+// Unicast frames are acknowledged by the Handle whose address
+// matches the frame's receiver address, if that Handle is tuned
+// to the sender's channel and the frame is not lost.
+func (h *Handle) SendWithStatus(f gofi.Frame, r gofi.DataRate) (*gofi.TxReport, error) {
+	if r == 0 {
+		r = h.SupportedRates()[0]
+	}
+	h.lock.Lock()
+	closed := h.closed
+	h.lock.Unlock()
+	if closed {
+		return nil, gofi.ErrClosed
+	}
+	if len(f) < 4 {
+		return nil, gofi.ErrBufferUnderflow
+	}
+	return h.medium.transmit(h, append(gofi.Frame{}, f...), r), nil
+}
+
+// Info describes the simulated device.
+// The Interface field is set to the Handle's MAC address.
+func (h *Handle) Info() gofi.HandleInfo {
+	return gofi.HandleInfo{
+		Interface:    h.addr.String(),
+		DataLinkType: gofi.DLTIEEE802_11Radio,
+		Mode:         gofi.ModeMonitor,
+		Channel:      h.Channel(),
+		TxStatus:     true,
+	}
+}
+
+func (h *Handle) Close() {
+	h.medium.removeHandle(h)
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.closed = true
+	h.queue = nil
+	h.delayed = nil
+	h.cond.Broadcast()
+}
+
+// enqueue adds a transmission to the receive queue if the Handle is
+// able to hear it, returning true if the Handle received it.
+//
+// If delay is true, the frame is queued after the next frame which
+// the Handle receives instead, or after MaxReorderDelay if no frame
+// arrives before then.
+func (h *Handle) enqueue(tx transmission, f gofi.Frame, delay bool) bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.closed || h.channel.Number != tx.channel.Number {
+		return false
+	}
+	info := &gofi.RadioInfo{
+		Frequency:   tx.channel.Frequency(),
+		NoisePower:  NoisePower,
+		SignalPower: tx.signal,
+		Rate:        tx.rate,
+		RateInfo:    gofi.RateInfo{PHY: gofi.PHYLegacy, Legacy: tx.rate},
+	}
+	packet := gofi.RadioPacket{
+		Frame:     append(gofi.Frame{}, f...),
+		RadioInfo: info,
+	}
+	if delay && h.delayed == nil {
+		delayed := &packet
+		h.delayed = delayed
+		time.AfterFunc(MaxReorderDelay, func() {
+			h.lock.Lock()
+			defer h.lock.Unlock()
+			if h.delayed == delayed {
+				h.push(*delayed)
+				h.delayed = nil
+			}
+		})
+		return true
+	}
+	h.push(packet)
+	if h.delayed != nil {
+		h.push(*h.delayed)
+		h.delayed = nil
+	}
+	return true
+}
+
+// push adds a packet to the receive queue, unless it is full.
+// The caller must hold h.lock.
+func (h *Handle) push(packet gofi.RadioPacket) {
+	if len(h.queue) < QueueSize {
+		h.queue = append(h.queue, packet)
+		h.cond.Signal()
+	}
+}
+
+func supportedChannelNumbers() []int {
+	return []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 36, 40, 44, 48,
+		149, 153, 157, 161, 165}
+}
+
+func putChecksum(f gofi.Frame) {
+	checksum := crc32.ChecksumIEEE(f[:len(f)-4])
+	binary.LittleEndian.PutUint32(f[len(f)-4:], checksum)
+}
@@ -0,0 +1,181 @@
+// Package sim provides simulated gofi Handles which share a virtual
+// wireless medium, making it possible to test code that uses gofi
+// without any WiFi hardware.
+package sim
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/unixpickle/gofi"
+)
+
+// DefaultRetryLimit is the default number of times that an
+// unacknowledged unicast frame is retransmitted.
+const DefaultRetryLimit = 6
+
+// A Medium connects simulated Handles.
+//
+// Frames sent by a Handle are delivered to every other open Handle
+// which is tuned to the same channel number.
+type Medium struct {
+	lock        sync.Mutex
+	handles     []*Handle
+	lossRate    float64
+	reorderRate float64
+	retryLimit  int
+	random      *rand.Rand
+}
+
+// NewMedium creates a lossless Medium with no Handles.
+func NewMedium() *Medium {
+	return &Medium{
+		retryLimit: DefaultRetryLimit,
+		random:     rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// SetLossRate sets the probability, between 0 and 1, that any given
+// transmission is not received by any given Handle.
+// Acknowledgements are subject to the same probability of loss.
+func (m *Medium) SetLossRate(p float64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.lossRate = p
+}
+
+// SetReorderRate sets the probability, between 0 and 1, that any
+// given Handle holds back a frame it receives until after the next
+// frame it receives.
+// Frames are held back for at most MaxReorderDelay.
+func (m *Medium) SetReorderRate(p float64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.reorderRate = p
+}
+
+// SetRetryLimit sets the number of times that an unacknowledged
+// unicast frame is retransmitted.
+func (m *Medium) SetRetryLimit(n int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.retryLimit = n
+}
+
+// SetSeed seeds the random number generator used to simulate loss
+// and reordering, making a simulation reproducible.
+func (m *Medium) SetSeed(seed int64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.random = rand.New(rand.NewSource(seed))
+}
+
+// NewHandle creates a Handle on the medium with the given MAC address.
+// The MAC address determines which frames the Handle acknowledges.
+//
+// The new Handle is tuned to channel 1.
+func (m *Medium) NewHandle(addr net.HardwareAddr) *Handle {
+	h := &Handle{
+		medium:      m,
+		addr:        append(net.HardwareAddr{}, addr...),
+		channel:     gofi.Channel{Number: 1, Width: gofi.ChannelWidth20MHz},
+		signalPower: DefaultSignalPower,
+	}
+	h.cond = sync.NewCond(&h.lock)
+	m.lock.Lock()
+	m.handles = append(m.handles, h)
+	m.lock.Unlock()
+	return h
+}
+
+// transmit sends a frame from a Handle, retransmitting it as needed.
+func (m *Medium) transmit(sender *Handle, f gofi.Frame, r gofi.DataRate) *gofi.TxReport {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	sender.lock.Lock()
+	tx := transmission{channel: sender.channel, signal: sender.signalPower, rate: r}
+	sender.lock.Unlock()
+
+	report := &gofi.TxReport{Rate: r}
+	if !solicitsAck(f) {
+		m.deliver(sender, tx, f)
+		return report
+	}
+
+	for attempt := 0; attempt <= m.retryLimit; attempt++ {
+		if attempt > 0 {
+			f = retransmission(f)
+		}
+		report.Retries = attempt
+		if receiver := m.deliver(sender, tx, f); receiver != nil {
+			if m.random.Float64() >= m.lossRate {
+				report.Acked = true
+				break
+			}
+		}
+	}
+	return report
+}
+
+// deliver delivers one transmission and returns the Handle which was
+// addressed by the frame, if that Handle received it.
+// The caller must hold m.lock.
+func (m *Medium) deliver(sender *Handle, tx transmission, f gofi.Frame) *Handle {
+	var addressed *Handle
+	for _, h := range m.handles {
+		if h == sender || m.random.Float64() < m.lossRate {
+			continue
+		}
+		delay := m.reorderRate > 0 && m.random.Float64() < m.reorderRate
+		if h.enqueue(tx, f, delay) && len(f) >= 10 && string(f[4:10]) == string(h.addr) {
+			addressed = h
+		}
+	}
+	return addressed
+}
+
+func (m *Medium) removeHandle(h *Handle) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for i, x := range m.handles {
+		if x == h {
+			m.handles = append(m.handles[:i], m.handles[i+1:]...)
+			return
+		}
+	}
+}
+
+// A transmission describes how a frame was sent.
+type transmission struct {
+	channel gofi.Channel
+	signal  int
+	rate    gofi.DataRate
+}
+
+// solicitsAck checks if a frame is an individually addressed
+// management or data frame, which receivers must acknowledge.
+func solicitsAck(f gofi.Frame) bool {
+	if len(f) < 10 {
+		return false
+	}
+	frameType := (f[0] >> 2) & 3
+	if frameType != 0 && frameType != 2 {
+		return false
+	}
+	return (f[4] & 1) == 0
+}
+
+// retransmission creates a copy of a frame with the Retry flag set
+// and an updated checksum.
+func retransmission(f gofi.Frame) gofi.Frame {
+	res := append(gofi.Frame{}, f...)
+	if len(res) < 4 {
+		return res
+	}
+	res[1] |= 0x08
+	putChecksum(res)
+	return res
+}
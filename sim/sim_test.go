@@ -0,0 +1,253 @@
+package sim
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/unixpickle/gofi"
+)
+
+var (
+	testAddr1 = net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	testAddr2 = net.HardwareAddr{0x02, 0, 0, 0, 0, 2}
+	testAddr3 = net.HardwareAddr{0x02, 0, 0, 0, 0, 3}
+)
+
+func TestDelivery(t *testing.T) {
+	medium := NewMedium()
+	h1 := medium.NewHandle(testAddr1)
+	h2 := medium.NewHandle(testAddr2)
+	defer h1.Close()
+	defer h2.Close()
+
+	frame := testDataFrame(testAddr2, testAddr1)
+	if err := h1.Send(frame, 0); err != nil {
+		t.Fatal("could not send packet:", err)
+	}
+	received, info, err := h2.Receive()
+	if err != nil {
+		t.Fatal("could not receive packet:", err)
+	}
+	if !bytes.Equal(received, frame) {
+		t.Error("unexpected frame:", received)
+	}
+	if info.Frequency != 2412 || info.SignalPower != DefaultSignalPower || info.Rate != 2 {
+		t.Errorf("unexpected radio info: %+v", *info)
+	}
+}
+
+func TestChannelIsolation(t *testing.T) {
+	medium := NewMedium()
+	h1 := medium.NewHandle(testAddr1)
+	h2 := medium.NewHandle(testAddr2)
+	h3 := medium.NewHandle(testAddr3)
+	defer h1.Close()
+	defer h2.Close()
+	defer h3.Close()
+
+	if err := h3.SetChannel(gofi.Channel{Number: 6}); err != nil {
+		t.Fatal("could not set channel:", err)
+	}
+	if err := h3.SetChannel(gofi.Channel{Number: 99}); err == nil {
+		t.Error("set an invalid channel")
+	}
+
+	if err := h1.Send(testDataFrame(testAddr3, testAddr1), 0); err != nil {
+		t.Fatal("could not send packet:", err)
+	}
+	if _, _, err := h2.Receive(); err != nil {
+		t.Fatal("could not receive packet:", err)
+	}
+	if len(h3.queue) != 0 {
+		t.Error("packet crossed channels")
+	}
+}
+
+func TestTxStatus(t *testing.T) {
+	medium := NewMedium()
+	h1 := medium.NewHandle(testAddr1)
+	h2 := medium.NewHandle(testAddr2)
+	defer h1.Close()
+	defer h2.Close()
+
+	var handle gofi.TxStatusHandle = h1
+
+	report, err := handle.SendWithStatus(testDataFrame(testAddr2, testAddr1), 4)
+	if err != nil {
+		t.Fatal("could not send packet:", err)
+	}
+	if !report.Acked || report.Retries != 0 || report.Rate != 4 {
+		t.Errorf("unexpected report: %+v", *report)
+	}
+
+	report, err = handle.SendWithStatus(testDataFrame(testAddr3, testAddr1), 0)
+	if err != nil {
+		t.Fatal("could not send packet:", err)
+	}
+	if report.Acked || report.Retries != DefaultRetryLimit {
+		t.Errorf("unexpected report for absent receiver: %+v", *report)
+	}
+
+	broadcast := net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	report, err = handle.SendWithStatus(testDataFrame(broadcast, testAddr1), 0)
+	if err != nil {
+		t.Fatal("could not send packet:", err)
+	}
+	if report.Acked || report.Retries != 0 {
+		t.Errorf("unexpected report for broadcast: %+v", *report)
+	}
+}
+
+func TestTxStatusLoss(t *testing.T) {
+	medium := NewMedium()
+	medium.SetSeed(1337)
+	medium.SetLossRate(0.5)
+	h1 := medium.NewHandle(testAddr1)
+	h2 := medium.NewHandle(testAddr2)
+	defer h1.Close()
+	defer h2.Close()
+
+	var retried, acked int
+	for i := 0; i < 100; i++ {
+		report, err := h1.SendWithStatus(testDataFrame(testAddr2, testAddr1), 0)
+		if err != nil {
+			t.Fatal("could not send packet:", err)
+		}
+		if report.Acked {
+			acked++
+		}
+		if report.Retries > 0 {
+			retried++
+		}
+	}
+	if acked == 0 || retried == 0 {
+		t.Errorf("expected some retries and some ACKs (got %d and %d)", retried, acked)
+	}
+
+	for len(h2.queue) > 0 {
+		frame, _, _ := h2.Receive()
+		if (frame[1]&0x08) != 0 && bytes.Equal(frame[:1], []byte{0x08}) {
+			return
+		}
+	}
+	t.Error("no retransmissions were received")
+}
+
+func TestReorder(t *testing.T) {
+	medium := NewMedium()
+	medium.SetReorderRate(1)
+	h1 := medium.NewHandle(testAddr1)
+	h2 := medium.NewHandle(testAddr2)
+	defer h1.Close()
+	defer h2.Close()
+
+	var frames []gofi.Frame
+	for i := 0; i < 4; i++ {
+		frame := testDataFrame(testAddr2, testAddr1)
+		frame[22] = byte(i << 4)
+		frame.SetChecksum()
+		frames = append(frames, frame)
+		if err := h1.Send(frame, 0); err != nil {
+			t.Fatal("could not send packet:", err)
+		}
+	}
+	for _, i := range []int{1, 0, 3, 2} {
+		frame, _, err := h2.Receive()
+		if err != nil {
+			t.Fatal("could not receive packet:", err)
+		}
+		if !bytes.Equal(frame, frames[i]) {
+			t.Errorf("expected frame %d but got %x", i, frame)
+		}
+	}
+}
+
+func TestBandRates(t *testing.T) {
+	medium := NewMedium()
+	h1 := medium.NewHandle(testAddr1)
+	h2 := medium.NewHandle(testAddr2)
+	defer h1.Close()
+	defer h2.Close()
+
+	for _, h := range []*Handle{h1, h2} {
+		if err := h.SetChannel(gofi.Channel{Number: 36}); err != nil {
+			t.Fatal("could not set channel:", err)
+		}
+	}
+	if rates := h1.SupportedRates(); rates[0] != 12 {
+		t.Error("unexpected 5GHz rates:", rates)
+	}
+	if err := h1.Send(testDataFrame(testAddr2, testAddr1), 0); err != nil {
+		t.Fatal("could not send packet:", err)
+	}
+	_, info, err := h2.Receive()
+	if err != nil {
+		t.Fatal("could not receive packet:", err)
+	}
+	if info.Rate != 12 || info.Frequency != 5180 {
+		t.Errorf("unexpected radio info: %+v", *info)
+	}
+}
+
+func TestInfo(t *testing.T) {
+	medium := NewMedium()
+	h := medium.NewHandle(testAddr1)
+	defer h.Close()
+
+	var handle gofi.Handle = h
+	info := handle.Info()
+	if info.Interface != testAddr1.String() || info.Mode != gofi.ModeMonitor ||
+		info.DataLinkType != gofi.DLTIEEE802_11Radio || info.Channel.Number != 1 {
+		t.Errorf("unexpected info: %+v", info)
+	}
+}
+
+func TestSendBatch(t *testing.T) {
+	medium := NewMedium()
+	h1 := medium.NewHandle(testAddr1)
+	h2 := medium.NewHandle(testAddr2)
+	defer h2.Close()
+
+	frames := []gofi.Frame{testDataFrame(testAddr2, testAddr1), testDataFrame(testAddr3, testAddr1)}
+	if errs := gofi.SendBatch(h1, frames, 0); errs != nil {
+		t.Fatal("unexpected errors:", errs)
+	}
+	if _, _, err := h2.Receive(); err != nil {
+		t.Fatal("could not receive packet:", err)
+	}
+
+	h1.Close()
+	errs := gofi.SendBatch(h1, frames, 0)
+	if len(errs) != 2 || errs[0] != gofi.ErrClosed || errs[1] != gofi.ErrClosed {
+		t.Error("unexpected errors:", errs)
+	}
+}
+
+func TestCloseReceive(t *testing.T) {
+	medium := NewMedium()
+	h := medium.NewHandle(testAddr1)
+	go func() {
+		time.Sleep(time.Millisecond * 10)
+		h.Close()
+	}()
+	if _, _, err := h.Receive(); err != gofi.ErrClosed {
+		t.Error("unexpected error:", err)
+	}
+	if err := h.Send(testDataFrame(testAddr2, testAddr1), 0); err != gofi.ErrClosed {
+		t.Error("unexpected error:", err)
+	}
+}
+
+func testDataFrame(to, from net.HardwareAddr) gofi.Frame {
+	frame := gofi.Frame{0x08, 0, 0, 0}
+	frame = append(frame, to...)
+	frame = append(frame, from...)
+	frame = append(frame, from...)
+	frame = append(frame, 0, 0)
+	frame = append(frame, []byte("hello")...)
+	frame = append(frame, 0, 0, 0, 0)
+	putChecksum(frame)
+	return frame
+}
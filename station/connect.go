@@ -0,0 +1,355 @@
+package station
+
+import (
+	"net"
+	"time"
+
+	"github.com/unixpickle/gofi"
+	"github.com/unixpickle/gofi/crypto"
+	"github.com/unixpickle/gofi/mgmt"
+)
+
+// pendingSize is the number of responses from the BSS which are
+// buffered during a connection attempt.
+const pendingSize = 16
+
+// Connect authenticates and associates with a BSS from a scan.
+//
+// Open networks are joined with open system authentication, and
+// WPA3-Personal networks with SAE authentication using the password,
+// followed by the 4-way handshake.
+// The password is ignored for open networks.
+//
+// If the BSS hides its SSID, the SSID field of bss must be filled in
+// before connecting.
+func (s *Station) Connect(bss *BSS, password string) error {
+	s.opLock.Lock()
+	defer s.opLock.Unlock()
+
+	s.lock.Lock()
+	connected := s.connected
+	s.lock.Unlock()
+	if connected {
+		return ErrConnected
+	}
+
+	useSAE, err := usesSAE(bss)
+	if err != nil {
+		return err
+	}
+	if useSAE && password == "" {
+		return ErrNoPassword
+	}
+	if bss.Channel.Number != 0 {
+		if err := s.handle.SetChannel(bss.Channel); err != nil {
+			return err
+		}
+	}
+	rates, err := s.rates(bss)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	s.pending = make(chan gofi.Frame, pendingSize)
+	s.pendingBSSID = bss.BSSID
+	s.lock.Unlock()
+	defer func() {
+		s.lock.Lock()
+		s.pending = nil
+		s.pendingBSSID = nil
+		s.lock.Unlock()
+	}()
+
+	var pmk []byte
+	var rsn *gofi.Element
+	if useSAE {
+		pmk, err = s.authenticateSAE(bss, password)
+		rsn = rsnElement(bss)
+	} else {
+		err = s.authenticateOpen(bss)
+	}
+	if err != nil {
+		return err
+	}
+	aid, err := s.associate(bss, rates, rsn)
+	var k *keys
+	if err == nil && useSAE {
+		k, err = s.handshake(bss, pmk, rsn)
+	}
+	if err != nil {
+		// The BSS may still think that the station is authenticated.
+		s.sendManagement(gofi.SubtypeDeauth, bss.BSSID, mgmt.EncodeReason(mgmt.ReasonDeauthLeaving))
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.connected = true
+	s.bss = *bss
+	s.bss.BSSID = append(net.HardwareAddr{}, bss.BSSID...)
+	s.aid = aid
+	s.pmk = pmk
+	s.keys = k
+	s.lastBeacon = time.Now()
+	s.lastSequence = -1
+	s.received = nil
+	s.events = append(s.events, Event{Type: EventConnected, BSS: s.bss})
+	s.cond.Broadcast()
+	return nil
+}
+
+func (s *Station) authenticateOpen(bss *BSS) error {
+	request := func() gofi.Frame {
+		return s.authFrame(bss, &mgmt.Auth{Algorithm: mgmt.AuthOpen, Sequence: 1})
+	}
+	return s.exchange(request, func(f gofi.Frame) (bool, error) {
+		auth := parseAuth(f, mgmt.AuthOpen, 2)
+		if auth == nil {
+			return false, nil
+		}
+		return true, checkStatus("authentication", auth.Status)
+	})
+}
+
+func (s *Station) authenticateSAE(bss *BSS, password string) ([]byte, error) {
+	sae, err := crypto.NewSAE(password, s.config.Address, bss.BSSID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The BSS may ask for an anti-clogging token once, after which
+	// the commit is sent again with the token.
+	var token []byte
+	for attempt := 0; attempt < 2; attempt++ {
+		var newToken []byte
+		commit := func() gofi.Frame {
+			return s.authFrame(bss, &mgmt.Auth{
+				Algorithm: mgmt.AuthSAE,
+				Sequence:  1,
+				Data:      sae.Commit(token),
+			})
+		}
+		err := s.exchange(commit, func(f gofi.Frame) (bool, error) {
+			auth := parseAuth(f, mgmt.AuthSAE, 1)
+			if auth == nil {
+				return false, nil
+			}
+			switch auth.Status {
+			case mgmt.StatusSuccess:
+				return true, sae.HandleCommit(auth.Data)
+			case mgmt.StatusAntiCloggingToken:
+				if attempt > 0 || len(auth.Data) <= 2 {
+					return true, checkStatus("authentication", auth.Status)
+				}
+				// The token follows the finite cyclic group.
+				newToken = auth.Data[2:]
+				return true, nil
+			default:
+				return true, checkStatus("authentication", auth.Status)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+		if newToken == nil {
+			break
+		}
+		token = newToken
+	}
+
+	confirm := func() gofi.Frame {
+		return s.authFrame(bss, &mgmt.Auth{
+			Algorithm: mgmt.AuthSAE,
+			Sequence:  2,
+			Data:      sae.Confirm(),
+		})
+	}
+	err = s.exchange(confirm, func(f gofi.Frame) (bool, error) {
+		auth := parseAuth(f, mgmt.AuthSAE, 2)
+		if auth == nil {
+			return false, nil
+		}
+		if err := checkStatus("authentication", auth.Status); err != nil {
+			return true, err
+		}
+		return true, sae.HandleConfirm(auth.Data)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sae.PMK(), nil
+}
+
+// rsnElement creates the RSN element which the station sends to an
+// SAE BSS.
+func rsnElement(bss *BSS) *gofi.Element {
+	rsn := &crypto.RSNInfo{
+		GroupCipher:     bss.RSN.GroupCipher,
+		PairwiseCiphers: []crypto.Cipher{crypto.CipherCCMP128},
+		AKMs:            []crypto.AKM{crypto.AKMSAE},
+
+		// WPA3-Personal requires management frame protection to be
+		// possible.
+		Capabilities:       crypto.RSNCapMFPCapable | bss.RSN.Capabilities&crypto.RSNCapMFPRequired,
+		HasCapabilities:    true,
+		GroupMgmtCipher:    bss.RSN.GroupMgmtCipher,
+		HasGroupMgmtCipher: bss.RSN.HasGroupMgmtCipher,
+	}
+	return &gofi.Element{ID: gofi.ElementRSN, Data: rsn.Encode()}
+}
+
+// associate associates with a BSS, sending an RSN element if rsn is
+// non-nil.
+func (s *Station) associate(bss *BSS, rates []gofi.DataRate, rsn *gofi.Element) (int, error) {
+	capability := mgmt.CapESS | bss.Capability&(mgmt.CapShortPreamble|mgmt.CapShortSlotTime)
+	elements := []gofi.Element{mgmt.SSIDElement(bss.SSID)}
+	elements = append(elements, mgmt.RateElements(rates, nil)...)
+	if rsn != nil {
+		capability |= mgmt.CapPrivacy
+		elements = append(elements, *rsn)
+	}
+	request := &mgmt.AssocRequest{
+		Capability:     capability,
+		ListenInterval: s.config.ListenInterval,
+		Elements:       elements,
+	}
+
+	var aid int
+	err := s.exchange(func() gofi.Frame {
+		return mgmt.NewFrame(gofi.SubtypeAssocRequest, bss.BSSID, s.config.Address, bss.BSSID,
+			request.Encode())
+	}, func(f gofi.Frame) (bool, error) {
+		body, err := mgmt.Body(f, gofi.SubtypeAssocResponse)
+		if err != nil {
+			return false, nil
+		}
+		resp, err := mgmt.ParseAssocResponse(body)
+		if err != nil {
+			return false, nil
+		}
+		aid = resp.AID
+		return true, checkStatus("association", resp.Status)
+	})
+	return aid, err
+}
+
+// exchange sends requests to the BSS until accept takes a response,
+// retrying after each timeout.
+// If accept returns an error, the exchange stops with that error.
+//
+// When request returns nil, nothing is sent and the exchange only
+// waits for the BSS.
+func (s *Station) exchange(request func() gofi.Frame, accept func(f gofi.Frame) (bool, error)) error {
+	for i := 0; i < s.config.Retries; i++ {
+		if frame := request(); frame != nil {
+			if err := s.send(frame); err != nil {
+				return err
+			}
+		}
+		timeout := time.NewTimer(s.config.ResponseTimeout)
+		for waiting := true; waiting; {
+			select {
+			case <-s.done:
+				timeout.Stop()
+				return gofi.ErrClosed
+			case <-timeout.C:
+				waiting = false
+			case f := <-s.pending:
+				if f.Type() == gofi.FrameTypeManagement && f.Subtype() == gofi.SubtypeDeauth {
+					timeout.Stop()
+					return ErrDeauthenticated
+				}
+				if ok, err := accept(f); ok || err != nil {
+					timeout.Stop()
+					return err
+				}
+			}
+		}
+	}
+	return ErrTimeout
+}
+
+func (s *Station) authFrame(bss *BSS, auth *mgmt.Auth) gofi.Frame {
+	return mgmt.NewFrame(gofi.SubtypeAuth, bss.BSSID, s.config.Address, bss.BSSID, auth.Encode())
+}
+
+// rates returns the rates which both the Handle and the BSS support,
+// making sure that the Handle supports every basic rate.
+func (s *Station) rates(bss *BSS) ([]gofi.DataRate, error) {
+	supported := s.handle.SupportedRates()
+	for _, r := range bss.BasicRates {
+		if !containsRate(supported, r) {
+			return nil, ErrUnsupportedRates
+		}
+	}
+	if len(bss.Rates) == 0 {
+		return supported, nil
+	}
+	var res []gofi.DataRate
+	for _, r := range supported {
+		if containsRate(bss.Rates, r) {
+			res = append(res, r)
+		}
+	}
+	if len(res) == 0 {
+		return nil, ErrUnsupportedRates
+	}
+	return res, nil
+}
+
+// usesSAE checks if a BSS is joined with SAE rather than open system
+// authentication.
+func usesSAE(bss *BSS) (bool, error) {
+	if bss.RSN == nil {
+		if bss.Capability&mgmt.CapPrivacy != 0 {
+			// NOTE: this is WEP or WPA without an RSN element.
+			return false, ErrUnsupportedSecurity
+		}
+		return false, nil
+	}
+	if !supportedGroupCipher(bss.RSN.GroupCipher) {
+		return false, ErrUnsupportedSecurity
+	}
+	for _, akm := range bss.RSN.AKMs {
+		if akm != crypto.AKMSAE {
+			continue
+		}
+		for _, c := range bss.RSN.PairwiseCiphers {
+			if c == crypto.CipherCCMP128 {
+				return true, nil
+			}
+		}
+	}
+	return false, ErrUnsupportedSecurity
+}
+
+// parseAuth decodes an authentication frame, or returns nil if it is
+// not the expected step of an algorithm.
+func parseAuth(f gofi.Frame, algorithm, sequence uint16) *mgmt.Auth {
+	body, err := mgmt.Body(f, gofi.SubtypeAuth)
+	if err != nil {
+		return nil
+	}
+	auth, err := mgmt.ParseAuth(body)
+	if err != nil || auth.Algorithm != algorithm || auth.Sequence != sequence {
+		return nil
+	}
+	return auth
+}
+
+func checkStatus(op string, status uint16) error {
+	if status == mgmt.StatusSuccess {
+		return nil
+	}
+	return &StatusError{Op: op, Status: int(status)}
+}
+
+func containsRate(rates []gofi.DataRate, r gofi.DataRate) bool {
+	for _, x := range rates {
+		if x == r {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,318 @@
+package station
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"net"
+
+	"github.com/unixpickle/gofi"
+	"github.com/unixpickle/gofi/crypto"
+	"github.com/unixpickle/gofi/eapol"
+	"github.com/unixpickle/gofi/ether"
+)
+
+// eapolVersion is the protocol version of sent EAPOL packets.
+const eapolVersion = 2
+
+// nonceSize is the size of the nonces of the 4-way handshake.
+const nonceSize = 32
+
+// mgmtReplayIndex is the index in keys.rxPN of the packet number of
+// management frames, after those of the 16 TIDs.
+const mgmtReplayIndex = 16
+
+// keys holds the keys from the 4-way handshake with a BSS, along with
+// the packet numbers which go with them.
+//
+// The pairwise cipher is always CCMP-128.
+type keys struct {
+	ptk         *crypto.PTK
+	groupCipher crypto.Cipher
+	gtks        map[int]*groupKey
+
+	// mfp is set if management frames are protected.
+	mfp bool
+
+	anonce []byte
+	snonce []byte
+
+	// replayCounter is the replay counter of the latest EAPOL-Key
+	// packet from the BSS.
+	replayCounter uint64
+
+	txPN uint64
+	rxPN [mgmtReplayIndex + 1]uint64
+}
+
+// A groupKey is a GTK and the latest packet number received with it.
+type groupKey struct {
+	key  []byte
+	rxPN uint64
+}
+
+// handshake runs the 4-way handshake after associating with an SAE
+// BSS, where rsn is the RSN element of the association request.
+func (s *Station) handshake(bss *BSS, pmk []byte, rsn *gofi.Element) (*keys, error) {
+	res := &keys{
+		groupCipher: bss.RSN.GroupCipher,
+		gtks:        map[int]*groupKey{},
+		mfp:         bss.RSN.Capabilities&crypto.RSNCapMFPCapable != 0,
+		snonce:      make([]byte, nonceSize),
+	}
+	if _, err := rand.Read(res.snonce); err != nil {
+		return nil, err
+	}
+	beaconRSN := gofi.FindElement(bss.Elements, gofi.ElementRSN)
+
+	// The BSS starts the handshake, and retransmits message 1 itself
+	// until it gets message 2.
+	var message2 gofi.Frame
+	request := func() gofi.Frame {
+		return message2
+	}
+	err := s.exchange(request, func(f gofi.Frame) (bool, error) {
+		packet, key := parseKey(f)
+		if key == nil || key.Descriptor != eapol.DescriptorRSN || key.Info.Version() != 0 {
+			return false, nil
+		}
+		switch key.Message() {
+		case eapol.KeyMessage1:
+			if res.ptk != nil && key.ReplayCounter <= res.replayCounter {
+				return false, nil
+			}
+			res.anonce = append([]byte{}, key.Nonce...)
+			res.replayCounter = key.ReplayCounter
+			res.ptk = crypto.DerivePTK(pmk, crypto.AKMSAE, crypto.CipherCCMP128, bss.BSSID,
+				s.config.Address, res.anonce, res.snonce)
+			message2 = s.eapolFrame(bss.BSSID, res.encodeKey(&eapol.Key{
+				Descriptor:    eapol.DescriptorRSN,
+				Info:          eapol.KeyInfoPairwise | eapol.KeyInfoMIC,
+				ReplayCounter: key.ReplayCounter,
+				Nonce:         res.snonce,
+				Data:          gofi.EncodeElements([]gofi.Element{*rsn}),
+			}))
+			return false, s.send(message2)
+		case eapol.KeyMessage3:
+			if res.ptk == nil || !bytes.Equal(key.Nonce, res.anonce) || !res.checkKey(packet, key) {
+				return false, nil
+			}
+			elements, kdes, err := res.keyData(key)
+			if err != nil {
+				return true, ErrHandshake
+			}
+			// NOTE: an RSN element which differs from the beacons
+			// means that the beacons were forged to downgrade the
+			// security of the connection.
+			e := gofi.FindElement(elements, gofi.ElementRSN)
+			if e == nil || beaconRSN == nil || !bytes.Equal(e.Data, beaconRSN.Data) {
+				return true, ErrHandshake
+			}
+			if !res.installGTK(kdes, key.RSC) {
+				return true, ErrHandshake
+			}
+			return true, nil
+		}
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The BSS installs the PTK once it gets message 4, so it is sent
+	// unprotected.
+	if err := s.send(s.eapolFrame(bss.BSSID, res.message4())); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// handleKey handles an EAPOL-Key packet after the 4-way handshake,
+// returning the response to send, if any.
+// The caller must hold s.lock.
+func (s *Station) handleKey(f gofi.Frame) gofi.Frame {
+	packet, key := parseKey(f)
+	if key == nil || key.Descriptor != eapol.DescriptorRSN || !s.keys.checkKey(packet, key) {
+		return nil
+	}
+	switch key.Message() {
+	case eapol.KeyMessage3:
+		// The BSS did not get message 4.
+		// NOTE: the keys are not installed again, since that would
+		// reset their packet numbers.
+		if !bytes.Equal(key.Nonce, s.keys.anonce) {
+			return nil
+		}
+		return s.eapolFrame(s.bss.BSSID, s.keys.message4())
+	case eapol.KeyMessageGroup1:
+		_, kdes, err := s.keys.keyData(key)
+		if err != nil || !s.keys.installGTK(kdes, key.RSC) {
+			return nil
+		}
+		return s.eapolFrame(s.bss.BSSID, s.keys.encodeKey(&eapol.Key{
+			Descriptor:    eapol.DescriptorRSN,
+			Info:          eapol.KeyInfoMIC | eapol.KeyInfoSecure,
+			ReplayCounter: s.keys.replayCounter,
+		}))
+	}
+	return nil
+}
+
+// eapolFrame creates a data frame which carries an EAPOL packet to the
+// BSS.
+func (s *Station) eapolFrame(bssid net.HardwareAddr, packet []byte) gofi.Frame {
+	eth := append(append([]byte{}, bssid...), s.config.Address...)
+	eth = append(eth, 0x88, 0x8e)
+	frame, _ := ether.FromEthernet(append(eth, packet...), bssid, ether.DirectionToAP)
+	return frame
+}
+
+// parseKey decodes the EAPOL-Key packet in an unprotected data frame,
+// or returns nil if the frame carries no such packet.
+func parseKey(f gofi.Frame) (*eapol.Packet, *eapol.Key) {
+	packet, err := eapol.ParseFrame(f)
+	if err != nil {
+		return nil, nil
+	}
+	key, err := packet.Key()
+	if err != nil {
+		return nil, nil
+	}
+	return packet, key
+}
+
+// message4 encodes message 4 of the 4-way handshake, in response to
+// the latest message 3.
+func (k *keys) message4() []byte {
+	return k.encodeKey(&eapol.Key{
+		Descriptor:    eapol.DescriptorRSN,
+		Info:          eapol.KeyInfoPairwise | eapol.KeyInfoMIC | eapol.KeyInfoSecure,
+		ReplayCounter: k.replayCounter,
+	})
+}
+
+// encodeKey encodes an EAPOL-Key packet and sets its MIC.
+func (k *keys) encodeKey(key *eapol.Key) []byte {
+	key.MIC = nil
+	packet := &eapol.Packet{Version: eapolVersion, Type: eapol.TypeKey, Body: key.Encode()}
+	key.MIC = crypto.KeyMIC(key.Info.Version(), crypto.AKMSAE, k.ptk.KCK, packet.Encode())
+	packet.Body = key.Encode()
+	return packet.Encode()
+}
+
+// checkKey checks the MIC and the replay counter of an EAPOL-Key
+// packet from the BSS, and records its replay counter.
+func (k *keys) checkKey(packet *eapol.Packet, key *eapol.Key) bool {
+	if !key.Info.Has(eapol.KeyInfoMIC) || key.ReplayCounter <= k.replayCounter {
+		return false
+	}
+	zeroed := *key
+	zeroed.MIC = make([]byte, len(key.MIC))
+	unsigned := &eapol.Packet{Version: packet.Version, Type: eapol.TypeKey, Body: zeroed.Encode()}
+	mic := crypto.KeyMIC(key.Info.Version(), crypto.AKMSAE, k.ptk.KCK, unsigned.Encode())
+	if !hmac.Equal(mic, key.MIC) {
+		return false
+	}
+	k.replayCounter = key.ReplayCounter
+	return true
+}
+
+// keyData decrypts the key data of an EAPOL-Key packet, returning its
+// elements and KDEs.
+func (k *keys) keyData(key *eapol.Key) ([]gofi.Element, []eapol.KDE, error) {
+	if !key.Info.Has(eapol.KeyInfoEncryptedData) {
+		return nil, nil, ErrHandshake
+	}
+	data, err := crypto.KeyUnwrap(k.ptk.KEK, key.Data)
+	if err != nil {
+		return nil, nil, err
+	}
+	decrypted := *key
+	decrypted.Data = data
+	elements, err := decrypted.Elements()
+	if err != nil {
+		return nil, nil, err
+	}
+	return elements, decrypted.KDEs(), nil
+}
+
+// installGTK installs the GTK from the GTK KDE of a handshake message,
+// where rsc is the latest packet number which the BSS sent with it.
+// It returns false if there is no valid GTK KDE.
+func (k *keys) installGTK(kdes []eapol.KDE, rsc []byte) bool {
+	for _, kde := range kdes {
+		if !kde.IsStandard() || kde.Type != eapol.KDEGTK ||
+			len(kde.Data) != 2+k.groupCipher.KeySize() {
+			continue
+		}
+		id := int(kde.Data[0] & 3)
+		key := kde.Data[2:]
+		if old, ok := k.gtks[id]; ok && bytes.Equal(old.key, key) {
+			// NOTE: installing the same key again would reset its
+			// packet number, letting old frames be replayed.
+			return true
+		}
+		var pn uint64
+		for i := 5; i >= 0; i-- {
+			pn = pn<<8 | uint64(rsc[i])
+		}
+		k.gtks[id] = &groupKey{key: append([]byte{}, key...), rxPN: pn}
+		return true
+	}
+	return false
+}
+
+// protect encrypts a frame to the BSS with the PTK.
+// Management frames are only encrypted with management frame
+// protection, and nothing is encrypted without keys.
+func (k *keys) protect(f gofi.Frame) (gofi.Frame, error) {
+	if k == nil || (f.Type() == gofi.FrameTypeManagement && !k.mfp) {
+		return f, nil
+	}
+	k.txPN++
+	return crypto.EncryptFrame(crypto.CipherCCMP128, k.ptk.TK, f, k.txPN, 0)
+}
+
+// unprotect decrypts a frame from the BSS, or returns nil if it
+// cannot be decrypted or is a replay.
+func (k *keys) unprotect(f gofi.Frame) gofi.Frame {
+	if k == nil {
+		return nil
+	}
+	pn, keyID, err := crypto.FramePN(f)
+	if err != nil {
+		return nil
+	}
+	c, key := crypto.CipherCCMP128, k.ptk.TK
+	var last *uint64
+	if isGroup(f.Addr1()) {
+		gtk := k.gtks[keyID]
+		if gtk == nil {
+			return nil
+		}
+		c, key, last = k.groupCipher, gtk.key, &gtk.rxPN
+	} else if f.Type() == gofi.FrameTypeManagement {
+		last = &k.rxPN[mgmtReplayIndex]
+	} else {
+		last = &k.rxPN[f.TID()]
+	}
+	if pn <= *last {
+		return nil
+	}
+	res, err := crypto.DecryptFrame(c, key, f)
+	if err != nil {
+		return nil
+	}
+	*last = pn
+	return res
+}
+
+// supportedGroupCipher checks if a group cipher can be used with the
+// packet numbers of unprotect.
+func supportedGroupCipher(c crypto.Cipher) bool {
+	switch c {
+	case crypto.CipherCCMP128, crypto.CipherCCMP256, crypto.CipherGCMP128, crypto.CipherGCMP256:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,217 @@
+package station
+
+import (
+	"bytes"
+	"net"
+	"time"
+
+	"github.com/unixpickle/gofi"
+	"github.com/unixpickle/gofi/crypto"
+	"github.com/unixpickle/gofi/eapol"
+	"github.com/unixpickle/gofi/ether"
+	"github.com/unixpickle/gofi/mgmt"
+)
+
+func (s *Station) receiveLoop() {
+	for {
+		frame, info, err := s.handle.Receive()
+		if err != nil {
+			s.lock.Lock()
+			defer s.lock.Unlock()
+			s.err = err
+			s.cond.Broadcast()
+			return
+		}
+		if info != nil && info.TxStatus != nil {
+			continue
+		}
+		if !frame.ChecksumValid() || bytes.Equal(frame.Addr2(), s.config.Address) {
+			continue
+		}
+		switch frame.Type() {
+		case gofi.FrameTypeManagement:
+			s.handleManagement(frame, info)
+		case gofi.FrameTypeData:
+			s.handleData(frame)
+		}
+	}
+}
+
+func (s *Station) handleManagement(f gofi.Frame, info *gofi.RadioInfo) {
+	switch f.Subtype() {
+	case gofi.SubtypeBeacon, gofi.SubtypeProbeResponse:
+		s.handleBeacon(f, info)
+		return
+	}
+	if !bytes.Equal(f.Addr1(), s.config.Address) && !isGroup(f.Addr1()) {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	switch f.Subtype() {
+	case gofi.SubtypeAuth, gofi.SubtypeAssocResponse, gofi.SubtypeReassocResponse,
+		gofi.SubtypeDeauth:
+		if s.pending != nil && bytes.Equal(f.Addr3(), s.pendingBSSID) {
+			select {
+			case s.pending <- f:
+			default:
+			}
+		}
+	}
+	switch f.Subtype() {
+	case gofi.SubtypeDeauth, gofi.SubtypeDisassoc:
+		if s.connected && bytes.Equal(f.Addr3(), s.bss.BSSID) {
+			if f.Protected() {
+				if f = s.keys.unprotect(f); f == nil {
+					return
+				}
+			}
+			// NOTE: with management frame protection, unprotected
+			// frames should start an SA Query, which is not
+			// implemented, so they are honored anyway.
+			body, err := mgmt.Body(f, f.Subtype())
+			if err != nil {
+				return
+			}
+			if reason, err := mgmt.ParseReason(body); err == nil {
+				s.disconnect(reason, false)
+			}
+		}
+	}
+}
+
+func (s *Station) handleBeacon(f gofi.Frame, info *gofi.RadioInfo) {
+	body, err := mgmt.Body(f, gofi.SubtypeBeacon, gofi.SubtypeProbeResponse)
+	if err != nil {
+		return
+	}
+	beacon, err := mgmt.ParseBeacon(body)
+	if err != nil {
+		return
+	}
+	bss := newBSS(f.Addr3(), beacon, s.handle.Channel(), info)
+	key := bss.BSSID.String()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.scanResults != nil {
+		if old, ok := s.scanResults[key]; ok && bss.SSID == "" {
+			// NOTE: a hidden BSS only reveals its SSID in probe
+			// responses, which its beacons should not erase.
+			bss.SSID = old.SSID
+		}
+		s.scanResults[key] = bss
+	}
+	if s.connected && bytes.Equal(bss.BSSID, s.bss.BSSID) {
+		if bss.SSID == "" {
+			bss.SSID = s.bss.SSID
+		}
+		s.bss = *bss
+		s.lastBeacon = bss.LastSeen
+	}
+}
+
+func (s *Station) handleData(f gofi.Frame) {
+	if ether.FrameDirection(f) != ether.DirectionFromAP {
+		return
+	}
+	if !bytes.Equal(f.Addr1(), s.config.Address) && !isGroup(f.Addr1()) {
+		return
+	}
+
+	s.lock.Lock()
+	if s.pending != nil && !f.Protected() && bytes.Equal(f.Addr2(), s.pendingBSSID) {
+		// The 4-way handshake runs during the connection attempt.
+		if _, err := eapol.ParseFrame(f); err == nil {
+			select {
+			case s.pending <- f:
+			default:
+			}
+		}
+	}
+	response := s.receiveData(f)
+	s.lock.Unlock()
+	if response != nil {
+		s.sendProtected(response)
+	}
+}
+
+// receiveData queues the Ethernet frames from a data frame, returning
+// the response to an EAPOL-Key packet, if any.
+// The caller must hold s.lock.
+func (s *Station) receiveData(f gofi.Frame) gofi.Frame {
+	if !s.connected || !bytes.Equal(f.Addr2(), s.bss.BSSID) {
+		return nil
+	}
+	seq := int(f.SequenceControl())
+	duplicate := f.Retry() && seq == s.lastSequence
+	s.lastSequence = seq
+	if duplicate || !f.HasBody() {
+		return nil
+	}
+	protected := f.Protected()
+	if protected {
+		if f = s.keys.unprotect(f); f == nil {
+			return nil
+		}
+	}
+	if s.keys != nil {
+		if _, err := eapol.ParseFrame(f); err == nil {
+			return s.handleKey(f)
+		}
+		if !protected {
+			// NOTE: anybody could have sent this frame.
+			return nil
+		}
+	}
+	packets, err := ether.ToEthernet(f)
+	if err != nil {
+		return nil
+	}
+	for _, eth := range packets {
+		// Group frames from the station come back from the BSS.
+		if bytes.Equal(eth[6:12], s.config.Address) {
+			continue
+		}
+		if len(s.received) < s.config.QueueSize {
+			s.received = append(s.received, eth)
+			s.cond.Broadcast()
+		}
+	}
+	return nil
+}
+
+// newBSS describes a BSS from a beacon or probe response which was
+// received on a channel.
+func newBSS(bssid net.HardwareAddr, b *mgmt.Beacon, c gofi.Channel,
+	info *gofi.RadioInfo) *BSS {
+	res := &BSS{
+		BSSID:          append(net.HardwareAddr{}, bssid...),
+		Channel:        c,
+		Capability:     b.Capability,
+		BeaconInterval: time.Duration(b.Interval) * mgmt.TimeUnit,
+		Elements:       b.Elements,
+		LastSeen:       time.Now(),
+	}
+	res.SSID, _ = mgmt.SSID(b.Elements)
+	res.Rates, res.BasicRates = mgmt.Rates(b.Elements)
+	if num := mgmt.DSChannel(b.Elements); num != 0 {
+		// Frames from nearby channels may leak onto this one.
+		res.Channel = gofi.Channel{Number: num}
+	}
+	if res.BeaconInterval == 0 {
+		res.BeaconInterval = 100 * mgmt.TimeUnit
+	}
+	if e := gofi.FindElement(b.Elements, gofi.ElementRSN); e != nil {
+		res.RSN, _ = crypto.ParseRSN(e.Data)
+	}
+	if info != nil {
+		res.SignalPower = info.SignalPower
+	}
+	return res
+}
+
+func isGroup(addr net.HardwareAddr) bool {
+	return len(addr) > 0 && addr[0]&1 != 0
+}
@@ -0,0 +1,124 @@
+package station
+
+import (
+	"net"
+	"sort"
+	"time"
+
+	"github.com/unixpickle/gofi"
+	"github.com/unixpickle/gofi/mgmt"
+)
+
+// Defaults for a ScanConfig which leaves these fields unset.
+const (
+	DefaultActiveDwell  = time.Millisecond * 50
+	DefaultPassiveDwell = time.Millisecond * 250
+)
+
+// A ScanConfig configures a scan.
+type ScanConfig struct {
+	// SSID limits the scan to networks with this SSID, including
+	// hidden networks which reveal it in probe responses.
+	// If it is empty, every network is found.
+	SSID string
+
+	// Channels are the channels to scan.
+	// If there are no channels, every supported channel is scanned.
+	Channels []gofi.Channel
+
+	// If Passive is true, the scan only listens for beacons instead
+	// of sending probe requests.
+	Passive bool
+
+	// Dwell is the time spent on each channel.
+	// If it is 0, DefaultActiveDwell or DefaultPassiveDwell is used.
+	Dwell time.Duration
+}
+
+// Scan finds the networks on a set of channels, sorted from the
+// strongest signal to the weakest.
+//
+// The Handle returns to its original channel after the scan, so a
+// connected Station may scan, but it misses frames from its BSS in the
+// meantime.
+func (s *Station) Scan(c *ScanConfig) ([]BSS, error) {
+	s.opLock.Lock()
+	defer s.opLock.Unlock()
+
+	channels := c.Channels
+	if len(channels) == 0 {
+		channels = s.defaultChannels()
+	}
+	dwell := c.Dwell
+	if dwell == 0 {
+		dwell = DefaultActiveDwell
+		if c.Passive {
+			dwell = DefaultPassiveDwell
+		}
+	}
+
+	s.lock.Lock()
+	s.scanResults = map[string]*BSS{}
+	s.lock.Unlock()
+	defer func() {
+		s.lock.Lock()
+		s.scanResults = nil
+		s.lock.Unlock()
+	}()
+
+	original := s.handle.Channel()
+	defer s.handle.SetChannel(original)
+	for _, ch := range channels {
+		if err := s.handle.SetChannel(ch); err != nil {
+			if err == gofi.ErrClosed {
+				return nil, err
+			}
+			continue
+		}
+		if !c.Passive {
+			if err := s.sendProbe(c.SSID); err == gofi.ErrClosed {
+				return nil, err
+			}
+		}
+		select {
+		case <-s.done:
+			return nil, gofi.ErrClosed
+		case <-time.After(dwell):
+		}
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var res []BSS
+	for _, bss := range s.scanResults {
+		if c.SSID == "" || bss.SSID == c.SSID {
+			res = append(res, *bss)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].SignalPower > res[j].SignalPower
+	})
+	return res, nil
+}
+
+func (s *Station) sendProbe(ssid string) error {
+	broadcast := net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	elements := []gofi.Element{mgmt.SSIDElement(ssid)}
+	elements = append(elements, mgmt.RateElements(s.handle.SupportedRates(), nil)...)
+	return s.sendManagementTo(gofi.SubtypeProbeRequest, broadcast, broadcast,
+		gofi.EncodeElements(elements))
+}
+
+// defaultChannels returns the 20MHz versions of the supported
+// channels.
+func (s *Station) defaultChannels() []gofi.Channel {
+	var res []gofi.Channel
+	seen := map[int]bool{}
+	for _, ch := range s.handle.SupportedChannels() {
+		if !seen[ch.Number] {
+			seen[ch.Number] = true
+			res = append(res, gofi.Channel{Number: ch.Number})
+		}
+	}
+	return res
+}
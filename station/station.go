@@ -0,0 +1,433 @@
+// Package station implements the client side of joining a BSS on top
+// of a Handle.
+//
+// A Station scans for networks, authenticates with open system or SAE
+// authentication, associates, and then keeps track of the BSS and
+// exchanges Ethernet frames with it.
+//
+// For SAE networks, the Station runs the 4-way handshake after
+// association, and then protects data frames with CCMP.
+// Management frames are protected as well if the BSS supports it, but
+// group addressed ones are not checked, since BIP is not implemented.
+package station
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/unixpickle/gofi"
+	"github.com/unixpickle/gofi/crypto"
+	"github.com/unixpickle/gofi/ether"
+	"github.com/unixpickle/gofi/mgmt"
+	"github.com/unixpickle/gofi/mpdu"
+)
+
+var (
+	ErrNoAddress           = errors.New("missing station address")
+	ErrConnected           = errors.New("station is already connected")
+	ErrNotConnected        = errors.New("station is not connected")
+	ErrTimeout             = errors.New("BSS did not respond")
+	ErrUnsupportedSecurity = errors.New("unsupported BSS security")
+	ErrUnsupportedRates    = errors.New("basic rates of the BSS are not supported")
+	ErrNoPassword          = errors.New("missing password")
+	ErrDeauthenticated     = errors.New("BSS deauthenticated the station")
+	ErrHandshake           = errors.New("4-way handshake failed")
+)
+
+// These are the timeouts, retry counts, and queue size of a Station
+// whose Config leaves the corresponding fields at 0.
+const (
+	DefaultResponseTimeout = time.Millisecond * 200
+	DefaultRetries         = 3
+	DefaultBeaconLoss      = 10
+	DefaultListenInterval  = 10
+	DefaultQueueSize       = 64
+)
+
+// A StatusError is returned when a BSS rejects authentication or
+// association.
+type StatusError struct {
+	// Op is "authentication" or "association".
+	Op string
+
+	// Status is the status code from the BSS.
+	Status int
+}
+
+func (s *StatusError) Error() string {
+	return fmt.Sprintf("%s rejected with status %d", s.Op, s.Status)
+}
+
+// A Config configures a Station.
+type Config struct {
+	// Address is the address of the station.
+	Address net.HardwareAddr
+
+	// ResponseTimeout is the time to wait for each response from
+	// the BSS before retrying.
+	// If it is 0, DefaultResponseTimeout is used.
+	ResponseTimeout time.Duration
+
+	// Retries is the number of times to send each request before
+	// giving up.
+	// If it is 0, DefaultRetries is used.
+	Retries int
+
+	// BeaconLoss is the number of beacon intervals without a beacon
+	// after which the BSS is assumed to be gone.
+	// If it is 0, DefaultBeaconLoss is used.
+	BeaconLoss int
+
+	// ListenInterval is sent in association requests, in beacon
+	// intervals.
+	// If it is 0, DefaultListenInterval is used.
+	ListenInterval int
+
+	// QueueSize is the number of received Ethernet frames which are
+	// buffered before new ones are dropped.
+	// If it is 0, DefaultQueueSize is used.
+	QueueSize int
+
+	// Rate is the data rate of frames sent to the BSS.
+	// If it is 0, the Handle uses its lowest rate.
+	Rate gofi.DataRate
+}
+
+func (c *Config) setDefaults() {
+	if c.ResponseTimeout == 0 {
+		c.ResponseTimeout = DefaultResponseTimeout
+	}
+	if c.Retries == 0 {
+		c.Retries = DefaultRetries
+	}
+	if c.BeaconLoss == 0 {
+		c.BeaconLoss = DefaultBeaconLoss
+	}
+	if c.ListenInterval == 0 {
+		c.ListenInterval = DefaultListenInterval
+	}
+	if c.QueueSize == 0 {
+		c.QueueSize = DefaultQueueSize
+	}
+}
+
+// A BSS describes a network from its beacons and probe responses.
+type BSS struct {
+	BSSID net.HardwareAddr
+
+	// SSID is empty if the BSS hides its SSID.
+	SSID string
+
+	Channel        gofi.Channel
+	Capability     uint16
+	BeaconInterval time.Duration
+
+	// Rates are the supported rates of the BSS, and BasicRates are
+	// the rates which every station must support.
+	Rates      []gofi.DataRate
+	BasicRates []gofi.DataRate
+
+	// RSN is nil if the BSS has no RSN element.
+	RSN *crypto.RSNInfo
+
+	Elements []gofi.Element
+
+	// SignalPower is the signal power, in dBm, of the latest beacon
+	// or probe response.
+	SignalPower int
+
+	LastSeen time.Time
+}
+
+// An EventType is the type of an Event.
+type EventType int
+
+const (
+	EventConnected EventType = iota
+	EventDisconnected
+)
+
+// An Event reports a change in the connection of a Station.
+type Event struct {
+	Type EventType
+	BSS  BSS
+
+	// Reason is the reason code of a disconnection.
+	Reason int
+
+	// Local is true if the Station ended the connection, either
+	// because Disconnect was called or because beacons were lost.
+	Local bool
+}
+
+// A Station connects to a BSS with a Handle.
+type Station struct {
+	handle gofi.Handle
+	config Config
+	mpdu   *mpdu.Sender
+
+	// opLock serializes scans and connection attempts.
+	opLock sync.Mutex
+
+	// sendLock makes sure that protected frames are sent in the order
+	// of their packet numbers, which the BSS checks for replays.
+	// It must be locked before lock.
+	sendLock sync.Mutex
+
+	lock   sync.Mutex
+	cond   *sync.Cond
+	closed bool
+	err    error
+	events []Event
+
+	// scanResults collects BSSs while a scan runs, and is nil
+	// otherwise.
+	scanResults map[string]*BSS
+
+	// pending receives the authentication and association frames
+	// from the BSS while a connection attempt runs.
+	pending      chan gofi.Frame
+	pendingBSSID net.HardwareAddr
+
+	connected    bool
+	bss          BSS
+	aid          int
+	pmk          []byte
+	keys         *keys
+	lastBeacon   time.Time
+	lastSequence int
+	received     [][]byte
+
+	done chan struct{}
+}
+
+// New creates a Station which owns the Handle.
+// Closing the Station closes the Handle.
+func New(h gofi.Handle, c *Config) (*Station, error) {
+	if len(c.Address) != 6 {
+		return nil, ErrNoAddress
+	}
+	res := &Station{
+		handle: h,
+		config: *c,
+		mpdu:   mpdu.NewSender(),
+		done:   make(chan struct{}),
+	}
+	res.config.Address = append(net.HardwareAddr{}, c.Address...)
+	res.config.setDefaults()
+	res.cond = sync.NewCond(&res.lock)
+	go res.receiveLoop()
+	go res.beaconLossLoop()
+	return res, nil
+}
+
+// BSS returns the BSS to which the Station is connected, as of its
+// latest beacon.
+func (s *Station) BSS() (BSS, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.connected {
+		return BSS{}, ErrNotConnected
+	}
+	return s.bss, nil
+}
+
+// AID returns the association ID, or 0 if the Station is not
+// connected.
+func (s *Station) AID() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.aid
+}
+
+// PMK returns the pairwise master key from SAE authentication, or nil
+// if the connection does not use SAE.
+func (s *Station) PMK() []byte {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.pmk
+}
+
+// NextEvent waits for the next connection or disconnection.
+//
+// If the Handle fails, the events which were already queued are
+// returned before the Handle's error.
+func (s *Station) NextEvent() (*Event, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for len(s.events) == 0 && !s.closed && s.err == nil {
+		s.cond.Wait()
+	}
+	if s.closed {
+		return nil, gofi.ErrClosed
+	}
+	if len(s.events) == 0 {
+		return nil, s.err
+	}
+	event := s.events[0]
+	s.events[0] = Event{}
+	s.events = s.events[1:]
+	return &event, nil
+}
+
+// SendEthernet sends an Ethernet frame to the BSS.
+func (s *Station) SendEthernet(eth []byte) error {
+	s.lock.Lock()
+	connected := s.connected
+	bssid := s.bss.BSSID
+	s.lock.Unlock()
+	if !connected {
+		return ErrNotConnected
+	}
+	frame, err := ether.FromEthernet(eth, bssid, ether.DirectionToAP)
+	if err != nil {
+		return err
+	}
+	return s.sendProtected(frame)
+}
+
+// ReceiveEthernet waits for the next Ethernet frame from the BSS.
+//
+// Frames which arrive while nobody is receiving are buffered, and are
+// dropped once the buffer is full.
+// Buffered frames are discarded on disconnection.
+func (s *Station) ReceiveEthernet() ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for len(s.received) == 0 && !s.closed && s.err == nil {
+		s.cond.Wait()
+	}
+	if s.closed {
+		return nil, gofi.ErrClosed
+	}
+	if len(s.received) == 0 {
+		return nil, s.err
+	}
+	eth := s.received[0]
+	s.received[0] = nil
+	s.received = s.received[1:]
+	return eth, nil
+}
+
+// Disconnect deauthenticates from the BSS.
+func (s *Station) Disconnect() error {
+	return s.leave(mgmt.ReasonDeauthLeaving)
+}
+
+// Close deauthenticates from the BSS, if the Station is connected,
+// and closes the Handle.
+func (s *Station) Close() {
+	s.Disconnect()
+
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return
+	}
+	s.closed = true
+	s.events = nil
+	s.received = nil
+	s.cond.Broadcast()
+	s.lock.Unlock()
+
+	close(s.done)
+	s.handle.Close()
+}
+
+// disconnect forgets the BSS and reports the disconnection.
+// The caller must hold s.lock.
+func (s *Station) disconnect(reason int, local bool) {
+	if !s.connected {
+		return
+	}
+	s.events = append(s.events, Event{
+		Type:   EventDisconnected,
+		BSS:    s.bss,
+		Reason: reason,
+		Local:  local,
+	})
+	s.connected = false
+	s.bss = BSS{}
+	s.aid = 0
+	s.pmk = nil
+	s.keys = nil
+	s.received = nil
+	s.cond.Broadcast()
+}
+
+// beaconLossLoop disconnects from a BSS which stops sending beacons.
+func (s *Station) beaconLossLoop() {
+	ticker := time.NewTicker(time.Millisecond * 100)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+		s.lock.Lock()
+		timeout := s.bss.BeaconInterval * time.Duration(s.config.BeaconLoss)
+		lost := s.connected && time.Since(s.lastBeacon) >= timeout
+		s.lock.Unlock()
+		if lost {
+			// Like mac80211, tell the BSS in case it can still hear us.
+			s.leave(mgmt.ReasonInactivity)
+		}
+	}
+}
+
+// leave deauthenticates from the BSS and reports the disconnection.
+func (s *Station) leave(reason int) error {
+	s.sendLock.Lock()
+	defer s.sendLock.Unlock()
+	s.lock.Lock()
+	if !s.connected {
+		s.lock.Unlock()
+		return ErrNotConnected
+	}
+	// The deauthentication is protected before the keys are gone.
+	bssid := s.bss.BSSID
+	deauth, err := s.keys.protect(mgmt.NewFrame(gofi.SubtypeDeauth, bssid, s.config.Address,
+		bssid, mgmt.EncodeReason(reason)))
+	s.disconnect(reason, true)
+	s.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	return s.send(deauth)
+}
+
+func (s *Station) sendManagement(subtype int, bssid net.HardwareAddr, body []byte) error {
+	return s.sendManagementTo(subtype, bssid, bssid, body)
+}
+
+func (s *Station) sendManagementTo(subtype int, dst, bssid net.HardwareAddr, body []byte) error {
+	return s.send(mgmt.NewFrame(subtype, dst, s.config.Address, bssid, body))
+}
+
+// sendProtected sends a frame to the BSS, protecting it if the Station
+// has keys.
+func (s *Station) sendProtected(f gofi.Frame) error {
+	s.sendLock.Lock()
+	defer s.sendLock.Unlock()
+	s.lock.Lock()
+	if !s.connected {
+		// NOTE: the keys are gone, and the frame must not be sent
+		// without them.
+		s.lock.Unlock()
+		return ErrNotConnected
+	}
+	f, err := s.keys.protect(f)
+	s.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	return s.send(f)
+}
+
+func (s *Station) send(f gofi.Frame) error {
+	return s.mpdu.Send(s.handle, f, s.config.Rate)
+}
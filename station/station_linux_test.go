@@ -0,0 +1,169 @@
+// +build linux
+
+package station
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/unixpickle/gofi"
+)
+
+// TestHostapd connects to hostapd running SAE on one mac80211_hwsim
+// radio, using a monitor interface on another, and checks that ARP
+// works over the resulting connection.
+func TestHostapd(t *testing.T) {
+	apName, staName := testHwsimPair(t)
+	if _, err := exec.LookPath("hostapd"); err != nil {
+		t.Skip("hostapd is not installed")
+	}
+
+	conf := filepath.Join(t.TempDir(), "hostapd.conf")
+	err := os.WriteFile(conf, []byte("interface="+apName+"\n"+
+		"driver=nl80211\n"+
+		"ssid=gofitest\n"+
+		"hw_mode=g\n"+
+		"channel=6\n"+
+		"wpa=2\n"+
+		"wpa_key_mgmt=SAE\n"+
+		"rsn_pairwise=CCMP\n"+
+		"ieee80211w=2\n"+
+		"sae_pwe=0\n"+
+		"sae_password=correct horse\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostapd := exec.Command("hostapd", conf)
+	if err := hostapd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		hostapd.Process.Kill()
+		hostapd.Wait()
+	}()
+
+	staInfo, err := net.InterfaceByName(staName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handle, err := gofi.NewLinuxHandle(staName, &gofi.LinuxOptions{
+		MonitorFlags: gofi.MonitorActive,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sta, err := New(handle, &Config{Address: staInfo.HardwareAddr})
+	if err != nil {
+		handle.Close()
+		t.Fatal(err)
+	}
+	defer sta.Close()
+
+	var bss *BSS
+	for i := 0; i < 10 && bss == nil; i++ {
+		results, err := sta.Scan(&ScanConfig{
+			SSID:     "gofitest",
+			Channels: []gofi.Channel{{Number: 6}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) > 0 {
+			bss = &results[0]
+		}
+	}
+	if bss == nil {
+		t.Fatal("hostapd did not start")
+	}
+	if err := sta.Connect(bss, "correct horse"); err != nil {
+		t.Fatal(err)
+	}
+
+	apIP := net.IP{10, 77, 0, 1}
+	staIP := net.IP{10, 77, 0, 2}
+	if err := exec.Command("ip", "addr", "add", apIP.String()+"/24", "dev",
+		apName).Run(); err != nil {
+		t.Fatal("could not assign address:", err)
+	}
+
+	replies := make(chan []byte, 1)
+	go func() {
+		for {
+			eth, err := sta.ReceiveEthernet()
+			if err != nil {
+				return
+			}
+			if len(eth) >= 42 && bytes.Equal(eth[12:14], []byte{0x08, 0x06}) &&
+				eth[21] == 2 && bytes.Equal(eth[28:32], apIP) {
+				replies <- eth
+				return
+			}
+		}
+	}()
+	request := testARPRequest(staInfo.HardwareAddr, staIP, apIP)
+	for i := 0; i < 10; i++ {
+		if err := sta.SendEthernet(request); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case eth := <-replies:
+			if !bytes.Equal(eth[:6], staInfo.HardwareAddr) {
+				t.Error("unexpected destination:", net.HardwareAddr(eth[:6]))
+			}
+			return
+		case <-time.After(time.Millisecond * 500):
+		}
+	}
+	t.Fatal("no ARP reply")
+}
+
+// testHwsimPair loads mac80211_hwsim and returns the names of two of
+// its interfaces.
+// The test is skipped if the module cannot be loaded, e.g. because
+// the test is not running as root.
+func testHwsimPair(t *testing.T) (string, string) {
+	if os.Geteuid() != 0 {
+		t.Skip("mac80211_hwsim requires root")
+	}
+	if _, err := os.Stat("/sys/module/mac80211_hwsim"); os.IsNotExist(err) {
+		if err := exec.Command("modprobe", "mac80211_hwsim", "radios=2").Run(); err != nil {
+			t.Skip("could not load mac80211_hwsim:", err)
+		}
+		t.Cleanup(func() {
+			exec.Command("modprobe", "-r", "mac80211_hwsim").Run()
+		})
+	}
+	infos, err := gofi.Interfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, info := range infos {
+		if info.Driver == "mac80211_hwsim" && info.Mode == gofi.ModeStation {
+			names = append(names, info.Name)
+		}
+	}
+	if len(names) < 2 {
+		t.Skip("not enough mac80211_hwsim interfaces")
+	}
+	sort.Strings(names)
+	return names[0], names[1]
+}
+
+// testARPRequest creates an Ethernet frame which asks for the MAC
+// address of an IPv4 address.
+func testARPRequest(src net.HardwareAddr, srcIP, dstIP net.IP) []byte {
+	res := append(append([]byte{}, testBroadcast...), src...)
+	res = append(res, 0x08, 0x06)
+	res = append(res, 0, 1, 0x08, 0x00, 6, 4, 0, 1)
+	res = append(res, src...)
+	res = append(res, srcIP...)
+	res = append(res, make([]byte, 6)...)
+	return append(res, dstIP...)
+}
@@ -0,0 +1,569 @@
+package station
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/unixpickle/gofi"
+	"github.com/unixpickle/gofi/ap"
+	"github.com/unixpickle/gofi/crypto"
+	"github.com/unixpickle/gofi/eapol"
+	"github.com/unixpickle/gofi/ether"
+	"github.com/unixpickle/gofi/internal/testutil"
+	"github.com/unixpickle/gofi/mgmt"
+	"github.com/unixpickle/gofi/sim"
+)
+
+var (
+	testBSSID1 = net.HardwareAddr{0x02, 0, 0, 0, 0, 0xaa}
+	testBSSID2 = net.HardwareAddr{0x02, 0, 0, 0, 0, 0xbb}
+	testBSSID3 = net.HardwareAddr{0x02, 0, 0, 0, 0, 0xcc}
+	testAddr1  = net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	testExt    = net.HardwareAddr{0x02, 0, 0, 0, 0, 0xee}
+
+	testBroadcast = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+)
+
+func TestScan(t *testing.T) {
+	medium := sim.NewMedium()
+	ap1 := testAP(t, medium, testBSSID1, &ap.Config{SSID: "near", Channel: gofi.Channel{Number: 6}})
+	defer ap1.Close()
+	handle := medium.NewHandle(testBSSID2)
+	handle.SetSignalPower(-70)
+	ap2, err := ap.New(handle, &ap.Config{BSSID: testBSSID2, SSID: "far", Channel: gofi.Channel{Number: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ap2.Close()
+	ap3 := testAP(t, medium, testBSSID3, &ap.Config{
+		SSID:     "hidden",
+		HideSSID: true,
+		Channel:  gofi.Channel{Number: 11},
+	})
+	defer ap3.Close()
+
+	sta := testStation(t, medium, testAddr1)
+	defer sta.Close()
+	channels := []gofi.Channel{{Number: 1}, {Number: 6}, {Number: 11}}
+	for _, passive := range []bool{false, true} {
+		results, err := sta.Scan(&ScanConfig{Channels: channels, Passive: passive})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 3 || results[2].SSID != "far" || results[2].Channel.Number != 1 ||
+			results[2].SignalPower != -70 {
+			t.Fatalf("unexpected results: %+v", results)
+		}
+		for _, bss := range results {
+			if bss.BeaconInterval != ap.DefaultBeaconInterval || len(bss.Rates) != 12 ||
+				len(bss.BasicRates) != 1 || bss.RSN != nil {
+				t.Errorf("unexpected BSS: %+v", bss)
+			}
+		}
+	}
+
+	results, err := sta.Scan(&ScanConfig{SSID: "hidden", Channels: channels})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || !bytes.Equal(results[0].BSSID, testBSSID3) ||
+		results[0].Channel.Number != 11 {
+		t.Errorf("unexpected results: %+v", results)
+	}
+}
+
+func TestConnect(t *testing.T) {
+	medium := sim.NewMedium()
+	forwarded := make(chan []byte, 10)
+	accessPoint := testAP(t, medium, testBSSID1, &ap.Config{
+		SSID:    "test",
+		Channel: gofi.Channel{Number: 6},
+		Forward: func(eth []byte) {
+			forwarded <- append([]byte{}, eth...)
+		},
+	})
+	defer accessPoint.Close()
+
+	sta := testStation(t, medium, testAddr1)
+	defer sta.Close()
+	bss := testScan(t, sta, "test")
+	if err := sta.SendEthernet(testutil.Ethernet(testExt, testAddr1, []byte("hello"))); err != ErrNotConnected {
+		t.Error("unexpected error:", err)
+	}
+	if err := sta.Connect(bss, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := sta.Connect(bss, ""); err != ErrConnected {
+		t.Error("unexpected error:", err)
+	}
+	if event := testEvent(t, sta); event.Type != EventConnected ||
+		!bytes.Equal(event.BSS.BSSID, testBSSID1) {
+		t.Errorf("unexpected event: %+v", event)
+	}
+	if sta.AID() != 1 || sta.PMK() != nil {
+		t.Error("unexpected AID or PMK")
+	}
+	stations := accessPoint.Stations()
+	if len(stations) != 1 || !stations[0].Associated ||
+		stations[0].Capability != mgmt.CapESS|mgmt.CapShortSlotTime {
+		t.Errorf("unexpected stations: %+v", stations)
+	}
+
+	eth := testutil.Ethernet(testExt, testAddr1, []byte("hello"))
+	if err := sta.SendEthernet(eth); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case actual := <-forwarded:
+		if !bytes.Equal(actual, eth) {
+			t.Errorf("unexpected frame: %x", actual)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("frame was not forwarded")
+	}
+	eth = testutil.Ethernet(testAddr1, testExt, []byte("hi"))
+	if err := accessPoint.SendEthernet(eth); err != nil {
+		t.Fatal(err)
+	}
+	if actual, err := sta.ReceiveEthernet(); err != nil || !bytes.Equal(actual, eth) {
+		t.Errorf("unexpected frame %x (error %v)", actual, err)
+	}
+
+	accessPoint.Deauthenticate(testAddr1, mgmt.ReasonUnspecified)
+	if event := testEvent(t, sta); event.Type != EventDisconnected ||
+		event.Reason != mgmt.ReasonUnspecified || event.Local {
+		t.Errorf("unexpected event: %+v", event)
+	}
+
+	if err := sta.Connect(bss, ""); err != nil {
+		t.Fatal(err)
+	}
+	testEvent(t, sta)
+	if err := sta.Disconnect(); err != nil {
+		t.Fatal(err)
+	}
+	if event := testEvent(t, sta); event.Type != EventDisconnected ||
+		event.Reason != mgmt.ReasonDeauthLeaving || !event.Local {
+		t.Errorf("unexpected event: %+v", event)
+	}
+	testutil.WaitFor(t, func() bool {
+		return len(accessPoint.Stations()) == 0
+	})
+
+	bss.BasicRates = []gofi.DataRate{1000}
+	if err := sta.Connect(bss, ""); err != ErrUnsupportedRates {
+		t.Error("unexpected error:", err)
+	}
+	bss.RSN = &crypto.RSNInfo{
+		GroupCipher:     crypto.CipherCCMP128,
+		PairwiseCiphers: []crypto.Cipher{crypto.CipherCCMP128},
+		AKMs:            []crypto.AKM{crypto.AKMPSK},
+	}
+	if err := sta.Connect(bss, "password"); err != ErrUnsupportedSecurity {
+		t.Error("unexpected error:", err)
+	}
+}
+
+func TestBeaconLoss(t *testing.T) {
+	medium := sim.NewMedium()
+	handle := medium.NewHandle(testBSSID1)
+	accessPoint, err := ap.New(handle, &ap.Config{
+		BSSID:          testBSSID1,
+		SSID:           "test",
+		BeaconInterval: mgmt.TimeUnit * 20,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer accessPoint.Close()
+	sta := testStation(t, medium, testAddr1)
+	defer sta.Close()
+	if err := sta.Connect(testScan(t, sta, "test"), ""); err != nil {
+		t.Fatal(err)
+	}
+	testEvent(t, sta)
+
+	// The AP leaves without telling the station.
+	handle.SetChannel(gofi.Channel{Number: 11})
+	if event := testEvent(t, sta); event.Type != EventDisconnected ||
+		event.Reason != mgmt.ReasonInactivity || !event.Local {
+		t.Errorf("unexpected event: %+v", event)
+	}
+}
+
+func TestSAE(t *testing.T) {
+	medium := sim.NewMedium()
+	responder := newTestSAEResponder(medium, "password")
+	defer responder.Close()
+
+	for _, password := range []string{"wrong", "password"} {
+		sta, err := New(medium.NewHandle(testAddr1), &Config{
+			Address:         testAddr1,
+			ResponseTimeout: time.Second,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		bss := testScan(t, sta, "wpa3")
+		if bss.RSN == nil || bss.Capability&mgmt.CapPrivacy == 0 {
+			t.Fatalf("unexpected BSS: %+v", bss)
+		}
+		if err := sta.Connect(bss, ""); err != ErrNoPassword {
+			t.Error("unexpected error:", err)
+		}
+		err = sta.Connect(bss, password)
+		if password == "wrong" {
+			if err != ErrTimeout {
+				t.Error("unexpected error:", err)
+			}
+			sta.Close()
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		pmk := <-responder.pmks
+		if len(pmk) != crypto.PMKSize || !bytes.Equal(sta.PMK(), pmk) {
+			t.Error("PMKs differ")
+		}
+		rsn := <-responder.rsns
+		if rsn == nil || len(rsn.AKMs) != 1 || rsn.AKMs[0] != crypto.AKMSAE ||
+			rsn.Capabilities&crypto.RSNCapMFPCapable == 0 {
+			t.Errorf("unexpected RSN: %+v", rsn)
+		}
+		if event := testEvent(t, sta); event.Type != EventConnected {
+			t.Errorf("unexpected event: %+v", event)
+		}
+		testSAEData(t, sta, responder, <-responder.ptks)
+		sta.Close()
+	}
+	if responder.tokens != 2 {
+		t.Errorf("expected 2 anti-clogging tokens but got %d", responder.tokens)
+	}
+}
+
+// testSAEData checks that data is protected in both directions after
+// the 4-way handshake, and that the keys survive a retransmitted
+// message 3 and a group key handshake.
+func testSAEData(t *testing.T, sta *Station, responder *testSAEResponder, ptk *crypto.PTK) {
+	eth := testutil.Ethernet(testExt, testAddr1, []byte("hello"))
+	if err := sta.SendEthernet(eth); err != nil {
+		t.Fatal(err)
+	}
+	frame := testResponderData(t, responder)
+	if packets, err := ether.ToEthernet(frame); err != nil || len(packets) != 1 ||
+		!bytes.Equal(packets[0], eth) {
+		t.Errorf("unexpected frame: %x", frame)
+	}
+
+	// Unprotected and replayed frames must be dropped.
+	unicast := testutil.Ethernet(testAddr1, testExt, []byte("unicast"))
+	frame, _ = ether.FromEthernet(unicast, testBSSID1, ether.DirectionFromAP)
+	responder.handle.Send(frame, 0)
+	protected, _ := crypto.EncryptFrame(crypto.CipherCCMP128, ptk.TK, frame, 1, 0)
+	responder.handle.Send(protected, 0)
+	responder.handle.Send(protected, 0)
+	group := testutil.Ethernet(testBroadcast, testExt, []byte("group"))
+	frame, _ = ether.FromEthernet(group, testBSSID1, ether.DirectionFromAP)
+	protected, _ = crypto.EncryptFrame(crypto.CipherCCMP128, responder.gtk, frame, 1, 1)
+	responder.handle.Send(protected, 0)
+	for _, expected := range [][]byte{unicast, group} {
+		if actual, err := sta.ReceiveEthernet(); err != nil || !bytes.Equal(actual, expected) {
+			t.Errorf("unexpected frame %x (error %v)", actual, err)
+		}
+	}
+
+	responder.sendKey(responder.message3(3), 0)
+	if _, key := parseKey(testResponderData(t, responder)); key == nil ||
+		key.Message() != eapol.KeyMessage4 || key.ReplayCounter != 3 {
+		t.Errorf("unexpected response to message 3: %+v", key)
+	}
+
+	gtk := bytes.Repeat([]byte{2}, 16)
+	responder.sendKey(&eapol.Key{
+		Descriptor:    eapol.DescriptorRSN,
+		Info:          eapol.KeyInfoAck | eapol.KeyInfoMIC | eapol.KeyInfoSecure | eapol.KeyInfoEncryptedData,
+		Length:        16,
+		ReplayCounter: 4,
+		Data:          responder.wrap(gofi.EncodeElements([]gofi.Element{testGTKKDE(2, gtk)})),
+	}, 2)
+	if _, key := parseKey(testResponderData(t, responder)); key == nil ||
+		key.Message() != eapol.KeyMessageGroup2 || key.ReplayCounter != 4 {
+		t.Errorf("unexpected response to group message 1: %+v", key)
+	}
+	protected, _ = crypto.EncryptFrame(crypto.CipherCCMP128, gtk, frame, 1, 2)
+	responder.handle.Send(protected, 0)
+	if actual, err := sta.ReceiveEthernet(); err != nil || !bytes.Equal(actual, group) {
+		t.Errorf("unexpected frame %x (error %v)", actual, err)
+	}
+}
+
+// A testSAEResponder is the smallest AP which authenticates stations
+// with SAE and runs the 4-way handshake.
+//
+// It asks for an anti-clogging token before processing every commit,
+// and ignores confirms which do not match.
+// Protected data frames from the station are decrypted and sent to
+// the data channel.
+type testSAEResponder struct {
+	handle *sim.Handle
+	pmks   chan []byte
+	rsns   chan *crypto.RSNInfo
+	ptks   chan *crypto.PTK
+	data   chan gofi.Frame
+	tokens int
+
+	rsn    []byte
+	gtk    []byte
+	sae    *crypto.SAE
+	anonce []byte
+	ptk    *crypto.PTK
+}
+
+func newTestSAEResponder(medium *sim.Medium, password string) *testSAEResponder {
+	res := &testSAEResponder{
+		handle: medium.NewHandle(testBSSID1),
+		pmks:   make(chan []byte, 1),
+		rsns:   make(chan *crypto.RSNInfo, 1),
+		ptks:   make(chan *crypto.PTK, 1),
+		data:   make(chan gofi.Frame, 10),
+		gtk:    bytes.Repeat([]byte{1}, 16),
+		anonce: bytes.Repeat([]byte{3}, 32),
+	}
+	rsn := &crypto.RSNInfo{
+		GroupCipher:     crypto.CipherCCMP128,
+		PairwiseCiphers: []crypto.Cipher{crypto.CipherCCMP128},
+		AKMs:            []crypto.AKM{crypto.AKMSAE},
+		Capabilities:    crypto.RSNCapMFPCapable,
+		HasCapabilities: true,
+	}
+	res.rsn = rsn.Encode()
+	elements := []gofi.Element{mgmt.SSIDElement("wpa3")}
+	elements = append(elements, mgmt.RateElements(gofi.LegacyRates(gofi.Band2GHz), []gofi.DataRate{2})...)
+	elements = append(elements, gofi.Element{ID: gofi.ElementRSN, Data: res.rsn})
+	beacon := &mgmt.Beacon{Interval: 100, Capability: mgmt.CapESS | mgmt.CapPrivacy, Elements: elements}
+
+	go func() {
+		for {
+			frame, _, err := res.handle.Receive()
+			if err != nil {
+				return
+			}
+			if !bytes.Equal(frame.Addr2(), testAddr1) {
+				continue
+			}
+			if frame.Type() == gofi.FrameTypeData {
+				res.handleData(frame)
+				continue
+			}
+			switch frame.Subtype() {
+			case gofi.SubtypeProbeRequest:
+				res.send(gofi.SubtypeProbeResponse, beacon.Encode())
+			case gofi.SubtypeAuth:
+				auth, err := mgmt.ParseAuth(frame.Body())
+				if err == nil {
+					res.handleAuth(password, auth)
+				}
+			case gofi.SubtypeAssocRequest:
+				req, err := mgmt.ParseAssocRequest(frame.Body(), false)
+				if err != nil {
+					continue
+				}
+				var rsn *crypto.RSNInfo
+				if e := gofi.FindElement(req.Elements, gofi.ElementRSN); e != nil {
+					rsn, _ = crypto.ParseRSN(e.Data)
+				}
+				res.rsns <- rsn
+				resp := &mgmt.AssocResponse{Capability: beacon.Capability, AID: 1}
+				res.send(gofi.SubtypeAssocResponse, resp.Encode())
+				res.sendKey(&eapol.Key{
+					Descriptor:    eapol.DescriptorRSN,
+					Info:          eapol.KeyInfoPairwise | eapol.KeyInfoAck,
+					Length:        16,
+					ReplayCounter: 1,
+					Nonce:         res.anonce,
+				}, 0)
+			}
+		}
+	}()
+	return res
+}
+
+func (t *testSAEResponder) handleAuth(password string, auth *mgmt.Auth) {
+	token := []byte("token")
+	switch auth.Sequence {
+	case 1:
+		if !bytes.HasPrefix(auth.Data[2:], token) {
+			t.tokens++
+			response := &mgmt.Auth{
+				Algorithm: mgmt.AuthSAE,
+				Sequence:  1,
+				Status:    mgmt.StatusAntiCloggingToken,
+				Data:      append([]byte{crypto.SAEGroup, 0}, token...),
+			}
+			t.send(gofi.SubtypeAuth, response.Encode())
+			return
+		}
+		sae, err := crypto.NewSAE(password, testBSSID1, testAddr1)
+		if err != nil || sae.HandleCommit(auth.Data) != nil {
+			return
+		}
+		t.sae = sae
+		response := &mgmt.Auth{Algorithm: mgmt.AuthSAE, Sequence: 1, Data: sae.Commit(nil)}
+		t.send(gofi.SubtypeAuth, response.Encode())
+	case 2:
+		if t.sae == nil || t.sae.HandleConfirm(auth.Data) != nil {
+			return
+		}
+		t.pmks <- t.sae.PMK()
+		response := &mgmt.Auth{Algorithm: mgmt.AuthSAE, Sequence: 2, Data: t.sae.Confirm()}
+		t.send(gofi.SubtypeAuth, response.Encode())
+	}
+}
+
+func (t *testSAEResponder) handleData(f gofi.Frame) {
+	if f.Protected() {
+		if t.ptk == nil {
+			return
+		}
+		if plain, err := crypto.DecryptFrame(crypto.CipherCCMP128, t.ptk.TK, f); err == nil {
+			t.data <- plain
+		}
+		return
+	}
+	packet, key := parseKey(f)
+	if key == nil {
+		return
+	}
+	switch key.Message() {
+	case eapol.KeyMessage2:
+		t.ptk = crypto.DerivePTK(t.sae.PMK(), crypto.AKMSAE, crypto.CipherCCMP128, testBSSID1,
+			testAddr1, t.anonce, key.Nonce)
+		if bytes.Equal(key.MIC, t.mic(packet, key)) {
+			t.sendKey(t.message3(2), 0)
+		}
+	case eapol.KeyMessage4:
+		if t.ptk != nil && bytes.Equal(key.MIC, t.mic(packet, key)) {
+			t.ptks <- t.ptk
+		}
+	}
+}
+
+func (t *testSAEResponder) message3(replayCounter uint64) *eapol.Key {
+	elements := []gofi.Element{{ID: gofi.ElementRSN, Data: t.rsn}, testGTKKDE(1, t.gtk)}
+	return &eapol.Key{
+		Descriptor: eapol.DescriptorRSN,
+		Info: eapol.KeyInfoPairwise | eapol.KeyInfoInstall | eapol.KeyInfoAck | eapol.KeyInfoMIC |
+			eapol.KeyInfoSecure | eapol.KeyInfoEncryptedData,
+		Length:        16,
+		ReplayCounter: replayCounter,
+		Nonce:         t.anonce,
+		Data:          t.wrap(gofi.EncodeElements(elements)),
+	}
+}
+
+// wrap pads key data and encrypts it with the KEK.
+func (t *testSAEResponder) wrap(data []byte) []byte {
+	if len(data)%8 != 0 || len(data) < 16 {
+		data = append(data, 0xdd)
+	}
+	for len(data)%8 != 0 || len(data) < 16 {
+		data = append(data, 0)
+	}
+	res, _ := crypto.KeyWrap(t.ptk.KEK, data)
+	return res
+}
+
+// mic computes the MIC of an EAPOL-Key packet with the KCK.
+func (t *testSAEResponder) mic(packet *eapol.Packet, key *eapol.Key) []byte {
+	zeroed := *key
+	zeroed.MIC = nil
+	unsigned := &eapol.Packet{Version: packet.Version, Type: eapol.TypeKey, Body: zeroed.Encode()}
+	return crypto.KeyMIC(0, crypto.AKMSAE, t.ptk.KCK, unsigned.Encode())
+}
+
+// sendKey sends an EAPOL-Key packet, setting its MIC if it has the MIC
+// bit, and encrypting it with the TK if pn is non-zero.
+func (t *testSAEResponder) sendKey(key *eapol.Key, pn uint64) {
+	packet := &eapol.Packet{Version: 2, Type: eapol.TypeKey}
+	if key.Info.Has(eapol.KeyInfoMIC) {
+		key.MIC = t.mic(packet, key)
+	}
+	packet.Body = key.Encode()
+	eth := append(append(append([]byte{}, testAddr1...), testBSSID1...), 0x88, 0x8e)
+	frame, _ := ether.FromEthernet(append(eth, packet.Encode()...), testBSSID1,
+		ether.DirectionFromAP)
+	if pn != 0 {
+		frame, _ = crypto.EncryptFrame(crypto.CipherCCMP128, t.ptk.TK, frame, pn, 0)
+	}
+	t.handle.Send(frame, 0)
+}
+
+func (t *testSAEResponder) send(subtype int, body []byte) {
+	t.handle.Send(mgmt.NewFrame(subtype, testAddr1, testBSSID1, testBSSID1, body), 0)
+}
+
+func (t *testSAEResponder) Close() {
+	t.handle.Close()
+}
+
+func testAP(t *testing.T, medium *sim.Medium, bssid net.HardwareAddr, c *ap.Config) *ap.AP {
+	c.BSSID = bssid
+	res, err := ap.New(medium.NewHandle(bssid), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func testStation(t *testing.T, medium *sim.Medium, addr net.HardwareAddr) *Station {
+	res, err := New(medium.NewHandle(addr), &Config{Address: addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func testScan(t *testing.T, sta *Station, ssid string) *BSS {
+	channels := []gofi.Channel{{Number: 1}, {Number: 6}, {Number: 11}}
+	results, err := sta.Scan(&ScanConfig{SSID: ssid, Channels: channels})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+	return &results[0]
+}
+
+func testEvent(t *testing.T, sta *Station) *Event {
+	events := make(chan *Event, 1)
+	go func() {
+		event, _ := sta.NextEvent()
+		events <- event
+	}()
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for event")
+	}
+	return nil
+}
+
+func testResponderData(t *testing.T, responder *testSAEResponder) gofi.Frame {
+	select {
+	case f := <-responder.data:
+		return f
+	case <-time.After(time.Second * 5):
+		t.Fatal("timed out waiting for data")
+	}
+	return nil
+}
+
+func testGTKKDE(keyID byte, gtk []byte) gofi.Element {
+	data := append([]byte{0x00, 0x0f, 0xac, eapol.KDEGTK, keyID, 0}, gtk...)
+	return gofi.Element{ID: gofi.ElementVendorSpecific, Data: data}
+}
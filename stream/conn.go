@@ -0,0 +1,720 @@
+package stream
+
+import (
+	"io"
+	"net"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/unixpickle/gofi/internal/deadline"
+)
+
+const (
+	// segmentSize is the largest payload of a segment.
+	segmentSize = 1200
+
+	// sendBufferSize is the number of bytes which Write buffers
+	// before they are sent.
+	sendBufferSize = 1 << 18
+
+	// receiveWindowSize is the number of segments which a Conn
+	// buffers before they are read.
+	receiveWindowSize = 64
+
+	initialRTO = 200 * time.Millisecond
+	minRTO     = 20 * time.Millisecond
+	maxRTO     = 5 * time.Second
+
+	// maxTimeouts is the number of consecutive retransmission
+	// timeouts after which a connection is given up.
+	maxTimeouts = 10
+
+	initialCongestionWindow = 4
+	maxCongestionWindow     = 1024
+
+	// fastRetransmitThreshold is the number of selectively
+	// acknowledged segments after a missing segment which cause the
+	// missing segment to be retransmitted.
+	fastRetransmitThreshold = 3
+)
+
+type connState int
+
+const (
+	stateSynSent connState = iota
+	stateSynReceived
+	stateEstablished
+	stateDone
+)
+
+// An outSegment is a segment which has been sent but not
+// cumulatively acknowledged.
+type outSegment struct {
+	seq     uint32
+	payload []byte
+	fin     bool
+
+	fastRetransmitted bool
+	sacked            bool
+
+	// lost is set for segments which should be retransmitted as
+	// soon as the congestion window allows.
+	lost bool
+}
+
+// An inSegment is a segment which arrived before the segments
+// preceding it.
+type inSegment struct {
+	payload []byte
+	fin     bool
+}
+
+// A Conn is one end of a reliable, ordered byte stream.
+//
+// Close sends any buffered data before closing the connection, but
+// does not wait for it to be delivered.
+type Conn struct {
+	endpoint *endpoint
+	remote   net.Addr
+	id       uint32
+	start    time.Time
+
+	readDeadline  *deadline.Deadline
+	writeDeadline *deadline.Deadline
+
+	lock sync.Mutex
+
+	// changed is closed and replaced whenever the state changes in a
+	// way which waiting Goroutines might care about.
+	changed chan struct{}
+
+	state  connState
+	err    error
+	closed bool
+
+	sendBuf       []byte
+	nextSeq       uint32
+	unacked       uint32
+	inflight      []*outSegment
+	peerWindow    int
+	cwnd          float64
+	ssthresh      float64
+	inRecovery    bool
+	recoveryPoint uint32
+	finSent       bool
+	finAcked      bool
+
+	srtt   time.Duration
+	rttvar time.Duration
+	rto    time.Duration
+
+	// echo is the timestamp to echo back to the peer.
+	echo uint32
+
+	// timeouts is the number of consecutive timeouts, each of which
+	// doubles the time until the next one.
+	timeouts int
+	timer    *time.Timer
+	timerGen int
+
+	rcvNext    uint32
+	outOfOrder map[uint32]inSegment
+	readBuf    []byte
+	eof        bool
+	advertised int
+}
+
+func newConn(e *endpoint, remote net.Addr, id uint32, state connState) *Conn {
+	return &Conn{
+		endpoint:      e,
+		remote:        remote,
+		id:            id,
+		start:         time.Now(),
+		readDeadline:  deadline.New(),
+		writeDeadline: deadline.New(),
+		changed:       make(chan struct{}),
+		state:         state,
+		cwnd:          initialCongestionWindow,
+		ssthresh:      maxCongestionWindow,
+		rto:           initialRTO,
+		outOfOrder:    map[uint32]inSegment{},
+	}
+}
+
+// Read reads data from the connection.
+//
+// Once the peer closes the connection and all of its data has been
+// read, this returns io.EOF.
+func (c *Conn) Read(b []byte) (int, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for {
+		if c.closed {
+			return 0, net.ErrClosed
+		}
+		if deadline.IsClosed(c.readDeadline.Wait()) {
+			return 0, os.ErrDeadlineExceeded
+		}
+		if len(c.readBuf) > 0 {
+			n := copy(b, c.readBuf)
+			c.readBuf = c.readBuf[n:]
+			if c.receiveWindow() >= c.advertised+receiveWindowSize/4 {
+				// NOTE: the peer may be waiting for the window to
+				// open up.
+				c.send(c.ackSegment())
+			}
+			return n, nil
+		}
+		if c.eof {
+			return 0, io.EOF
+		}
+		if c.err != nil {
+			return 0, c.err
+		}
+		if err := c.wait(c.readDeadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Write writes data to the connection.
+//
+// This returns once the data is buffered, which may be before it is
+// sent.
+func (c *Conn) Write(b []byte) (int, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var written int
+	for written < len(b) {
+		if c.closed {
+			return written, net.ErrClosed
+		}
+		if c.err != nil {
+			return written, c.err
+		}
+		if deadline.IsClosed(c.writeDeadline.Wait()) {
+			return written, os.ErrDeadlineExceeded
+		}
+		if space := sendBufferSize - len(c.sendBuf); space > 0 {
+			n := len(b) - written
+			if n > space {
+				n = space
+			}
+			c.sendBuf = append(c.sendBuf, b[written:written+n]...)
+			written += n
+			c.flush()
+			continue
+		}
+		if err := c.wait(c.writeDeadline); err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// Close closes the connection.
+//
+// Buffered data is still sent, followed by a FIN, but any data which
+// arrives afterwards is discarded.
+func (c *Conn) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	c.closed = true
+	c.readBuf = nil
+	c.notify()
+	if c.state == stateDone {
+		return nil
+	}
+	c.flush()
+	c.checkDone()
+	return nil
+}
+
+// LocalAddr returns the address of the underlying PacketConn.
+func (c *Conn) LocalAddr() net.Addr {
+	return c.endpoint.pc.LocalAddr()
+}
+
+// RemoteAddr returns the address of the peer.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+// SetDeadline sets the read and write deadlines.
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.readDeadline.Set(t)
+	c.writeDeadline.Set(t)
+	return nil
+}
+
+// SetReadDeadline sets the deadline for Read calls, including calls
+// which are already waiting.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.Set(t)
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for Write calls, including
+// calls which are waiting for buffer space.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.Set(t)
+	return nil
+}
+
+// wait waits for the state to change or for a deadline to pass.
+// The caller must hold c.lock, which is released while waiting.
+func (c *Conn) wait(d *deadline.Deadline) error {
+	changed := c.changed
+	expired := d.Wait()
+	c.lock.Unlock()
+	defer c.lock.Lock()
+	select {
+	case <-changed:
+		return nil
+	case <-expired:
+		return os.ErrDeadlineExceeded
+	}
+}
+
+func (c *Conn) notify() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// handleSegment processes a segment from the peer.
+func (c *Conn) handleSegment(seg *segment) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if seg.has(flagRST) {
+		c.fail(ErrReset)
+		return
+	}
+	if len(seg.payload) > 0 || seg.has(flagFIN|flagSYN) {
+		c.echo = seg.timestamp
+	}
+
+	switch c.state {
+	case stateDone:
+		return
+	case stateSynSent:
+		if seg.has(flagSYN) && seg.has(flagACK) {
+			c.peerWindow = int(seg.window)
+			c.establish()
+			c.send(c.ackSegment())
+		}
+		return
+	case stateSynReceived:
+		if seg.has(flagSYN) {
+			c.peerWindow = int(seg.window)
+			c.send(c.synSegment())
+			if c.timer == nil {
+				c.startTimer()
+			}
+			return
+		} else if !seg.has(flagACK) {
+			return
+		}
+		c.establish()
+		c.endpoint.accept(c)
+	}
+
+	if seg.has(flagSYN) {
+		// The peer did not get our acknowledgement of its SYN.
+		c.send(c.ackSegment())
+		return
+	}
+	if seg.has(flagACK) {
+		c.handleAck(seg)
+	}
+	if len(seg.payload) > 0 || seg.has(flagFIN) {
+		c.handleData(seg)
+	}
+	c.checkDone()
+}
+
+func (c *Conn) establish() {
+	c.state = stateEstablished
+	c.stopTimer()
+	c.timeouts = 0
+	c.notify()
+}
+
+func (c *Conn) handleAck(seg *segment) {
+	c.peerWindow = int(seg.window)
+
+	var progress bool
+	if seg.ack > c.unacked && seg.ack <= c.nextSeq {
+		progress = true
+		n := int(seg.ack - c.unacked)
+		for _, s := range c.inflight[:n] {
+			if s.fin {
+				c.finAcked = true
+			}
+		}
+		c.inflight = c.inflight[n:]
+		c.unacked = seg.ack
+		c.timeouts = 0
+		c.growWindow(n)
+		if c.inRecovery && c.unacked >= c.recoveryPoint {
+			c.inRecovery = false
+		}
+		c.notify()
+	}
+	var newlySacked bool
+	for _, block := range seg.sack {
+		for _, s := range c.inflight {
+			if s.seq >= block.start && s.seq < block.end && !s.sacked {
+				s.sacked = true
+				newlySacked = true
+			}
+		}
+	}
+	if progress || newlySacked {
+		c.updateRTT(c.clock() - seg.echo)
+	}
+	c.fastRetransmit()
+	c.flush()
+
+	if len(c.inflight) == 0 {
+		c.stopTimer()
+	} else if progress || c.timer == nil {
+		c.stopTimer()
+		c.startTimer()
+	}
+}
+
+// fastRetransmit retransmits missing segments which the peer has
+// selectively acknowledged enough later segments to suggest were
+// lost.
+func (c *Conn) fastRetransmit() {
+	var sackedAfter int
+	for i := len(c.inflight) - 1; i >= 0; i-- {
+		s := c.inflight[i]
+		if s.sacked {
+			sackedAfter++
+			continue
+		}
+		if sackedAfter < fastRetransmitThreshold || s.fastRetransmitted {
+			continue
+		}
+		if !c.inRecovery {
+			c.inRecovery = true
+			c.recoveryPoint = c.nextSeq
+			c.ssthresh = maxFloat(c.cwnd/2, 2)
+			c.cwnd = c.ssthresh
+		}
+		s.fastRetransmitted = true
+		s.lost = false
+		c.transmit(s)
+	}
+}
+
+func (c *Conn) growWindow(acked int) {
+	if c.cwnd < c.ssthresh {
+		c.cwnd += float64(acked)
+	} else {
+		c.cwnd += float64(acked) / c.cwnd
+	}
+	if c.cwnd > maxCongestionWindow {
+		c.cwnd = maxCongestionWindow
+	}
+}
+
+// updateRTT updates the RTO from a round-trip time sample in
+// microseconds, as described in RFC 6298.
+func (c *Conn) updateRTT(micros uint32) {
+	sample := time.Duration(micros) * time.Microsecond
+	if c.srtt == 0 {
+		c.srtt = sample
+		c.rttvar = sample / 2
+	} else {
+		diff := c.srtt - sample
+		if diff < 0 {
+			diff = -diff
+		}
+		c.rttvar = (3*c.rttvar + diff) / 4
+		c.srtt = (7*c.srtt + sample) / 8
+	}
+	c.rto = c.srtt + 4*c.rttvar
+	if c.rto < minRTO {
+		c.rto = minRTO
+	} else if c.rto > maxRTO {
+		c.rto = maxRTO
+	}
+}
+
+func (c *Conn) handleData(seg *segment) {
+	window := uint32(c.receiveWindow())
+	if seg.seq >= c.rcvNext && seg.seq < c.rcvNext+window {
+		if _, ok := c.outOfOrder[seg.seq]; !ok {
+			c.outOfOrder[seg.seq] = inSegment{
+				payload: append([]byte{}, seg.payload...),
+				fin:     seg.has(flagFIN),
+			}
+		}
+		for {
+			in, ok := c.outOfOrder[c.rcvNext]
+			if !ok {
+				break
+			}
+			delete(c.outOfOrder, c.rcvNext)
+			if !c.closed {
+				c.readBuf = append(c.readBuf, in.payload...)
+			}
+			if in.fin {
+				c.eof = true
+			}
+			c.rcvNext++
+		}
+		c.notify()
+	}
+	c.send(c.ackSegment())
+}
+
+// flush retransmits lost segments and sends as many new segments
+// as the windows allow.
+func (c *Conn) flush() {
+	if c.state != stateEstablished {
+		return
+	}
+
+	// pipe estimates the number of segments in the network, like
+	// in RFC 6675.
+	var pipe int
+	for _, s := range c.inflight {
+		if !s.sacked && !s.lost {
+			pipe++
+		}
+	}
+	var sent bool
+	for _, s := range c.inflight {
+		if pipe >= int(c.cwnd) {
+			break
+		}
+		if s.lost {
+			s.lost = false
+			c.transmit(s)
+			pipe++
+			sent = true
+		}
+	}
+
+	window := c.peerWindow
+	if window < 1 && len(c.inflight) == 0 {
+		// NOTE: a segment beyond a closed window serves as a probe,
+		// since the peer will reply with its current window.
+		window = 1
+	}
+	for pipe < int(c.cwnd) && int(c.nextSeq-c.unacked) < window {
+		s := &outSegment{seq: c.nextSeq}
+		if len(c.sendBuf) > 0 {
+			n := len(c.sendBuf)
+			if n > segmentSize {
+				n = segmentSize
+			}
+			s.payload = append([]byte{}, c.sendBuf[:n]...)
+			c.sendBuf = c.sendBuf[n:]
+			c.notify()
+		} else if c.closed && !c.finSent {
+			s.fin = true
+			c.finSent = true
+		} else {
+			break
+		}
+		c.nextSeq++
+		c.inflight = append(c.inflight, s)
+		c.transmit(s)
+		pipe++
+		sent = true
+	}
+	if sent && c.timer == nil {
+		c.startTimer()
+	}
+}
+
+func (c *Conn) transmit(s *outSegment) {
+	seg := c.ackSegment()
+	seg.seq = s.seq
+	seg.payload = s.payload
+	if s.fin {
+		seg.flags |= flagFIN
+	}
+	c.send(seg)
+}
+
+func (c *Conn) startTimer() {
+	c.timerGen++
+	gen := c.timerGen
+	timeout := c.rto
+	for i := 0; i < c.timeouts && timeout < maxRTO; i++ {
+		timeout *= 2
+	}
+	if timeout > maxRTO {
+		timeout = maxRTO
+	}
+	c.timer = time.AfterFunc(timeout, func() {
+		c.handleTimeout(gen)
+	})
+}
+
+func (c *Conn) stopTimer() {
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	c.timerGen++
+}
+
+func (c *Conn) handleTimeout(gen int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if gen != c.timerGen {
+		return
+	}
+	c.timer = nil
+
+	// NOTE: probing a closed window may take arbitrarily long, so
+	// it never causes the connection to time out.
+	c.timeouts++
+	if c.timeouts > maxTimeouts && (c.state != stateEstablished || c.peerWindow > 0) {
+		c.fail(ErrTimeout)
+		return
+	}
+
+	switch c.state {
+	case stateSynSent, stateSynReceived:
+		c.send(c.synSegment())
+	case stateEstablished:
+		if len(c.inflight) == 0 {
+			return
+		}
+		c.ssthresh = maxFloat(c.cwnd/2, 2)
+		c.cwnd = 1
+		c.inRecovery = false
+		for _, s := range c.inflight {
+			s.fastRetransmitted = false
+			s.lost = !s.sacked
+		}
+		c.flush()
+		if c.timer == nil {
+			c.startTimer()
+		}
+		return
+	default:
+		return
+	}
+	c.startTimer()
+}
+
+// checkDone finishes the connection once it has been closed and
+// its FIN has been acknowledged.
+func (c *Conn) checkDone() {
+	if c.closed && c.finAcked {
+		c.finish()
+	}
+}
+
+// fail finishes the connection with an error.
+func (c *Conn) fail(err error) {
+	if c.state == stateDone {
+		return
+	}
+	if c.err == nil {
+		c.err = err
+	}
+	c.finish()
+}
+
+func (c *Conn) finish() {
+	if c.state == stateDone {
+		return
+	}
+	c.state = stateDone
+	c.stopTimer()
+	c.notify()
+	c.endpoint.remove(c)
+}
+
+func (c *Conn) send(seg *segment) {
+	seg.connID = c.id
+	seg.timestamp = c.clock()
+	seg.echo = c.echo
+	c.endpoint.send(seg, c.remote)
+}
+
+// clock returns the timestamp for the current time.
+func (c *Conn) clock() uint32 {
+	return uint32(time.Since(c.start) / time.Microsecond)
+}
+
+func (c *Conn) synSegment() *segment {
+	res := &segment{flags: flagSYN, window: uint16(c.receiveWindow())}
+	if c.state == stateSynReceived {
+		res.flags |= flagACK
+	}
+	return res
+}
+
+func (c *Conn) ackSegment() *segment {
+	window := c.receiveWindow()
+	c.advertised = window
+	return &segment{
+		flags:  flagACK,
+		seq:    c.nextSeq,
+		ack:    c.rcvNext,
+		window: uint16(window),
+		sack:   c.sackBlocks(),
+	}
+}
+
+// receiveWindow returns the number of segments which can be
+// received, starting at rcvNext.
+func (c *Conn) receiveWindow() int {
+	if c.closed {
+		return receiveWindowSize
+	}
+	buffered := (len(c.readBuf) + segmentSize - 1) / segmentSize
+	if buffered > receiveWindowSize {
+		return 0
+	}
+	return receiveWindowSize - buffered
+}
+
+// sackBlocks describes the out-of-order segments which have been
+// received.
+func (c *Conn) sackBlocks() []sackBlock {
+	if len(c.outOfOrder) == 0 {
+		return nil
+	}
+	seqs := make([]uint32, 0, len(c.outOfOrder))
+	for seq := range c.outOfOrder {
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool {
+		return seqs[i] < seqs[j]
+	})
+	var res []sackBlock
+	for _, seq := range seqs {
+		if len(res) > 0 && res[len(res)-1].end == seq {
+			res[len(res)-1].end++
+		} else if len(res) == maxSACKBlocks {
+			break
+		} else {
+			res = append(res, sackBlock{start: seq, end: seq + 1})
+		}
+	}
+	return res
+}
+
+func maxFloat(x, y float64) float64 {
+	if x > y {
+		return x
+	}
+	return y
+}
@@ -0,0 +1,110 @@
+package stream
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var errBadSegment = errors.New("invalid segment")
+
+// These are the flags of a segment.
+const (
+	flagSYN = 1 << iota
+	flagACK
+	flagFIN
+	flagRST
+)
+
+// segmentHeaderSize is the size of a segment header without any
+// SACK blocks.
+const segmentHeaderSize = 24
+
+// maxSACKBlocks is the largest number of SACK blocks in a segment.
+const maxSACKBlocks = 4
+
+// A sackBlock is a range [start, end) of sequence numbers which were
+// received after a gap.
+type sackBlock struct {
+	start uint32
+	end   uint32
+}
+
+// A segment is the unit which the protocol sends in each datagram.
+//
+// Sequence numbers count segments, not bytes.
+// Every segment with a payload or the FIN flag takes up one sequence
+// number, while other segments use the next unused one.
+type segment struct {
+	flags  byte
+	connID uint32
+	seq    uint32
+
+	// ack is the next sequence number which the sender expects,
+	// which is only meaningful with flagACK.
+	ack uint32
+
+	// window is the number of segments, starting at ack, which the
+	// sender is willing to receive.
+	window uint16
+
+	// timestamp is the sender's clock, in microseconds, and echo is
+	// the timestamp of the segment which prompted this one.
+	// Together they measure round-trip times, even when segments
+	// are retransmitted or acknowledgements are lost.
+	timestamp uint32
+	echo      uint32
+
+	sack    []sackBlock
+	payload []byte
+}
+
+func (s *segment) has(flag byte) bool {
+	return (s.flags & flag) != 0
+}
+
+func (s *segment) encode() []byte {
+	res := make([]byte, segmentHeaderSize, segmentHeaderSize+8*len(s.sack)+len(s.payload))
+	res[0] = s.flags
+	binary.BigEndian.PutUint32(res[1:], s.connID)
+	binary.BigEndian.PutUint32(res[5:], s.seq)
+	binary.BigEndian.PutUint32(res[9:], s.ack)
+	binary.BigEndian.PutUint16(res[13:], s.window)
+	binary.BigEndian.PutUint32(res[15:], s.timestamp)
+	binary.BigEndian.PutUint32(res[19:], s.echo)
+	res[23] = byte(len(s.sack))
+	for _, block := range s.sack {
+		var encoded [8]byte
+		binary.BigEndian.PutUint32(encoded[:], block.start)
+		binary.BigEndian.PutUint32(encoded[4:], block.end)
+		res = append(res, encoded[:]...)
+	}
+	return append(res, s.payload...)
+}
+
+func decodeSegment(data []byte) (*segment, error) {
+	if len(data) < segmentHeaderSize {
+		return nil, errBadSegment
+	}
+	res := &segment{
+		flags:     data[0],
+		connID:    binary.BigEndian.Uint32(data[1:]),
+		seq:       binary.BigEndian.Uint32(data[5:]),
+		ack:       binary.BigEndian.Uint32(data[9:]),
+		window:    binary.BigEndian.Uint16(data[13:]),
+		timestamp: binary.BigEndian.Uint32(data[15:]),
+		echo:      binary.BigEndian.Uint32(data[19:]),
+	}
+	numBlocks := int(data[23])
+	data = data[segmentHeaderSize:]
+	if numBlocks > maxSACKBlocks || len(data) < 8*numBlocks {
+		return nil, errBadSegment
+	}
+	for i := 0; i < numBlocks; i++ {
+		res.sack = append(res.sack, sackBlock{
+			start: binary.BigEndian.Uint32(data[8*i:]),
+			end:   binary.BigEndian.Uint32(data[8*i+4:]),
+		})
+	}
+	res.payload = data[8*numBlocks:]
+	return res, nil
+}
@@ -0,0 +1,243 @@
+// Package stream provides reliable, ordered byte streams on top of
+// a net.PacketConn, such as a dgram.PacketConn which sends raw
+// 802.11 frames.
+//
+// Connections start with a handshake, and number their segments so
+// that lost segments can be retransmitted.
+// Receivers acknowledge segments cumulatively and selectively, and
+// advertise how many more segments they can buffer.
+// Senders estimate the round-trip time to choose retransmission
+// timeouts, and back off when segments are lost.
+package stream
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+)
+
+var (
+	ErrReset   = errors.New("connection reset by peer")
+	ErrTimeout = errors.New("connection timed out")
+)
+
+// acceptQueueSize is the number of established connections which a
+// Listener keeps while nothing accepts them.
+const acceptQueueSize = 16
+
+type connKey struct {
+	addr string
+	id   uint32
+}
+
+// An endpoint demultiplexes the segments which arrive on a
+// PacketConn.
+//
+// The PacketConn is closed once the endpoint is neither listening
+// nor has any connections.
+type endpoint struct {
+	pc net.PacketConn
+
+	lock      sync.Mutex
+	conns     map[connKey]*Conn
+	listener  *Listener
+	listening bool
+
+	// done is closed when the PacketConn fails, after which err is
+	// set.
+	done chan struct{}
+	err  error
+}
+
+func newEndpoint(pc net.PacketConn) *endpoint {
+	return &endpoint{
+		pc:    pc,
+		conns: map[connKey]*Conn{},
+		done:  make(chan struct{}),
+	}
+}
+
+func (e *endpoint) readLoop() {
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := e.pc.ReadFrom(buf)
+		if err != nil {
+			e.shutdown(err)
+			return
+		}
+		seg, err := decodeSegment(append([]byte{}, buf[:n]...))
+		if err != nil {
+			continue
+		}
+		e.dispatch(seg, addr)
+	}
+}
+
+func (e *endpoint) dispatch(seg *segment, addr net.Addr) {
+	key := connKey{addr: addr.String(), id: seg.connID}
+	e.lock.Lock()
+	c := e.conns[key]
+	if c == nil && seg.flags == flagSYN && e.listening {
+		c = newConn(e, addr, seg.connID, stateSynReceived)
+		e.conns[key] = c
+	}
+	e.lock.Unlock()
+
+	if c != nil {
+		c.handleSegment(seg)
+	} else if !seg.has(flagRST) {
+		e.send(&segment{flags: flagRST, connID: seg.connID}, addr)
+	}
+}
+
+func (e *endpoint) send(seg *segment, addr net.Addr) {
+	// NOTE: segments may be lost anyway, so failures are ignored.
+	e.pc.WriteTo(seg.encode(), addr)
+}
+
+func (e *endpoint) add(c *Conn) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.conns[connKey{addr: c.remote.String(), id: c.id}] = c
+}
+
+func (e *endpoint) remove(c *Conn) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	delete(e.conns, connKey{addr: c.remote.String(), id: c.id})
+	e.closeIfIdle()
+}
+
+// accept passes a newly established connection to the Listener,
+// resetting it if the accept queue is full.
+func (e *endpoint) accept(c *Conn) {
+	select {
+	case e.listener.queue <- c:
+	default:
+		c.send(&segment{flags: flagRST})
+		c.fail(ErrReset)
+	}
+}
+
+func (e *endpoint) stopListening() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.listening = false
+	e.closeIfIdle()
+}
+
+// closeIfIdle closes the PacketConn if it is no longer needed.
+// The caller must hold e.lock.
+func (e *endpoint) closeIfIdle() {
+	if !e.listening && len(e.conns) == 0 {
+		e.pc.Close()
+	}
+}
+
+func (e *endpoint) shutdown(err error) {
+	e.lock.Lock()
+	var conns []*Conn
+	for _, c := range e.conns {
+		conns = append(conns, c)
+	}
+	e.err = err
+	close(e.done)
+	e.lock.Unlock()
+
+	for _, c := range conns {
+		c.lock.Lock()
+		c.fail(err)
+		c.lock.Unlock()
+	}
+}
+
+// A Listener accepts connections on a PacketConn.
+type Listener struct {
+	endpoint *endpoint
+	queue    chan *Conn
+
+	closeLock sync.Mutex
+	closed    chan struct{}
+}
+
+// Listen creates a Listener which owns pc.
+//
+// Once the Listener and all of its connections are closed, pc is
+// closed.
+func Listen(pc net.PacketConn) *Listener {
+	e := newEndpoint(pc)
+	res := &Listener{
+		endpoint: e,
+		queue:    make(chan *Conn, acceptQueueSize),
+		closed:   make(chan struct{}),
+	}
+	e.listener = res
+	e.listening = true
+	go e.readLoop()
+	return res
+}
+
+// Accept waits for the next connection.
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.queue:
+		return c, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	case <-l.endpoint.done:
+		return nil, l.endpoint.err
+	}
+}
+
+// Close stops accepting connections.
+// Connections which were already accepted are unaffected.
+func (l *Listener) Close() error {
+	l.closeLock.Lock()
+	defer l.closeLock.Unlock()
+	select {
+	case <-l.closed:
+		return net.ErrClosed
+	default:
+	}
+	close(l.closed)
+	l.endpoint.stopListening()
+
+	// Close connections which were never accepted.
+	for {
+		select {
+		case c := <-l.queue:
+			c.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+// Addr returns the address of the PacketConn.
+func (l *Listener) Addr() net.Addr {
+	return l.endpoint.pc.LocalAddr()
+}
+
+// Dial connects to a Listener at addr, taking ownership of pc.
+//
+// Once the connection is closed, or if it cannot be established, pc
+// is closed.
+func Dial(pc net.PacketConn, addr net.Addr) (*Conn, error) {
+	e := newEndpoint(pc)
+	c := newConn(e, addr, rand.Uint32(), stateSynSent)
+	e.add(c)
+	go e.readLoop()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.send(c.synSegment())
+	c.startTimer()
+	for c.state == stateSynSent {
+		c.wait(c.readDeadline)
+	}
+	if c.state == stateDone {
+		return nil, c.err
+	}
+	return c, nil
+}
@@ -0,0 +1,212 @@
+package stream
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/unixpickle/gofi/dgram"
+	"github.com/unixpickle/gofi/internal/testutil"
+	"github.com/unixpickle/gofi/sim"
+)
+
+var (
+	testClientAddr = net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	testServerAddr = net.HardwareAddr{0x02, 0, 0, 0, 0, 2}
+)
+
+var (
+	_ net.Conn     = &Conn{}
+	_ net.Listener = &Listener{}
+)
+
+func TestSegment(t *testing.T) {
+	seg := &segment{
+		flags:   flagACK | flagFIN,
+		connID:  0x12345678,
+		seq:     7,
+		ack:     3,
+		window:  40,
+		sack:    []sackBlock{{5, 7}, {9, 10}},
+		payload: []byte("payload"),
+	}
+	decoded, err := decodeSegment(seg.encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, seg) {
+		t.Errorf("expected %+v but got %+v", seg, decoded)
+	}
+	if _, err := decodeSegment(seg.encode()[:20]); err == nil {
+		t.Error("decoded a truncated segment")
+	}
+}
+
+func TestTransfer(t *testing.T) {
+	testTransfer(t, sim.NewMedium(), 1<<16)
+}
+
+func TestTransferLossy(t *testing.T) {
+	medium := sim.NewMedium()
+	medium.SetSeed(1337)
+	medium.SetLossRate(0.1)
+	medium.SetReorderRate(0.1)
+	medium.SetRetryLimit(0)
+	testTransfer(t, medium, 1<<18)
+}
+
+func TestClose(t *testing.T) {
+	client, server, listener := testConnect(t, sim.NewMedium())
+	defer listener.Close()
+
+	if _, err := client.Write([]byte("goodbye")); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Write([]byte("x")); err != net.ErrClosed {
+		t.Error("unexpected error writing to closed conn:", err)
+	}
+	server.SetReadDeadline(time.Now().Add(time.Second * 5))
+	data, err := io.ReadAll(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "goodbye" {
+		t.Errorf("unexpected data: %q", data)
+	}
+	server.Close()
+}
+
+func TestDeadline(t *testing.T) {
+	client, server, listener := testConnect(t, sim.NewMedium())
+	defer listener.Close()
+	defer client.Close()
+	defer server.Close()
+
+	server.SetReadDeadline(time.Now().Add(time.Millisecond * 20))
+	if _, err := server.Read(make([]byte, 10)); !testutil.IsTimeout(err) {
+		t.Error("expected timeout but got:", err)
+	}
+	server.SetReadDeadline(time.Time{})
+	if _, err := client.Write([]byte("late")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 10)
+	n, err := server.Read(buf)
+	if err != nil || string(buf[:n]) != "late" {
+		t.Errorf("unexpected read: %q %v", buf[:n], err)
+	}
+}
+
+func TestDeadlineConcurrent(t *testing.T) {
+	client, server, listener := testConnect(t, sim.NewMedium())
+	defer listener.Close()
+	defer client.Close()
+	defer server.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			server.SetReadDeadline(time.Now().Add(time.Millisecond))
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		if _, err := server.Read(make([]byte, 10)); err != nil && !testutil.IsTimeout(err) {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReset(t *testing.T) {
+	medium := sim.NewMedium()
+	client, server, listener := testConnect(t, medium)
+	defer listener.Close()
+	defer client.Close()
+
+	// Simulate the server disappearing by removing the connection
+	// without closing it.
+	server.lock.Lock()
+	server.finish()
+	server.lock.Unlock()
+
+	client.Write([]byte("hello?"))
+	client.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if _, err := client.Read(make([]byte, 10)); err != ErrReset {
+		t.Error("expected reset but got:", err)
+	}
+}
+
+func testTransfer(t *testing.T, medium *sim.Medium, size int) {
+	client, server, listener := testConnect(t, medium)
+	defer listener.Close()
+
+	data := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(data)
+	reply := []byte("got it")
+
+	errs := make(chan error, 1)
+	go func() {
+		defer server.Close()
+		server.SetDeadline(time.Now().Add(time.Second * 30))
+		received := make([]byte, len(data))
+		if _, err := io.ReadFull(server, received); err != nil {
+			errs <- err
+			return
+		}
+		if !bytes.Equal(received, data) {
+			t.Error("received data does not match")
+		}
+		_, err := server.Write(reply)
+		errs <- err
+	}()
+
+	client.SetDeadline(time.Now().Add(time.Second * 30))
+	if _, err := client.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+	received, err := io.ReadAll(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(received, reply) {
+		t.Errorf("unexpected reply: %q", received)
+	}
+	client.Close()
+}
+
+func testConnect(t *testing.T, medium *sim.Medium) (client, server *Conn, l *Listener) {
+	serverPC := dgram.NewPacketConn(medium.NewHandle(testServerAddr), &dgram.Config{
+		Address:  testServerAddr,
+		Protocol: 0x88b5,
+	})
+	clientPC := dgram.NewPacketConn(medium.NewHandle(testClientAddr), &dgram.Config{
+		Address:  testClientAddr,
+		Protocol: 0x88b5,
+	})
+	l = Listen(serverPC)
+	client, err := Dial(clientPC, dgram.Addr(testServerAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, conn.(*Conn), l
+}
@@ -0,0 +1,147 @@
+// Package tap bridges a gofi Handle to a network device, so that
+// ordinary networking tools can run over injected 802.11 frames.
+//
+// On Linux, OpenTAP creates a TAP device to serve as the bridge's
+// network device.
+package tap
+
+import (
+	"bytes"
+	"io"
+	"net"
+
+	"github.com/unixpickle/gofi"
+	"github.com/unixpickle/gofi/ether"
+)
+
+// A Config configures a Bridge.
+type Config struct {
+	// BSSID is the BSSID of sent frames.
+	// Received frames from other BSSs are dropped.
+	BSSID net.HardwareAddr
+
+	// Direction is the direction of sent frames, which must not be
+	// ether.DirectionWDS.
+	// Received frames must be sent in the reverse direction, so a
+	// bridge acting as a station receives ether.DirectionFromAP
+	// frames.
+	Direction ether.Direction
+
+	// Address is the MAC address of the network device.
+	// If it is set, received frames whose receiver address is
+	// neither Address nor a group address are dropped.
+	Address net.HardwareAddr
+
+	// Rate is the data rate at which frames read from the device are
+	// transmitted.
+	// If it is 0, the Handle picks its lowest rate.
+	Rate gofi.DataRate
+}
+
+// A Bridge copies frames between a Handle and a network device,
+// converting between 802.11 data frames and Ethernet frames.
+//
+// Each Read from the device must return one Ethernet frame, and
+// each Write to the device writes one Ethernet frame.
+type Bridge struct {
+	handle gofi.Handle
+	device io.ReadWriter
+	config Config
+}
+
+// NewBridge creates a Bridge between h and device.
+func NewBridge(h gofi.Handle, device io.ReadWriter, c *Config) *Bridge {
+	return &Bridge{handle: h, device: device, config: *c}
+}
+
+// Run copies frames in both directions until reading from the
+// Handle or the device fails, and returns the first error.
+//
+// To stop the bridge, close the Handle and the device.
+func (b *Bridge) Run() error {
+	if b.config.Direction == ether.DirectionWDS {
+		return ether.ErrNeedAddresses
+	}
+	errs := make(chan error, 2)
+	go func() {
+		errs <- b.deviceToHandle()
+	}()
+	go func() {
+		errs <- b.handleToDevice()
+	}()
+	return <-errs
+}
+
+func (b *Bridge) deviceToHandle() error {
+	buf := make([]byte, 65536)
+	for {
+		n, err := b.device.Read(buf)
+		if err != nil {
+			return err
+		}
+		frame, err := ether.FromEthernet(buf[:n], b.config.BSSID, b.config.Direction)
+		if err != nil {
+			continue
+		}
+		// NOTE: like a network card, the bridge drops frames which
+		// fail to send.
+		if err := b.handle.Send(frame, b.config.Rate); err == gofi.ErrClosed {
+			return err
+		}
+	}
+}
+
+func (b *Bridge) handleToDevice() error {
+	for {
+		frame, _, err := b.handle.Receive()
+		if err != nil {
+			return err
+		}
+		if !b.accept(frame) {
+			continue
+		}
+		packets, err := ether.ToEthernet(frame)
+		if err != nil {
+			continue
+		}
+		for _, packet := range packets {
+			if _, err := b.device.Write(packet); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// accept checks if a received frame should be passed to the device.
+func (b *Bridge) accept(f gofi.Frame) bool {
+	if f.Type() != gofi.FrameTypeData || !f.HasBody() || f.Protected() {
+		return false
+	}
+	if ether.FrameDirection(f) != reverseDirection(b.config.Direction) {
+		return false
+	}
+	_, _, bssid := ether.Addresses(f)
+	if !bytes.Equal(bssid, b.config.BSSID) {
+		return false
+	}
+	receiver := f.Addr1()
+	if len(receiver) != 6 {
+		return false
+	}
+	if b.config.Address != nil && (receiver[0]&1) == 0 &&
+		!bytes.Equal(receiver, b.config.Address) {
+		return false
+	}
+	return true
+}
+
+func reverseDirection(d ether.Direction) ether.Direction {
+	switch d {
+	case ether.DirectionToAP:
+		return ether.DirectionFromAP
+	case ether.DirectionFromAP:
+		return ether.DirectionToAP
+	default:
+		return d
+	}
+}
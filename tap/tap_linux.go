@@ -0,0 +1,131 @@
+// +build linux
+
+package tap
+
+import (
+	"errors"
+	"net"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+var errBadAddress = errors.New("hardware address must be 6 bytes")
+
+// A TAP is a Linux TAP device, which exchanges Ethernet frames with
+// the kernel.
+type TAP struct {
+	file *os.File
+	name string
+}
+
+// OpenTAP creates a TAP device and brings it up.
+//
+// If name is empty, the kernel picks a name.
+// If addr is non-nil, it is used as the device's MAC address.
+//
+// This usually requires root privileges or CAP_NET_ADMIN.
+func OpenTAP(name string, addr net.HardwareAddr) (*TAP, error) {
+	file, err := os.OpenFile("/dev/net/tun", os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	ifreq, err := unix.NewIfreq(name)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	ifreq.SetUint16(unix.IFF_TAP | unix.IFF_NO_PI)
+	rawConn, err := file.SyscallConn()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	var ioctlErr error
+	err = rawConn.Control(func(fd uintptr) {
+		ioctlErr = unix.IoctlIfreq(int(fd), unix.TUNSETIFF, ifreq)
+	})
+	if err == nil {
+		err = ioctlErr
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	res := &TAP{file: file, name: ifreq.Name()}
+	if addr != nil {
+		if err := res.setHardwareAddr(addr); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+	if err := res.setUp(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return res, nil
+}
+
+// Name returns the name of the network interface.
+func (t *TAP) Name() string {
+	return t.name
+}
+
+// Read reads one Ethernet frame which the kernel sent.
+func (t *TAP) Read(b []byte) (int, error) {
+	return t.file.Read(b)
+}
+
+// Write passes one Ethernet frame to the kernel.
+func (t *TAP) Write(b []byte) (int, error) {
+	return t.file.Write(b)
+}
+
+// Close destroys the device.
+func (t *TAP) Close() error {
+	return t.file.Close()
+}
+
+func (t *TAP) setHardwareAddr(addr net.HardwareAddr) error {
+	if len(addr) != 6 {
+		return errBadAddress
+	}
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	// NOTE: unix.Ifreq cannot hold a struct sockaddr, so the ifreq is
+	// laid out by hand: the name, then the address family, then the
+	// address.
+	var ifreq [unix.IFNAMSIZ + 24]byte
+	copy(ifreq[:unix.IFNAMSIZ-1], t.name)
+	*(*uint16)(unsafe.Pointer(&ifreq[unix.IFNAMSIZ])) = unix.ARPHRD_ETHER
+	copy(ifreq[unix.IFNAMSIZ+2:], addr)
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), uintptr(unix.SIOCSIFHWADDR),
+		uintptr(unsafe.Pointer(&ifreq[0])))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func (t *TAP) setUp() error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+	ifreq, err := unix.NewIfreq(t.name)
+	if err != nil {
+		return err
+	}
+	if err := unix.IoctlIfreq(fd, unix.SIOCGIFFLAGS, ifreq); err != nil {
+		return err
+	}
+	ifreq.SetUint16(ifreq.Uint16() | unix.IFF_UP)
+	return unix.IoctlIfreq(fd, unix.SIOCSIFFLAGS, ifreq)
+}
@@ -0,0 +1,36 @@
+// +build linux
+
+package tap
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"testing"
+)
+
+func TestOpenTAP(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("must be root to create TAP devices")
+	}
+	if _, err := os.Stat("/dev/net/tun"); err != nil {
+		t.Skip("no /dev/net/tun")
+	}
+	addr := net.HardwareAddr{0x02, 0x12, 0x34, 0x56, 0x78, 0x9a}
+	device, err := OpenTAP("gofitap%d", addr)
+	if err != nil {
+		t.Fatal("could not open TAP:", err)
+	}
+	defer device.Close()
+
+	iface, err := net.InterfaceByName(device.Name())
+	if err != nil {
+		t.Fatal("could not find interface:", err)
+	}
+	if !bytes.Equal(iface.HardwareAddr, addr) {
+		t.Error("unexpected hardware address:", iface.HardwareAddr)
+	}
+	if (iface.Flags & net.FlagUp) == 0 {
+		t.Error("interface is not up")
+	}
+}
@@ -0,0 +1,38 @@
+// +build !linux
+
+package tap
+
+import (
+	"errors"
+	"net"
+)
+
+var errUnsupported = errors.New("TAP devices are not supported on this platform")
+
+// A TAP is a TAP device, which is only supported on Linux.
+type TAP struct{}
+
+// OpenTAP always fails on this platform.
+func OpenTAP(name string, addr net.HardwareAddr) (*TAP, error) {
+	return nil, errUnsupported
+}
+
+// Name returns the name of the network interface.
+func (t *TAP) Name() string {
+	return ""
+}
+
+// Read always fails on this platform.
+func (t *TAP) Read(b []byte) (int, error) {
+	return 0, errUnsupported
+}
+
+// Write always fails on this platform.
+func (t *TAP) Write(b []byte) (int, error) {
+	return 0, errUnsupported
+}
+
+// Close does nothing on this platform.
+func (t *TAP) Close() error {
+	return nil
+}
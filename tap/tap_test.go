@@ -0,0 +1,150 @@
+package tap
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/unixpickle/gofi"
+	"github.com/unixpickle/gofi/ether"
+	"github.com/unixpickle/gofi/internal/testutil"
+	"github.com/unixpickle/gofi/sim"
+)
+
+var (
+	testAP      = net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	testStation = net.HardwareAddr{0x02, 0, 0, 0, 0, 2}
+	testOther   = net.HardwareAddr{0x02, 0, 0, 0, 0, 3}
+	testHost    = net.HardwareAddr{0x02, 0, 0, 0, 0, 4}
+)
+
+func TestBridge(t *testing.T) {
+	medium := sim.NewMedium()
+	apHandle := medium.NewHandle(testAP)
+	staHandle := medium.NewHandle(testStation)
+	otherHandle := medium.NewHandle(testOther)
+	defer otherHandle.Close()
+
+	apDevice := newTestDevice()
+	staDevice := newTestDevice()
+	apBridge := NewBridge(apHandle, apDevice, &Config{
+		BSSID:     testAP,
+		Direction: ether.DirectionFromAP,
+	})
+	staBridge := NewBridge(staHandle, staDevice, &Config{
+		BSSID:     testAP,
+		Direction: ether.DirectionToAP,
+		Address:   testStation,
+	})
+	errs := make(chan error, 2)
+	go func() {
+		errs <- apBridge.Run()
+	}()
+	go func() {
+		errs <- staBridge.Run()
+	}()
+	defer func() {
+		apHandle.Close()
+		staHandle.Close()
+		apDevice.Close()
+		staDevice.Close()
+		for i := 0; i < 2; i++ {
+			if err := <-errs; err != gofi.ErrClosed && err != io.EOF {
+				t.Error("unexpected bridge error:", err)
+			}
+		}
+	}()
+
+	up := testutil.Ethernet(testHost, testStation, []byte("hello AP"))
+	staDevice.in <- up
+	if packet := apDevice.next(t); !bytes.Equal(packet, up) {
+		t.Errorf("unexpected packet at AP: %x", packet)
+	}
+
+	down := testutil.Ethernet(testStation, testHost, []byte("hello station"))
+	apDevice.in <- down
+	if packet := staDevice.next(t); !bytes.Equal(packet, down) {
+		t.Errorf("unexpected packet at station: %x", packet)
+	}
+
+	// Frames from another BSS, in the wrong direction, or to another
+	// station should all be dropped.
+	eth := testutil.Ethernet(testStation, testHost, []byte("dropped"))
+	foreign, _ := ether.FromEthernet(eth, testOther, ether.DirectionFromAP)
+	wrongDirection, _ := ether.FromEthernet(eth, testAP, ether.DirectionToAP)
+	otherStation, _ := ether.FromEthernet(testutil.Ethernet(testOther, testHost, []byte("dropped")),
+		testAP, ether.DirectionFromAP)
+	for _, frame := range []gofi.Frame{foreign, wrongDirection, otherStation} {
+		if err := otherHandle.Send(frame, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	broadcast := testutil.Ethernet(net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, testHost,
+		[]byte("broadcast"))
+	apDevice.in <- broadcast
+	if packet := staDevice.next(t); !bytes.Equal(packet, broadcast) {
+		t.Errorf("unexpected packet at station: %x", packet)
+	}
+}
+
+func TestBridgeWDS(t *testing.T) {
+	medium := sim.NewMedium()
+	h := medium.NewHandle(testAP)
+	defer h.Close()
+	b := NewBridge(h, newTestDevice(), &Config{BSSID: testAP, Direction: ether.DirectionWDS})
+	if err := b.Run(); err != ether.ErrNeedAddresses {
+		t.Error("unexpected error:", err)
+	}
+}
+
+func TestBridgeShortFrame(t *testing.T) {
+	b := NewBridge(nil, nil, &Config{Direction: ether.DirectionToAP, Address: testStation})
+	// A data frame from an AP which ends before its first address.
+	if b.accept(gofi.Frame{0x08, 0x02, 0, 0}) {
+		t.Error("accepted a short frame")
+	}
+}
+
+type testDevice struct {
+	in     chan []byte
+	out    chan []byte
+	closed chan struct{}
+}
+
+func newTestDevice() *testDevice {
+	return &testDevice{
+		in:     make(chan []byte),
+		out:    make(chan []byte, 16),
+		closed: make(chan struct{}),
+	}
+}
+
+func (t *testDevice) Read(b []byte) (int, error) {
+	select {
+	case packet := <-t.in:
+		return copy(b, packet), nil
+	case <-t.closed:
+		return 0, io.EOF
+	}
+}
+
+func (t *testDevice) Write(b []byte) (int, error) {
+	t.out <- append([]byte{}, b...)
+	return len(b), nil
+}
+
+func (t *testDevice) Close() {
+	close(t.closed)
+}
+
+func (t *testDevice) next(test *testing.T) []byte {
+	select {
+	case packet := <-t.out:
+		return packet
+	case <-time.After(time.Second * 5):
+		test.Fatal("timed out waiting for packet")
+		return nil
+	}
+}
@@ -0,0 +1,171 @@
+// +build linux
+
+package gofi
+
+import (
+	"errors"
+	"os"
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// These are the dimensions of a packetTxRing.
+const (
+	txRingFrameSize  = 1 << 12
+	txRingFrameCount = 256
+)
+
+var errTxRingFrameTooLarge = errors.New("frame too large for transmit ring")
+
+// A packetTxRing is a TPACKET_V2 transmit ring mapped into memory.
+//
+// Packets are queued in the ring and then sent with a single system
+// call, rather than one system call per packet.
+//
+// The ring has its own socket, so that its packet version does not
+// conflict with the receive path.
+type packetTxRing struct {
+	fd   int
+	data []byte
+
+	// head is the index of the next frame to fill.
+	head int
+}
+
+func newPacketTxRing(ifindex int) (*packetTxRing, error) {
+	// NOTE: protocol 0 keeps the socket from receiving anything.
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := setupPacketTxRing(fd, ifindex); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	data, err := unix.Mmap(fd, 0, txRingFrameSize*txRingFrameCount,
+		unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	return &packetTxRing{fd: fd, data: data}, nil
+}
+
+func setupPacketTxRing(fd, ifindex int) error {
+	if err := unix.SetsockoptInt(fd, unix.SOL_PACKET, unix.PACKET_VERSION,
+		unix.TPACKET_V2); err != nil {
+		return err
+	}
+	// NOTE: without PACKET_LOSS, a malformed frame stalls the ring.
+	// SendBatch checks frames before queueing them, so this should
+	// rarely matter.
+	if err := unix.SetsockoptInt(fd, unix.SOL_PACKET, unix.PACKET_LOSS, 1); err != nil {
+		return err
+	}
+	req := unix.TpacketReq{
+		Block_size: uint32(os.Getpagesize()),
+		Frame_size: txRingFrameSize,
+		Frame_nr:   txRingFrameCount,
+	}
+	if req.Block_size < txRingFrameSize {
+		req.Block_size = txRingFrameSize
+	}
+	req.Block_nr = txRingFrameSize * txRingFrameCount / req.Block_size
+	if err := unix.SetsockoptTpacketReq(fd, unix.SOL_PACKET, unix.PACKET_TX_RING,
+		&req); err != nil {
+		return err
+	}
+	return unix.Bind(fd, &unix.SockaddrLinklayer{Ifindex: ifindex})
+}
+
+// SendBatch sends every packet in a batch.
+// It returns nil if every packet was sent, or one error per packet.
+func (r *packetTxRing) SendBatch(packets [][]byte) []error {
+	var errs []error
+	fail := func(i int, err error) {
+		if errs == nil {
+			errs = make([]error, len(packets))
+		}
+		errs[i] = err
+	}
+
+	dataOffset := tpacketAlign(unix.SizeofTpacket2Hdr)
+	var queued []int
+	for i, packet := range packets {
+		if len(packet) > txRingFrameSize-dataOffset {
+			fail(i, errTxRingFrameTooLarge)
+			continue
+		}
+		frame := r.frame(r.head)
+		header := (*unix.Tpacket2Hdr)(unsafe.Pointer(&frame[0]))
+		if atomic.LoadUint32(&header.Status) != unix.TP_STATUS_AVAILABLE {
+			fail(i, errors.New("transmit ring is out of sync"))
+			continue
+		}
+		copy(frame[dataOffset:], packet)
+		header.Len = uint32(len(packet))
+		atomic.StoreUint32(&header.Status, unix.TP_STATUS_SEND_REQUEST)
+		r.head = (r.head + 1) % txRingFrameCount
+		queued = append(queued, i)
+
+		if len(queued) == txRingFrameCount {
+			r.kick(queued, fail)
+			queued = queued[:0]
+		}
+	}
+	if len(queued) > 0 {
+		r.kick(queued, fail)
+	}
+	return errs
+}
+
+// Close unmaps the ring and closes its socket.
+func (r *packetTxRing) Close() error {
+	unix.Munmap(r.data)
+	return unix.Close(r.fd)
+}
+
+// kick tells the kernel to send every queued frame and waits for it
+// to finish.
+// Frames which were not sent are reported to fail and removed from
+// the ring.
+func (r *packetTxRing) kick(queued []int, fail func(int, error)) {
+	var err error
+	for {
+		// NOTE: without MSG_DONTWAIT, this blocks until every frame
+		// has left the ring.
+		err = unix.Sendto(r.fd, nil, 0, nil)
+		if err != unix.EINTR {
+			break
+		}
+	}
+
+	first := (r.head - len(queued) + txRingFrameCount) % txRingFrameCount
+	unsent := -1
+	for j, i := range queued {
+		index := (first + j) % txRingFrameCount
+		header := (*unix.Tpacket2Hdr)(unsafe.Pointer(&r.frame(index)[0]))
+		if atomic.LoadUint32(&header.Status) == unix.TP_STATUS_AVAILABLE {
+			continue
+		}
+		if unsent < 0 {
+			unsent = index
+		}
+		if err == nil {
+			err = errors.New("frame was not sent")
+		}
+		fail(i, err)
+		atomic.StoreUint32(&header.Status, unix.TP_STATUS_AVAILABLE)
+	}
+	if unsent >= 0 {
+		// The kernel stops at the first frame it could not send, so
+		// that is where the next batch should start.
+		r.head = unsent
+	}
+}
+
+func (r *packetTxRing) frame(index int) []byte {
+	return r.data[index*txRingFrameSize : (index+1)*txRingFrameSize]
+}
@@ -0,0 +1,100 @@
+// +build linux
+
+package gofi
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestTxRing(t *testing.T) {
+	_, receiver := testVethSockets(t, &RxRingOptions{})
+	ring := testTxRing(t)
+
+	// Send more frames than fit in the ring at once.
+	var packets [][]byte
+	for i := 0; i < txRingFrameCount+20; i++ {
+		packets = append(packets, encodeRadiotapPacket(testRingFrame(i), 2))
+	}
+	packets[3] = make([]byte, txRingFrameSize)
+	if errs := ring.SendBatch(packets); len(errs) != len(packets) {
+		t.Fatal("unexpected errors:", errs)
+	} else {
+		for i, err := range errs {
+			if (i == 3) != (err != nil) {
+				t.Fatal("unexpected error for frame", i, "-", err)
+			}
+		}
+	}
+
+	for i := range packets {
+		if i == 3 {
+			continue
+		}
+		received, err := receiver.Receive()
+		if err != nil {
+			t.Fatal("could not receive packet", i, "-", err)
+		}
+		if !bytes.Equal(received.Frame, testRingFrame(i)) {
+			t.Fatal("unexpected frame", i, "-", received.Frame)
+		}
+	}
+
+	if errs := ring.SendBatch(packets[:1]); errs != nil {
+		t.Fatal("unexpected errors:", errs)
+	}
+	if received, err := receiver.Receive(); err != nil {
+		t.Fatal("could not receive packet:", err)
+	} else if !bytes.Equal(received.Frame, testRingFrame(0)) {
+		t.Fatal("unexpected frame:", received.Frame)
+	}
+}
+
+func BenchmarkSendLoop(b *testing.B) {
+	sender, _ := testVethSockets(b, nil)
+	frame := testRingFrame(0)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := sender.Send(frame, 2); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSendTxRing(b *testing.B) {
+	testVethSockets(b, nil)
+	ring := testTxRing(b)
+	packet := encodeRadiotapPacket(testRingFrame(0), 2)
+	batch := make([][]byte, 64)
+	for i := range batch {
+		batch[i] = packet
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i += len(batch) {
+		n := len(batch)
+		if b.N-i < n {
+			n = b.N - i
+		}
+		if errs := ring.SendBatch(batch[:n]); errs != nil {
+			b.Fatal(errs)
+		}
+	}
+}
+
+// testTxRing creates a transmit ring on the sending end of the
+// pair created by testVethSockets.
+func testTxRing(t testing.TB) *packetTxRing {
+	netIface, err := net.InterfaceByName(testVethSender)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ring, err := newPacketTxRing(netIface.Index)
+	if err != nil {
+		t.Fatal("could not create transmit ring:", err)
+	}
+	t.Cleanup(func() {
+		ring.Close()
+	})
+	return ring
+}
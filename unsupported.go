@@ -1,4 +1,4 @@
-// +build !darwin
+// +build !darwin,!linux
 
 package gofi
 
@@ -14,6 +14,11 @@ func DefaultInterfaceName() (string, error) {
 	return "", errors.New("this OS is unsupported")
 }
 
+// Interfaces describes every WiFi device on this machine.
+func Interfaces() ([]InterfaceInfo, error) {
+	return nil, errors.New("this OS is unsupported")
+}
+
 // NewHandle creates a new handle with the given interface name.
 // If the handle cannot be created for any reason (e.g., permissions, no such
 // device, etc.), then this returns an error.